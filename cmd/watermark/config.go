@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"watermark/pkg/watermark"
+)
+
+// fileConfig is the YAML config file. Top-level keys are flag names without
+// the leading dash; presets group the same keys under a name, and tenants
+// group presets under a tenant name or API key, selected with -tenant.
+//
+//	mode: repeat
+//	font: /path/to/font.ttf
+//	opacity: 0.3
+//	presets:
+//	  draft:
+//	    text: DRAFT
+//	    angle: 45
+//	tenants:
+//	  acme:
+//	    draft:
+//	      text: ACME DRAFT
+//	      font: /fonts/acme.ttf
+type fileConfig struct {
+	Values  map[string]interface{}
+	Presets map[string]map[string]interface{}
+	Tenants map[string]map[string]map[string]interface{}
+}
+
+// reservedKeys are flags that cannot be set from the config file.
+var reservedKeys = map[string]bool{"config": true, "preset": true, "tenant": true}
+
+// defaultConfigPath returns $XDG_CONFIG_HOME/watermark/config.yaml, falling
+// back to the platform user config directory.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(dir, "watermark", "config.yaml")
+}
+
+func loadConfig(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg := &fileConfig{Values: raw, Tenants: map[string]map[string]map[string]interface{}{}}
+	if cfg.Presets, err = configPresets(raw["presets"]); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	delete(raw, "presets")
+	if t, ok := raw["tenants"]; ok {
+		delete(raw, "tenants")
+		tenants, ok := t.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: tenants must be a mapping", path)
+		}
+		for tenant, v := range tenants {
+			if cfg.Tenants[tenant], err = configPresets(v); err != nil {
+				return nil, fmt.Errorf("%s: tenant %q: %w", path, tenant, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// configPresets converts a presets mapping of the config file, or nil, to
+// flag values by preset name.
+func configPresets(v interface{}) (map[string]map[string]interface{}, error) {
+	out := map[string]map[string]interface{}{}
+	if v == nil {
+		return out, nil
+	}
+	presets, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("presets must be a mapping")
+	}
+	for name, v := range presets {
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("preset %q must be a mapping", name)
+		}
+		out[name] = values
+	}
+	return out, nil
+}
+
+// applyConfig fills flags that were not given on the command line, first
+// from the preset (if any) and then from the top-level config values.
+// Presets are looked up in the config file, then among the library's
+// registered presets; with a tenant, its own presets come first in each.
+// An explicit path must exist; the default is optional.
+func applyConfig(path, preset, tenant string) error {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	var cfg *fileConfig
+	if path != "" {
+		c, err := loadConfig(path)
+		switch {
+		case err == nil:
+			cfg = c
+		case explicit || !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if tenant != "" && (cfg == nil || cfg.Tenants[tenant] == nil) && watermark.TenantPresetNames(tenant) == nil {
+		return fmt.Errorf("unknown tenant %q", tenant)
+	}
+	if preset != "" {
+		values, err := presetValues(cfg, preset, tenant)
+		if err != nil {
+			return err
+		}
+		if err := setFlags(values, set); err != nil {
+			return fmt.Errorf("preset %q: %w", preset, err)
+		}
+	}
+	if cfg == nil {
+		return nil
+	}
+	if err := setFlags(cfg.Values, set); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// presetValues resolves a preset name to flag values. The tenant's presets,
+// from the config file and then those registered for it, shadow the shared
+// ones of the same name, again from the config file first.
+func presetValues(cfg *fileConfig, name, tenant string) (map[string]interface{}, error) {
+	if cfg != nil && tenant != "" {
+		if values, ok := cfg.Tenants[tenant][name]; ok {
+			return values, nil
+		}
+	}
+	if slices.Contains(watermark.TenantPresetNames(tenant), name) {
+		p, _ := watermark.LookupTenantPreset(tenant, name)
+		return presetFlagValues(p)
+	}
+	if cfg != nil {
+		if values, ok := cfg.Presets[name]; ok {
+			return values, nil
+		}
+	}
+	p, ok := watermark.LookupPreset(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q (built-in: %s)", name, strings.Join(watermark.PresetNames(), ", "))
+	}
+	return presetFlagValues(p)
+}
+
+// presetFlagValues returns the flag values p sets.
+func presetFlagValues(p watermark.Preset) (map[string]interface{}, error) {
+	// Round-trip through YAML: preset field names match flag names.
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// setFlags assigns values to flags not yet in set, marking them as set.
+func setFlags(values map[string]interface{}, set map[string]bool) error {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if reservedKeys[k] || flag.Lookup(k) == nil {
+			return fmt.Errorf("unknown setting %q", k)
+		}
+		if set[k] {
+			continue
+		}
+		v := fmt.Sprint(values[k])
+		if list, ok := values[k].([]interface{}); ok {
+			// Lists, such as transform, become comma-separated flag values.
+			items := make([]string, len(list))
+			for i, item := range list {
+				items[i] = fmt.Sprint(item)
+			}
+			v = strings.Join(items, ",")
+		}
+		if err := flag.Set(k, v); err != nil {
+			return fmt.Errorf("setting %q: %w", k, err)
+		}
+		set[k] = true
+	}
+	return nil
+}
+
+// sidecarSuffix names the per-file override next to an input:
+// photo.jpg.watermark.json for photo.jpg.
+const sidecarSuffix = ".watermark.json"
+
+// sidecarFlags are the flags a sidecar may set: those that style or place
+// the mark on one file. Anything else, such as plugins, fonts and other
+// files to read, limits or where the output goes, stays with whoever runs
+// the batch.
+var sidecarFlags = map[string]bool{
+	"text": true, "position-text": true, "transform": true, "text-transform": true,
+	"text-direction": true, "line-height": true, "align": true, "color": true,
+	"opacity": true, "space": true, "angle": true, "font-size": true,
+	"font-height-crop": true, "rotate-tiles": true, "position": true,
+	"position-font-size": true, "position-angle": true, "font-size-ratio": true,
+	"width-ratio": true, "fill-color": true, "outline-color": true,
+	"outline-width": true, "outline-dash": true, "shadow": true, "anchor": true,
+	"offset-x": true, "offset-y": true, "margin-ratio": true, "margin": true,
+	"avoid-chrome": true, "avoid-borders": true, "avoid-edges": true,
+	"max-nudge-ratio": true, "crop": true, "pad-to": true, "pad-color": true,
+	"page": true, "pages": true, "pdf-pages": true,
+}
+
+// applySidecar reads the JSON sidecar of input, if there is one, and sets
+// its flags over the command line, so curators can fix single files of a
+// batch without touching the shared flags or config:
+//
+//	{"position": "top-left", "opacity": 0.3}
+//
+// Only the style flags in sidecarFlags are accepted. {"skip": true} leaves
+// the file alone; applySidecar then reports skip and sets nothing. It returns the sidecar path, or "" if input has none.
+func applySidecar(input string) (path string, skip bool, err error) {
+	path = input + sidecarSuffix
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return path, false, err
+	}
+	values := map[string]interface{}{}
+	// Numbers stay as written, so large integers do not become 2.5e+06.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return path, false, fmt.Errorf("%s: %w", path, err)
+	}
+	for k := range values {
+		if k != "skip" && !sidecarFlags[k] {
+			return path, false, fmt.Errorf("%s: %q cannot be set per file", path, k)
+		}
+	}
+	if v, ok := values["skip"]; ok {
+		skip, ok = v.(bool)
+		if !ok {
+			return path, false, fmt.Errorf("%s: skip must be true or false", path)
+		}
+		if skip {
+			return path, true, nil
+		}
+	}
+	delete(values, "skip")
+	// An empty set overrides flags from the command line too.
+	if err := setFlags(values, map[string]bool{}); err != nil {
+		return path, false, fmt.Errorf("%s: %w", path, err)
+	}
+	return path, false, nil
+}
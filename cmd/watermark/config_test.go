@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"watermark/pkg/watermark"
+)
+
+func TestApplySidecarRejects(t *testing.T) {
+	for _, tc := range []struct{ name, sidecar string }{
+		{"plugin", `{"text-plugin": "sh -c 'touch /tmp/pwned'"}`},
+		{"output", `{"out": "/etc/passwd"}`},
+		{"font file", `{"font": "/etc/shadow"}`},
+		{"unknown", `{"no-such-flag": 1}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			input := filepath.Join(t.TempDir(), "photo.jpg")
+			if err := os.WriteFile(input+sidecarSuffix, []byte(tc.sidecar), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, skip, err := applySidecar(input)
+			if err == nil || !strings.Contains(err.Error(), "cannot be set per file") {
+				t.Fatalf("err = %v, want a per-file error", err)
+			}
+			if skip {
+				t.Error("skip = true")
+			}
+		})
+	}
+}
+
+func TestApplySidecarSkip(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "photo.jpg")
+	if path, skip, err := applySidecar(input); path != "" || skip || err != nil {
+		t.Fatalf("no sidecar: %q, %v, %v", path, skip, err)
+	}
+	if err := os.WriteFile(input+sidecarSuffix, []byte(`{"skip": true, "opacity": 0.3}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path, skip, err := applySidecar(input)
+	if path != input+sidecarSuffix || !skip || err != nil {
+		t.Fatalf("got %q, %v, %v", path, skip, err)
+	}
+}
+
+func TestPresetValuesOrder(t *testing.T) {
+	text := "tenant registered"
+	if err := watermark.RegisterTenantPreset("acme", watermark.Preset{Name: "stamp", Text: text}); err != nil {
+		t.Fatal(err)
+	}
+	defer watermark.UnregisterTenant("acme")
+	cfg := &fileConfig{
+		Presets: map[string]map[string]interface{}{"stamp": {"text": "shared config"}},
+		Tenants: map[string]map[string]map[string]interface{}{"beta": {"stamp": {"text": "tenant config"}}},
+	}
+	for _, tc := range []struct{ tenant, want string }{
+		{"acme", text},
+		{"beta", "tenant config"},
+		{"", "shared config"},
+		{"gamma", "shared config"},
+	} {
+		values, err := presetValues(cfg, "stamp", tc.tenant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := values["text"]; got != tc.want {
+			t.Errorf("tenant %q: text = %v, want %q", tc.tenant, got, tc.want)
+		}
+	}
+	if values, err := presetValues(nil, "stamp", "acme"); err != nil || values["text"] != text {
+		t.Errorf("no config file: %v, %v", values, err)
+	}
+}
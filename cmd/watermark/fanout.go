@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"watermark/pkg/watermark"
+)
+
+// runFanout implements "watermark fanout": one traceable copy per recipient
+// plus a manifest. It returns the exit code.
+func runFanout(args []string) int {
+	fs := flag.NewFlagSet("fanout", flag.ContinueOnError)
+	input := fs.String("in", "", "input image path (required)")
+	outDir := fs.String("out-dir", "", "directory for the copies and manifest.json (required)")
+	recipients := fs.String("recipients", "", "comma-separated recipient names")
+	recipientsFile := fs.String("recipients-file", "", "file with one recipient per line")
+	text := fs.String("text", "", "visible text, placed like -mode position; {recipient}, {serial} and {seq} are replaced")
+	invisible := fs.Bool("invisible", false, "also embed a robust invisible mark with a per-copy key (see watermark detect)")
+	counter := fs.Int("counter", 1, "number of the first copy, filled into {counter}/{seq} and counting up per copy")
+	fingerprint := fs.Bool("fingerprint", false, "hide each copy's serial in the spacing of -text (see watermark fingerprint)")
+	fontPath := fs.String("font", "", "font file for -text; a comma-separated list adds fallback fonts")
+	opacity := fs.Float64("opacity", 0.5, "opacity of the visible text")
+	position := fs.String("position", "bottom-right", "position of the visible text")
+	format := fs.String("format", "", "output format png|jpeg|tiff; default keeps the input's")
+	robustStrength := fs.Float64("robust-strength", 4, "amplitude of the invisible mark in luma levels")
+	drainTimeout := fs.Duration("drain-timeout", 30*time.Second, "on SIGINT/SIGTERM, time allowed to finish before aborting; an aborted fan-out removes its copies")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *input == "" || *outDir == "" {
+		fmt.Fprintln(os.Stderr, "missing -in or -out-dir")
+		fs.Usage()
+		return 2
+	}
+
+	var names []string
+	for _, r := range strings.Split(*recipients, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			names = append(names, r)
+		}
+	}
+	if *recipientsFile != "" {
+		data, err := os.ReadFile(*recipientsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -recipients-file:", err)
+			return 2
+		}
+		for _, r := range strings.Split(string(data), "\n") {
+			if r = strings.TrimSpace(r); r != "" {
+				names = append(names, r)
+			}
+		}
+	}
+
+	mainFont, fallbackFonts := splitFonts(*fontPath)
+	opts := []watermark.Option{
+		watermark.WithFont(mainFont),
+		watermark.WithFontFallbacks(fallbackFonts...),
+		watermark.WithOpacity(*opacity),
+		watermark.WithPosition(watermark.Position(*position)),
+		watermark.WithRobustStrength(*robustStrength),
+		watermark.WithSequence(watermark.NewSequence(*counter)),
+	}
+	if *format != "" {
+		f, err := watermark.ParseFormat(*format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -format:", err)
+			return 2
+		}
+		opts = append(opts, watermark.WithFormat(f))
+	}
+
+	fc := watermark.FanoutConfig{
+		Recipients:  names,
+		Text:        strings.ReplaceAll(*text, `\n`, "\n"),
+		Invisible:   *invisible,
+		Fingerprint: *fingerprint,
+	}
+	ctx, stop := drainOnSignal(*drainTimeout)
+	defer stop()
+	m, err := watermark.Fanout(ctx, *input, *outDir, fc, opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, c := range m.Copies {
+		fmt.Printf("%s\t%s\t%s\n", c.Serial, c.Recipient, c.Path)
+	}
+	return 0
+}
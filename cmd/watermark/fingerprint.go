@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"watermark/pkg/watermark"
+)
+
+// runFingerprint implements "watermark fingerprint": it decodes the
+// fingerprint hidden by -fingerprint from copied or OCR'd text and, given
+// candidates, names the one it matches. It exits 3 when nothing matches.
+func runFingerprint(args []string) int {
+	fs := flag.NewFlagSet("fingerprint", flag.ContinueOnError)
+	input := fs.String("in", "-", "text file to decode, or - for stdin")
+	manifest := fs.String("manifest", "", "fanout manifest.json whose serials are the candidates")
+	ids := fs.String("ids", "", "comma-separated candidate fingerprint IDs")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var data []byte
+	var err error
+	if *input == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*input)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	text := string(data)
+	fp := watermark.DecodeFingerprint(text)
+	fmt.Printf("id=%q space-bits=%s\n", fp.ID, fp.SpaceBits)
+
+	candidates := map[string]string{}
+	for _, id := range strings.Split(*ids, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			candidates[id] = id
+		}
+	}
+	if *manifest != "" {
+		m, err := watermark.ReadManifest(*manifest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, c := range m.Copies {
+			candidates[c.Serial] = c.Recipient
+		}
+	}
+	if len(candidates) == 0 {
+		return 0
+	}
+	list := make([]string, 0, len(candidates))
+	for id := range candidates {
+		list = append(list, id)
+	}
+	id, ok := watermark.MatchFingerprint(text, list)
+	if !ok {
+		fmt.Println("no match")
+		return 3
+	}
+	fmt.Printf("match=%s recipient=%s\n", id, candidates[id])
+	return 0
+}
@@ -1,101 +1,766 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"image/color"
+	"io"
+	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"watermark/pkg/watermark"
 )
 
 func main() {
-	mode := flag.String("mode", "repeat", "watermark mode: repeat or position")
-	input := flag.String("in", "", "input image path (required)")
-	output := flag.String("out", "", "output image path (required)")
-	text := flag.String("text", "", "watermark text (required)")
+	var card *testCard
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "extract":
+			os.Exit(runExtract(os.Args[2:]))
+		case "detect":
+			os.Exit(runDetect(os.Args[2:]))
+		case "verify-origin":
+			os.Exit(runVerifyOrigin(os.Args[2:]))
+		case "fanout":
+			os.Exit(runFanout(os.Args[2:]))
+		case "fingerprint":
+			os.Exit(runFingerprint(os.Args[2:]))
+		case "video":
+			os.Exit(runVideo(os.Args[2:]))
+		case "selftest":
+			os.Exit(runSelftest(os.Args[2:]))
+		case "testcard":
+			// testcard shares the flags below, so it is parsed with them.
+			card = &testCard{}
+			os.Args = append(os.Args[:1:1], os.Args[2:]...)
+		}
+	}
 
-	colorHex := flag.String("color", "#4db6ac", "repeat: watermark color hex")
+	mode := flag.String("mode", "repeat", "watermark mode: repeat, position, combined (tiled -text plus a positioned -position-text), qr (-text encoded as a QR code), invisible (-text hidden in the pixels; see watermark extract), or robust (compression-resistant invisible mark keyed by -text; see watermark detect)")
+	input := flag.String("in", "", "input image or PDF path, or - for stdin (required)")
+	output := flag.String("out", "", "output image path, or - for stdout (required)")
+	outFormat := flag.String("format", "", "output format png|jpeg|tiff|ico|webp|pdf (PDF inputs only), overriding the -out extension; required with -out -")
+	flag.StringVar(outFormat, "out-format", "", "deprecated alias of -format")
+	cleanOut := flag.String("clean-out", "", "also write the decoded, auto-oriented input without watermark to this path")
+	densities := flag.String("densities", "", "write pixel-density variants, e.g. 1,2,3 for -out plus @2x and @3x files; the input is the largest, and the mark keeps its size in points")
+	forceFormat := flag.Bool("force-format", false, "write -format even if the -out extension is not an image format, or missing")
+	quality := flag.Int("quality", 100, "JPEG output quality 1..100; around 85 is usually indistinguishable and several times smaller")
+	progressive := flag.Bool("progressive", false, "write progressive JPEG")
+	subsampling := flag.String("subsampling", "4:2:0", "JPEG chroma subsampling: 4:2:0, or 4:4:4 to keep colored text sharp")
+	pngCompression := flag.String("png-compression", "default", "PNG compression level: default|fast|best|none")
+	pngColor := flag.String("png-color", "auto", "PNG color type: auto (palette or grayscale when lossless), truecolor, or gray")
+	text := flag.String("text", "", "watermark text (required); \\n starts a new line, {filename}, {date}, {datetime}, {year}, {width}, {height}, {counter} or {seq} (padded as {counter:0000}), {page}, {pages} and {exif.Model} etc. are replaced")
+	fingerprint := flag.String("fingerprint", "", "hide this ID, e.g. a recipient code, in zero-width characters and the word spacing of the text (see watermark fingerprint)")
+	transform := flag.String("transform", "", "comma-separated text transformers applied after variables are filled in: "+strings.Join(watermark.TextTransformerNames(), ", "))
+	textCase := flag.String("text-transform", "none", "case of the rendered text: none|upper|lower|title|smallcaps (small caps use the font's own glyphs where it has them)")
+	textDirection := flag.String("text-direction", "horizontal", "repeat/position: horizontal, or vertical for top-to-bottom columns read right to left, as in Chinese and Japanese")
+	lineHeight := flag.Float64("line-height", 1, "line spacing of multi-line text relative to the font's line height")
+	align := flag.String("align", "left", "alignment of multi-line text: left|center|right")
+	positionText := flag.String("position-text", "", "combined: text of the positioned mark")
+	layerOrder := flag.String("layer-order", "tiles,position", "combined: stacking order of the layers, bottom first")
+	tileLayer := flag.String("tile-layer", "", "combined: opacity and blend mode of the tiles as opacity[,normal|multiply|screen|overlay], e.g. 0.2,multiply (default -opacity, normal)")
+	positionLayer := flag.String("position-layer", "", "combined: opacity and blend mode of the positioned mark, like -tile-layer")
+	counter := flag.Int("counter", 1, "number filled into {counter}/{seq} in the text, e.g. the index of the image in a batch")
+	page := flag.Int("page", 1, "page number filled into {page} in the text")
+	pages := flag.Int("pages", 1, "page count filled into {pages} in the text")
+	pdfPages := flag.String("pdf-pages", "", "PDF input: mark only these pages, e.g. 1-3,5,8- (default all); each page fills in its own {page} and {pages}")
+
+	colorHex := flag.String("color", "#4db6ac", "repeat: watermark color hex, or palette:color such as okabe-ito:blue (see -list-palettes)")
+	cvdCheck := flag.Bool("cvd-check", false, "warn when the mark color has low contrast against the image, including for color-blind viewers")
+	jpegProof := flag.String("jpeg-proof", "", "preview JPEG compression at -quality/-subsampling: write the marked image as it decodes again to this path, warning when fine strokes of the mark are lost")
+	coverageMap := flag.String("coverage-map", "", "write a heat map of where the mark landed to this path and print the share of the image it covers, to tune -space, -angle and -font-size")
+	listPalettes := flag.Bool("list-palettes", false, "print the color-blind safe palettes usable in color flags and exit")
 	space := flag.Int("space", 75, "repeat: spacing between tiles")
-	angle := flag.Int("angle", 30, "repeat: rotation angle")
+	angle := flag.String("angle", "30", "repeat: rotation angle in degrees, or auto to cross the image's strongest edges (falling back to 30)")
 	opacity := flag.Float64("opacity", 0.5, "opacity 0..1")
-	fontPath := flag.String("font", "", "font path (.ttf/.otf)")
+	fontPath := flag.String("font", "", "font path (.ttf/.otf, or .ttc#index or .ttc#family for a font in a collection); a comma-separated list draws runes a font lacks from the next one, e.g. latin.ttf,cjk.ttf")
+	fontVariation := flag.String("font-variation", "", "axes of a variable -font, e.g. wght=700,slnt=-10")
 	fontSize := flag.Int("font-size", 48, "repeat: font size")
 	fontHeightCrop := flag.Float64("font-height-crop", 1.0, "repeat: font height crop factor")
+	maxTiles := flag.Int("max-tiles", 250000, "repeat: fail instead of pasting more tiles than this (0: no limit)")
+	rotateTiles := flag.Bool("rotate-tiles", false, "repeat: rotate each tile about its center on an upright grid instead of rotating the whole pattern")
 
-	position := flag.String("position", "bottom-right", "position: bottom-right|bottom-left|top-right|top-left|center")
+	position := flag.String("position", "bottom-right", "position: top-left|top-center|top-right|center-left|center|center-right|bottom-left|bottom-center|bottom-right, or x=PX,y=PX / x=PCT%,y=PCT%")
+	positionFontSize := flag.Int("position-font-size", 0, "position: font size in pixels (0: derive from -font-size-ratio)")
+	positionAngle := flag.Float64("position-angle", 0, "position: rotate the text counter-clockwise by this many degrees")
+	fontSizeRatio := flag.Float64("font-size-ratio", 0.04, "position: font size relative to the shorter image side")
+	widthRatio := flag.Float64("width-ratio", 0, "position: size the text to span this fraction of the image width, e.g. 0.3 (0 = use -font-size-ratio)")
+	fillColor := flag.String("fill-color", "", "position: text color hex (default: black or white by background brightness)")
+	outlineColor := flag.String("outline-color", "", "position: outline color hex (default: the opposite of the text color)")
+	randomRegion := flag.String("random-region", "", "position: place the mark at a random spot inside x0,y0,x1,y1 (pixels or %), e.g. 50%,50%,100%,100%")
+	randomSeed := flag.Int64("random-seed", 0, "seed for -random-region and -color-jitter; combined with the image content")
+	colorJitter := flag.String("color-jitter", "", "shift the mark colors per image by up to hue,lightness, e.g. 12,0.06 (degrees, fraction)")
+	anchor := flag.String("anchor", "top-left", "position: point of the mark placed at an explicit x=,y= position, named like -position")
+	offsetX := flag.Int("offset-x", 0, "position: shift the mark right (negative: left) by this many pixels")
+	outlineWidth := flag.Float64("outline-width", 2, "position: outline thickness in pixels beyond the glyph edges, 0 to disable")
+	shadow := flag.String("shadow", "", "position: drop shadow as dx,dy,blur,color, e.g. 3,3,2,#00000099")
+	outlineDash := flag.String("outline-dash", "", "position: dashed outline as on,off lengths in pixels, e.g. 6,3")
+	offsetY := flag.Int("offset-y", 0, "position: shift the mark down (negative: up) by this many pixels")
 	marginRatio := flag.Float64("margin-ratio", 0.04, "position: margin ratio relative to width")
+	margin := flag.String("margin", "", "position: per-side margins in pixels or percent, e.g. top=2%,right=5%,bottom=3%,left=5%; sides left out use -margin-ratio")
+	avoidChrome := flag.Bool("avoid-chrome", false, "position: keep the mark off the top and bottom bars of phone screenshots")
+	avoidBorders := flag.Bool("avoid-borders", false, "position: place the mark within the content, inside uniform borders and letterbox bars")
+	avoidEdges := flag.Bool("avoid-edges", false, "position: nudge the mark inward when the corner covers strong edges")
+	maxNudgeRatio := flag.Float64("max-nudge-ratio", 0.15, "position: max inward nudge relative to the shorter side")
+	maxBytes := flag.Int64("max-bytes", 0, "refuse inputs larger than this many bytes (0: no limit)")
+	maxPixels := flag.Int64("max-pixels", 0, "refuse inputs with more pixels than this, read from the header before decoding (0: no limit)")
+	preserveAlpha := flag.Bool("preserve-alpha", false, "keep the transparency of the input exactly: draw the mark only where the input is visible, and refuse outputs such as JPEG that would flatten it")
+	workers := flag.Int("workers", 0, "goroutines compositing bands of the image at once (0: GOMAXPROCS, 1: single-threaded)")
+	verify := flag.Bool("verify", false, "repeat: warn when the result is identical to the input, e.g. at a near-zero -opacity (costs an extra pass over the image)")
+	linearBlend := flag.Bool("linear-blend", false, "composite the mark in linear light, so semi-transparent text is not darkened as with plain sRGB blending")
+	originChecksum := flag.Bool("origin-checksum", false, "record the SHA-256 of the input file in the JPEG or PNG output (see watermark verify-origin)")
+	stripMetadata := flag.Bool("strip-metadata", false, "do not copy EXIF/XMP from the input into JPEG outputs")
+	colorProfile := flag.String("color-profile", "keep", "embedded ICC profile of the input (e.g. Display P3): keep it in JPEG/PNG outputs, or srgb to convert the pixels to sRGB and drop it")
+	ignoreOrientation := flag.Bool("ignore-orientation", false, "do not rotate the input according to its EXIF orientation tag")
+	crop := flag.String("crop", "", "crop the input before watermarking: x,y,w,h in pixels or gravity:WxH, e.g. center:1080x1080")
+	padTo := flag.String("pad-to", "", "extend the canvas to this aspect ratio W:H before watermarking, e.g. 4:5 or 1.91:1")
+	padColor := flag.String("pad-color", "#ffffff", "-pad-to: fill of the added area, a hex color or blur for a blurred copy of the image")
+	tolerant := flag.Bool("tolerant", false, "salvage truncated or partially corrupted JPEG inputs instead of failing")
+	stencil := flag.Bool("stencil", false, "document mode: render the mark as a light-gray stencil on a grayscale copy")
+	stencilGray := flag.Int("stencil-gray", 200, "document mode: stencil gray level 0..255")
+	robustStrength := flag.Float64("robust-strength", 4, "robust: mark amplitude in luma levels; higher survives more compression but may show on flat areas")
+	payloadFile := flag.String("payload-file", "", "invisible: embed the bytes of this file instead of -text")
+	markImage := flag.String("mark-image", "", "repeat/position: tile or place this image (e.g. a logo) instead of rendering -text; .svg files are rasterized at the size drawn")
+	markImageWidth := flag.Int("mark-image-width", 0, "width in pixels to scale -mark-image to (0 = natural size, or -mark-image-ratio for SVG)")
+	textPlugin := flag.String("text-plugin", "", "run this command (split on spaces) to supply the mark text over the JSON plugin protocol, see watermark.Plugin")
+	placePlugin := flag.String("place-plugin", "", "run this command (split on spaces) to choose where position mode puts the mark, see watermark.Plugin")
+	pluginTimeout := flag.Duration("plugin-timeout", watermark.DefaultPluginTimeout, "time limit for each plugin call")
+	markImageRatio := flag.Float64("mark-image-ratio", 0, "SVG -mark-image: width relative to the image width (0 = 0.2)")
+	qrLevel := flag.String("qr-level", "M", "qr: error-correction level L|M|Q|H")
+	qrSize := flag.Int("qr-size", 0, "qr: code size in pixels (0 = a fifth of the shorter image side)")
+	qrQuiet := flag.Int("qr-quiet-zone", 4, "qr: light border around the code, in modules")
+	qrTile := flag.Bool("qr-tile", false, "qr: tile the code like repeat mode instead of placing it like position mode")
+	icoMinSize := flag.Int("ico-min-size", 32, "ICO input: leave icons smaller than this many pixels unmarked")
+	bilevel := flag.Bool("bilevel", false, "convert the output to dithered black and white (1-bit PNG or TIFF)")
 	jpgBG := flag.String("jpg-bg", "255,255,255", "jpeg background RGB, e.g. 255,255,255")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "on SIGINT/SIGTERM, time allowed to finish the current image before aborting")
+
+	configPath := flag.String("config", "", "YAML config file with default flag values and presets (default $XDG_CONFIG_HOME/watermark/config.yaml)")
+	preset := flag.String("preset", "", "named preset from the config file or a built-in one (confidential, draft, copyright)")
+	tenant := flag.String("tenant", "", "look -preset up among this tenant's presets under tenants in the config file first")
+	ignoreSidecar := flag.Bool("ignore-sidecar", false, "do not read per-file overrides from the -in path plus .watermark.json")
+
+	if card != nil {
+		card.defineFlags()
+	}
 
 	flag.Parse()
 
-	if err := validateRequired(*input, *output, *text); err != nil {
+	// The sidecar goes first: flags it sets count as given on the command
+	// line, so the preset and config file fill in only what is left.
+	if card == nil && !*ignoreSidecar && *input != "" && *input != "-" {
+		path, skip, err := applySidecar(*input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "sidecar:", err)
+			os.Exit(2)
+		}
+		if skip {
+			fmt.Fprintf(os.Stderr, "skipping %s (%s)\n", *input, path)
+			return
+		}
+	}
+
+	if err := applyConfig(*configPath, *preset, *tenant); err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(2)
+	}
+
+	if *listPalettes {
+		printPalettes()
+		return
+	}
+
+	if card != nil {
+		if *input != "" {
+			fmt.Fprintln(os.Stderr, "testcard draws its own input; drop -in")
+			os.Exit(2)
+		}
+		// The card stands in for -in, named after the output for {filename}.
+		*input = *output
+	}
+	if err := validateRequired(*input, *output, *text, *markImage != "" || *payloadFile != ""); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		flag.Usage()
 		os.Exit(2)
 	}
 
+	*text = strings.ReplaceAll(*text, `\n`, "\n")
+	*positionText = strings.ReplaceAll(*positionText, `\n`, "\n")
+
+	if *payloadFile != "" {
+		payload, err := os.ReadFile(*payloadFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -payload-file:", err)
+			os.Exit(2)
+		}
+		*text = string(payload)
+	}
+
 	bg, err := parseRGB(*jpgBG)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "invalid -jpg-bg:", err)
 		os.Exit(2)
 	}
 
-	switch strings.ToLower(*mode) {
-	case "repeat":
-		if strings.TrimSpace(*fontPath) == "" {
-			fmt.Fprintln(os.Stderr, "repeat mode requires -font to be set")
+	sub, err := watermark.ParseSubsampling(*subsampling)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -subsampling:", err)
+		os.Exit(2)
+	}
+	pngLevel, err := watermark.ParsePNGCompression(*pngCompression)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -png-compression:", err)
+		os.Exit(2)
+	}
+	pngType, err := watermark.ParsePNGColor(*pngColor)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -png-color:", err)
+		os.Exit(2)
+	}
+	caseTransform, err := watermark.ParseTextTransform(*textCase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -text-transform:", err)
+		os.Exit(2)
+	}
+	direction, err := watermark.ParseTextDirection(*textDirection)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -text-direction:", err)
+		os.Exit(2)
+	}
+	profile, err := watermark.ParseColorProfile(*colorProfile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -color-profile:", err)
+		os.Exit(2)
+	}
+	if *quality < 1 || *quality > 100 {
+		fmt.Fprintln(os.Stderr, "invalid -quality: must be 1..100")
+		os.Exit(2)
+	}
+
+	if *stencilGray < 0 || *stencilGray > 255 {
+		fmt.Fprintln(os.Stderr, "invalid -stencil-gray: must be 0..255")
+		os.Exit(2)
+	}
+
+	var format watermark.Format
+	streaming := *input == "-" || *output == "-"
+	if card != nil {
+		if err := card.prepare(*output, *outFormat, *forceFormat); err != nil {
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(2)
 		}
-		opts := &watermark.RepeatOptions{
-			Color:          colorHex,
-			Space:          space,
-			Angle:          angle,
-			Opacity:        opacity,
-			FontPath:       *fontPath,
-			FontSize:       fontSize,
-			FontHeightCrop: fontHeightCrop,
+	} else if streaming || *outFormat != "" {
+		if format, err = outputFormat(*output, *outFormat, *forceFormat); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		_, err := watermark.AddRepeatWatermark(*input, *output, *text, opts)
+	}
+
+	mainFont, fallbackFonts := splitFonts(*fontPath)
+	opts := []watermark.Option{
+		watermark.WithColor(*colorHex),
+		watermark.WithSpace(*space),
+		watermark.WithOpacity(*opacity),
+		watermark.WithFont(mainFont),
+		watermark.WithFontFallbacks(fallbackFonts...),
+		watermark.WithFontSize(*fontSize),
+		watermark.WithFontHeightCrop(*fontHeightCrop),
+		watermark.WithRotateTiles(*rotateTiles),
+		watermark.WithMaxTiles(*maxTiles),
+		watermark.WithLineHeight(*lineHeight),
+		watermark.WithAlign(watermark.Align(*align)),
+		watermark.WithPosition(watermark.Position(*position)),
+		watermark.WithMarginRatio(*marginRatio),
+		watermark.WithShift(*offsetX, *offsetY),
+		watermark.WithPositionFontSize(*positionFontSize),
+		watermark.WithFontSizeRatio(*fontSizeRatio),
+		watermark.WithWidthRatio(*widthRatio),
+		watermark.WithPositionAngle(*positionAngle),
+		watermark.WithOutlineWidth(*outlineWidth),
+		watermark.WithJPGBackground(bg),
+		watermark.WithEncodeOptions(watermark.EncodeOptions{
+			Quality:        *quality,
+			Progressive:    *progressive,
+			Subsampling:    sub,
+			PNGCompression: pngLevel,
+			PNGColor:       pngType,
+		}),
+		watermark.WithAvoidEdges(*avoidEdges),
+		watermark.WithAvoidChrome(*avoidChrome),
+		watermark.WithAvoidBorders(*avoidBorders),
+		watermark.WithCVDCheck(*cvdCheck),
+		watermark.WithJPEGProof(*jpegProof != ""),
+		watermark.WithCoverageMap(*coverageMap != ""),
+		watermark.WithRobustStrength(*robustStrength),
+		watermark.WithMaxNudgeRatio(*maxNudgeRatio),
+		watermark.WithStripMetadata(*stripMetadata),
+		watermark.WithOriginChecksum(*originChecksum),
+		watermark.WithColorProfile(profile),
+		watermark.WithTextTransform(caseTransform),
+		watermark.WithTextDirection(direction),
+		watermark.WithPreserveAlpha(*preserveAlpha),
+		watermark.WithLinearBlend(*linearBlend),
+		watermark.WithWorkers(*workers),
+		watermark.WithVerify(*verify),
+		watermark.WithLimits(watermark.SourceLimits{MaxBytes: *maxBytes, MaxPixels: *maxPixels}),
+		watermark.WithIgnoreOrientation(*ignoreOrientation),
+		watermark.WithTolerant(*tolerant),
+		watermark.WithStencil(*stencil),
+		watermark.WithBilevel(*bilevel),
+		watermark.WithStencilGray(uint8(*stencilGray)),
+		watermark.WithPage(*page, *pages),
+		watermark.WithCounter(*counter),
+		watermark.WithICOMinSize(*icoMinSize),
+		watermark.WithFormat(format),
+		watermark.WithForceFormat(*forceFormat),
+		watermark.WithCleanOutput(*cleanOut),
+		watermark.WithQRLevel(watermark.QRLevel(*qrLevel)),
+		watermark.WithQRSize(*qrSize),
+		watermark.WithQRQuietZone(*qrQuiet),
+		watermark.WithQRTiled(*qrTile),
+		watermark.WithLogger(log.Default()),
+	}
+	if *input != "-" {
+		// Streams have no path of their own; name them after the input file.
+		opts = append(opts, watermark.WithFilename(filepath.Base(*input)))
+	}
+	if *fillColor != "" {
+		opts = append(opts, watermark.WithFillColor(*fillColor))
+	}
+	if *outlineColor != "" {
+		opts = append(opts, watermark.WithOutlineColor(*outlineColor))
+	}
+	if *shadow != "" {
+		dx, dy, blur, col, err := parseShadow(*shadow)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -shadow:", err)
+			os.Exit(2)
+		}
+		opts = append(opts, watermark.WithShadow(dx, dy, blur, col))
+	}
+	if *densities != "" {
+		var scales []int
+		for _, p := range strings.Split(*densities, ",") {
+			v, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid -densities: invalid scale %q\n", p)
+				os.Exit(2)
+			}
+			scales = append(scales, v)
+		}
+		opts = append(opts, watermark.WithDensities(scales...))
+	}
+	if *fontVariation != "" {
+		vars, err := watermark.ParseFontVariations(*fontVariation)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -font-variation:", err)
+			os.Exit(2)
+		}
+		opts = append(opts, watermark.WithFontVariations(vars...))
+	}
+	if *outlineDash != "" {
+		dash, err := parseFloats(*outlineDash)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -outline-dash:", err)
+			os.Exit(2)
+		}
+		opts = append(opts, watermark.WithOutlineDash(dash...))
+	}
+	if *layerOrder != "tiles,position" {
+		var layers []watermark.Layer
+		for _, l := range strings.Split(*layerOrder, ",") {
+			layers = append(layers, watermark.Layer(strings.TrimSpace(l)))
+		}
+		opts = append(opts, watermark.WithLayerOrder(layers...))
+	}
+	for _, l := range []struct {
+		name  string
+		layer watermark.Layer
+		raw   string
+	}{{"tile-layer", watermark.LayerTiles, *tileLayer}, {"position-layer", watermark.LayerPosition, *positionLayer}} {
+		if l.raw == "" {
+			continue
+		}
+		opacity, blend, err := parseLayerStyle(l.raw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -%s: %v\n", l.name, err)
+			os.Exit(2)
+		}
+		opts = append(opts, watermark.WithLayerStyle(l.layer, opacity, blend))
+	}
+	if *fingerprint != "" {
+		opts = append(opts, watermark.WithFingerprint(*fingerprint))
+	}
+	if *transform != "" {
+		opts = append(opts, watermark.WithTextTransforms(strings.Split(*transform, ",")...))
+	}
+	if *pdfPages != "" {
+		opts = append(opts, watermark.WithPDFPages(*pdfPages))
+	}
+	if *margin != "" {
+		m, err := watermark.ParseMargins(*margin, watermark.UniformMargins(watermark.Pct(*marginRatio*100)))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -margin:", err)
+			os.Exit(2)
+		}
+		opts = append(opts, watermark.WithMargins(m))
+	}
+	if *crop != "" {
+		c, err := watermark.ParseCrop(*crop)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -crop:", err)
+			os.Exit(2)
+		}
+		opts = append(opts, watermark.WithCrop(c))
+	}
+	if *padTo != "" {
+		ratio, err := watermark.ParseAspectRatio(*padTo)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -pad-to:", err)
+			os.Exit(2)
+		}
+		opts = append(opts, watermark.WithPadTo(ratio, *padColor))
+	}
+	if *textPlugin != "" {
+		opts = append(opts, watermark.WithTextPlugin(newPlugin(*textPlugin, *pluginTimeout)))
+	}
+	if *placePlugin != "" {
+		opts = append(opts, watermark.WithPlacementPlugin(newPlugin(*placePlugin, *pluginTimeout)))
+	}
+	if *markImage != "" {
+		r, err := markImageRenderer(*markImage, *markImageWidth, *markImageRatio)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -mark-image:", err)
+			os.Exit(2)
+		}
+		opts = append(opts, watermark.WithRenderer(r))
+	}
+	if *randomRegion != "" {
+		region, err := watermark.ParseRegion(*randomRegion)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			os.Exit(2)
 		}
-	case "position":
-		opts := &watermark.PositionOptions{
-			Opacity:       opacity,
-			Position:      watermark.Position(strings.ToLower(*position)),
-			FontPath:      *fontPath,
-			MarginRatio:   marginRatio,
-			JPGBackground: &bg,
+		opts = append(opts, watermark.WithRandomPosition(region, *randomSeed))
+	}
+	if *angle == "auto" {
+		opts = append(opts, watermark.WithAutoAngle())
+	} else if deg, err := strconv.Atoi(strings.TrimSpace(*angle)); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -angle:", *angle)
+		os.Exit(2)
+	} else {
+		opts = append(opts, watermark.WithAngle(deg))
+	}
+	if *colorJitter != "" {
+		hue, lightness, err := parseColorJitter(*colorJitter)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -color-jitter:", err)
+			os.Exit(2)
 		}
-		_, err := watermark.AddPositionWatermark(*input, *output, *text, opts)
+		opts = append(opts, watermark.WithColorJitter(hue, lightness, *randomSeed))
+	}
+	if strings.Contains(*position, "=") {
+		off, err := watermark.ParseOffset(*position)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			os.Exit(2)
+		}
+		opts = append(opts, watermark.WithOffset(off, watermark.Position(*anchor)))
+	}
+
+	var run func(context.Context, string, string, string, ...watermark.Option) (*watermark.Result, error)
+	var runStream func(context.Context, io.Reader, io.Writer, watermark.Format, string, ...watermark.Option) (*watermark.Result, error)
+	switch strings.ToLower(*mode) {
+	case "repeat":
+		if strings.TrimSpace(*fontPath) == "" && !watermark.HasEmbeddedFont() && *markImage == "" {
+			fmt.Fprintln(os.Stderr, "repeat mode requires -font to be set")
+			os.Exit(2)
+		}
+		run, runStream = watermark.AddRepeatWatermark, watermark.AddRepeatWatermarkStream
+	case "position":
+		run, runStream = watermark.AddPositionWatermark, watermark.AddPositionWatermarkStream
+	case "qr":
+		run, runStream = watermark.AddQRWatermark, watermark.AddQRWatermarkStream
+	case "invisible":
+		run, runStream = watermark.AddInvisibleWatermark, watermark.AddInvisibleWatermarkStream
+	case "robust":
+		run, runStream = watermark.AddRobustWatermark, watermark.AddRobustWatermarkStream
+	case "combined":
+		if strings.TrimSpace(*fontPath) == "" && !watermark.HasEmbeddedFont() {
+			fmt.Fprintln(os.Stderr, "combined mode requires -font to be set")
+			os.Exit(2)
+		}
+		if strings.TrimSpace(*positionText) == "" {
+			fmt.Fprintln(os.Stderr, "combined mode requires -position-text to be set")
+			os.Exit(2)
+		}
+		run = func(ctx context.Context, in, out, text string, opts ...watermark.Option) (*watermark.Result, error) {
+			return watermark.AddCombinedWatermark(ctx, in, out, text, *positionText, opts...)
+		}
+		runStream = func(ctx context.Context, r io.Reader, w io.Writer, f watermark.Format, text string, opts ...watermark.Option) (*watermark.Result, error) {
+			return watermark.AddCombinedWatermarkStream(ctx, r, w, f, text, *positionText, opts...)
 		}
 	default:
 		fmt.Fprintln(os.Stderr, "unsupported mode:", *mode)
 		os.Exit(2)
 	}
+
+	ctx, stop := drainOnSignal(*drainTimeout)
+	defer stop()
+
+	var res *watermark.Result
+	switch {
+	case card != nil:
+		res, err = card.run(func(r io.Reader, w io.Writer, f watermark.Format) (*watermark.Result, error) {
+			return runStream(ctx, r, w, f, *text, opts...)
+		})
+	case streaming:
+		res, err = withStreams(*input, *output, func(r io.Reader, w io.Writer) (*watermark.Result, error) {
+			return runStream(ctx, r, w, format, *text, opts...)
+		})
+	default:
+		res, err = run(ctx, *input, *output, *text, opts...)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		stop()
+		os.Exit(1)
+	}
+	reportSalvage(res, *input)
+	for _, path := range res.Variants {
+		fmt.Println(path)
+	}
+	if *jpegProof != "" && res.Proof != nil {
+		if err := watermark.SaveImage(res.Proof, *jpegProof, bg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "JPEG proof: %.0f%% of the mark's fine detail survives\n", res.ProofDetail*100)
+	}
+	if *coverageMap != "" && res.CoverageMap != nil {
+		if err := watermark.SaveImage(res.CoverageMap, *coverageMap, bg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "coverage: the mark changes %.1f%% of the image\n", res.Coverage*100)
+	}
+}
+
+// drainOnSignal returns a context that is cancelled timeout after the first
+// SIGINT/SIGTERM, or right away on a second one, so the image in flight can
+// still be written out on a normal shutdown.
+func drainOnSignal(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			fmt.Fprintf(os.Stderr, "received %s; finishing current image (up to %s, repeat to abort)\n", sig, timeout)
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-sigs:
+		case <-time.After(timeout):
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+func printPalettes() {
+	for _, name := range watermark.PaletteNames() {
+		colors, _ := watermark.Palette(name)
+		fmt.Println(name)
+		for _, c := range colors {
+			fmt.Printf("  %-32s %s\n", name+":"+c.Name, c.Hex)
+		}
+	}
+}
+
+func reportSalvage(res *watermark.Result, input string) {
+	if input == "-" {
+		input = "stdin"
+	}
+	if res.Salvaged {
+		fmt.Fprintf(os.Stderr, "warning: %s is damaged; output was made from the salvaged part\n", input)
+	}
+}
+
+// outputFormat resolves -format, falling back to the -out extension. An
+// explicit format wins over the extension of an output file, which must
+// still name an image format unless force is set.
+func outputFormat(output, explicit string, force bool) (watermark.Format, error) {
+	if explicit == "" {
+		if output == "-" {
+			return "", errors.New("-out - requires -format")
+		}
+		return watermark.FormatFromPath(output)
+	}
+	format, err := watermark.ParseFormat(explicit)
+	if err != nil {
+		return "", err
+	}
+	if output != "-" && !force {
+		if err := watermark.CheckFormat(output, format); err != nil {
+			return "", fmt.Errorf("%w (use -force-format to write it anyway)", err)
+		}
+	}
+	return format, nil
+}
+
+// withStreams runs fn with input and output opened as streams, where "-"
+// means stdin or stdout. Output files are written to a temporary file and
+// renamed into place only on success.
+func withStreams(input, output string, fn func(io.Reader, io.Writer) (*watermark.Result, error)) (*watermark.Result, error) {
+	var r io.Reader = os.Stdin
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return withOutput(output, func(w io.Writer) (*watermark.Result, error) { return fn(r, w) })
+}
+
+// withOutput runs fn with output opened as a stream like withStreams.
+func withOutput(output string, fn func(io.Writer) (*watermark.Result, error)) (*watermark.Result, error) {
+	if output == "-" {
+		return fn(os.Stdout)
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return nil, err
+	}
+	res, err := fn(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), output)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
-func validateRequired(input, output, text string) error {
+func validateRequired(input, output, text string, textOptional bool) error {
 	if strings.TrimSpace(input) == "" {
 		return errors.New("missing -in")
 	}
 	if strings.TrimSpace(output) == "" {
 		return errors.New("missing -out")
 	}
-	if strings.TrimSpace(text) == "" {
+	if strings.TrimSpace(text) == "" && !textOptional {
 		return errors.New("missing -text")
 	}
 	return nil
 }
 
+// splitFonts splits a -font list into the font and its fallbacks.
+func splitFonts(raw string) (string, []string) {
+	if !strings.Contains(raw, ",") {
+		return raw, nil
+	}
+	var paths []string
+	for _, p := range strings.Split(raw, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return "", nil
+	}
+	return paths[0], paths[1:]
+}
+
+// parseShadow parses dx,dy,blur,color.
+func parseShadow(raw string) (dx, dy int, blur float64, col string, err error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return 0, 0, 0, "", errors.New("expected format dx,dy,blur,color")
+	}
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if dx, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, 0, "", fmt.Errorf("invalid dx: %q", parts[0])
+	}
+	if dy, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, 0, "", fmt.Errorf("invalid dy: %q", parts[1])
+	}
+	if blur, err = strconv.ParseFloat(parts[2], 64); err != nil {
+		return 0, 0, 0, "", fmt.Errorf("invalid blur: %q", parts[2])
+	}
+	return dx, dy, blur, parts[3], nil
+}
+
+// parseColorJitter parses hue,lightness.
+func parseColorJitter(raw string) (hue, lightness float64, err error) {
+	h, l, ok := strings.Cut(raw, ",")
+	if !ok {
+		return 0, 0, errors.New("expected format hue,lightness")
+	}
+	if hue, err = strconv.ParseFloat(strings.TrimSpace(h), 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid hue: %q", h)
+	}
+	if lightness, err = strconv.ParseFloat(strings.TrimSpace(l), 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid lightness: %q", l)
+	}
+	return hue, lightness, nil
+}
+
+func parseLayerStyle(raw string) (opacity float64, blend watermark.BlendMode, err error) {
+	op, mode, _ := strings.Cut(raw, ",")
+	if opacity, err = strconv.ParseFloat(strings.TrimSpace(op), 64); err != nil {
+		return 0, "", fmt.Errorf("invalid opacity: %q", op)
+	}
+	blend = watermark.BlendNormal
+	if mode != "" {
+		if blend, err = watermark.ParseBlendMode(strings.TrimSpace(mode)); err != nil {
+			return 0, "", err
+		}
+	}
+	return opacity, blend, nil
+}
+
+func parseFloats(raw string) ([]float64, error) {
+	var vals []float64
+	for _, p := range strings.Split(raw, ",") {
+		p = strings.TrimSpace(p)
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %q", p)
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
 func parseRGB(raw string) (color.NRGBA, error) {
 	parts := strings.Split(raw, ",")
 	if len(parts) != 3 {
@@ -112,3 +777,37 @@ func parseRGB(raw string) (color.NRGBA, error) {
 	}
 	return color.NRGBA{R: vals[0], G: vals[1], B: vals[2], A: 255}, nil
 }
+
+// newPlugin makes a plugin of a command line split on spaces.
+func newPlugin(command string, timeout time.Duration) *watermark.Plugin {
+	p := &watermark.Plugin{Timeout: timeout}
+	if fields := strings.Fields(command); len(fields) > 0 {
+		p.Command, p.Args = fields[0], fields[1:]
+	}
+	return p
+}
+
+// markImageRenderer renders the -mark-image file: SVG documents as vectors,
+// anything else as a raster image.
+func markImageRenderer(path string, width int, ratio float64) (watermark.MarkRenderer, error) {
+	if strings.EqualFold(filepath.Ext(path), ".svg") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := watermark.ParseSVG(data)
+		if err != nil {
+			return nil, err
+		}
+		return watermark.SVGRenderer{SVG: doc, Width: width, WidthRatio: ratio}, nil
+	}
+	src, err := watermark.OpenImageSource(path, watermark.SourceLimits{})
+	if err != nil {
+		return nil, err
+	}
+	logo, err := src.Decode()
+	if err != nil {
+		return nil, err
+	}
+	return watermark.ImageRenderer{Image: logo, Width: width}, nil
+}
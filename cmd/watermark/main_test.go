@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"watermark/pkg/watermark"
+)
+
+func TestOutputFormat(t *testing.T) {
+	for _, tc := range []struct {
+		output, format string
+		force          bool
+		want           watermark.Format
+		err            error
+	}{
+		{"x.jpg", "", false, watermark.FormatJPEG, nil},
+		{"x.webp", "", false, watermark.FormatWebP, nil},
+		{"x.jpg", "png", false, watermark.FormatPNG, nil},
+		{"x.gif", "webp", false, watermark.FormatWebP, nil},
+		{"x.txt", "png", false, "", watermark.ErrFormatMismatch},
+		{"x", "png", false, "", watermark.ErrFormatMismatch},
+		{"x.txt", "png", true, watermark.FormatPNG, nil},
+		{"-", "tiff", false, watermark.FormatTIFF, nil},
+	} {
+		got, err := outputFormat(tc.output, tc.format, tc.force)
+		if got != tc.want || !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+			t.Errorf("outputFormat(%q, %q, %t) = %q, %v, want %q, %v", tc.output, tc.format, tc.force, got, err, tc.want, tc.err)
+		}
+	}
+}
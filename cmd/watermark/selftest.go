@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"watermark/pkg/watermark"
+)
+
+// selftestCorpus holds the inputs of "watermark selftest". cmyk.jpg is
+// video-001.cmyk.jpeg from the Go image testdata; the rest are generated:
+// huge.png is 6000x4000 but compresses to a few kilobytes, truncated.jpg
+// is cut off halfway and corrupt.png is a PNG signature followed by noise.
+//
+//go:embed selftest
+var selftestCorpus embed.FS
+
+// selftestCase is an input of the corpus and the error it must fail with,
+// or nil if it must be marked.
+type selftestCase struct {
+	file string
+	want error
+	// betweenTiles is set for images small enough to fit in the gap
+	// between repeat-mode tiles, which repeat mode may leave unmarked.
+	betweenTiles bool
+}
+
+var selftestCases = []selftestCase{
+	{"gray.jpg", nil, false},
+	{"gray16.png", nil, false},
+	{"cmyk.jpg", nil, false},
+	{"transparent.png", nil, false},
+	{"huge.png", nil, false},
+	{"tiny.png", nil, true},
+	{"truncated.jpg", watermark.ErrCorruptInput, false},
+	{"corrupt.png", watermark.ErrCorruptInput, false},
+}
+
+// selftestStep is a mode and output format each case is run through, so
+// every encoder sees every input.
+type selftestStep struct {
+	mode   string
+	format watermark.Format
+	run    func(context.Context, io.Reader, io.Writer, watermark.Format, string, ...watermark.Option) (*watermark.Result, error)
+}
+
+var selftestSteps = []selftestStep{
+	{"repeat", watermark.FormatPNG, watermark.AddRepeatWatermarkStream},
+	{"position", watermark.FormatJPEG, watermark.AddPositionWatermarkStream},
+	{"repeat", watermark.FormatTIFF, watermark.AddRepeatWatermarkStream},
+}
+
+// runSelftest implements "watermark selftest": it marks each image of an
+// embedded corpus of awkward inputs in repeat and position modes and into
+// every output format, decodes the outputs again and prints a PASS or FAIL
+// line per run, so a deployment's fonts and codecs can be checked in one
+// go. Warnings such as a font fallback fail the run, and so does a mark
+// that leaves the image unchanged. It exits 1 when any
+// run fails.
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	fontPath := fs.String("font", "", "font path to check, as for the main command; a comma-separated list adds fallbacks")
+	text := fs.String("text", "© watermark selftest", "watermark text; include the scripts the deployment marks, e.g. CJK, to check the fonts cover them")
+	outDir := fs.String("out-dir", "", "also write the outputs to this directory for inspection")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if strings.TrimSpace(*fontPath) == "" && !watermark.HasEmbeddedFont() {
+		fmt.Fprintln(os.Stderr, "selftest requires -font to be set")
+		return 2
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0o755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	primary, fallbacks := splitFonts(*fontPath)
+	failed, runs := 0, 0
+	for _, c := range selftestCases {
+		data, err := selftestCorpus.ReadFile("selftest/" + c.file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, step := range selftestSteps {
+			name := fmt.Sprintf("%s %s/%s", c.file, step.mode, step.format)
+			start := time.Now()
+			out, err := selftestRun(c, step, data, *text, primary, fallbacks)
+			runs++
+			if err != nil {
+				failed++
+				fmt.Printf("FAIL %-32s %v\n", name, err)
+				continue
+			}
+			fmt.Printf("PASS %-32s %v\n", name, time.Since(start).Round(time.Millisecond))
+			if *outDir != "" && out != nil {
+				path := filepath.Join(*outDir, fmt.Sprintf("%s-%s.%s", strings.TrimSuffix(c.file, filepath.Ext(c.file)), step.mode, step.format))
+				if err := os.WriteFile(path, out, 0o644); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return 1
+				}
+			}
+		}
+	}
+	fmt.Printf("%d of %d passed\n", runs-failed, runs)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// selftestRun marks data in one step and checks that the mark changed the
+// image and that the output decodes at the size of the input, returning the
+// output. Cases that must fail return nil
+// when they fail with the error they expect.
+func selftestRun(c selftestCase, step selftestStep, data []byte, text, font string, fallbacks []string) ([]byte, error) {
+	var warnings []string
+	opts := []watermark.Option{
+		watermark.WithEventHandler(func(e watermark.Event) { warnings = append(warnings, e.Message) }),
+	}
+	if font != "" {
+		opts = append(opts, watermark.WithFont(font))
+	}
+	if len(fallbacks) > 0 {
+		opts = append(opts, watermark.WithFontFallbacks(fallbacks...))
+	}
+	var out bytes.Buffer
+	res, err := step.run(context.Background(), bytes.NewReader(data), &out, step.format, text, opts...)
+	if c.want != nil {
+		switch {
+		case err == nil:
+			return nil, fmt.Errorf("marked, want an error: %v", c.want)
+		case !errors.Is(err, c.want):
+			return nil, fmt.Errorf("got %v, want %v", err, c.want)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(warnings) > 0 {
+		return nil, fmt.Errorf("warning: %s", strings.Join(warnings, "; "))
+	}
+	in, err := watermark.NewImageSource(data, watermark.SourceLimits{})
+	if err != nil {
+		return nil, err
+	}
+	orig, err := in.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if markedPixels(orig, res.Image) == 0 && !(c.betweenTiles && step.mode == "repeat") {
+		return nil, errors.New("no mark drawn: the output matches the input")
+	}
+	src, err := watermark.NewImageSource(out.Bytes(), watermark.SourceLimits{})
+	if err != nil {
+		return nil, fmt.Errorf("output: %w", err)
+	}
+	if src.Format != step.format {
+		return nil, fmt.Errorf("output is %s, want %s", src.Format, step.format)
+	}
+	img, err := src.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("output: %w", err)
+	}
+	if b := img.Bounds(); b.Dx() != in.Width || b.Dy() != in.Height {
+		return nil, fmt.Errorf("output is %dx%d, want %dx%d", b.Dx(), b.Dy(), in.Width, in.Height)
+	}
+	return out.Bytes(), nil
+}
+
+// markedPixels returns how many pixels of marked look different from orig.
+// Colors are compared premultiplied, so pixels that stay fully transparent
+// count as unchanged whatever color they hold: a mark drawn only there is
+// invisible.
+func markedPixels(orig, marked image.Image) int {
+	a, b := premultiplied(orig), premultiplied(marked)
+	if a.Rect.Size() != b.Rect.Size() {
+		return a.Rect.Dx() * a.Rect.Dy()
+	}
+	n := 0
+	for i := 0; i < len(a.Pix); i += 4 {
+		if !bytes.Equal(a.Pix[i:i+4], b.Pix[i:i+4]) {
+			n++
+		}
+	}
+	return n
+}
+
+func premultiplied(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
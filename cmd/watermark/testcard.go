@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"watermark/pkg/watermark"
+)
+
+// testCard is "watermark testcard": it takes the marking flags, config file
+// and presets of the main command, and marks a generated calibration card
+// instead of -in, once per output format, so a preset can be checked on
+// gradients and color patches before it is rolled out.
+type testCard struct {
+	size    *string
+	formats *string
+
+	width, height int
+	paths         []string
+	outFormats    []watermark.Format
+}
+
+// defineFlags adds the testcard flags to the command line.
+func (c *testCard) defineFlags() {
+	c.size = flag.String("size", "1920x1080", "testcard: size of the card as WIDTHxHEIGHT")
+	c.formats = flag.String("formats", "", "testcard: comma-separated formats to write the card in, e.g. png,jpeg,tiff, each next to -out with its own extension (default: the format of -out)")
+}
+
+// prepare checks the testcard flags and resolves the outputs: -out in its
+// format, or with -formats one file per format named after -out.
+func (c *testCard) prepare(output, explicit string, force bool) error {
+	w, h, err := parseSize(*c.size)
+	if err != nil {
+		return fmt.Errorf("invalid -size: %w", err)
+	}
+	c.width, c.height = w, h
+	if *c.formats == "" {
+		f, err := outputFormat(output, explicit, force)
+		if err != nil {
+			return err
+		}
+		c.paths, c.outFormats = []string{output}, []watermark.Format{f}
+		return nil
+	}
+	if explicit != "" {
+		return errors.New("use either -format or -formats")
+	}
+	if output == "-" {
+		return errors.New("-formats writes files, not -out -")
+	}
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	for _, name := range strings.Split(*c.formats, ",") {
+		f, err := watermark.ParseFormat(strings.TrimSpace(name))
+		if err != nil {
+			return fmt.Errorf("invalid -formats: %w", err)
+		}
+		c.paths = append(c.paths, base+"."+string(f))
+		c.outFormats = append(c.outFormats, f)
+	}
+	return nil
+}
+
+// run draws the card and marks it into each output with mark, listing the
+// files written. It returns the result of the first output.
+func (c *testCard) run(mark func(io.Reader, io.Writer, watermark.Format) (*watermark.Result, error)) (*watermark.Result, error) {
+	card, err := watermark.TestCard(c.width, c.height)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, card); err != nil {
+		return nil, err
+	}
+	var first *watermark.Result
+	for i, path := range c.paths {
+		res, err := withOutput(path, func(w io.Writer) (*watermark.Result, error) {
+			return mark(bytes.NewReader(buf.Bytes()), w, c.outFormats[i])
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if first == nil {
+			first = res
+		}
+		if path != "-" {
+			fmt.Println(path)
+		}
+	}
+	return first, nil
+}
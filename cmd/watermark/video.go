@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"watermark/pkg/watermark"
+)
+
+// runVideo implements "watermark video": it decodes a clip with ffmpeg,
+// tiles the mark over every frame and re-encodes it, copying any audio.
+// With -raw it filters raw RGBA frames from -in to -out instead, for
+// callers running their own pipeline. It returns the exit code.
+func runVideo(args []string) int {
+	fs := flag.NewFlagSet("video", flag.ContinueOnError)
+	input := fs.String("in", "", "input video path, or with -raw a raw frame file or - for stdin (required)")
+	output := fs.String("out", "", "output video path, or with -raw a raw frame file or - for stdout (required)")
+	raw := fs.String("raw", "", "read and write raw RGBA frames of this size, e.g. 1920x1080, instead of running ffmpeg")
+	ffmpeg := fs.String("ffmpeg", "ffmpeg", "ffmpeg binary")
+	ffprobe := fs.String("ffprobe", "ffprobe", "ffprobe binary, used to read the frame size and rate")
+	text := fs.String("text", "", "watermark text; \\n starts a new line")
+	fontPath := fs.String("font", "", "font path (.ttf/.otf); a comma-separated list adds fallback fonts")
+	colorHex := fs.String("color", "#4db6ac", "watermark color hex")
+	opacity := fs.Float64("opacity", 0.5, "opacity 0..1")
+	fontSize := fs.Int("font-size", 48, "font size")
+	space := fs.Int("space", 75, "spacing between tiles")
+	angle := fs.Int("angle", 30, "rotation angle")
+	markImage := fs.String("mark-image", "", "tile this image (e.g. a logo, .svg included) instead of rendering -text")
+	markImageWidth := fs.Int("mark-image-width", 0, "width in pixels to scale -mark-image to (0 = natural size)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *input == "" || *output == "" || (*text == "" && *markImage == "") {
+		fmt.Fprintln(os.Stderr, "missing -in, -out or -text")
+		fs.Usage()
+		return 2
+	}
+
+	mainFont, fallbackFonts := splitFonts(*fontPath)
+	wmArgs := watermark.WatermarkArgs{
+		Mark:           strings.ReplaceAll(*text, `\n`, "\n"),
+		Color:          *colorHex,
+		Space:          *space,
+		Angle:          *angle,
+		FontFamily:     mainFont,
+		FontFallbacks:  fallbackFonts,
+		FontHeightCrop: 1,
+		Size:           *fontSize,
+		Opacity:        *opacity,
+		Logger:         log.Default(),
+	}
+	if *markImage != "" {
+		r, err := markImageRenderer(*markImage, *markImageWidth, 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -mark-image:", err)
+			return 2
+		}
+		wmArgs.Renderer = r
+		if wmArgs.Mark == "" {
+			wmArgs.Mark = *markImage
+		}
+	}
+	wm, err := watermark.NewWatermarker(wmArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	if *raw != "" {
+		w, h, err := parseSize(*raw)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -raw:", err)
+			return 2
+		}
+		in, out := io.Reader(os.Stdin), io.Writer(os.Stdout)
+		if *input != "-" {
+			f, err := os.Open(*input)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			in = f
+		}
+		if *output != "-" {
+			f, err := os.Create(*output)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			out = f
+		}
+		if _, err := wm.MarkFrames(ctx, in, out, w, h); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	w, h, rate, err := probeVideo(*ffprobe, *input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	n, err := transcodeVideo(ctx, *ffmpeg, *input, *output, w, h, rate, wm)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "marked %d frames\n", n)
+	return 0
+}
+
+// probeVideo reads the frame size and rate of the first video stream. The
+// size is that of the frames ffmpeg decodes, which it turns upright, so
+// width and height swap for clips rotated by 90 or 270 degrees.
+func probeVideo(ffprobe, path string) (w, h int, rate string, err error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(ffprobe, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height,r_frame_rate:stream_tags=rotate:stream_side_data=rotation",
+		"-of", "json", path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("ffprobe: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	w, h, rate, err = parseProbe(out)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("ffprobe: %s: %w", path, err)
+	}
+	return w, h, rate, nil
+}
+
+// parseProbe reads the JSON output of probeVideo's ffprobe. The rotation
+// is in the display matrix side data, or in a rotate tag from older
+// ffmpeg versions.
+func parseProbe(out []byte) (w, h int, rate string, err error) {
+	var probe struct {
+		Streams []struct {
+			Width      int    `json:"width"`
+			Height     int    `json:"height"`
+			RFrameRate string `json:"r_frame_rate"`
+			Tags       struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+			SideData []struct {
+				Rotation float64 `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return 0, 0, "", fmt.Errorf("unexpected output %q", out)
+	}
+	if len(probe.Streams) == 0 {
+		return 0, 0, "", errors.New("no video stream")
+	}
+	s := probe.Streams[0]
+	if s.Width <= 0 || s.Height <= 0 {
+		return 0, 0, "", fmt.Errorf("unexpected output %q", out)
+	}
+	rotation, _ := strconv.ParseFloat(s.Tags.Rotate, 64)
+	for _, sd := range s.SideData {
+		if sd.Rotation != 0 {
+			rotation = sd.Rotation
+		}
+	}
+	w, h = s.Width, s.Height
+	if int(math.Abs(math.Round(rotation)))%180 == 90 {
+		w, h = h, w
+	}
+	return w, h, s.RFrameRate, nil
+}
+
+// transcodeVideo pipes the frames of input from one ffmpeg through wm into
+// another that encodes output, taking the audio from input. Frames of odd
+// width or height get a row or column of padding.
+func transcodeVideo(ctx context.Context, ffmpeg, input, output string, w, h int, rate string, wm *watermark.Watermarker) (int, error) {
+	var decErr, encErr bytes.Buffer
+	dec := exec.CommandContext(ctx, ffmpeg, "-v", "error", "-i", input,
+		"-map", "0:v:0", "-f", "rawvideo", "-pix_fmt", "rgba", "-")
+	dec.Stderr = &decErr
+	enc := exec.CommandContext(ctx, ffmpeg, "-v", "error", "-y",
+		"-f", "rawvideo", "-pix_fmt", "rgba", "-s", fmt.Sprintf("%dx%d", w, h), "-r", rate, "-i", "-",
+		"-i", input, "-map", "0:v:0", "-map", "1:a?", "-c:a", "copy",
+		// yuv420p needs an even width and height.
+		"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2", "-pix_fmt", "yuv420p", output)
+	enc.Stderr = &encErr
+
+	frames, err := dec.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	sink, err := enc.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := dec.Start(); err != nil {
+		return 0, fmt.Errorf("start ffmpeg: %w", err)
+	}
+	if err := enc.Start(); err != nil {
+		dec.Process.Kill()
+		dec.Wait()
+		return 0, fmt.Errorf("start ffmpeg: %w", err)
+	}
+
+	n, markErr := wm.MarkFrames(ctx, frames, sink, w, h)
+	sink.Close()
+	if markErr != nil {
+		dec.Process.Kill()
+	}
+	decWaitErr := dec.Wait()
+	encWaitErr := enc.Wait()
+	switch {
+	case decWaitErr != nil && markErr == nil:
+		return n, fmt.Errorf("decode %s: %w: %s", input, decWaitErr, strings.TrimSpace(decErr.String()))
+	case encWaitErr != nil:
+		return n, fmt.Errorf("encode %s: %w: %s", output, encWaitErr, strings.TrimSpace(encErr.String()))
+	case markErr != nil:
+		return n, markErr
+	}
+	return n, nil
+}
+
+// parseSize parses a size like "1920x1080".
+func parseSize(s string) (int, int, error) {
+	ws, hs, ok := strings.Cut(strings.ToLower(s), "x")
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(hs)
+	if !ok || err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("size must be WIDTHxHEIGHT, got %q", s)
+	}
+	return w, h, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestParseProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name, out string
+		w, h      int
+	}{
+		{"plain", `{"streams": [{"width": 1920, "height": 1080, "r_frame_rate": "30/1"}]}`, 1920, 1080},
+		{"side data", `{"streams": [{"width": 1920, "height": 1080, "r_frame_rate": "30/1",
+			"side_data_list": [{"rotation": -90}]}]}`, 1080, 1920},
+		{"tag", `{"streams": [{"width": 1920, "height": 1080, "r_frame_rate": "30/1", "tags": {"rotate": "270"}}]}`, 1080, 1920},
+		{"upside down", `{"streams": [{"width": 1920, "height": 1080, "r_frame_rate": "30/1",
+			"side_data_list": [{"rotation": 180}]}]}`, 1920, 1080},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w, h, rate, err := parseProbe([]byte(tc.out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w != tc.w || h != tc.h || rate != "30/1" {
+				t.Errorf("got %dx%d at %s, want %dx%d at 30/1", w, h, rate, tc.w, tc.h)
+			}
+		})
+	}
+	if _, _, _, err := parseProbe([]byte(`{"streams": []}`)); err == nil {
+		t.Error("no error without a video stream")
+	}
+}
@@ -4,7 +4,11 @@ go 1.21
 
 require (
 	github.com/disintegration/imaging v1.6.2
+	github.com/go-text/typesetting v0.2.1
+	go.opentelemetry.io/otel v1.29.0
+	go.opentelemetry.io/otel/trace v1.29.0
 	golang.org/x/image v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/text v0.14.0 // indirect
+require golang.org/x/text v0.14.0
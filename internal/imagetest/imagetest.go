@@ -0,0 +1,37 @@
+// Package imagetest builds the synthetic images the tests and benchmarks of
+// this module share.
+package imagetest
+
+import (
+	"image"
+	"math/rand"
+)
+
+// RandomMark returns a w×h image at origin min, transparent but for n
+// random pixels of random color.
+func RandomMark(rng *rand.Rand, min image.Point, w, h, n int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rectangle{Min: min, Max: min.Add(image.Pt(w, h))})
+	for i := 0; i < n; i++ {
+		o := img.PixOffset(min.X+rng.Intn(w), min.Y+rng.Intn(h))
+		rng.Read(img.Pix[o : o+3])
+		img.Pix[o+3] = uint8(1 + rng.Intn(255))
+	}
+	return img
+}
+
+// TextLine returns a 4000x1000 mark: a band of glyph-like strokes with
+// transparent margins, like a large rendered text line.
+func TextLine() *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 4000, 1000))
+	for x := 200; x < 3800; x += 40 {
+		top, bottom := 150+rng.Intn(100), 750+rng.Intn(100)
+		for y := top; y < bottom; y++ {
+			for dx := 0; dx < 12; dx++ {
+				o := img.PixOffset(x+dx, y)
+				img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = 200, 40, 40, 255
+			}
+		}
+	}
+	return img
+}
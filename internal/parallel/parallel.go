@@ -0,0 +1,45 @@
+// Package parallel runs per-row image work on several goroutines for the
+// packages of this module.
+package parallel
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// minBandRows keeps bands tall enough that handing one to a goroutine is
+// worth it; small marks and images are done on the calling goroutine.
+const minBandRows = 64
+
+// Rows splits r into horizontal bands, at most one per worker, and
+// calls fn on each concurrently. workers <= 0 means runtime.GOMAXPROCS(0).
+// It returns the first error fn returns; the other bands still finish.
+func Rows(workers int, r image.Rectangle, fn func(band image.Rectangle) error) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	n := min(workers, r.Dy()/minBandRows)
+	if n <= 1 {
+		return fn(r)
+	}
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		band := r
+		band.Min.Y = r.Min.Y + r.Dy()*i/n
+		band.Max.Y = r.Min.Y + r.Dy()*(i+1)/n
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fn(band)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"watermark/pkg/codec"
+	"watermark/pkg/compose"
+)
+
+// checkAlpha returns ErrAlphaLost if the output was marked with
+// WithPreserveAlpha, has transparent pixels and format cannot store them.
+// An empty format stands for the formats saveImage flattens by extension.
+func (o *output) checkAlpha(format codec.Format) error {
+	if !o.preserveAlpha || o.img == nil || compose.IsOpaque(o.img) {
+		return nil
+	}
+	switch format {
+	case codec.FormatPNG, codec.FormatTIFF, codec.FormatICO, codec.FormatWebP, codec.FormatPDF:
+		return nil
+	}
+	name := string(format)
+	if name == "" {
+		name = "this"
+	}
+	return fmt.Errorf("%w: the image has transparency and %s format would flatten it; write PNG, TIFF, ICO or WebP", ErrAlphaLost, name)
+}
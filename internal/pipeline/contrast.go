@@ -0,0 +1,18 @@
+package pipeline
+
+import (
+	"image"
+	"image/color"
+
+	"watermark/pkg/render"
+)
+
+// checkCVDContrast warns when mark, blended over the mean color of img
+// within r, is hard to tell from that background for typical vision or any
+// of the simulated color-vision deficiencies.
+func checkCVDContrast(n notifier, img image.Image, r image.Rectangle, mark color.NRGBA) {
+	if vision, d, low := render.LowContrast(img, r, mark); low {
+		n.warn(EventLowContrast, "mark color #%02x%02x%02x has low contrast against the image for %s (ΔE %.1f < %.0f)",
+			mark.R, mark.G, mark.B, vision, d, render.MinDeltaE)
+	}
+}
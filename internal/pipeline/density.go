@@ -0,0 +1,53 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"go.opentelemetry.io/otel/attribute"
+
+	"watermark/pkg/codec"
+)
+
+// densityPath names the variant of path at scale: path itself at 1x,
+// otherwise with "@<scale>x" before the extension.
+func densityPath(path string, scale int) string {
+	if scale == 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s@%dx%s", strings.TrimSuffix(path, ext), scale, ext)
+}
+
+// saveDensities writes the variants of out, which is at the largest scale,
+// next to path, largest first, and returns their paths.
+func saveDensities(ctx context.Context, out *output, path string, cfg *Settings) ([]string, error) {
+	if out.pdf != nil || out.icon != nil {
+		return nil, fmt.Errorf("%w: density variants need a raster image input", codec.ErrUnsupportedFormat)
+	}
+	top := cfg.Densities[0]
+	b := out.img.Bounds()
+	paths := make([]string, len(cfg.Densities))
+	for i, scale := range cfg.Densities {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		v := *out
+		if scale != top {
+			w := max(1, (b.Dx()*scale+top/2)/top)
+			h := max(1, (b.Dy()*scale+top/2)/top)
+			v.img = imaging.Resize(out.img, w, h, imaging.Lanczos)
+		}
+		paths[i] = densityPath(path, scale)
+		_, span := cfg.startSpan(ctx, "encode", attribute.String("path", paths[i]), attribute.Int("scale", scale))
+		err := v.save(paths[i], cfg.Format, cfg.ForceFormat)
+		endSpan(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("write @%dx output: %w", scale, err)
+		}
+	}
+	return paths, nil
+}
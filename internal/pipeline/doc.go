@@ -0,0 +1,7 @@
+// Package pipeline implements package watermark: Settings built from
+// options, and the decoding, marking and encoding of images, PDFs, icons
+// and videos they drive, with the events, tracing and checks around them.
+//
+// Its names are exported for package watermark, which re-exports the ones
+// callers need; nothing outside this module can import it.
+package pipeline
@@ -0,0 +1,13 @@
+package pipeline
+
+import "errors"
+
+// Sentinel errors of the pipeline, in addition to those of packages codec,
+// render and invisible it passes on.
+var (
+	// ErrTooManyTiles means repeat mode would paste more tiles than allowed.
+	ErrTooManyTiles = errors.New("too many tiles")
+	// ErrAlphaLost means WithPreserveAlpha is set and the output format
+	// cannot store the transparency of the image.
+	ErrAlphaLost = errors.New("output format cannot keep transparency")
+)
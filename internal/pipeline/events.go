@@ -0,0 +1,54 @@
+package pipeline
+
+import "fmt"
+
+// Logger receives diagnostic messages. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// EventKind identifies a warning raised while watermarking.
+type EventKind string
+
+const (
+	// EventEmptyMark means the rendered mark has no visible pixels.
+	EventEmptyMark EventKind = "empty-mark"
+	// EventFontFallback means the requested font could not be loaded and a
+	// fallback font was used instead.
+	EventFontFallback EventKind = "font-fallback"
+	// EventInvisible means the result is identical to the source, as
+	// WithVerify checks, or that a video pattern is empty.
+	EventInvisible EventKind = "invisible"
+	// EventLowContrast means WithCVDCheck found the mark color hard to tell
+	// from the image for typical or color-deficient vision.
+	EventLowContrast EventKind = "low-contrast"
+	// EventJPEGLoss means WithJPEGProof found that JPEG compression destroys
+	// most of the mark's fine detail.
+	EventJPEGLoss EventKind = "jpeg-loss"
+)
+
+// Event is a warning raised while watermarking.
+type Event struct {
+	Kind    EventKind
+	Message string
+}
+
+// notifier forwards warnings to an optional Logger and event callback. The
+// zero value drops them.
+type notifier struct {
+	logger  Logger
+	onEvent func(Event)
+}
+
+func (n notifier) warn(kind EventKind, format string, v ...interface{}) {
+	if n.logger == nil && n.onEvent == nil {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	if n.logger != nil {
+		n.logger.Printf("%s", msg)
+	}
+	if n.onEvent != nil {
+		n.onEvent(Event{Kind: kind, Message: msg})
+	}
+}
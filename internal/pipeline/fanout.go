@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"watermark/pkg/codec"
+	"watermark/pkg/fanout"
+	"watermark/pkg/render"
+)
+
+// FanoutConfig describes the copies Fanout makes.
+type FanoutConfig struct {
+	// Recipients get one copy each, in order.
+	Recipients []string
+	// Text is the visible mark, placed like position mode. {recipient} and
+	// {serial} are filled in along with the other template variables. Empty
+	// adds no visible mark.
+	Text string
+	// Invisible also embeds a robust invisible mark with a random key per
+	// copy, see AddRobustWatermark.
+	Invisible bool
+	// Fingerprint hides each copy's serial in the visible text, see
+	// WithFingerprint.
+	Fingerprint bool
+}
+
+// Fanout writes one uniquely marked copy of the image at inputPath per
+// recipient into outputDir, for tracing leaks back to their source, and
+// records who got which in outputDir/manifest.json. Copies are named after
+// the input and their serial, keep the input's extension unless WithFormat
+// is given, and do not reveal the recipient in their name. An existing
+// manifest is never overwritten. If a copy fails or ctx is cancelled, the
+// copies already written are removed and no manifest is written.
+func Fanout(ctx context.Context, inputPath, outputDir string, fc FanoutConfig, opts ...Option) (m *fanout.Manifest, err error) {
+	if len(fc.Recipients) == 0 {
+		return nil, errors.New("fanout needs at least one recipient")
+	}
+	if fc.Text == "" && !fc.Invisible {
+		return nil, fmt.Errorf("%w: fanout needs visible text, an invisible mark or both", render.ErrEmptyMark)
+	}
+	cfg, err := NewSettings(opts)
+	if err != nil {
+		return nil, err
+	}
+	manifestPath := filepath.Join(outputDir, fanout.ManifestName)
+	if _, err := os.Stat(manifestPath); err == nil {
+		return nil, fmt.Errorf("%s already exists; fan out into an empty directory", manifestPath)
+	}
+	ext := filepath.Ext(inputPath)
+	base := strings.TrimSuffix(filepath.Base(inputPath), ext)
+	if cfg.Format != "" {
+		ext = "." + string(cfg.Format)
+	}
+	if err := checkOutputPath(base+ext, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Filename == "" {
+		cfg.Filename = filepath.Base(inputPath)
+	}
+	ctx, span := cfg.startSpan(ctx, "fanout")
+	defer func() { endSpan(span, err) }()
+
+	src, err := codec.OpenImageSource(inputPath, cfg.Limits)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	m = &fanout.Manifest{Source: inputPath, Created: time.Now().UTC()}
+	// A failed or cancelled run leaves no copies without a manifest.
+	var written []string
+	defer func() {
+		if err != nil {
+			for _, path := range written {
+				os.Remove(path)
+			}
+		}
+	}()
+	used := map[string]bool{}
+	for i, recipient := range fc.Recipients {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		entry := fanout.Entry{Recipient: recipient}
+		// Serials are random, so draw again on the rare repeat, or a file
+		// left in outputDir by an earlier run.
+		for {
+			entry.Serial = fanout.NewSerial()
+			entry.Path = filepath.Join(outputDir, base+"-"+entry.Serial+ext)
+			if _, err := os.Lstat(entry.Path); !used[entry.Serial] && errors.Is(err, os.ErrNotExist) {
+				break
+			}
+		}
+		used[entry.Serial] = true
+		if fc.Invisible {
+			entry.Key = fanout.NewKey()
+		}
+
+		c := *cfg
+		c.Recipient, c.Serial, c.Counter = recipient, entry.Serial, cfg.Counter+i
+		c.number()
+		if fc.Fingerprint {
+			c.Fingerprint = entry.Serial
+		}
+		out, err := process(ctx, fanoutMark(entry.Key), src, fc.Text, &c)
+		if err != nil {
+			return nil, fmt.Errorf("copy for %q: %w", recipient, err)
+		}
+		if c.OriginChecksum {
+			out.origin = codec.OriginSum(src.Bytes())
+		}
+		if err := out.save(entry.Path, c.Format, c.ForceFormat); err != nil {
+			return nil, fmt.Errorf("write copy for %q: %w", recipient, err)
+		}
+		written = append(written, entry.Path)
+		m.Copies = append(m.Copies, entry)
+	}
+
+	if err := m.Write(manifestPath); err != nil {
+		return nil, fmt.Errorf("write manifest: %w", err)
+	}
+	return m, nil
+}
+
+// fanoutMark places the visible text, if any, and then embeds the robust
+// mark of key, if any, so the visible text carries it too.
+func fanoutMark(key string) MarkFunc {
+	return func(ctx context.Context, img image.Image, text string, cfg *Settings) (image.Image, MarkStats, error) {
+		marked := img
+		if strings.TrimSpace(text) != "" {
+			var err error
+			if marked, _, err = PositionMark(ctx, marked, text, cfg); err != nil {
+				return nil, MarkStats{}, err
+			}
+		}
+		if key != "" {
+			return RobustMark(ctx, marked, key, cfg)
+		}
+		return marked, MarkStats{}, nil
+	}
+}
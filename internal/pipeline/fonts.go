@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/image/font/gofont/goregular"
+
+	"watermark/pkg/render"
+)
+
+// fontWithFallback returns the font at path or, if it cannot be loaded, the
+// embedded font, Arial or Go Regular, warning about the fallback. It also
+// returns a name for messages.
+func fontWithFallback(path string, notify notifier) (*render.Font, string, error) {
+	if strings.TrimSpace(path) != "" {
+		f, err := render.LoadFont(path)
+		if err == nil {
+			return f, render.FontName(path), nil
+		}
+		notify.warn(EventFontFallback, "failed to load font %q, falling back to the default font: %v", render.FontName(path), err)
+	}
+	if render.HasDefaultFont() {
+		f, err := render.LoadFont("")
+		return f, "embedded font", err
+	}
+	if strings.TrimSpace(path) == "" {
+		if arial := firstExistingFontPath([]string{
+			"arial.ttf",
+			"/Library/Fonts/Arial.ttf",
+			"/System/Library/Fonts/Supplemental/Arial.ttf",
+			"C:\\\\Windows\\\\Fonts\\\\arial.ttf",
+			"/usr/share/fonts/truetype/msttcorefonts/Arial.ttf",
+			"/usr/share/fonts/truetype/msttcorefonts/arial.ttf",
+		}); arial != "" {
+			f, err := render.LoadFont(arial)
+			if err == nil {
+				return f, arial, nil
+			}
+			notify.warn(EventFontFallback, "failed to load fallback Arial font %q, using Go Regular: %v", arial, err)
+		}
+	}
+	f, err := render.BuiltinFont("Go Regular", goregular.TTF)
+	return f, "Go Regular", err
+}
+
+func firstExistingFontPath(candidates []string) string {
+	for _, p := range candidates {
+		if p == "" {
+			continue
+		}
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// loadFallbackFonts returns the fonts at paths, warning about and leaving
+// out those that cannot be loaded.
+func loadFallbackFonts(paths []string, notify notifier) []*render.Font {
+	fonts := make([]*render.Font, 0, len(paths))
+	for _, p := range paths {
+		f, err := render.LoadFont(p)
+		if err != nil {
+			notify.warn(EventFontFallback, "failed to load fallback font %q, leaving it out: %v", render.FontName(p), err)
+			continue
+		}
+		fonts = append(fonts, f)
+	}
+	return fonts
+}
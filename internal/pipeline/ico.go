@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"watermark/pkg/codec"
+)
+
+// processICO marks every image of an ICO file at least cfg.icoMinSize on its
+// shorter side and leaves smaller ones, where text would be illegible, as
+// they are; the largest image is always marked. Sizes are scaled from the
+// largest image, so the mark covers the same share of every icon.
+func processICO(ctx context.Context, mark MarkFunc, data []byte, text string, cfg *Settings) (*output, error) {
+	entries, err := codec.DecodeICO(data)
+	if err != nil {
+		return nil, codec.DecodeError(err)
+	}
+	largest := 0
+	for i, e := range entries {
+		if side(e.Image) > side(entries[largest].Image) {
+			largest = i
+		}
+	}
+	ref := side(entries[largest].Image)
+
+	out := &output{background: cfg.JPGBackground, encoding: cfg.Encoding, icon: entries, preserveAlpha: cfg.PreserveAlpha, workers: cfg.Workers}
+	if cfg.CleanPath != "" {
+		out.clean = &output{
+			img:        entries[largest].Image,
+			background: cfg.JPGBackground,
+			encoding:   cfg.Encoding,
+			icon:       append([]codec.ICOEntry(nil), entries...),
+			workers:    cfg.Workers,
+		}
+	}
+	for i, e := range entries {
+		if side(e.Image) < cfg.ICOMinSize && i != largest {
+			continue
+		}
+		marked, stats, err := markImage(ctx, mark, e.Image, text, cfg.scaled(float64(side(e.Image))/float64(ref)))
+		if err != nil {
+			return nil, err
+		}
+		if out.icon[i], err = codec.NewICOEntry(marked); err != nil {
+			return nil, err
+		}
+		if i == largest {
+			out.img, out.tiles = marked, stats.Tiles
+		}
+	}
+	return out, nil
+}
+
+func side(img image.Image) int {
+	return min(img.Bounds().Dx(), img.Bounds().Dy())
+}
+
+// scaled returns a copy of s with pixel sizes multiplied by f, for marking
+// a downsized variant of an image.
+func (s *Settings) scaled(f float64) *Settings {
+	c := *s
+	if f == 1 {
+		return &c
+	}
+	scale := func(v int) int {
+		if v == 0 {
+			return 0
+		}
+		return max(int(math.Round(float64(v)*f)), 1)
+	}
+	c.FontSize = scale(s.FontSize)
+	c.Space = scale(s.Space)
+	c.PositionFontSize = scale(s.PositionFontSize)
+	c.ShiftX = int(math.Round(float64(s.ShiftX) * f))
+	c.ShiftY = int(math.Round(float64(s.ShiftY) * f))
+	c.OutlineWidth = s.OutlineWidth * f
+	return &c
+}
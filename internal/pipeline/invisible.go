@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+
+	"watermark/pkg/codec"
+	"watermark/pkg/invisible"
+	"watermark/pkg/render"
+)
+
+// CheckLossless rejects output formats that would not keep the embedded
+// bits. path is consulted for formats only imaging knows, such as BMP.
+func CheckLossless(format codec.Format, path string) error {
+	switch format {
+	case codec.FormatPNG, codec.FormatTIFF, codec.FormatWebP:
+		return nil
+	case "":
+		if f, err := imaging.FormatFromFilename(path); err == nil && f == imaging.BMP {
+			return nil
+		}
+	}
+	name := string(format)
+	if name == "" {
+		name = filepath.Ext(path)
+	}
+	return fmt.Errorf("%w: invisible watermarks need a lossless PNG, TIFF or BMP output, not %q", codec.ErrUnsupportedFormat, name)
+}
+
+// checkExact rejects settings that would rewrite the pixels of an
+// invisible mark after it is embedded, as the payload lives in their lowest
+// bits.
+func checkExact(cfg *Settings) error {
+	var rewrite string
+	switch {
+	case cfg.Stencil:
+		rewrite = "a stencil"
+	case cfg.Bilevel:
+		rewrite = "bilevel output"
+	case len(cfg.Densities) > 0:
+		rewrite = "density variants"
+	case cfg.Encoding.PNGColor == codec.PNGColorGray:
+		rewrite = "grayscale PNG output"
+	default:
+		return nil
+	}
+	return fmt.Errorf("invisible watermarks cannot be combined with %s, which rewrites the pixels holding the payload", rewrite)
+}
+
+// InvisibleMark embeds text as the payload of an invisible LSB mark. The
+// pipeline passes it the payload as given, without template variables,
+// plugins or transforms.
+func InvisibleMark(_ context.Context, img image.Image, text string, _ *Settings) (image.Image, MarkStats, error) {
+	if text == "" {
+		return nil, MarkStats{}, render.ErrEmptyMark
+	}
+	marked := imaging.Clone(img)
+	if err := invisible.Embed(marked, []byte(text)); err != nil {
+		return nil, MarkStats{}, err
+	}
+	return marked, MarkStats{}, nil
+}
+
+// RobustMark embeds the robust invisible mark of key.
+func RobustMark(_ context.Context, img image.Image, key string, cfg *Settings) (image.Image, MarkStats, error) {
+	if key == "" {
+		return nil, MarkStats{}, render.ErrEmptyMark
+	}
+	return invisible.EmbedRobust(img, key, cfg.RobustStrength), MarkStats{}, nil
+}
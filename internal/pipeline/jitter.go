@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"watermark/pkg/render"
+)
+
+// jittered returns a copy of cfg whose colors are shifted for the input
+// data, or cfg itself without WithColorJitter. Colors position mode picks
+// for itself are shifted where it picks them.
+func (cfg *Settings) jittered(data []byte) (*Settings, error) {
+	if cfg.ColorJitter == nil {
+		return cfg, nil
+	}
+	c := *cfg
+	c.ColorShift = cfg.ColorJitter.Shift(data)
+	col, err := render.ParseHexColor(cfg.Color)
+	if err != nil {
+		return nil, err
+	}
+	col = c.ColorShift.Apply(col)
+	c.Color = fmt.Sprintf("#%02x%02x%02x%02x", col.R, col.G, col.B, col.A)
+	if cfg.FillColor != nil {
+		fill := c.ColorShift.Apply(*cfg.FillColor)
+		c.FillColor = &fill
+	}
+	if cfg.OutlineColor != nil {
+		outline := c.ColorShift.Apply(*cfg.OutlineColor)
+		c.OutlineColor = &outline
+	}
+	if cfg.Shadow != nil {
+		sh := *cfg.Shadow
+		sh.Color = c.ColorShift.Apply(sh.Color)
+		c.Shadow = &sh
+	}
+	return &c, nil
+}
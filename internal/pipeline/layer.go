@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+
+	"watermark/pkg/compose"
+)
+
+// Layer names one of the marks combined mode stacks.
+type Layer string
+
+const (
+	// LayerTiles is the repeated text or mark image.
+	LayerTiles Layer = "tiles"
+	// LayerPosition is the single positioned mark.
+	LayerPosition Layer = "position"
+)
+
+// Valid reports whether l is a known layer.
+func (l Layer) Valid() bool {
+	return l == LayerTiles || l == LayerPosition
+}
+
+// LayerStyle is the opacity and blend mode set for a combined-mode layer.
+type LayerStyle struct {
+	Opacity float64
+	Blend   compose.BlendMode
+}
+
+// layers returns the combined-mode layers bottom first.
+func (s *Settings) layers() []Layer {
+	if s.LayerOrder != nil {
+		return s.LayerOrder
+	}
+	return []Layer{LayerTiles, LayerPosition}
+}
+
+// layerCanvas returns where a placed mark is drawn: img itself, or a
+// transparent canvas of its size when a layer is rendered for blending or
+// composited in linear light.
+func layerCanvas(img *image.NRGBA, cfg *Settings) *image.NRGBA {
+	if cfg.LayerOnly || cfg.LinearBlend {
+		return image.NewNRGBA(img.Bounds())
+	}
+	return img
+}
+
+// finishLayer returns what a mark drawn onto the layerCanvas dst of img
+// comes to: dst itself, or dst composited onto img in linear light.
+func finishLayer(ctx context.Context, img image.Image, dst *image.NRGBA, cfg *Settings) (image.Image, error) {
+	if cfg.LayerOnly || !cfg.LinearBlend {
+		return dst, nil
+	}
+	return compose.Blend(ctx, img, dst, compose.BlendNormal, true, cfg.Workers)
+}
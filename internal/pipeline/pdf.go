@@ -0,0 +1,277 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"watermark/pkg/codec"
+	"watermark/pkg/compose"
+	"watermark/pkg/pdf"
+	"watermark/pkg/render"
+)
+
+// processPDF stamps text on the pages of the PDF in data selected by
+// WithPDFPages, as vector text in an embedded subset of the font, and returns
+// the document with the stamps appended as an incremental update. Only
+// repeat and position modes are supported. Sizes, spacing and margins in
+// pixels are taken as points; position mode draws black text unless
+// WithFillColor is set, and an outline only if WithOutlineColor is.
+func processPDF(ctx context.Context, data []byte, text string, cfg *Settings) (*output, error) {
+	if cfg.Renderer != nil || (cfg.Mode != "repeat" && cfg.Mode != "position") {
+		return nil, fmt.Errorf("%w: PDF inputs support only repeat and position text watermarks", codec.ErrUnsupportedFormat)
+	}
+	if cfg.PlacePlugin != nil {
+		return nil, fmt.Errorf("%w: placement plugins work in pixels and do not support PDF inputs", codec.ErrUnsupportedFormat)
+	}
+	_, decSpan := cfg.startSpan(ctx, "decode", attribute.Int("bytes", len(data)))
+	doc, err := pdf.Parse(data)
+	var pages []pdf.Page
+	if err == nil {
+		pages, err = doc.Pages()
+	}
+	if err == nil {
+		decSpan.SetAttributes(attribute.Int("pages", len(pages)))
+	}
+	endSpan(decSpan, err)
+	if err != nil {
+		return nil, codec.DecodeError(err)
+	}
+
+	f, fontName, err := fontWithFallback(cfg.FontPath, cfg.notifier())
+	if err != nil {
+		return nil, err
+	}
+	pf, err := pdf.NewFont(f.Data())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", render.ErrFontLoad, fontName, err)
+	}
+
+	u := pdf.NewUpdate(doc)
+	fontRef := u.Add(nil) // filled in once all pages are laid out
+	style, err := newPDFStyle(cfg)
+	if err != nil {
+		return nil, err
+	}
+	gsRef := u.Add(pdf.Dict{"Type": pdf.Name("ExtGState"), "ca": style.FillAlpha, "CA": style.StrokeAlpha})
+	openRef := u.Add(pdf.Flate([]byte("q\n")))
+
+	out := &output{}
+	marked := 0
+	for i, page := range pages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !pdf.InPageRanges(cfg.PDFPages, i+1) {
+			continue
+		}
+		c := *cfg
+		c.Page, c.Pages = i+1, len(pages)
+		w, h := page.Box[2]-page.Box[0], page.Box[3]-page.Box[1]
+		if page.Rotate%180 == 90 {
+			w, h = h, w
+		}
+		t, err := expandText(ctx, text, &c, image.Rect(0, 0, int(math.Round(w)), int(math.Round(h))))
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(t) == "" {
+			return nil, fmt.Errorf("%w: page %d", render.ErrEmptyMark, i+1)
+		}
+
+		res := pdf.CopyDict(page.Resources)
+		fonts, _ := doc.Resolve(res["Font"]).(pdf.Dict)
+		states, _ := doc.Resolve(res["ExtGState"]).(pdf.Dict)
+		fonts, states = pdf.CopyDict(fonts), pdf.CopyDict(states)
+		fontKey, gsKey := pdf.FreshName(fonts, "WmkF"), pdf.FreshName(states, "WmkGS")
+		fonts[fontKey], states[gsKey] = fontRef, gsRef
+		res["Font"], res["ExtGState"] = fonts, states
+
+		var ops bytes.Buffer
+		fmt.Fprintf(&ops, "\nQ\nq\n%s cm\n/%s gs\n", page.Matrix(), gsKey)
+		style.WriteColors(&ops)
+		var tiles int
+		if cfg.Mode == "repeat" {
+			tiles, err = writePDFRepeat(pf, &ops, fontKey, t, w, h, &c)
+		} else {
+			err = writePDFPosition(pf, &ops, fontKey, t, w, h, &c)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ops.WriteString("Q\n")
+		out.tiles += tiles
+
+		contents := pdf.Array{openRef}
+		switch v := page.Dict["Contents"].(type) {
+		case pdf.Ref:
+			if arr, ok := doc.Resolve(v).(pdf.Array); ok {
+				contents = append(contents, arr...)
+			} else {
+				contents = append(contents, v)
+			}
+		case pdf.Array:
+			contents = append(contents, v...)
+		}
+		contents = append(contents, u.Add(pdf.Flate(ops.Bytes())))
+
+		dict := pdf.CopyDict(page.Dict)
+		dict["Resources"], dict["Contents"] = res, contents
+		u.Replace(page.Ref, dict)
+		marked++
+	}
+	if marked == 0 {
+		return nil, fmt.Errorf("the page range matches none of the %d pages", len(pages))
+	}
+
+	pf.WriteObjects(u, fontRef)
+	out.pdf = u.Bytes()
+	if cfg.CleanPath != "" {
+		out.clean = &output{pdf: data}
+	}
+	return out, nil
+}
+
+func newPDFStyle(cfg *Settings) (*pdf.Paint, error) {
+	s := &pdf.Paint{StrokeAlpha: 1}
+	set := func(dst *[3]float64, alpha *float64, hex string) error {
+		c, err := render.ParseHexColor(hex)
+		if err != nil {
+			return err
+		}
+		c = render.ScaleAlpha(c, cfg.Opacity)
+		*dst = [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+		*alpha = float64(c.A) / 255
+		return nil
+	}
+	if cfg.Mode == "repeat" {
+		return s, set(&s.Fill, &s.FillAlpha, cfg.Color)
+	}
+	s.FillAlpha = clampFloat(cfg.Opacity, 0, 1)
+	black := cfg.ColorShift.Apply(color.NRGBA{A: 255})
+	s.Fill = [3]float64{float64(black.R) / 255, float64(black.G) / 255, float64(black.B) / 255}
+	if cfg.FillColor != nil {
+		c := render.ScaleAlpha(*cfg.FillColor, cfg.Opacity)
+		s.Fill = [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+		s.FillAlpha = float64(c.A) / 255
+	}
+	if cfg.OutlineColor != nil {
+		c := render.ScaleAlpha(*cfg.OutlineColor, cfg.Opacity)
+		s.Stroke = [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+		s.StrokeAlpha = float64(c.A) / 255
+		s.Outline, s.OutlineWidth = true, cfg.OutlineWidth
+	}
+	return s, nil
+}
+
+// textStyle is how the text of cfg is set.
+func textStyle(cfg *Settings) pdf.TextStyle {
+	return pdf.TextStyle{
+		Transform:  cfg.TextTransform,
+		LineHeight: cfg.LineHeight,
+		Align:      cfg.Align,
+		Outline:    cfg.Mode == "position" && cfg.OutlineColor != nil,
+	}
+}
+
+func clampFloat(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// writePDFPosition places one block of f like position mode places text on
+// an image of w×h pixels.
+func writePDFPosition(f *pdf.Font, buf *bytes.Buffer, key pdf.Name, text string, w, h float64, cfg *Settings) error {
+	size := float64(cfg.PositionFontSize)
+	if size == 0 {
+		size = math.Max(math.Min(w, h)*cfg.FontSizeRatio, 16)
+	}
+	st := textStyle(cfg)
+	bw, _, _, err := f.Block(key, text, size, st)
+	if err != nil {
+		return err
+	}
+	if cfg.WidthRatio > 0 && cfg.PositionFontSize == 0 {
+		size *= w * cfg.WidthRatio / bw
+	}
+	bw, bh, draw, err := f.Block(key, text, size, st)
+	if err != nil {
+		return err
+	}
+	sin, cos := math.Sincos(cfg.PositionAngle * math.Pi / 180)
+	boxW := math.Abs(bw*cos) + math.Abs(bh*sin)
+	boxH := math.Abs(bw*sin) + math.Abs(bh*cos)
+
+	// Lay out top-down like placeBox, then flip to PDF's upward y axis.
+	mt, mr, mb, ml := cfg.marginSides(w, h)
+	left, right := ml, w-boxW-mr
+	top, bottom := mt, h-boxH-mb
+	centerX, centerY := (left+right)/2, (top+bottom)/2
+	pt := map[compose.Position][2]float64{
+		compose.BottomRight:  {right, bottom},
+		compose.BottomCenter: {centerX, bottom},
+		compose.BottomLeft:   {left, bottom},
+		compose.TopRight:     {right, top},
+		compose.TopCenter:    {centerX, top},
+		compose.TopLeft:      {left, top},
+		compose.CenterRight:  {right, centerY},
+		compose.CenterLeft:   {left, centerY},
+		compose.Center:       {centerX, centerY},
+	}[cfg.Position]
+	x, y := pt[0], pt[1]
+	if cfg.Offset != nil {
+		p := cfg.Offset.Place(cfg.Anchor, int(math.Round(boxW)), int(math.Round(boxH)), int(math.Round(w)), int(math.Round(h)))
+		x, y = float64(p.X), float64(p.Y)
+	}
+	x += float64(cfg.ShiftX)
+	y += float64(cfg.ShiftY)
+
+	fmt.Fprintf(buf, "q\n%s cm\n", pdf.Nums(cos, sin, -sin, cos, x+boxW/2, h-y-boxH/2))
+	draw(buf)
+	buf.WriteString("Q\n")
+	return nil
+}
+
+// writePDFRepeat tiles blocks of f over the page like repeat mode tiles an
+// image: rows of marks spaced cfg.space apart, every other row shifted by
+// half a step, turned by cfg.angle about the page center. Tiles that would
+// fall off the page are left out. It returns the number of tiles drawn.
+func writePDFRepeat(f *pdf.Font, buf *bytes.Buffer, key pdf.Name, text string, w, h float64, cfg *Settings) (int, error) {
+	bw, bh, draw, err := f.Block(key, text, float64(cfg.FontSize), textStyle(cfg))
+	if err != nil {
+		return 0, err
+	}
+	stepX, stepY := bw+float64(cfg.Space), bh+float64(cfg.Space)
+	side := math.Hypot(w, h) + 2*math.Max(bw, bh)
+	reach := math.Hypot(bw, bh) / 2
+	sin, cos := math.Sincos(float64(cfg.Angle) * math.Pi / 180)
+
+	var tiles bytes.Buffer
+	n := 0
+	row := 0
+	for y := -side / 2; y < side/2; y += stepY {
+		x0 := -side/2 - stepX*0.5*float64(row%2)
+		row++
+		for x := x0; x < side/2; x += stepX {
+			lx, ly := x+bw/2, -(y + bh/2)
+			cx, cy := w/2+lx*cos-ly*sin, h/2+lx*sin+ly*cos
+			if cx+reach < 0 || cx-reach > w || cy+reach < 0 || cy-reach > h {
+				continue
+			}
+			n++
+			if cfg.MaxTiles > 0 && n > cfg.MaxTiles {
+				return 0, fmt.Errorf("%w: more than %d tiles on a page; increase the spacing or font size, or raise the limit", ErrTooManyTiles, cfg.MaxTiles)
+			}
+			fmt.Fprintf(&tiles, "q\n%s cm\n", pdf.Nums(cos, sin, -sin, cos, cx, cy))
+			draw(&tiles)
+			tiles.WriteString("Q\n")
+		}
+	}
+	buf.Write(tiles.Bytes())
+	return n, nil
+}
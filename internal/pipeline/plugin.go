@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// PluginProtocolVersion is the version of the plugin protocol sent in every
+// PluginRequest. It changes only when a field changes meaning.
+const PluginProtocolVersion = 1
+
+// DefaultPluginTimeout bounds a plugin call when Plugin.Timeout is 0.
+const DefaultPluginTimeout = 10 * time.Second
+
+// Plugin hooks name what a plugin is asked for.
+const (
+	// PluginHookText asks for the mark text; see WithTextPlugin.
+	PluginHookText = "text"
+	// PluginHookPlace asks where position mode puts the mark; see
+	// WithPlacementPlugin.
+	PluginHookPlace = "place"
+)
+
+// Plugin is an external program that extends marking without forking this
+// package, e.g. to fetch per-order text from a database. For every call the
+// program is started, reads one PluginRequest as JSON from stdin, writes one
+// PluginResponse as JSON to stdout and exits. An error in the response, a
+// non-zero exit status or a timeout fails the file being marked.
+type Plugin struct {
+	// Command is the program to run, looked up in PATH if it has no slash.
+	Command string
+	// Args are passed to Command.
+	Args []string
+	// Timeout bounds each call; 0 means DefaultPluginTimeout.
+	Timeout time.Duration
+}
+
+// PluginRequest is what a plugin reads from stdin.
+type PluginRequest struct {
+	Version int `json:"version"`
+	// Hook is PluginHookText or PluginHookPlace.
+	Hook string `json:"hook"`
+	// Text is the mark text with template variables filled in.
+	Text     string `json:"text"`
+	Filename string `json:"filename,omitempty"`
+	// Width and Height are the size of the image in pixels.
+	Width   int               `json:"width"`
+	Height  int               `json:"height"`
+	Page    int               `json:"page"`
+	Pages   int               `json:"pages"`
+	Counter int               `json:"counter"`
+	EXIF    map[string]string `json:"exif,omitempty"`
+	// MarkWidth and MarkHeight are the size of the mark, and X and Y the
+	// top-left corner the built-in placement chose, for PluginHookPlace;
+	// they are 0 for PluginHookText.
+	MarkWidth  int `json:"mark_width"`
+	MarkHeight int `json:"mark_height"`
+	X          int `json:"x"`
+	Y          int `json:"y"`
+}
+
+// PluginResponse is what a plugin writes to stdout.
+type PluginResponse struct {
+	// Text replaces the mark text, for PluginHookText.
+	Text *string `json:"text,omitempty"`
+	// X and Y are the top-left corner of the mark, for PluginHookPlace.
+	// Leaving both out keeps the built-in placement.
+	X *int `json:"x,omitempty"`
+	Y *int `json:"y,omitempty"`
+	// Error fails the file being marked with this message.
+	Error string `json:"error,omitempty"`
+}
+
+// Call sends req to the plugin and returns its response.
+func (p *Plugin) Call(ctx context.Context, req PluginRequest) (*PluginResponse, error) {
+	if p.Command == "" {
+		return nil, errors.New("plugin command is empty")
+	}
+	req.Version = PluginProtocolVersion
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Children of a killed plugin may hold its stdout open; don't wait on them.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("plugin %s: %w", p.Command, err)
+	}
+	var resp PluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("plugin %s: invalid response: %w", p.Command, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", p.Command, resp.Error)
+	}
+	return &resp, nil
+}
+
+// pluginRequest fills in what every hook is told about the image.
+func pluginRequest(hook, text string, cfg *Settings, b image.Rectangle) PluginRequest {
+	page, pages := cfg.Page, cfg.Pages
+	if pages == 0 {
+		page, pages = 1, 1
+	}
+	return PluginRequest{
+		Hook:     hook,
+		Text:     text,
+		Filename: cfg.Filename,
+		Width:    b.Dx(),
+		Height:   b.Dy(),
+		Page:     page,
+		Pages:    pages,
+		Counter:  cfg.Counter,
+		EXIF:     cfg.EXIF,
+	}
+}
+
+// pluginText asks the text plugin for the mark text.
+func pluginText(ctx context.Context, text string, cfg *Settings, b image.Rectangle) (string, error) {
+	resp, err := cfg.TextPlugin.Call(ctx, pluginRequest(PluginHookText, text, cfg, b))
+	if err != nil {
+		return "", err
+	}
+	if resp.Text == nil {
+		return "", fmt.Errorf("plugin %s: response has no text", cfg.TextPlugin.Command)
+	}
+	return *resp.Text, nil
+}
+
+// pluginPlace asks the placement plugin where to put a w×h mark whose
+// built-in placement is pt.
+func pluginPlace(ctx context.Context, text string, cfg *Settings, b image.Rectangle, w, h int, pt image.Point) (image.Point, error) {
+	req := pluginRequest(PluginHookPlace, text, cfg, b)
+	req.MarkWidth, req.MarkHeight = w, h
+	req.X, req.Y = pt.X, pt.Y
+	resp, err := cfg.PlacePlugin.Call(ctx, req)
+	if err != nil {
+		return image.Point{}, err
+	}
+	switch {
+	case resp.X == nil && resp.Y == nil:
+		return pt, nil
+	case resp.X == nil || resp.Y == nil:
+		return image.Point{}, fmt.Errorf("plugin %s: response needs both x and y", cfg.PlacePlugin.Command)
+	}
+	return image.Pt(*resp.X, *resp.Y), nil
+}
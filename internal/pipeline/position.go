@@ -0,0 +1,55 @@
+package pipeline
+
+import (
+	"slices"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+
+	"watermark/pkg/render"
+)
+
+// PositionFont is the font position mode uses for a font path, after any
+// fallback, and the fallback fonts that load.
+type PositionFont struct {
+	path          string
+	fallbackPaths []string
+	*render.Font
+	fallbacks []*render.Font
+}
+
+// LoadPositionFont loads the fonts cfg names, falling back and warning like
+// position mode does.
+func LoadPositionFont(cfg *Settings) (*PositionFont, error) {
+	f, _, err := fontWithFallback(cfg.FontPath, cfg.notifier())
+	if err != nil {
+		return nil, err
+	}
+	return &PositionFont{
+		path:          cfg.FontPath,
+		fallbackPaths: cfg.FontFallbacks,
+		Font:          f,
+		fallbacks:     loadFallbackFonts(cfg.FontFallbacks, cfg.notifier()),
+	}, nil
+}
+
+// matches reports whether f was loaded for the fonts cfg names.
+func (f *PositionFont) matches(cfg *Settings) bool {
+	return f != nil && f.path == cfg.FontPath && slices.Equal(f.fallbackPaths, cfg.FontFallbacks)
+}
+
+// measure returns the bounds of text and the ascent of the font at size.
+func (f *PositionFont) measure(text string, size int) (fixed.Rectangle26_6, fixed.Int26_6, error) {
+	face, err := f.Face(size)
+	if err != nil {
+		return fixed.Rectangle26_6{}, 0, err
+	}
+	face.Lock()
+	defer face.Unlock()
+	full, err := render.WithFallbacks(face.Face, f.fallbacks, size)
+	if err != nil {
+		return fixed.Rectangle26_6{}, 0, err
+	}
+	bounds, _ := font.BoundString(full, text)
+	return bounds, face.Metrics().Ascent, nil
+}
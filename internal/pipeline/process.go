@@ -0,0 +1,781 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/disintegration/imaging"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/image/math/fixed"
+
+	"watermark/internal/parallel"
+	"watermark/pkg/codec"
+	"watermark/pkg/compose"
+	"watermark/pkg/render"
+)
+
+// checkOutputPath fails early, before any decoding, on output paths that
+// save would reject.
+func checkOutputPath(path string, cfg *Settings) error {
+	if cfg.Format != "" {
+		if cfg.ForceFormat {
+			return nil
+		}
+		return codec.CheckFormat(path, cfg.Format)
+	}
+	if _, err := codec.FormatFromPath(path); err == nil {
+		return nil
+	}
+	if _, err := imaging.FormatFromFilename(path); err != nil {
+		return fmt.Errorf("%w: output extension %q", codec.ErrUnsupportedFormat, filepath.Ext(path))
+	}
+	return nil
+}
+
+func checkCleanPath(cfg *Settings) error {
+	if cfg.CleanPath == "" {
+		return nil
+	}
+	return checkOutputPath(cfg.CleanPath, &Settings{})
+}
+
+// output is a watermarked image ready to be encoded.
+type output struct {
+	img        image.Image
+	background color.NRGBA
+	encoding   codec.EncodeOptions
+	segs       [][]byte
+	salvaged   bool
+	tiles      int
+	// preserveAlpha refuses formats that would flatten a transparent img.
+	preserveAlpha bool
+	// icc is the color profile of img, embedded where the format allows.
+	icc []byte
+	// icon holds every image of an ICO input; img is then the largest.
+	icon []codec.ICOEntry
+	// pdf is the marked document of a PDF input; img is then nil.
+	pdf []byte
+	// clean is the decoded input before marking, kept for WithCleanOutput.
+	clean *output
+	// origin is the SHA-256 of the input, recorded with WithOriginChecksum.
+	origin []byte
+	// workers is how many goroutines flatten img for JPEG; 0 means
+	// GOMAXPROCS.
+	workers int
+	// proof is img after a JPEG round trip, made with WithJPEGProof, and
+	// proofDetail the share of the mark's detail it keeps.
+	proof       image.Image
+	proofDetail float64
+	// coverageMap and coverage are made with WithCoverageMap.
+	coverageMap image.Image
+	coverage    float64
+}
+
+// save writes the output to path in format, or in the format named by the
+// extension of path if format is empty. An explicit format must match the
+// extension unless force is set.
+func (o *output) save(path string, format codec.Format, force bool) error {
+	if format == "" {
+		f, err := codec.FormatFromPath(path)
+		if err != nil && o.pdf == nil {
+			if err := o.checkAlpha(""); err != nil {
+				return err
+			}
+			if o.origin != nil {
+				return codec.CheckOriginFormat(codec.Format(filepath.Ext(path)))
+			}
+			return codec.Save(o.img, path, o.background, o.encoding, o.segs, o.icc, o.workers)
+		}
+		format = f
+	} else if err := codec.CheckFormat(path, format); err != nil && !force {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return codec.WriteFileAtomic(path, func(w io.Writer) error { return o.encode(w, format) })
+}
+
+// encode writes the output to w in format, like save.
+func (o *output) encode(w io.Writer, format codec.Format) error {
+	if o.origin == nil {
+		return o.encodeMarked(w, format)
+	}
+	var buf bytes.Buffer
+	if err := o.encodeMarked(&buf, format); err != nil {
+		return err
+	}
+	data, err := codec.EmbedOrigin(buf.Bytes(), format, o.origin)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// encodeMarked writes the output to w in format, without the origin
+// checksum.
+func (o *output) encodeMarked(w io.Writer, format codec.Format) error {
+	if o.pdf != nil {
+		if format != codec.FormatPDF {
+			return fmt.Errorf("%w: a PDF input can only be written as PDF, not %q", codec.ErrUnsupportedFormat, format)
+		}
+		_, err := w.Write(o.pdf)
+		return err
+	}
+	if err := o.checkAlpha(format); err != nil {
+		return err
+	}
+	if format == codec.FormatICO && o.icon != nil {
+		return codec.EncodeICO(w, o.icon)
+	}
+	return codec.Encode(w, o.img, format, o.background, o.encoding, o.segs, o.icc, o.workers)
+}
+
+func (o *output) result() *Result {
+	return &Result{Image: o.img, Salvaged: o.salvaged, Tiles: o.tiles, Proof: o.proof, ProofDetail: o.proofDetail,
+		CoverageMap: o.coverageMap, Coverage: o.coverage}
+}
+
+// Result describes a watermarked image written by AddRepeatWatermark or
+// AddPositionWatermark.
+type Result struct {
+	// Image is the marked image, or nil for PDF inputs.
+	Image image.Image
+	// Salvaged reports that the input was damaged and only partially decoded.
+	Salvaged bool
+	// Tiles is the number of repeat-mode tiles pasted, summed over the pages
+	// of a PDF; 0 in position mode.
+	Tiles int
+	// Proof is Image as it decodes after JPEG compression with the set
+	// encoding options, made with WithJPEGProof; nil otherwise.
+	Proof image.Image
+	// ProofDetail is the share of the mark's fine detail, 0 to 1, that
+	// survives in Proof.
+	ProofDetail float64
+	// CoverageMap is a heat map of where and how strongly the mark changes
+	// the image, made with WithCoverageMap; nil otherwise.
+	CoverageMap image.Image
+	// Coverage is the share of pixels, 0 to 1, the mark changes.
+	Coverage float64
+	// Variants are the paths written with WithDensities, largest scale
+	// first; nil otherwise.
+	Variants []string
+}
+
+// MarkFunc draws a watermark onto a decoded image.
+type MarkFunc func(ctx context.Context, img image.Image, text string, cfg *Settings) (image.Image, MarkStats, error)
+
+// MarkStats is what a MarkFunc reports about its work: how many tiles it
+// pasted, for Result.Tiles.
+type MarkStats struct {
+	Tiles int
+}
+
+// AddFile marks the image at inputPath with mark and writes it to
+// outputPath, configured by opts; mode names the mode for messages and
+// mode-specific options.
+func AddFile(ctx context.Context, mode string, mark MarkFunc, inputPath, outputPath, text string, opts []Option) (res *Result, err error) {
+	cfg, err := NewSettings(opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Mode = mode
+	if mode == "invisible" {
+		if err := checkExact(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkOutputPath(outputPath, cfg); err != nil {
+		return nil, err
+	}
+	if err := checkCleanPath(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Filename == "" {
+		cfg.Filename = filepath.Base(inputPath)
+	}
+	cfg.number()
+	ctx, span := cfg.startSpan(ctx, mode)
+	defer func() { endSpan(span, err) }()
+
+	src, err := codec.OpenImageSource(inputPath, cfg.Limits)
+	if err != nil {
+		return nil, err
+	}
+	out, err := process(ctx, mark, src, text, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.OriginChecksum {
+		out.origin = codec.OriginSum(src.Bytes())
+	}
+	var variants []string
+	if len(cfg.Densities) > 0 {
+		if variants, err = saveDensities(ctx, out, outputPath, cfg); err != nil {
+			return nil, err
+		}
+	} else {
+		_, encSpan := cfg.startSpan(ctx, "encode", attribute.String("path", outputPath))
+		err = out.save(outputPath, cfg.Format, cfg.ForceFormat)
+		endSpan(encSpan, err)
+		if err != nil {
+			return nil, fmt.Errorf("write output: %w", err)
+		}
+	}
+	if err := saveClean(ctx, out, cfg); err != nil {
+		return nil, err
+	}
+	res = out.result()
+	res.Variants = variants
+	return res, nil
+}
+
+// AddStream is AddFile reading the input from r and writing the output to
+// w in format.
+func AddStream(ctx context.Context, mode string, mark MarkFunc, r io.Reader, w io.Writer, format codec.Format, text string, opts []Option) (res *Result, err error) {
+	cfg, err := NewSettings(opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Mode = mode
+	if mode == "invisible" {
+		if err := checkExact(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkCleanPath(cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Densities) > 0 {
+		return nil, errors.New("density variants need an output path, not a stream")
+	}
+	cfg.number()
+	ctx, span := cfg.startSpan(ctx, mode)
+	defer func() { endSpan(span, err) }()
+
+	src, err := codec.ReadImageSource(r, cfg.Limits)
+	if err != nil {
+		return nil, err
+	}
+	out, err := process(ctx, mark, src, text, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.OriginChecksum {
+		out.origin = codec.OriginSum(src.Bytes())
+	}
+	_, encSpan := cfg.startSpan(ctx, "encode", attribute.String("format", string(format)))
+	err = out.encode(w, format)
+	endSpan(encSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("write output: %w", err)
+	}
+	if err := saveClean(ctx, out, cfg); err != nil {
+		return nil, err
+	}
+	return out.result(), nil
+}
+
+// saveClean writes the unmarked image of out to the WithCleanOutput path, if
+// one is set. Its format always follows the extension.
+func saveClean(ctx context.Context, out *output, cfg *Settings) error {
+	if out.clean == nil {
+		return nil
+	}
+	_, span := cfg.startSpan(ctx, "encode", attribute.String("path", cfg.CleanPath))
+	err := out.clean.save(cfg.CleanPath, "", false)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("write clean output: %w", err)
+	}
+	return nil
+}
+
+// proofMinDetail is the share of the mark's fine detail below which
+// WithJPEGProof warns that compression destroys it.
+const proofMinDetail = 0.4
+
+// process decodes src and applies mark, with a span per stage.
+func process(ctx context.Context, mark MarkFunc, src *codec.ImageSource, text string, cfg *Settings) (*output, error) {
+	data := src.Bytes()
+	cfg, err := cfg.jittered(data)
+	if err != nil {
+		return nil, err
+	}
+	switch src.Format {
+	case codec.FormatICO:
+		return processICO(ctx, mark, data, text, cfg)
+	case codec.FormatPDF:
+		return processPDF(ctx, data, text, cfg)
+	}
+	_, decSpan := cfg.startSpan(ctx, "decode", attribute.Int("bytes", len(data)))
+	img, salvaged, err := codec.Decode(data, cfg.IgnoreOrientation, cfg.Tolerant)
+	if err == nil {
+		decSpan.SetAttributes(
+			attribute.Int("width", img.Bounds().Dx()),
+			attribute.Int("height", img.Bounds().Dy()),
+			attribute.Bool("salvaged", salvaged),
+		)
+	}
+	endSpan(decSpan, err)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	icc := src.ICC
+	if icc != nil && cfg.ColorProfile == codec.ColorProfileSRGB {
+		if img, err = codec.ConvertToSRGB(img, icc); err != nil {
+			return nil, err
+		}
+		icc = nil
+	}
+
+	if cfg.Crop != nil {
+		r := cfg.Crop.Resolve(img.Bounds())
+		if r.Empty() {
+			return nil, fmt.Errorf("crop %s lies outside the %dx%d image", cfg.Crop, img.Bounds().Dx(), img.Bounds().Dy())
+		}
+		img = imaging.Crop(img, r)
+	}
+	if cfg.Pad != nil {
+		img = cfg.Pad.Apply(img)
+	}
+
+	cfg.EXIF = src.EXIF
+	marked, stats, err := markImage(ctx, mark, img, text, cfg)
+	if err != nil {
+		return nil, err
+	}
+	out := &output{
+		img:           marked,
+		background:    cfg.JPGBackground,
+		encoding:      cfg.Encoding,
+		segs:          inputMetadata(data, cfg),
+		icc:           icc,
+		salvaged:      salvaged,
+		tiles:         stats.Tiles,
+		preserveAlpha: cfg.PreserveAlpha,
+		workers:       cfg.Workers,
+	}
+	if cfg.CleanPath != "" {
+		out.clean = &output{img: img, background: out.background, encoding: out.encoding, segs: out.segs, icc: icc, workers: cfg.Workers}
+	}
+	if cfg.CoverageMap {
+		out.coverageMap, out.coverage = compose.Coverage(img, marked, cfg.JPGBackground, cfg.Workers)
+	}
+	if cfg.JPEGProof {
+		if out.proof, out.proofDetail, err = codec.JPEGProof(img, marked, cfg.JPGBackground, cfg.Encoding, cfg.Workers); err != nil {
+			return nil, err
+		}
+		if out.proofDetail < proofMinDetail {
+			q := cfg.Encoding.Quality
+			if q == 0 {
+				q = 100
+			}
+			cfg.notifier().warn(EventJPEGLoss, "JPEG at quality %d keeps %.0f%% of the mark's fine detail; increase the font size, opacity or quality",
+				q, out.proofDetail*100)
+		}
+	}
+	return out, nil
+}
+
+// inputMetadata collects the metadata segments to copy from the input, or
+// none when stripping metadata.
+func inputMetadata(data []byte, cfg *Settings) [][]byte {
+	if cfg.StripMetadata {
+		return nil
+	}
+	return codec.Metadata(data, !cfg.IgnoreOrientation)
+}
+
+// markImage applies mark to a decoded image, along with the stencil and
+// bilevel post-processing the settings ask for. Invisible marks get their
+// payload as is and no post-processing.
+func markImage(ctx context.Context, mark MarkFunc, img image.Image, text string, cfg *Settings) (image.Image, MarkStats, error) {
+	if cfg.Mode == "invisible" {
+		// The payload is embedded byte for byte, and nothing may touch the
+		// pixels after it; Embed leaves alpha alone.
+		applyCtx, span := cfg.startSpan(ctx, "apply")
+		marked, stats, err := mark(applyCtx, img, text, cfg)
+		endSpan(span, err)
+		return marked, stats, err
+	}
+	if cfg.Stencil {
+		mark = stencilMark(mark)
+	}
+	text, err := expandText(ctx, text, cfg, img.Bounds())
+	if err != nil {
+		return nil, MarkStats{}, err
+	}
+	if cfg.AutoAngle {
+		c := *cfg
+		c.Angle = compose.AutoAngle(img, cfg.Angle)
+		cfg = &c
+	}
+	applyCtx, applySpan := cfg.startSpan(ctx, "apply")
+	marked, stats, err := mark(applyCtx, img, text, cfg)
+	if err == nil {
+		applySpan.SetAttributes(attribute.Int("tiles", stats.Tiles))
+	}
+	endSpan(applySpan, err)
+	if err != nil {
+		return nil, MarkStats{}, err
+	}
+	if cfg.PreserveAlpha {
+		marked = compose.KeepAlpha(img, marked)
+	}
+	if cfg.Bilevel {
+		marked = compose.Bilevel(marked)
+	}
+	return marked, stats, nil
+}
+
+// RepeatMark tiles text across the image.
+func RepeatMark(ctx context.Context, img image.Image, text string, cfg *Settings) (image.Image, MarkStats, error) {
+	if cfg.Renderer != nil {
+		return rendererMark(cfg.Renderer, true)(ctx, img, text, cfg)
+	}
+	args := WatermarkArgs{
+		Mark:           text,
+		Color:          cfg.Color,
+		Space:          cfg.Space,
+		Angle:          cfg.Angle,
+		FontFamily:     cfg.FontPath,
+		FontFallbacks:  cfg.FontFallbacks,
+		FontVariations: cfg.FontVariations,
+		FontHeightCrop: cfg.FontHeightCrop,
+		Size:           cfg.FontSize,
+		Opacity:        cfg.Opacity,
+		MaxTiles:       cfg.MaxTiles,
+		LineHeight:     cfg.LineHeight,
+		Align:          cfg.Align,
+		TextTransform:  cfg.TextTransform,
+		TextDirection:  cfg.TextDirection,
+		RotateTiles:    cfg.RotateTiles,
+		LinearBlend:    cfg.LinearBlend,
+		Workers:        cfg.Workers,
+		Verify:         cfg.Verify,
+		Logger:         cfg.Logger,
+		OnEvent:        cfg.OnEvent,
+	}
+	wm, err := NewWatermarker(args)
+	if err != nil {
+		return nil, MarkStats{}, err
+	}
+	if cfg.CVDCheck {
+		c, _ := render.ParseHexColor(cfg.Color)
+		checkCVDContrast(cfg.notifier(), img, img.Bounds(), render.ScaleAlpha(c, cfg.Opacity))
+	}
+	marked, err := applyTiles(ctx, wm, img, cfg)
+	if err != nil {
+		return nil, MarkStats{}, err
+	}
+	return marked, MarkStats{Tiles: wm.TileCount(img.Bounds().Dx(), img.Bounds().Dy())}, nil
+}
+
+// applyTiles tiles the pattern of wm over img, or alone onto a transparent
+// canvas when a layer is rendered for blending.
+func applyTiles(ctx context.Context, wm *Watermarker, img image.Image, cfg *Settings) (image.Image, error) {
+	if cfg.LayerOnly {
+		return wm.overlay(ctx, img.Bounds())
+	}
+	return wm.ApplyContext(ctx, img)
+}
+
+// CombinedMark returns a MarkFunc that tiles the text across the image
+// and places positionText once, stacking the layers per the settings.
+func CombinedMark(positionText string) MarkFunc {
+	return func(ctx context.Context, img image.Image, text string, cfg *Settings) (image.Image, MarkStats, error) {
+		posText, err := expandText(ctx, positionText, cfg, img.Bounds())
+		if err != nil {
+			return nil, MarkStats{}, err
+		}
+		var stats MarkStats
+		marked := img
+		for _, l := range cfg.layers() {
+			layerCfg := *cfg
+			style, ok := cfg.LayerStyles[l]
+			if ok {
+				layerCfg.Opacity = style.Opacity
+			}
+			// Layers other than normal ones, and all of them in linear
+			// light, are rendered alone, then blended.
+			if !ok {
+				style.Blend = compose.BlendNormal
+			}
+			layerCfg.LayerOnly = style.Blend != compose.BlendNormal || cfg.LinearBlend
+			var out image.Image
+			if l == LayerTiles {
+				out, stats, err = RepeatMark(ctx, marked, text, &layerCfg)
+			} else {
+				out, _, err = PositionMark(ctx, marked, posText, &layerCfg)
+			}
+			if err != nil {
+				return nil, MarkStats{}, err
+			}
+			if layerCfg.LayerOnly {
+				if out, err = compose.Blend(ctx, marked, out.(*image.NRGBA), style.Blend, cfg.LinearBlend, cfg.Workers); err != nil {
+					return nil, MarkStats{}, err
+				}
+			}
+			marked = out
+		}
+		return marked, stats, nil
+	}
+}
+
+// fitFontSize returns the font size at which text, rotated by the position
+// angle, spans target pixels horizontally. Text width grows linearly with the
+// size apart from hinting, so one measurement at a reference size and one
+// correction at the estimate are enough.
+func fitFontSize(fnt *render.Font, fallbacks []*render.Font, text string, cfg *Settings, target int) (int, error) {
+	measure := func(size int) (int, error) {
+		o, err := render.OutlineText(fnt, fallbacks, text, size, cfg.LineHeight, cfg.Align, cfg.TextTransform, cfg.TextDirection, cfg.FontVariations)
+		if err != nil {
+			return 0, err
+		}
+		if cfg.PositionAngle != 0 {
+			o = o.Rotate(cfg.PositionAngle)
+		}
+		return o.Bounds().Dx(), nil
+	}
+	const ref = 256
+	size := ref
+	for i := 0; i < 2; i++ {
+		w, err := measure(size)
+		if err != nil {
+			return 0, err
+		}
+		if w <= 0 {
+			return 0, errors.New("text has no visible glyphs")
+		}
+		size = max(int(math.Round(float64(size)*float64(target)/float64(w))), minFitFontSize)
+	}
+	return size, nil
+}
+
+// minFitFontSize keeps WithWidthRatio from shrinking text below legibility.
+const minFitFontSize = 8
+
+// PositionMark places text once.
+func PositionMark(ctx context.Context, img image.Image, text string, cfg *Settings) (image.Image, MarkStats, error) {
+	if cfg.Renderer != nil {
+		return rendererMark(cfg.Renderer, false)(ctx, img, text, cfg)
+	}
+	rgba := imaging.Clone(img)
+
+	width := rgba.Bounds().Dx()
+	height := rgba.Bounds().Dy()
+	fontSize := cfg.PositionFontSize
+	if fontSize == 0 {
+		// The epsilon keeps e.g. 575*0.04 from flooring to 22.
+		fontSize = max(int(float64(min(width, height))*cfg.FontSizeRatio+1e-9), 16)
+	}
+
+	pf := cfg.PositionFont
+	if !pf.matches(cfg) {
+		var err error
+		if pf, err = LoadPositionFont(cfg); err != nil {
+			return nil, MarkStats{}, err
+		}
+	}
+	fnt, fallbacks := pf.Font, pf.fallbacks
+	var err error
+	if cfg.WidthRatio > 0 && cfg.PositionFontSize == 0 {
+		fontSize, err = fitFontSize(fnt, fallbacks, text, cfg, int(float64(width)*cfg.WidthRatio))
+		if err != nil {
+			return nil, MarkStats{}, fmt.Errorf("%w: %w", render.ErrFontLoad, err)
+		}
+	}
+	bounds, ascent, err := pf.measure(text, fontSize)
+	if err != nil {
+		return nil, MarkStats{}, err
+	}
+	outline, err := render.OutlineText(fnt, fallbacks, text, fontSize, cfg.LineHeight, cfg.Align, cfg.TextTransform, cfg.TextDirection, cfg.FontVariations)
+	if err != nil {
+		return nil, MarkStats{}, fmt.Errorf("%w: %w", render.ErrFontLoad, err)
+	}
+
+	textW := fixedToInt(bounds.Max.X - bounds.Min.X)
+	textH := fixedToInt(bounds.Max.Y - bounds.Min.Y)
+	// dotOffset maps the top-left of the textW×textH box to the text origin.
+	dotOffset := image.Pt(0, ascent.Round())
+	if cfg.PositionAngle != 0 || strings.Contains(text, "\n") || render.NeedsShaping(text) || outline.HasColor() || cfg.TextDirection == render.TextDirectionVertical || len(cfg.FontVariations) > 0 {
+		if cfg.PositionAngle != 0 {
+			outline = outline.Rotate(cfg.PositionAngle)
+		}
+		textW, textH = outline.Bounds().Dx(), outline.Bounds().Dy()
+		dotOffset = outline.Bounds().Min.Mul(-1)
+	}
+	if textW <= 0 || textH <= 0 {
+		return nil, MarkStats{}, fmt.Errorf("%w: text bounds are empty", render.ErrEmptyMark)
+	}
+
+	sample := image.Rect(
+		width/2-textW/2,
+		height/2-textH/2,
+		width/2+textW/2,
+		height/2+textH/2,
+	).Intersect(rgba.Bounds())
+	if sample.Empty() {
+		sample = rgba.Bounds()
+	}
+
+	brightness := meanRedChannel(rgba, sample)
+	alpha := clampInt(int(math.Round(255*cfg.Opacity)), 0, 255)
+	outlineAlpha := clampInt(int(math.Round(255*cfg.Opacity*0.6)), 0, 255)
+
+	var fillColor, outlineColor color.NRGBA
+	if brightness > 128 {
+		fillColor = color.NRGBA{0, 0, 0, uint8(alpha)}
+		outlineColor = color.NRGBA{255, 255, 255, uint8(outlineAlpha)}
+	} else {
+		fillColor = color.NRGBA{255, 255, 255, uint8(alpha)}
+		outlineColor = color.NRGBA{0, 0, 0, uint8(outlineAlpha)}
+	}
+	fillColor, outlineColor = cfg.ColorShift.Apply(fillColor), cfg.ColorShift.Apply(outlineColor)
+	if cfg.FillColor != nil {
+		fillColor = render.ScaleAlpha(*cfg.FillColor, cfg.Opacity)
+	}
+	if cfg.OutlineColor != nil {
+		outlineColor = render.ScaleAlpha(*cfg.OutlineColor, cfg.Opacity)
+	}
+
+	pt, err := placeBox(ctx, rgba, textW, textH, text, cfg)
+	if err != nil {
+		return nil, MarkStats{}, err
+	}
+	if cfg.CVDCheck {
+		checkCVDContrast(cfg.notifier(), rgba, image.Rect(pt.X, pt.Y, pt.X+textW, pt.Y+textH), fillColor)
+	}
+	dot := pt.Add(dotOffset)
+	var shadow *render.Shadow
+	if cfg.Shadow != nil {
+		sh := *cfg.Shadow
+		sh.Color = render.ScaleAlpha(sh.Color, cfg.Opacity)
+		shadow = &sh
+	}
+	stroke := render.Stroke{Width: 2 * cfg.OutlineWidth, Dash: cfg.OutlineDash}
+	dst := layerCanvas(rgba, cfg)
+	render.DrawOutlinedText(dst, outline, dot, fillColor, outlineColor, stroke, shadow)
+
+	marked, err := finishLayer(ctx, rgba, dst, cfg)
+	return marked, MarkStats{}, err
+}
+
+// placeBox returns the top-left corner of a w×h position-mode mark on img:
+// at the named position inside the margins, or at the explicit offset or
+// random spot the settings ask for, then shifted. A WithPlacementPlugin
+// plugin has the last word.
+func placeBox(ctx context.Context, img *image.NRGBA, w, h int, text string, cfg *Settings) (image.Point, error) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	area := image.Rect(0, 0, width, height)
+	if cfg.AvoidBorders {
+		area = compose.DetectContent(img).Sub(img.Bounds().Min)
+	}
+	mt, mr, mb, ml := cfg.marginSides(float64(area.Dx()), float64(area.Dy()))
+
+	left, right := area.Min.X+int(ml), area.Max.X-w-int(mr)
+	top, bottom := area.Min.Y+int(mt), area.Max.Y-h-int(mb)
+	if cfg.AvoidChrome {
+		barTop, barBottom := compose.DetectChrome(img)
+		top += barTop
+		bottom -= barBottom
+	}
+	chosen := cfg.Position.At(left, top, right, bottom)
+	if cfg.Offset != nil {
+		chosen = cfg.Offset.Place(cfg.Anchor, w, h, width, height)
+	} else if cfg.RandomRegion != nil {
+		chosen = compose.RandomPlace(img, *cfg.RandomRegion, cfg.RandomSeed, w, h)
+	} else if cfg.AvoidEdges {
+		maxShift := int(float64(min(width, height)) * cfg.MaxNudgeRatio)
+		chosen = compose.NudgeAwayFromEdges(img, chosen, w, h, cfg.Position, maxShift)
+	}
+	chosen = chosen.Add(image.Pt(cfg.ShiftX, cfg.ShiftY))
+	if cfg.PlacePlugin != nil {
+		return pluginPlace(ctx, text, cfg, img.Bounds(), w, h, chosen)
+	}
+	return chosen, nil
+}
+
+// meanRedChannel returns the mean red value of the pixels of img in r,
+// which must lie within the bounds of img.
+func meanRedChannel(img *image.NRGBA, r image.Rectangle) float64 {
+	if r.Empty() {
+		return 0
+	}
+	var sum uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			sum += uint64(row[i])
+		}
+	}
+	return float64(sum) / float64(r.Dx()*r.Dy())
+}
+
+// samePixels reports whether a and b, of the same bounds, hold the same
+// pixels. Bands stop early once any band has found a difference.
+func samePixels(a, b *image.NRGBA, workers int) bool {
+	var differ atomic.Bool
+	parallel.Rows(workers, a.Rect, func(band image.Rectangle) error {
+		n := band.Dx() * 4
+		for y := band.Min.Y; y < band.Max.Y && !differ.Load(); y++ {
+			i, j := a.PixOffset(band.Min.X, y), b.PixOffset(band.Min.X, y)
+			if !bytes.Equal(a.Pix[i:i+n], b.Pix[j:j+n]) {
+				differ.Store(true)
+			}
+		}
+		return nil
+	})
+	return !differ.Load()
+}
+
+func fixedToInt(v fixed.Int26_6) int {
+	return int(math.Ceil(float64(v) / 64.0))
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
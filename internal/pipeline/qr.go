@@ -0,0 +1,25 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"watermark/pkg/render"
+)
+
+// QRMark draws payload as a QR code, placed like position-mode text or, with
+// WithQRTiled, tiled like repeat-mode text.
+func QRMark(ctx context.Context, img image.Image, payload string, cfg *Settings) (image.Image, MarkStats, error) {
+	if payload == "" {
+		return nil, MarkStats{}, fmt.Errorf("%w: QR payload must not be empty", render.ErrEmptyMark)
+	}
+	r := render.QRRenderer{Level: cfg.QRLevel, Size: cfg.QRSize, QuietZone: cfg.QRQuietZone}
+	if r.QuietZone == 0 {
+		r.QuietZone = -1
+	}
+	if cfg.FillColor != nil {
+		r.Color = *cfg.FillColor
+	}
+	return rendererMark(r, cfg.QRTiled)(ctx, img, payload, cfg)
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+
+	"watermark/pkg/render"
+)
+
+// rendererMark draws the mark of cfg.renderer, tiled or placed.
+func rendererMark(r render.MarkRenderer, tiled bool) MarkFunc {
+	return func(ctx context.Context, img image.Image, text string, cfg *Settings) (image.Image, MarkStats, error) {
+		mark, err := r.Render(render.RenderOptions{Text: text, Bounds: img.Bounds(), Opacity: cfg.Opacity, Workers: cfg.Workers})
+		if err != nil {
+			return nil, MarkStats{}, err
+		}
+		if mark == nil || mark.Bounds().Empty() {
+			return nil, MarkStats{}, render.ErrEmptyMark
+		}
+		if tiled {
+			return tileMark(ctx, img, mark, cfg)
+		}
+		marked, err := placeMark(ctx, img, mark, text, cfg)
+		return marked, MarkStats{}, err
+	}
+}
+
+// tileMark tiles mark over img with the repeat-mode spacing and angle.
+func tileMark(ctx context.Context, img, mark image.Image, cfg *Settings) (image.Image, MarkStats, error) {
+	wm := newImageWatermarker(WatermarkArgs{
+		Space:       cfg.Space,
+		Angle:       cfg.Angle,
+		MaxTiles:    cfg.MaxTiles,
+		RotateTiles: cfg.RotateTiles,
+		LinearBlend: cfg.LinearBlend,
+		Workers:     cfg.Workers,
+		Verify:      cfg.Verify,
+		Logger:      cfg.Logger,
+		OnEvent:     cfg.OnEvent,
+	}, mark)
+	marked, err := applyTiles(ctx, wm, img, cfg)
+	if err != nil {
+		return nil, MarkStats{}, err
+	}
+	return marked, MarkStats{Tiles: wm.TileCount(img.Bounds().Dx(), img.Bounds().Dy())}, nil
+}
+
+// placeMark draws mark once onto a copy of img, where position mode would
+// put text of its size.
+func placeMark(ctx context.Context, img, mark image.Image, text string, cfg *Settings) (image.Image, error) {
+	rgba := imaging.Clone(img)
+	mw, mh := mark.Bounds().Dx(), mark.Bounds().Dy()
+	pt, err := placeBox(ctx, rgba, mw, mh, text, cfg)
+	if err != nil {
+		return nil, err
+	}
+	dst := layerCanvas(rgba, cfg)
+	draw.Draw(dst, image.Rect(pt.X, pt.Y, pt.X+mw, pt.Y+mh), mark, mark.Bounds().Min, draw.Over)
+	return finishLayer(ctx, rgba, dst, cfg)
+}
@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"image/color"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"watermark/pkg/codec"
+	"watermark/pkg/compose"
+	"watermark/pkg/pdf"
+	"watermark/pkg/render"
+)
+
+// Option configures AddRepeatWatermark and AddPositionWatermark. Values are
+// validated when the options are applied, before any image is read.
+// Options that only concern one mode are ignored by the other.
+type Option func(*Settings) error
+
+// Settings is the resolved configuration of a watermark run.
+type Settings struct {
+	Color             string
+	Space             int
+	Angle             int
+	AutoAngle         bool
+	Opacity           float64
+	FontPath          string
+	FontFallbacks     []string
+	FontVariations    []render.FontVariation
+	FontSize          int
+	FontHeightCrop    float64
+	RotateTiles       bool
+	MaxTiles          int
+	LineHeight        float64
+	Align             render.Align
+	PositionFontSize  int
+	PositionAngle     float64
+	FontSizeRatio     float64
+	WidthRatio        float64
+	Filename          string
+	Transforms        []TextTransformer
+	TextTransform     render.TextTransform
+	TextDirection     render.TextDirection
+	Fingerprint       string
+	Recipient         string
+	Serial            string
+	Counter           int
+	Sequence          *Sequence
+	EXIF              map[string]string
+	Page              int
+	Pages             int
+	FillColor         *color.NRGBA
+	OutlineColor      *color.NRGBA
+	Position          compose.Position
+	Offset            *compose.Offset
+	Anchor            compose.Position
+	RandomRegion      *compose.Region
+	RandomSeed        int64
+	ColorJitter       *render.ColorJitter
+	ColorShift        render.ColorShift
+	ShiftX            int
+	ShiftY            int
+	OutlineWidth      float64
+	OutlineDash       []float64
+	Shadow            *render.Shadow
+	MarginRatio       float64
+	Margins           *compose.Margins
+	JPGBackground     color.NRGBA
+	Encoding          codec.EncodeOptions
+	PreserveAlpha     bool
+	AvoidEdges        bool
+	AvoidChrome       bool
+	AvoidBorders      bool
+	CVDCheck          bool
+	JPEGProof         bool
+	CoverageMap       bool
+	MaxNudgeRatio     float64
+	StripMetadata     bool
+	OriginChecksum    bool
+	ColorProfile      codec.ColorProfile
+	IgnoreOrientation bool
+	Tolerant          bool
+	Limits            codec.SourceLimits
+	Crop              *compose.Crop
+	Pad               *compose.Padding
+	PDFPages          []pdf.PageRange
+	Stencil           bool
+	StencilGray       uint8
+	Bilevel           bool
+	ICOMinSize        int
+	Format            codec.Format
+	ForceFormat       bool
+	CleanPath         string
+	Densities         []int
+	QRLevel           render.QRLevel
+	QRSize            int
+	QRQuietZone       int
+	QRTiled           bool
+	RobustStrength    float64
+	Renderer          render.MarkRenderer
+	LayerOrder        []Layer
+	LayerStyles       map[Layer]LayerStyle
+	LayerOnly         bool
+	LinearBlend       bool
+	Workers           int
+	Verify            bool
+	PositionFont      *PositionFont
+	TextPlugin        *Plugin
+	PlacePlugin       *Plugin
+	Mode              string
+	Logger            Logger
+	OnEvent           func(Event)
+	TracerProvider    trace.TracerProvider
+}
+
+// number sets {counter} for the next file processed, from the WithSequence
+// sequence if there is one.
+func (s *Settings) number() {
+	if s.Sequence != nil {
+		s.Counter = s.Sequence.Next()
+	}
+}
+
+// marginSides returns the position-mode margins of a w×h image.
+func (s *Settings) marginSides(w, h float64) (top, right, bottom, left float64) {
+	if s.Margins != nil {
+		return s.Margins.Sides(w, h)
+	}
+	return h * s.MarginRatio, w * s.MarginRatio, h * s.MarginRatio, w * s.MarginRatio
+}
+
+func (s *Settings) notifier() notifier {
+	return notifier{logger: s.Logger, onEvent: s.OnEvent}
+}
+
+func defaultSettings() Settings {
+	return Settings{
+		Color:          "#4db6ac",
+		Space:          75,
+		Angle:          30,
+		Opacity:        0.5,
+		FontSize:       48,
+		FontHeightCrop: 1.0,
+		MaxTiles:       250000,
+		FontSizeRatio:  0.04,
+		OutlineWidth:   2,
+		LineHeight:     1,
+		Align:          render.AlignLeft,
+		Position:       compose.BottomRight,
+		Anchor:         compose.TopLeft,
+		MarginRatio:    0.04,
+		JPGBackground:  color.NRGBA{255, 255, 255, 255},
+		MaxNudgeRatio:  0.15,
+		StencilGray:    200,
+		ICOMinSize:     32,
+		Counter:        1,
+		QRLevel:        render.QRLevelM,
+		QRQuietZone:    4,
+		RobustStrength: 4,
+	}
+}
+
+// NewSettings returns the defaults with opts applied in order.
+func NewSettings(opts []Option) (*Settings, error) {
+	s := defaultSettings()
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(&s); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
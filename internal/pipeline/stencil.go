@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+
+	"watermark/pkg/compose"
+)
+
+// stencilMark renders inner's watermark as a gray stencil over a grayscale
+// copy of the image. The stencil only darkens pixels lighter than
+// cfg.stencilGray, so black text on the page stays untouched.
+func stencilMark(inner MarkFunc) MarkFunc {
+	return func(ctx context.Context, img image.Image, text string, cfg *Settings) (image.Image, MarkStats, error) {
+		b := img.Bounds()
+
+		// Render the mark alone, at full strength and without outline or
+		// shadow, and use its alpha as coverage.
+		layerCfg := *cfg
+		layerCfg.Color = "#ffffff"
+		layerCfg.Opacity = 1
+		layerCfg.FillColor = &color.NRGBA{255, 255, 255, 255}
+		layerCfg.OutlineWidth = 0
+		layerCfg.Shadow = nil
+		canvas := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		layer, stats, err := inner(ctx, canvas, text, &layerCfg)
+		if err != nil {
+			return nil, MarkStats{}, err
+		}
+		page, err := compose.Stencil(ctx, img, imaging.Clone(layer), cfg.StencilGray)
+		if err != nil {
+			return nil, MarkStats{}, err
+		}
+		return page, stats, nil
+	}
+}
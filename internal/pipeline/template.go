@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"watermark/pkg/codec"
+	"watermark/pkg/invisible"
+)
+
+// expandText fills the template variables in a watermark text for one image
+// or page of the given bounds:
+//
+//	{filename}          input file name, from the input path or WithFilename
+//	{date}, {datetime}  current date as 2006-01-02, or with time 2006-01-02 15:04
+//	{year}              current year
+//	{width}, {height}   image size in pixels, or page size in points for PDFs
+//	{counter}, {seq}    value set with WithCounter, 1 by default, or the
+//	                    number of the file in a WithSequence batch;
+//	                    {counter:0000} pads it with zeros to four digits
+//	{page}, {pages}     values set with WithPage, 1 of 1 by default; each
+//	                    page of a PDF sets its own
+//	{exif.Name}         camera metadata of JPEG inputs, see codec.EXIFNames
+//	{recipient}, {serial}  recipient and serial of a Fanout copy
+//
+// Unknown names in braces are left as they are; EXIF tags the image lacks
+// expand to nothing. A WithTextPlugin plugin may then replace the text, the
+// WithTextTransforms transformers run on the result, and WithFingerprint
+// last of all.
+func expandText(ctx context.Context, text string, cfg *Settings, bounds image.Rectangle) (string, error) {
+	text = fillTemplate(text, cfg, bounds)
+	if cfg.TextPlugin != nil {
+		var err error
+		if text, err = pluginText(ctx, text, cfg, bounds); err != nil {
+			return "", err
+		}
+	}
+	for _, fn := range cfg.Transforms {
+		text = fn(text)
+	}
+	if cfg.Fingerprint != "" {
+		text = invisible.HideFingerprint(text, cfg.Fingerprint)
+	}
+	return text, nil
+}
+
+// fillTemplate replaces the template variables listed at expandText.
+func fillTemplate(text string, cfg *Settings, b image.Rectangle) string {
+	if !strings.Contains(text, "{") {
+		return text
+	}
+	page, pages := cfg.Page, cfg.Pages
+	if pages == 0 {
+		page, pages = 1, 1
+	}
+	text = counterVar.ReplaceAllStringFunc(text, func(v string) string {
+		_, zeros, _ := strings.Cut(strings.TrimSuffix(v, "}"), ":")
+		return fmt.Sprintf("%0*d", len(zeros), cfg.Counter)
+	})
+	now := time.Now()
+	pairs := []string{
+		"{filename}", cfg.Filename,
+		"{date}", now.Format("2006-01-02"),
+		"{datetime}", now.Format("2006-01-02 15:04"),
+		"{year}", strconv.Itoa(now.Year()),
+		"{width}", strconv.Itoa(b.Dx()),
+		"{height}", strconv.Itoa(b.Dy()),
+		"{page}", strconv.Itoa(page),
+		"{pages}", strconv.Itoa(pages),
+	}
+	if cfg.Serial != "" {
+		pairs = append(pairs, "{recipient}", cfg.Recipient, "{serial}", cfg.Serial)
+	}
+	for _, name := range codec.EXIFNames {
+		pairs = append(pairs, "{exif."+name+"}", cfg.EXIF[name])
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// counterVar matches {counter} and {seq}, with an optional zero-padding
+// width such as {seq:000}.
+var counterVar = regexp.MustCompile(`\{(?:counter|seq)(?::0+)?\}`)
+
+// Sequence numbers the files of a batch: share one between the Add* calls
+// of a batch with WithSequence and each file processed takes the next
+// number for {counter} and {seq}. It is safe for concurrent use, though
+// files marked in parallel are numbered in the order they start.
+type Sequence struct {
+	next atomic.Int64
+}
+
+// NewSequence returns a Sequence whose first number is start.
+func NewSequence(start int) *Sequence {
+	s := &Sequence{}
+	s.next.Store(int64(start))
+	return s
+}
+
+// Next returns the next number and advances the sequence.
+func (s *Sequence) Next() int {
+	return int(s.next.Add(1) - 1)
+}
+
+// TextTransformer rewrites the watermark text after template variables are
+// filled in and before it is rendered.
+type TextTransformer func(text string) string
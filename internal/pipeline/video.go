@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"watermark/pkg/render"
+)
+
+// MarkFrames watermarks a stream of raw video frames: it reads frames of
+// width×height non-premultiplied 8-bit RGBA pixels from r until EOF, draws
+// the tiled pattern over each and writes them to dst in the same layout.
+// The pattern is rendered once, so every frame carries the identical mark.
+// It returns the number of frames written.
+func (w *Watermarker) MarkFrames(ctx context.Context, r io.Reader, dst io.Writer, width, height int) (int, error) {
+	if w.markImg == nil {
+		return 0, fmt.Errorf("%w: mark image not generated", render.ErrEmptyMark)
+	}
+	if width <= 0 || height <= 0 {
+		return 0, fmt.Errorf("invalid frame size %dx%d", width, height)
+	}
+	if n := w.TileCount(width, height); w.args.MaxTiles > 0 && n > w.args.MaxTiles {
+		return 0, fmt.Errorf("%w: %d tiles exceed the limit of %d; increase the spacing or font size, or raise the limit", ErrTooManyTiles, n, w.args.MaxTiles)
+	}
+	overlay := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if err := w.drawPattern(ctx, overlay); err != nil {
+		return 0, err
+	}
+	// Only the pixels the pattern touches need blending.
+	var touched []int
+	for i := 3; i < len(overlay.Pix); i += 4 {
+		if overlay.Pix[i] != 0 {
+			touched = append(touched, i-3)
+		}
+	}
+	if len(touched) == 0 {
+		w.notify.warn(EventInvisible, "watermark pattern is empty; frames pass through unmarked (increase opacity or verify font)")
+	}
+
+	frame := make([]byte, len(overlay.Pix))
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if _, err := io.ReadFull(r, frame); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return n, fmt.Errorf("frame %d is truncated", n+1)
+			}
+			return n, err
+		}
+		for _, i := range touched {
+			blendOver(frame[i:i+4], overlay.Pix[i:i+4])
+		}
+		if _, err := dst.Write(frame); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// blendOver draws the non-premultiplied RGBA pixel src over dst in place.
+func blendOver(dst, src []byte) {
+	sa := int(src[3])
+	if sa == 255 {
+		copy(dst, src)
+		return
+	}
+	da := int(dst[3]) * (255 - sa) / 255
+	oa := sa + da
+	if oa == 0 {
+		return
+	}
+	for c := 0; c < 3; c++ {
+		dst[c] = uint8((int(src[c])*sa + int(dst[c])*da + oa/2) / oa)
+	}
+	dst[3] = uint8(oa)
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	"watermark/internal/imagetest"
+)
+
+// naiveMeanRedChannel is meanRedChannel as a plain NRGBAAt loop.
+func naiveMeanRedChannel(img *image.NRGBA, r image.Rectangle) float64 {
+	if r.Empty() {
+		return 0
+	}
+	var sum uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sum += uint64(img.NRGBAAt(x, y).R)
+		}
+	}
+	return float64(sum) / float64(r.Dx()*r.Dy())
+}
+
+func TestMeanRedChannel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		min := image.Pt(rng.Intn(21)-10, rng.Intn(21)-10)
+		w, h := 1+rng.Intn(40), 1+rng.Intn(40)
+		img := imagetest.RandomMark(rng, min, w, h, rng.Intn(w*h+1))
+		x0, y0 := min.X+rng.Intn(w), min.Y+rng.Intn(h)
+		r := image.Rect(x0, y0, x0+rng.Intn(w+1), y0+rng.Intn(h+1)).Intersect(img.Bounds())
+		if got, want := meanRedChannel(img, r), naiveMeanRedChannel(img, r); got != want {
+			t.Fatalf("%v in %v: got %v, want %v", r, img.Bounds(), got, want)
+		}
+	}
+}
+
+func BenchmarkMeanRedChannel(b *testing.B) {
+	img := imagetest.TextLine()
+	b.Run("Pix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			meanRedChannel(img, img.Bounds())
+		}
+	})
+	b.Run("NRGBAAt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			naiveMeanRedChannel(img, img.Bounds())
+		}
+	})
+}
+
+func BenchmarkApply(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	mark := imagetest.RandomMark(rng, image.Point{}, 300, 80, 6000)
+	for _, bc := range []struct {
+		name  string
+		alpha uint8
+	}{{"Opaque", 255}, {"Transparent", 128}} {
+		base := image.NewNRGBA(image.Rect(0, 0, 4000, 3000))
+		rng.Read(base.Pix)
+		for i := 3; i < len(base.Pix); i += 4 {
+			base.Pix[i] = bc.alpha
+		}
+		wm := newImageWatermarker(WatermarkArgs{Space: 75, Angle: 30}, mark)
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := wm.Apply(base); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+
+	"github.com/disintegration/imaging"
+
+	"watermark/internal/parallel"
+	"watermark/pkg/compose"
+	"watermark/pkg/render"
+)
+
+// WatermarkArgs mirrors the Python WatermarkArgs configuration.
+type WatermarkArgs struct {
+	Mark           string
+	Color          string
+	Space          int
+	Angle          int
+	FontFamily     string
+	FontHeightCrop float64
+	Size           int
+	Opacity        float64
+	// Font, if set, is used instead of FontFamily.
+	Font *render.Font
+	// FontFallbacks are font files to draw the runes of Mark the font has
+	// no glyph for, tried in order.
+	FontFallbacks []string
+	// FontVariations sets axes of a variable font, see WithFontVariations.
+	FontVariations []render.FontVariation
+	// LineHeight scales the distance between lines of a multi-line Mark
+	// relative to the font's line height; 0 means 1.
+	LineHeight float64
+	// Align aligns the lines of a multi-line Mark; empty means AlignLeft.
+	Align render.Align
+	// TextTransform changes the case of Mark as it is rendered.
+	TextTransform render.TextTransform
+	// TextDirection sets Mark horizontally or in vertical columns; empty
+	// means TextDirectionHorizontal.
+	TextDirection render.TextDirection
+	// RotateTiles rotates each tile about its own center and lays the tiles
+	// on an axis-aligned grid, instead of rotating the whole tiled canvas.
+	RotateTiles bool
+	// LinearBlend composites the pattern in linear light instead of on
+	// sRGB values, so semi-transparent text is not darkened.
+	LinearBlend bool
+	// Workers is how many goroutines composite bands of the image at
+	// once; 0 means GOMAXPROCS.
+	Workers int
+	// Verify makes Apply compare the result with the image and warn with
+	// EventInvisible when the mark left it unchanged. The comparison takes
+	// another copy of the image and a full extra pass over it.
+	Verify bool
+	// MaxTiles makes Apply fail with ErrTooManyTiles instead of pasting more
+	// tiles than this; 0 means no limit.
+	MaxTiles int
+	// Logger receives warnings such as an invisible result; nil drops them.
+	Logger Logger
+	// OnEvent is called for every warning; nil ignores them.
+	OnEvent func(Event)
+	// Renderer draws the mark instead of the text fields above (Mark, Color,
+	// FontFamily, Font, FontFallbacks, FontVariations, FontHeightCrop, Size,
+	// LineHeight, Align, TextTransform, TextDirection); Mark is still passed
+	// to it as the text. nil renders Mark as text.
+	Renderer render.MarkRenderer
+}
+
+// Watermarker provides watermark generation and application.
+type Watermarker struct {
+	args    WatermarkArgs
+	markImg image.Image
+	pattern *compose.Pattern // nil without a mark
+	notify  notifier
+}
+
+// NewWatermarker creates a Watermarker and pre-generates the mark tile image.
+func NewWatermarker(args WatermarkArgs) (*Watermarker, error) {
+	r := args.Renderer
+	if r == nil {
+		if strings.TrimSpace(args.Mark) == "" {
+			return nil, fmt.Errorf("%w: args.Mark must not be empty", render.ErrEmptyMark)
+		}
+		if args.Font == nil && strings.TrimSpace(args.FontFamily) == "" && !render.HasDefaultFont() {
+			return nil, fmt.Errorf("%w: args.FontFamily must not be empty", render.ErrFontLoad)
+		}
+		r = render.TextRenderer{
+			FontPath:       args.FontFamily,
+			Font:           args.Font,
+			FontFallbacks:  args.FontFallbacks,
+			Variations:     args.FontVariations,
+			Size:           args.Size,
+			Color:          args.Color,
+			FontHeightCrop: args.FontHeightCrop,
+			LineHeight:     args.LineHeight,
+			Align:          args.Align,
+			Transform:      args.TextTransform,
+			Direction:      args.TextDirection,
+		}
+	}
+	wm := &Watermarker{args: args, notify: notifier{logger: args.Logger, onEvent: args.OnEvent}}
+	mark, err := r.Render(render.RenderOptions{Text: args.Mark, Opacity: args.Opacity, Workers: args.Workers})
+	if err != nil {
+		return nil, err
+	}
+	if mark == nil {
+		wm.notify.warn(EventEmptyMark, "generated mark image is empty; check mark text and font path")
+	}
+	wm.setMark(mark)
+	return wm, nil
+}
+
+// newImageWatermarker is NewWatermarker tiling a ready-made mark image
+// instead of rendered text. Text and font fields of args are ignored.
+func newImageWatermarker(args WatermarkArgs, mark image.Image) *Watermarker {
+	wm := &Watermarker{args: args, notify: notifier{logger: args.Logger, onEvent: args.OnEvent}}
+	wm.setMark(mark)
+	return wm
+}
+
+func (w *Watermarker) setMark(mark image.Image) {
+	w.markImg, w.pattern = mark, nil
+	if mark != nil {
+		w.pattern = compose.NewPattern(mark, w.args.Space, w.args.Angle, w.args.RotateTiles)
+	}
+}
+
+// Apply overlays the repeated watermark onto the image.
+func (w *Watermarker) Apply(im image.Image) (image.Image, error) {
+	return w.ApplyContext(context.Background(), im)
+}
+
+// ApplyContext is Apply with cancellation; ctx is checked between tile rows
+// and between compositing stages.
+func (w *Watermarker) ApplyContext(ctx context.Context, im image.Image) (image.Image, error) {
+	if err := w.check(im.Bounds()); err != nil {
+		return nil, err
+	}
+
+	var result *image.NRGBA
+	if w.args.LinearBlend {
+		overlay, err := w.overlay(ctx, im.Bounds())
+		if err != nil {
+			return nil, err
+		}
+		if result, err = compose.Blend(ctx, im, overlay, compose.BlendNormal, true, w.args.Workers); err != nil {
+			return nil, err
+		}
+	} else if result = imaging.Clone(im); compose.IsOpaque(im) {
+		// Over an opaque base, drawing the pattern straight into the copy
+		// matches going through a separate overlay and saves a full-size
+		// buffer and pass.
+		if err := w.drawPattern(ctx, result); err != nil {
+			return nil, err
+		}
+	} else {
+		overlay := image.NewNRGBA(result.Rect)
+		if err := w.drawPattern(ctx, overlay); err != nil {
+			return nil, err
+		}
+		parallel.Rows(w.args.Workers, result.Bounds(), func(band image.Rectangle) error {
+			draw.Draw(result, band, overlay, band.Min, draw.Over)
+			return nil
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if w.args.Verify && samePixels(imaging.Clone(im), result, w.args.Workers) {
+		w.notify.warn(EventInvisible, "result identical to source; watermark not visible (increase opacity or verify font)")
+	}
+
+	return result, nil
+}
+
+// check fails before any drawing if there is no mark or tiling bounds
+// would paste more tiles than allowed.
+func (w *Watermarker) check(bounds image.Rectangle) error {
+	if w.markImg == nil {
+		return fmt.Errorf("%w: mark image not generated", render.ErrEmptyMark)
+	}
+	if n := w.TileCount(bounds.Dx(), bounds.Dy()); w.args.MaxTiles > 0 && n > w.args.MaxTiles {
+		return fmt.Errorf("%w: %d tiles exceed the limit of %d; increase the spacing or font size, or raise the limit", ErrTooManyTiles, n, w.args.MaxTiles)
+	}
+	return nil
+}
+
+// overlay returns the tiled pattern alone on a transparent canvas of the
+// size of bounds, for blending as a layer.
+func (w *Watermarker) overlay(ctx context.Context, bounds image.Rectangle) (*image.NRGBA, error) {
+	if err := w.check(bounds); err != nil {
+		return nil, err
+	}
+	overlay := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	if err := w.drawPattern(ctx, overlay); err != nil {
+		return nil, err
+	}
+	return overlay, ctx.Err()
+}
+
+// drawPattern composites the tiled, rotated mark onto dst, which has the
+// size of the image.
+func (w *Watermarker) drawPattern(ctx context.Context, dst *image.NRGBA) error {
+	return w.pattern.Draw(ctx, dst, w.args.Workers)
+}
+
+// TileCount returns how many tiles Apply pastes for an image of the given
+// size, without doing the work.
+func (w *Watermarker) TileCount(width, height int) int {
+	if w.pattern == nil {
+		return 0
+	}
+	return w.pattern.Count(width, height)
+}
@@ -0,0 +1,28 @@
+package codec
+
+import "fmt"
+
+// ColorProfile says what happens to the embedded ICC color profile of an
+// input, such as Display P3 or Adobe RGB, whose pixel values mean other
+// colors than the same values in sRGB.
+type ColorProfile string
+
+const (
+	// ColorProfileKeep embeds the input's profile in JPEG and PNG outputs,
+	// so they show the colors the input did. Mark colors are taken as
+	// values in that profile.
+	ColorProfileKeep ColorProfile = "keep"
+	// ColorProfileSRGB converts the pixels to sRGB before marking and
+	// embeds no profile, for viewers and sites that ignore profiles. Colors
+	// outside sRGB are clipped.
+	ColorProfileSRGB ColorProfile = "srgb"
+)
+
+// ParseColorProfile parses "keep" or "srgb".
+func ParseColorProfile(s string) (ColorProfile, error) {
+	switch p := ColorProfile(s); p {
+	case ColorProfileKeep, ColorProfileSRGB:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid color profile mode %q, want keep or srgb", s)
+}
@@ -0,0 +1,145 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// Decode decodes an encoded image. Unless ignoreOrientation is set, the
+// EXIF orientation tag is applied so pixels are stored upright. With tolerant
+// set, a damaged JPEG is repaired as far as possible instead of failing, and
+// salvaged reports whether that happened.
+func Decode(data []byte, ignoreOrientation, tolerant bool) (img image.Image, salvaged bool, err error) {
+	if isTIFF(data) {
+		img, err := tiff.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, false, DecodeError(err)
+		}
+		return img, false, nil
+	}
+	orient := imaging.AutoOrientation(!ignoreOrientation)
+	img, err = imaging.Decode(bytes.NewReader(data), orient)
+	if err == nil {
+		return img, false, nil
+	}
+	if isPlainCMYK(err) {
+		if img, err := decodePlainCMYK(data, ignoreOrientation); err == nil {
+			return img, false, nil
+		}
+	}
+	if tolerant {
+		if candidates, serr := salvageJPEG(data); serr == nil {
+			for _, c := range candidates {
+				if img, cerr := imaging.Decode(bytes.NewReader(c), orient); cerr == nil {
+					return img, true, nil
+				}
+			}
+		}
+	}
+	return nil, false, DecodeError(err)
+}
+
+// DecodeConfig returns the size and format name of data without decoding
+// its pixels, like image.DecodeConfig.
+func DecodeConfig(data []byte) (image.Config, string, error) {
+	if isTIFF(data) {
+		cfg, err := tiff.DecodeConfig(bytes.NewReader(data))
+		return cfg, "tiff", err
+	}
+	return image.DecodeConfig(bytes.NewReader(data))
+}
+
+// isTIFF reports whether data starts with a TIFF header. TIFF is decoded
+// from a bytes.Reader directly: through image.Decode, which wraps readers
+// without Peek in a bufio.Reader, package tiff loses io.ReaderAt and
+// buffers up to whatever offset the file points at, gigabytes for a few
+// hostile bytes. TIFFs carry no EXIF orientation imaging applies anyway.
+func isTIFF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))
+}
+
+// DecodeError wraps an error decoding data in ErrUnsupportedFormat if the
+// format is unknown or uses a feature the decoder lacks, and in
+// ErrCorruptInput otherwise.
+func DecodeError(err error) error {
+	switch {
+	case errors.Is(err, image.ErrFormat):
+		return fmt.Errorf("decode input: %w", ErrUnsupportedFormat)
+	case errors.Is(err, ErrUnsupportedFormat):
+		return fmt.Errorf("decode input: %w", err)
+	case isUnsupported(err):
+		return fmt.Errorf("decode input: %w: %w", ErrUnsupportedFormat, err)
+	}
+	return fmt.Errorf("decode input: %w: %w", ErrCorruptInput, err)
+}
+
+// isUnsupported reports whether err is a decoder refusing a valid file.
+func isUnsupported(err error) bool {
+	var jerr jpeg.UnsupportedError
+	var perr png.UnsupportedError
+	var terr tiff.UnsupportedError
+	return errors.As(err, &jerr) || errors.As(err, &perr) || errors.As(err, &terr) || errors.Is(err, bmp.ErrUnsupported)
+}
+
+// isPlainCMYK reports whether err is image/jpeg refusing a four-component
+// JPEG without an Adobe APP14 segment, as some prepress tools write.
+func isPlainCMYK(err error) bool {
+	var unsupported jpeg.UnsupportedError
+	return errors.As(err, &unsupported) && strings.Contains(string(unsupported), "4-component")
+}
+
+// adobeCMYKSegment is an APP14 segment declaring CMYK samples (transform 0).
+var adobeCMYKSegment = []byte{0xFF, 0xEE, 0, 14, 'A', 'd', 'o', 'b', 'e', 0, 100, 0, 0, 0, 0, 0}
+
+// decodePlainCMYK decodes a four-component JPEG without an Adobe segment as
+// CMYK. image/jpeg reads CMYK only with the segment, and then assumes
+// Adobe's inverted samples where 255 means no ink; files without it store
+// ink amounts directly, so the samples are inverted back after decoding.
+func decodePlainCMYK(data []byte, ignoreOrientation bool) (image.Image, error) {
+	img, err := jpeg.Decode(bytes.NewReader(InsertJPEGSegments(data, [][]byte{adobeCMYKSegment})))
+	if err != nil {
+		return nil, err
+	}
+	cmyk, ok := img.(*image.CMYK)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %T decoding a CMYK JPEG", img)
+	}
+	for i := range cmyk.Pix {
+		cmyk.Pix[i] = 255 - cmyk.Pix[i]
+	}
+	if ignoreOrientation {
+		return cmyk, nil
+	}
+	return orientImage(cmyk, JPEGOrientation(data)), nil
+}
+
+// orientImage turns img upright for an EXIF orientation of 1 to 8, the way
+// imaging.AutoOrientation does.
+func orientImage(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
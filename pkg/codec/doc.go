@@ -0,0 +1,13 @@
+// Package codec decodes and encodes the images watermark reads and writes:
+// ImageSource sniffs an input and checks it against SourceLimits before
+// its pixels are decoded, with tolerant JPEG decoding that salvages
+// truncated files and plain CMYK, and Encode and Save write JPEG, PNG,
+// TIFF and ICO outputs with the options EncodeOptions offers. Around the
+// pixels it carries metadata from an input into its output: the ICC
+// profile, EXIF with its thumbnail redrawn, JPEG segments and the origin
+// checksum of EmbedOrigin. JPEGProof shows how much of a mark survives
+// JPEG compression.
+//
+// It depends on nothing else in this module but internal/parallel, so it
+// can be used on its own.
+package codec
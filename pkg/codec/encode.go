@@ -0,0 +1,147 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/tiff"
+
+	"watermark/internal/parallel"
+)
+
+// Save writes img to path in the format its extension names, like Encode.
+// Extensions of other formats imaging can write are encoded by imaging,
+// flattened onto jpgBackground. The file is written by WriteFileAtomic,
+// creating the directory if need be.
+func Save(img image.Image, path string, jpgBackground color.NRGBA, enc EncodeOptions, segs [][]byte, icc []byte, workers int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	format, err := FormatFromPath(path)
+	if err != nil {
+		imgFormat, err := imaging.FormatFromFilename(path)
+		if err != nil {
+			return fmt.Errorf("%w: output extension %q", ErrUnsupportedFormat, filepath.Ext(path))
+		}
+		flattened := Flatten(img, jpgBackground, workers)
+		return WriteFileAtomic(path, func(w io.Writer) error {
+			return imaging.Encode(w, flattened, imgFormat, imaging.JPEGQuality(100))
+		})
+	}
+	return WriteFileAtomic(path, func(w io.Writer) error {
+		return Encode(w, img, format, jpgBackground, enc, segs, icc, workers)
+	})
+}
+
+// WriteFileAtomic writes to a temporary file next to path and renames it into
+// place, so an interrupted run never leaves a truncated output behind.
+func WriteFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp uses 0600; match what os.Create would give under a usual umask.
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Encode writes img to w in format. JPEG output is flattened onto
+// jpgBackground, on workers goroutines, and carries segs (raw EXIF or XMP
+// segments) right after the SOI marker, with EXIF thumbnails redrawn from
+// what is written. JPEG and PNG output is encoded per enc and embeds icc if
+// it fits the samples written.
+func Encode(w io.Writer, img image.Image, format Format, jpgBackground color.NRGBA, enc EncodeOptions, segs [][]byte, icc []byte, workers int) error {
+	switch format {
+	case FormatJPEG:
+		flattened := Flatten(img, jpgBackground, workers)
+		var buf bytes.Buffer
+		if err := EncodeJPEG(&buf, flattened, enc); err != nil {
+			return err
+		}
+		segs = refreshThumbnails(segs, flattened)
+		if ICCFits(icc, false) {
+			segs = append(segs[:len(segs):len(segs)], JPEGICCSegments(icc)...)
+		}
+		_, err := w.Write(InsertJPEGSegments(buf.Bytes(), segs))
+		return err
+	case FormatPNG:
+		return EncodePNG(w, img, enc, icc)
+	case FormatWebP:
+		return EncodeWebP(w, img)
+	case FormatTIFF:
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	case FormatICO:
+		e, err := NewICOEntry(img)
+		if err != nil {
+			return err
+		}
+		return EncodeICO(w, []ICOEntry{e})
+	case FormatPDF:
+		return fmt.Errorf("%w: PDF output needs a PDF input", ErrUnsupportedFormat)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+}
+
+// EncodeOptions tunes how JPEG and PNG outputs are encoded; other formats
+// ignore it. The zero value writes baseline JPEG at quality 100 with 4:2:0
+// chroma subsampling, and PNG at the default compression level in the
+// smallest lossless color type.
+type EncodeOptions struct {
+	// Quality is the JPEG quality from 1 to 100; 0 means 100. Around 85 is
+	// usually indistinguishable and several times smaller.
+	Quality int
+	// Progressive writes a progressive JPEG, which browsers show coarse
+	// first and refine while it loads.
+	Progressive bool
+	// Subsampling is the JPEG chroma subsampling; empty means 4:2:0.
+	// Subsampling444 keeps colored text and thin colored lines sharp.
+	Subsampling Subsampling
+	// PNGCompression is the PNG compression level; empty means default.
+	// Fast encodes large batches several times quicker.
+	PNGCompression PNGCompression
+	// PNGColor is the PNG color type; empty means PNGColorAuto. Marked
+	// images have 8 bits per channel, so 16-bit inputs are written at 8.
+	PNGColor PNGColor
+}
+
+// Validate reports options out of range or not known.
+func (o EncodeOptions) Validate() error {
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("JPEG quality must be 1 to 100, got %d", o.Quality)
+	}
+	if o.Subsampling != "" && o.Subsampling != Subsampling420 && o.Subsampling != Subsampling444 {
+		return fmt.Errorf("invalid chroma subsampling %q", o.Subsampling)
+	}
+	return o.validatePNG()
+}
+
+// Flatten returns img drawn over bg, on workers goroutines; 0 means
+// GOMAXPROCS.
+func Flatten(img image.Image, bg color.NRGBA, workers int) image.Image {
+	rgba := image.NewRGBA(img.Bounds())
+	parallel.Rows(workers, img.Bounds(), func(band image.Rectangle) error {
+		draw.Draw(rgba, band, &image.Uniform{C: bg}, image.Point{}, draw.Src)
+		draw.Draw(rgba, band, img, band.Min, draw.Over)
+		return nil
+	})
+	return rgba
+}
@@ -0,0 +1,23 @@
+package codec
+
+import "errors"
+
+// Sentinel errors returned (possibly wrapped) by this package. Use errors.Is
+// to test for them.
+var (
+	// ErrUnsupportedFormat means data could not be decoded as a known image
+	// format.
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrCorruptInput means data is in a known format but too damaged to
+	// decode.
+	ErrCorruptInput = errors.New("corrupt image")
+	// ErrInputTooLarge means an input exceeds the SourceLimits in effect.
+	ErrInputTooLarge = errors.New("input too large")
+	// ErrFormatMismatch means an explicit output format was given for a
+	// file whose extension names no image format.
+	ErrFormatMismatch = errors.New("output file extension is not an image format")
+	// ErrNoOrigin means an image carries no origin checksum.
+	ErrNoOrigin = errors.New("no origin checksum found")
+	// ErrColorProfile means an ICC color profile cannot be converted from.
+	ErrColorProfile = errors.New("unsupported color profile")
+)
@@ -0,0 +1,198 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// EXIF tags read for the {exif.*} text variables.
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920A
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+)
+
+// EXIFNames lists the names ReadEXIF returns values for, in the order the
+// {exif.*} text variables are documented.
+var EXIFNames = []string{"DateTimeOriginal", "Make", "Model", "ISO", "FNumber", "ExposureTime", "FocalLength", "GPS"}
+
+// ifdEntry is one tag of a TIFF image file directory.
+type ifdEntry struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+// exifReader reads tags from the TIFF structure inside an EXIF segment.
+type exifReader struct {
+	tiff  []byte
+	order binary.ByteOrder
+}
+
+// ReadEXIF returns the {exif.*} text variables of a JPEG stream, keyed by
+// name without the "exif." prefix. Tags the image lacks are left out.
+func ReadEXIF(data []byte) map[string]string {
+	vars := map[string]string{}
+	for _, seg := range readJPEGMetadata(data) {
+		if !bytes.HasPrefix(seg[4:], exifHeader) {
+			continue
+		}
+		r, ok := newEXIFReader(seg[4+len(exifHeader):])
+		if !ok {
+			continue
+		}
+		ifd0 := r.ifd(int(r.order.Uint32(r.tiff[4:])))
+		exif := r.ifd(r.offset(ifd0[tagExifIFD]))
+		gps := r.ifd(r.offset(ifd0[tagGPSIFD]))
+
+		set := func(name, v string) {
+			if v != "" {
+				vars[name] = v
+			}
+		}
+		set("Make", r.ascii(ifd0[tagMake]))
+		set("Model", r.ascii(ifd0[tagModel]))
+		if dt := r.ascii(exif[tagDateTimeOriginal]); len(dt) >= 10 {
+			// "2024:05:01 12:00:00" → "2024-05-01 12:00:00"
+			set("DateTimeOriginal", strings.Replace(dt[:10], ":", "-", 2)+dt[10:])
+		}
+		if iso, ok := r.uint(exif[tagISO]); ok {
+			set("ISO", strconv.Itoa(iso))
+		}
+		if f, ok := r.rational(exif[tagFNumber], 0); ok && f > 0 {
+			set("FNumber", "f/"+strconv.FormatFloat(math.Round(f*10)/10, 'f', -1, 64))
+		}
+		if t, ok := r.rational(exif[tagExposureTime], 0); ok && t > 0 {
+			if t < 1 {
+				set("ExposureTime", fmt.Sprintf("1/%ds", int(math.Round(1/t))))
+			} else {
+				set("ExposureTime", strconv.FormatFloat(t, 'f', -1, 64)+"s")
+			}
+		}
+		if fl, ok := r.rational(exif[tagFocalLength], 0); ok && fl > 0 {
+			set("FocalLength", strconv.FormatFloat(math.Round(fl*10)/10, 'f', -1, 64)+"mm")
+		}
+		lat, latOK := r.degrees(gps[tagGPSLatitude])
+		lon, lonOK := r.degrees(gps[tagGPSLongitude])
+		if latOK && lonOK {
+			latRef, lonRef := r.ascii(gps[tagGPSLatitudeRef]), r.ascii(gps[tagGPSLongitudeRef])
+			if latRef == "" {
+				latRef = "N"
+			}
+			if lonRef == "" {
+				lonRef = "E"
+			}
+			set("GPS", fmt.Sprintf("%.5f°%s, %.5f°%s", lat, latRef, lon, lonRef))
+		}
+	}
+	return vars
+}
+
+func newEXIFReader(tiff []byte) (*exifReader, bool) {
+	if len(tiff) < 8 {
+		return nil, false
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return &exifReader{tiff: tiff, order: binary.LittleEndian}, true
+	case "MM":
+		return &exifReader{tiff: tiff, order: binary.BigEndian}, true
+	default:
+		return nil, false
+	}
+}
+
+// ifd reads the directory at off. An invalid offset yields an empty map.
+func (r *exifReader) ifd(off int) map[uint16]ifdEntry {
+	tags := map[uint16]ifdEntry{}
+	if off < 8 || off+2 > len(r.tiff) {
+		return tags
+	}
+	n := int(r.order.Uint16(r.tiff[off:]))
+	for i := 0; i < n; i++ {
+		e := off + 2 + i*12
+		if e+12 > len(r.tiff) {
+			break
+		}
+		typ := r.order.Uint16(r.tiff[e+2:])
+		count := int(r.order.Uint32(r.tiff[e+4:]))
+		size := map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}[typ]
+		if size == 0 || count <= 0 || count > len(r.tiff)/size {
+			continue
+		}
+		value := r.tiff[e+8 : e+12]
+		if n := size * count; n > 4 {
+			p := int(r.order.Uint32(value))
+			if p < 0 || p > len(r.tiff)-n {
+				continue
+			}
+			value = r.tiff[p : p+n]
+		} else {
+			value = value[:n]
+		}
+		tags[r.order.Uint16(r.tiff[e:])] = ifdEntry{typ: typ, count: count, value: value}
+	}
+	return tags
+}
+
+func (r *exifReader) offset(e ifdEntry) int {
+	v, _ := r.uint(e)
+	return v
+}
+
+func (r *exifReader) ascii(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+func (r *exifReader) uint(e ifdEntry) (int, bool) {
+	switch e.typ {
+	case 3:
+		return int(r.order.Uint16(e.value)), true
+	case 4:
+		return int(r.order.Uint32(e.value)), true
+	}
+	return 0, false
+}
+
+// rational returns the i-th value of a RATIONAL or SRATIONAL entry.
+func (r *exifReader) rational(e ifdEntry, i int) (float64, bool) {
+	if (e.typ != 5 && e.typ != 10) || i >= e.count {
+		return 0, false
+	}
+	num, den := r.order.Uint32(e.value[8*i:]), r.order.Uint32(e.value[8*i+4:])
+	if den == 0 {
+		return 0, false
+	}
+	if e.typ == 10 {
+		return float64(int32(num)) / float64(int32(den)), true
+	}
+	return float64(num) / float64(den), true
+}
+
+// degrees converts a GPS degrees, minutes, seconds triple to decimal degrees.
+func (r *exifReader) degrees(e ifdEntry) (float64, bool) {
+	d, ok1 := r.rational(e, 0)
+	m, ok2 := r.rational(e, 1)
+	s, ok3 := r.rational(e, 2)
+	if !ok1 || !ok2 || !ok3 {
+		return 0, false
+	}
+	return d + m/60 + s/3600, true
+}
@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Format is an output image encoding.
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatTIFF Format = "tiff"
+	FormatICO  Format = "ico"
+	// FormatWebP is lossless WebP, see EncodeWebP.
+	FormatWebP Format = "webp"
+	// FormatPDF is only valid for PDF inputs, which keep their format.
+	FormatPDF Format = "pdf"
+)
+
+// ParseFormat parses a format name such as "png", "jpg", "jpeg" or "tif".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "jpg", "jpeg":
+		return FormatJPEG, nil
+	case "png":
+		return FormatPNG, nil
+	case "tif", "tiff":
+		return FormatTIFF, nil
+	case "ico":
+		return FormatICO, nil
+	case "webp":
+		return FormatWebP, nil
+	case "pdf":
+		return FormatPDF, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedFormat, name)
+	}
+}
+
+// FormatFromPath picks the output format from the file extension of path.
+func FormatFromPath(path string) (Format, error) {
+	return ParseFormat(filepath.Ext(path))
+}
+
+// CheckFormat returns ErrFormatMismatch if the extension of path names no
+// image format, so writing format to it would hide what the file holds. An
+// extension of another image format is fine: an explicit format wins.
+func CheckFormat(path string, format Format) error {
+	if _, err := FormatFromPath(path); err == nil {
+		return nil
+	}
+	if _, err := imaging.FormatFromFilename(path); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %q is not an image file name to write %s to", ErrFormatMismatch, filepath.Base(path), format)
+}
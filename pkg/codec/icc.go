@@ -0,0 +1,386 @@
+package codec
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// iccColorSpace returns the data color space of an ICC profile, e.g. "RGB "
+// or "GRAY", or "" if the header is too short.
+func iccColorSpace(icc []byte) string {
+	if len(icc) < 128 {
+		return ""
+	}
+	return string(icc[16:20])
+}
+
+// ICCFits reports whether icc describes an output with gray or RGB samples.
+func ICCFits(icc []byte, gray bool) bool {
+	if gray {
+		return iccColorSpace(icc) == "GRAY"
+	}
+	return iccColorSpace(icc) == "RGB "
+}
+
+// iccTag returns the data of the tag sig of an ICC profile, or nil.
+func iccTag(icc []byte, sig string) []byte {
+	if len(icc) < 132 {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint32(icc[128:]))
+	for i := 0; i < n && 132+12*(i+1) <= len(icc); i++ {
+		e := icc[132+12*i:]
+		if string(e[:4]) != sig {
+			continue
+		}
+		off, size := int(binary.BigEndian.Uint32(e[4:])), int(binary.BigEndian.Uint32(e[8:]))
+		if off < 0 || size < 0 || off+size > len(icc) {
+			return nil
+		}
+		return icc[off : off+size]
+	}
+	return nil
+}
+
+// s15Fixed16 decodes the ICC signed 15.16 fixed-point number at b.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// iccXYZ decodes an XYZType tag.
+func iccXYZ(tag []byte) ([3]float64, bool) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, false
+	}
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, true
+}
+
+// iccCurve decodes a curveType or parametricCurveType tag into a function
+// from encoded to linear values, both in [0, 1].
+func iccCurve(tag []byte) (func(float64) float64, bool) {
+	if len(tag) < 12 {
+		return nil, false
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n < 0 || 12+2*n > len(tag) {
+			return nil, false
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, true
+		case 1:
+			g := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, g) }, true
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			f := x * float64(n-1)
+			i := int(f)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			return table[i] + (table[i+1]-table[i])*(f-float64(i))
+		}, true
+	case "para":
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		count := []int{1, 3, 4, 5, 7}
+		if kind >= len(count) || 12+4*count[kind] > len(tag) {
+			return nil, false
+		}
+		var p [7]float64
+		for i := 0; i < count[kind]; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		// Every type is Y = (aX+b)^g + e from X >= d on, and Y = cX + f
+		// below, with the parameters of ICC.1 table 65 mapped onto that.
+		g, a, b := p[0], p[1], p[2]
+		var c, d, e, f float64
+		switch kind {
+		case 0:
+			a, d = 1, math.Inf(-1)
+		case 1:
+			d = -b / a
+		case 2:
+			d, e, f = -b/a, p[3], p[3]
+		case 3:
+			c, d = p[3], p[4]
+		case 4:
+			c, d, e, f = p[3], p[4], p[5], p[6]
+		}
+		return func(x float64) float64 {
+			if x < d {
+				return c*x + f
+			}
+			return math.Pow(math.Max(a*x+b, 0), g) + e
+		}, true
+	}
+	return nil, false
+}
+
+// srgbD50 maps linear sRGB to the D50 XYZ connection space of ICC
+// profiles; its columns are the colorants of the standard sRGB profile.
+var srgbD50 = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// srgbFromD50 maps the D50 connection space back to linear sRGB.
+var srgbFromD50 = invert3(srgbD50)
+
+// invert3 returns the inverse of the non-singular matrix m.
+func invert3(m [3][3]float64) [3][3]float64 {
+	var inv [3][3]float64
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// Cofactor of m[j][i], for the transposed adjugate.
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			inv[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+	return inv
+}
+
+// srgbEncode is the sRGB transfer function from linear light to an 8-bit
+// value, tabulated at 4096 steps.
+var srgbEncode = func() []uint8 {
+	t := make([]uint8, 4096)
+	for i := range t {
+		v := float64(i) / 4095
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		t[i] = uint8(math.Round(v * 255))
+	}
+	return t
+}()
+
+// srgbDecode is the inverse sRGB transfer function, from an 8-bit value to
+// linear light.
+var srgbDecode = func() (t [256]float64) {
+	for i := range t {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			t[i] = v / 12.92
+		} else {
+			t[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	return t
+}()
+
+// SRGBToLinear converts an 8-bit sRGB value to linear light in [0, 1].
+func SRGBToLinear(v uint8) float64 {
+	return srgbDecode[v]
+}
+
+// LinearToSRGB converts linear light in [0, 1], clamped, to an 8-bit sRGB
+// value.
+func LinearToSRGB(v float64) uint8 {
+	i := int(v*4095 + 0.5)
+	return srgbEncode[min(max(i, 0), 4095)]
+}
+
+// ConvertToSRGB converts img from the RGB or gray matrix/TRC profile icc to
+// sRGB. Profiles built from lookup tables, and those of other color spaces
+// such as CMYK, return ErrColorProfile.
+func ConvertToSRGB(img image.Image, icc []byte) (*image.NRGBA, error) {
+	var lin [3][256]float64
+	var m [3][3]float64
+	switch iccColorSpace(icc) {
+	case "RGB ":
+		for i, ch := range []string{"r", "g", "b"} {
+			xyz, ok := iccXYZ(iccTag(icc, ch+"XYZ"))
+			if !ok {
+				return nil, fmt.Errorf("%w: not a matrix profile", ErrColorProfile)
+			}
+			for k := 0; k < 3; k++ {
+				m[k][i] = xyz[k]
+			}
+			curve, ok := iccCurve(iccTag(icc, ch+"TRC"))
+			if !ok {
+				return nil, fmt.Errorf("%w: unreadable %sTRC curve", ErrColorProfile, ch)
+			}
+			for v := range lin[i] {
+				lin[i][v] = curve(float64(v) / 255)
+			}
+		}
+		m = mul3(srgbFromD50, m)
+	case "GRAY":
+		curve, ok := iccCurve(iccTag(icc, "kTRC"))
+		if !ok {
+			return nil, fmt.Errorf("%w: unreadable kTRC curve", ErrColorProfile)
+		}
+		for v := range lin[0] {
+			lin[0][v] = curve(float64(v) / 255)
+		}
+		lin[1], lin[2] = lin[0], lin[0]
+		m = [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	default:
+		return nil, fmt.Errorf("%w: %q color space", ErrColorProfile, iccColorSpace(icc))
+	}
+
+	out := imaging.Clone(img)
+	for i := 0; i+3 < len(out.Pix); i += 4 {
+		p := out.Pix[i : i+4 : i+4]
+		r, g, b := lin[0][p[0]], lin[1][p[1]], lin[2][p[2]]
+		p[0] = LinearToSRGB(m[0][0]*r + m[0][1]*g + m[0][2]*b)
+		p[1] = LinearToSRGB(m[1][0]*r + m[1][1]*g + m[1][2]*b)
+		p[2] = LinearToSRGB(m[2][0]*r + m[2][1]*g + m[2][2]*b)
+	}
+	return out, nil
+}
+
+func mul3(a, b [3][3]float64) [3][3]float64 {
+	var c [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				c[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return c
+}
+
+// iccChunkSize is the most profile data one JPEG APP2 segment holds.
+const iccChunkSize = 65535 - 2 - 14
+
+// JPEGICCSegments splits icc into the APP2 segments that carry it in a
+// JPEG stream, marker and length included.
+func JPEGICCSegments(icc []byte) [][]byte {
+	count := (len(icc) + iccChunkSize - 1) / iccChunkSize
+	if count == 0 || count > 255 {
+		return nil
+	}
+	segs := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := icc[i*iccChunkSize : min((i+1)*iccChunkSize, len(icc))]
+		seg := []byte{0xFF, MarkerAPP2, 0, 0}
+		binary.BigEndian.PutUint16(seg[2:], uint16(2+len(iccHeader)+2+len(chunk)))
+		seg = append(seg, iccHeader...)
+		seg = append(seg, byte(i+1), byte(count))
+		segs = append(segs, append(seg, chunk...))
+	}
+	return segs
+}
+
+// insertPNGICC adds an iCCP chunk holding icc after the IHDR chunk of an
+// encoded PNG stream, where the specification wants it.
+func insertPNGICC(data, icc []byte) ([]byte, error) {
+	var body bytes.Buffer
+	body.WriteString("iCCP")
+	body.WriteString("ICC profile\x00\x00")
+	zw := zlib.NewWriter(&body)
+	if _, err := zw.Write(icc); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return InsertPNGChunk(data, body.Bytes())
+}
+
+// InsertPNGChunk adds a chunk, body being its type followed by its data,
+// after the IHDR chunk of an encoded PNG stream.
+func InsertPNGChunk(data, body []byte) ([]byte, error) {
+	const ihdrEnd = 8 + 12 + 13
+	if len(data) < ihdrEnd {
+		return nil, fmt.Errorf("short PNG stream")
+	}
+	chunk := make([]byte, 4, 4+len(body)+4)
+	binary.BigEndian.PutUint32(chunk, uint32(len(body)-4))
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(body))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+// iccHeader starts each APP2 segment carrying a chunk of an ICC profile; a
+// sequence number and the chunk count follow it.
+var iccHeader = []byte("ICC_PROFILE\x00")
+
+// JPEGICC reassembles the ICC profile split across the APP2 segments of a
+// JPEG stream, or returns nil if there is none or a chunk is missing.
+func JPEGICC(data []byte) []byte {
+	type chunk struct {
+		seq  int
+		data []byte
+	}
+	var chunks []chunk
+	count := 0
+	WalkJPEGSegments(data, func(marker byte, seg []byte) {
+		payload := seg[4:]
+		if marker != MarkerAPP2 || !bytes.HasPrefix(payload, iccHeader) || len(payload) < len(iccHeader)+2 {
+			return
+		}
+		chunks = append(chunks, chunk{int(payload[len(iccHeader)]), payload[len(iccHeader)+2:]})
+		count = int(payload[len(iccHeader)+1])
+	})
+	if len(chunks) == 0 || len(chunks) != count {
+		return nil
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+	var icc []byte
+	for i, c := range chunks {
+		if c.seq != i+1 {
+			return nil
+		}
+		icc = append(icc, c.data...)
+	}
+	return icc
+}
+
+// PNGICC returns the decompressed profile of a PNG's iCCP chunk, or nil.
+func PNGICC(data []byte) []byte {
+	for i := 8; i+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		if n < 0 || i+12+n > len(data) || typ == "IDAT" {
+			return nil
+		}
+		if typ == "iCCP" {
+			chunk := data[i+8 : i+8+n]
+			// A profile name, a NUL and the compression method precede the
+			// zlib stream.
+			name := bytes.IndexByte(chunk, 0)
+			if name < 0 || name+2 > len(chunk) {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(chunk[name+2:]))
+			if err != nil {
+				return nil
+			}
+			icc, err := io.ReadAll(zr)
+			if err != nil {
+				return nil
+			}
+			return icc
+		}
+		i += 12 + n
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// ICOEntry is one image of an ICO file. Data holds the encoded image as
+// stored in the file (PNG or a headerless BMP); Image is its decoded form.
+type ICOEntry struct {
+	Image image.Image
+	Data  []byte
+	BPP   int // bits per pixel, as the directory records it
+}
+
+// isICO reports whether data starts with an ICO file header.
+func isICO(data []byte) bool {
+	return len(data) >= 6 && binary.LittleEndian.Uint16(data[0:]) == 0 &&
+		binary.LittleEndian.Uint16(data[2:]) == 1 && binary.LittleEndian.Uint16(data[4:]) > 0
+}
+
+// DecodeICO splits an ICO file into its images.
+func DecodeICO(data []byte) ([]ICOEntry, error) {
+	if !isICO(data) {
+		return nil, errors.New("not an ICO file")
+	}
+	n := int(binary.LittleEndian.Uint16(data[4:]))
+	if len(data) < 6+16*n {
+		return nil, errors.New("truncated ICO directory")
+	}
+	entries := make([]ICOEntry, 0, n)
+	for i := 0; i < n; i++ {
+		d := data[6+16*i:]
+		size := int(binary.LittleEndian.Uint32(d[8:]))
+		off := int(binary.LittleEndian.Uint32(d[12:]))
+		if off < 0 || size <= 0 || off > len(data) || size > len(data)-off {
+			return nil, fmt.Errorf("ICO image %d lies outside the file", i)
+		}
+		raw := data[off : off+size]
+		var img image.Image
+		var err error
+		if bytes.HasPrefix(raw, []byte("\x89PNG")) {
+			img, err = png.Decode(bytes.NewReader(raw))
+		} else {
+			img, err = decodeICOBitmap(raw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ICO image %d: %w", i, err)
+		}
+		entries = append(entries, ICOEntry{Image: img, Data: raw, BPP: int(binary.LittleEndian.Uint16(d[6:]))})
+	}
+	return entries, nil
+}
+
+// decodeICOBitmap decodes the headerless BMP of an ICO entry: a
+// BITMAPINFOHEADER with doubled height, optional palette, bottom-up XOR
+// pixels and a 1-bit AND transparency mask.
+func decodeICOBitmap(raw []byte) (image.Image, error) {
+	if len(raw) < 40 {
+		return nil, errors.New("truncated bitmap header")
+	}
+	hdr := int(binary.LittleEndian.Uint32(raw[0:]))
+	w := int(int32(binary.LittleEndian.Uint32(raw[4:])))
+	h := int(int32(binary.LittleEndian.Uint32(raw[8:]))) / 2
+	bpp := int(binary.LittleEndian.Uint16(raw[14:]))
+	if binary.LittleEndian.Uint32(raw[16:]) != 0 {
+		return nil, fmt.Errorf("%w: compressed ICO bitmap", ErrUnsupportedFormat)
+	}
+	if w <= 0 || h <= 0 || w > 1024 || h > 1024 || hdr < 40 || hdr > len(raw) {
+		return nil, errors.New("invalid bitmap header")
+	}
+	var palette []color.NRGBA
+	p := hdr
+	switch bpp {
+	case 1, 4, 8:
+		colors := int(binary.LittleEndian.Uint32(raw[32:]))
+		if colors == 0 || colors > 1<<bpp {
+			colors = 1 << bpp
+		}
+		if len(raw) < p+4*colors {
+			return nil, errors.New("truncated palette")
+		}
+		for i := 0; i < colors; i++ {
+			c := raw[p+4*i:]
+			palette = append(palette, color.NRGBA{c[2], c[1], c[0], 255})
+		}
+		p += 4 * colors
+	case 24, 32:
+	default:
+		return nil, fmt.Errorf("%w: ICO bitmap of %d bits per pixel", ErrUnsupportedFormat, bpp)
+	}
+	stride := (w*bpp + 31) / 32 * 4
+	maskStride := (w + 31) / 32 * 4
+	if len(raw) < p+stride*h {
+		return nil, errors.New("truncated pixel data")
+	}
+	hasMask := len(raw) >= p+stride*h+maskStride*h
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		row := raw[p+(h-1-y)*stride:]
+		for x := 0; x < w; x++ {
+			var c color.NRGBA
+			switch bpp {
+			case 32:
+				c = color.NRGBA{row[4*x+2], row[4*x+1], row[4*x], row[4*x+3]}
+			case 24:
+				c = color.NRGBA{row[3*x+2], row[3*x+1], row[3*x], 255}
+			default:
+				bit := x * bpp
+				idx := int(row[bit/8]>>(8-bpp-bit%8)) & (1<<bpp - 1)
+				if idx < len(palette) {
+					c = palette[idx]
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	// Bitmaps without an alpha channel take transparency from the AND mask.
+	// 32-bit ones normally carry alpha; an all-zero alpha means they don't.
+	if bpp == 32 && !allTransparent(img) {
+		return img, nil
+	}
+	if hasMask {
+		mask := raw[p+stride*h:]
+		for y := 0; y < h; y++ {
+			row := mask[(h-1-y)*maskStride:]
+			for x := 0; x < w; x++ {
+				i := img.PixOffset(x, y)
+				if row[x/8]&(0x80>>(x%8)) != 0 {
+					img.Pix[i+3] = 0
+				} else {
+					img.Pix[i+3] = 255
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+func allTransparent(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodeICO writes entries as an ICO file. Entries keep their stored bytes,
+// so marked images must have been re-encoded (as PNG) beforehand.
+func EncodeICO(w io.Writer, entries []ICOEntry) error {
+	var buf bytes.Buffer
+	hdr := []byte{0, 0, 1, 0, 0, 0}
+	binary.LittleEndian.PutUint16(hdr[4:], uint16(len(entries)))
+	buf.Write(hdr)
+	off := 6 + 16*len(entries)
+	for _, e := range entries {
+		b := e.Image.Bounds()
+		if b.Dx() > 256 || b.Dy() > 256 {
+			return fmt.Errorf("ICO images are at most 256×256, got %d×%d", b.Dx(), b.Dy())
+		}
+		d := make([]byte, 16)
+		d[0], d[1] = byte(b.Dx()), byte(b.Dy()) // 256 wraps to 0, as the format wants
+		binary.LittleEndian.PutUint16(d[4:], 1)
+		binary.LittleEndian.PutUint16(d[6:], uint16(e.BPP))
+		binary.LittleEndian.PutUint32(d[8:], uint32(len(e.Data)))
+		binary.LittleEndian.PutUint32(d[12:], uint32(off))
+		buf.Write(d)
+		off += len(e.Data)
+	}
+	for _, e := range entries {
+		buf.Write(e.Data)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// NewICOEntry encodes img as a PNG-compressed ICO entry.
+func NewICOEntry(img image.Image) (ICOEntry, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return ICOEntry{}, err
+	}
+	return ICOEntry{Image: img, Data: buf.Bytes(), BPP: 32}, nil
+}
@@ -0,0 +1,625 @@
+package codec
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math"
+)
+
+// Subsampling is a JPEG chroma subsampling scheme.
+type Subsampling string
+
+const (
+	Subsampling420 Subsampling = "4:2:0"
+	Subsampling444 Subsampling = "4:4:4"
+)
+
+// ParseSubsampling parses "420", "4:2:0", "444" or "4:4:4".
+func ParseSubsampling(s string) (Subsampling, error) {
+	switch s {
+	case "420", "4:2:0":
+		return Subsampling420, nil
+	case "444", "4:4:4":
+		return Subsampling444, nil
+	}
+	return "", fmt.Errorf("invalid chroma subsampling %q, want 4:2:0 or 4:4:4", s)
+}
+
+// EncodeJPEG writes img as a JPEG. Baseline 4:2:0 output goes through
+// image/jpeg; progressive or 4:4:4 output through jpegEncoder.
+func EncodeJPEG(w io.Writer, img image.Image, o EncodeOptions) error {
+	quality := o.Quality
+	if quality == 0 {
+		quality = 100
+	}
+	if !o.Progressive && o.Subsampling != Subsampling444 {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Rect, img, rgba.Rect.Min, draw.Src)
+	}
+	e := &jpegEncoder{w: bufio.NewWriter(w), sub444: o.Subsampling == Subsampling444}
+	e.encode(rgba, quality, o.Progressive)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// Quantization tables from Annex K of the JPEG specification, in natural
+// order, and the zigzag order coefficients are written in.
+var (
+	jpegLumaQuant = [64]int{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	}
+	jpegChromaQuant = [64]int{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	}
+	jpegZigzag = [64]int{
+		0, 1, 8, 16, 9, 2, 3, 10,
+		17, 24, 32, 25, 18, 11, 4, 5,
+		12, 19, 26, 33, 40, 48, 41, 34,
+		27, 20, 13, 6, 7, 14, 21, 28,
+		35, 42, 49, 56, 57, 50, 43, 36,
+		29, 22, 15, 23, 30, 37, 44, 51,
+		58, 59, 52, 45, 38, 31, 39, 46,
+		53, 60, 61, 54, 47, 55, 62, 63,
+	}
+)
+
+// jpegHuffSpec is a Huffman table as code counts per length and symbols.
+type jpegHuffSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// The standard Huffman tables of Annex K: luma DC, luma AC, chroma DC,
+// chroma AC.
+var jpegHuffSpecs = [4]jpegHuffSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// jpegCode is a Huffman code: its bits, right-aligned, and its length.
+type jpegCode struct {
+	bits uint32
+	n    uint
+}
+
+var jpegHuffCodes = func() (t [4][256]jpegCode) {
+	for i, spec := range jpegHuffSpecs {
+		t[i] = spec.codes()
+	}
+	return t
+}()
+
+// codes assigns the canonical Huffman codes of the table to its symbols.
+func (spec *jpegHuffSpec) codes() (t [256]jpegCode) {
+	code, k := uint32(0), 0
+	for n, count := range spec.counts {
+		for j := 0; j < int(count); j++ {
+			t[spec.values[k]] = jpegCode{code, uint(n + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return t
+}
+
+// jpegDCT is cos((2x+1)uπ/16) scaled by C(u)/2, so a row and a column pass
+// give the forward DCT of a block.
+var jpegDCT = func() (t [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			t[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// jpegEncoder writes a three-component YCbCr JPEG with the standard tables,
+// baseline or progressive by spectral selection.
+type jpegEncoder struct {
+	w      *bufio.Writer
+	err    error
+	sub444 bool
+	bits   uint32
+	nbits  uint
+	quant  [2][64]int
+}
+
+// jpegPlane holds the quantized coefficients of one component, in zigzag
+// order, for a grid of bw×bh blocks padded out to whole MCUs; cw×ch blocks
+// cover the image.
+type jpegPlane struct {
+	coef   [][64]int32
+	bw, bh int
+	cw, ch int
+}
+
+func (e *jpegEncoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *jpegEncoder) marker(m byte, payload []byte) {
+	n := len(payload) + 2
+	e.write([]byte{0xff, m, byte(n >> 8), byte(n)})
+	e.write(payload)
+}
+
+func (e *jpegEncoder) emit(bits uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		e.bits = e.bits<<1 | (bits>>uint(i))&1
+		e.nbits++
+		if e.nbits == 8 {
+			b := byte(e.bits)
+			e.write([]byte{b})
+			if b == 0xff {
+				e.write([]byte{0})
+			}
+			e.bits, e.nbits = 0, 0
+		}
+	}
+}
+
+// flushBits pads the last byte of a scan with ones.
+func (e *jpegEncoder) flushBits() {
+	if e.nbits > 0 {
+		e.emit(0xff, 8-e.nbits)
+	}
+}
+
+// emitValue writes a Huffman symbol carrying the magnitude category of v,
+// combined with run, followed by v's extra bits.
+func (e *jpegEncoder) emitValue(table int, run int, v int32) {
+	n, bits := jpegCategory(v)
+	c := jpegHuffCodes[table][byte(run<<4)|byte(n)]
+	e.emit(c.bits, c.n)
+	e.emit(bits, n)
+}
+
+// jpegCategory returns the magnitude category of v and the extra bits that
+// encode it within the category.
+func jpegCategory(v int32) (uint, uint32) {
+	a, b := v, v
+	if a < 0 {
+		a, b = -v, v-1
+	}
+	n := uint(0)
+	for a > 0 {
+		n++
+		a >>= 1
+	}
+	return n, uint32(b) & (1<<n - 1)
+}
+
+// jpegToken is a Huffman symbol followed by n extra bits.
+type jpegToken struct {
+	sym  byte
+	n    uint8
+	bits uint32
+}
+
+// acTokens codes coefficients ss to se of the blocks covering the image for
+// a progressive AC scan. Runs of blocks with nothing left in the band share
+// one end-of-band code.
+func (p *jpegPlane) acTokens(ss, se int) []jpegToken {
+	var toks []jpegToken
+	eobrun := 0
+	flushEOB := func() {
+		if eobrun == 0 {
+			return
+		}
+		n, _ := jpegCategory(int32(eobrun))
+		n--
+		toks = append(toks, jpegToken{byte(n << 4), uint8(n), uint32(eobrun) & (1<<n - 1)})
+		eobrun = 0
+	}
+	for by := 0; by < p.ch; by++ {
+		for bx := 0; bx < p.cw; bx++ {
+			blk := &p.coef[by*p.bw+bx]
+			run := 0
+			for k := ss; k <= se; k++ {
+				v := blk[k]
+				if v == 0 {
+					run++
+					continue
+				}
+				flushEOB()
+				for ; run > 15; run -= 16 {
+					toks = append(toks, jpegToken{sym: 0xf0})
+				}
+				n, bits := jpegCategory(v)
+				toks = append(toks, jpegToken{byte(run<<4) | byte(n), uint8(n), bits})
+				run = 0
+			}
+			if run > 0 {
+				if eobrun++; eobrun == 0x7fff {
+					flushEOB()
+				}
+			}
+		}
+	}
+	flushEOB()
+	return toks
+}
+
+// jpegOptimalTable builds a Huffman table for toks, limited to 16-bit codes,
+// following Annex K.2 of the JPEG specification.
+func jpegOptimalTable(toks []jpegToken) jpegHuffSpec {
+	var freq [257]int
+	for _, t := range toks {
+		freq[t.sym]++
+	}
+	// A dummy symbol reserves the all-ones code.
+	freq[256] = 1
+	var size [257]int
+	var next [257]int
+	for i := range next {
+		next[i] = -1
+	}
+	for {
+		v1, v2 := -1, -1
+		for i, f := range freq {
+			if f > 0 && (v1 < 0 || f <= freq[v1]) {
+				v1 = i
+			}
+		}
+		for i, f := range freq {
+			if f > 0 && i != v1 && (v2 < 0 || f <= freq[v2]) {
+				v2 = i
+			}
+		}
+		if v2 < 0 {
+			break
+		}
+		freq[v1] += freq[v2]
+		freq[v2] = 0
+		for size[v1]++; next[v1] >= 0; size[v1]++ {
+			v1 = next[v1]
+		}
+		next[v1] = v2
+		for size[v2]++; next[v2] >= 0; size[v2]++ {
+			v2 = next[v2]
+		}
+	}
+	var bits [33]int
+	for _, n := range size {
+		if n > 0 {
+			bits[n]++
+		}
+	}
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	var spec jpegHuffSpec
+	for n := 1; n <= 16; n++ {
+		spec.counts[n-1] = byte(bits[n])
+	}
+	// Symbols in order of code length, shortest first; the lengths limited
+	// above are handed out in the same order.
+	for n := 1; n <= 32; n++ {
+		for sym := 0; sym < 256; sym++ {
+			if size[sym] == n {
+				spec.values = append(spec.values, byte(sym))
+			}
+		}
+	}
+	return spec
+}
+
+func (e *jpegEncoder) encode(img *image.RGBA, quality int, progressive bool) {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for i, base := range [2]*[64]int{&jpegLumaQuant, &jpegChromaQuant} {
+		for k, q := range base {
+			e.quant[i][k] = min(max((q*scale+50)/100, 1), 255)
+		}
+	}
+	planes := e.transform(img)
+
+	b := img.Bounds()
+	e.write([]byte{0xff, 0xd8})
+	dqt := make([]byte, 0, 130)
+	for i := range e.quant {
+		dqt = append(dqt, byte(i))
+		for _, k := range jpegZigzag {
+			dqt = append(dqt, byte(e.quant[i][k]))
+		}
+	}
+	e.marker(0xdb, dqt)
+	sof := byte(0xc0)
+	if progressive {
+		sof = 0xc2
+	}
+	lumaSampling := byte(0x22)
+	if e.sub444 {
+		lumaSampling = 0x11
+	}
+	e.marker(sof, []byte{
+		8, byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx()), 3,
+		1, lumaSampling, 0,
+		2, 0x11, 1,
+		3, 0x11, 1,
+	})
+	var dht []byte
+	for i, spec := range jpegHuffSpecs {
+		dht = append(dht, byte(i%2)<<4|byte(i/2))
+		dht = append(dht, spec.counts[:]...)
+		dht = append(dht, spec.values...)
+	}
+	e.marker(0xc4, dht)
+
+	if !progressive {
+		e.marker(0xda, []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+		e.scanInterleaved(planes, 63)
+	} else {
+		e.marker(0xda, []byte{3, 1, 0x00, 2, 0x10, 3, 0x10, 0, 0, 0})
+		e.scanInterleaved(planes, 0)
+		for _, s := range []struct{ comp, ss, se int }{{0, 1, 5}, {1, 1, 63}, {2, 1, 63}, {0, 6, 63}} {
+			toks := planes[s.comp].acTokens(s.ss, s.se)
+			table := jpegOptimalTable(toks)
+			e.marker(0xc4, append([]byte{0x10}, append(table.counts[:], table.values...)...))
+			e.marker(0xda, []byte{1, byte(s.comp + 1), 0x00, byte(s.ss), byte(s.se), 0})
+			codes := table.codes()
+			for _, t := range toks {
+				e.emit(codes[t.sym].bits, codes[t.sym].n)
+				e.emit(t.bits, uint(t.n))
+			}
+			e.flushBits()
+		}
+	}
+	e.write([]byte{0xff, 0xd9})
+}
+
+// scanInterleaved writes coefficients 0 to se of every component, MCU by
+// MCU.
+func (e *jpegEncoder) scanInterleaved(planes [3]*jpegPlane, se int) {
+	n := 2
+	if e.sub444 {
+		n = 1
+	}
+	var pred [3]int32
+	mcuW, mcuH := planes[1].bw, planes[1].bh
+	for my := 0; my < mcuH; my++ {
+		for mx := 0; mx < mcuW; mx++ {
+			for c, p := range planes {
+				table := min(c, 1) * 2
+				k := 1
+				if c == 0 {
+					k = n
+				}
+				for y := 0; y < k; y++ {
+					for x := 0; x < k; x++ {
+						blk := &p.coef[(my*k+y)*p.bw+mx*k+x]
+						e.emitValue(table, 0, blk[0]-pred[c])
+						pred[c] = blk[0]
+						if se > 0 {
+							e.encodeAC(blk, table+1, 1, se)
+						}
+					}
+				}
+			}
+		}
+	}
+	e.flushBits()
+}
+
+// encodeAC writes coefficients ss to se of a block with run-length coding
+// and an end-of-block code if the band ends in zeros.
+func (e *jpegEncoder) encodeAC(blk *[64]int32, table, ss, se int) {
+	run := 0
+	for k := ss; k <= se; k++ {
+		v := blk[k]
+		if v == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			c := jpegHuffCodes[table][0xf0]
+			e.emit(c.bits, c.n)
+			run -= 16
+		}
+		e.emitValue(table, run, v)
+		run = 0
+	}
+	if run > 0 {
+		c := jpegHuffCodes[table][0x00]
+		e.emit(c.bits, c.n)
+	}
+}
+
+// transform converts img to YCbCr, subsamples chroma and returns the
+// quantized DCT coefficients of each component. img must be opaque.
+func (e *jpegEncoder) transform(img *image.RGBA) [3]*jpegPlane {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	mcu := 16
+	if e.sub444 {
+		mcu = 8
+	}
+	mw, mh := (w+mcu-1)/mcu, (h+mcu-1)/mcu
+	// Full-resolution planes padded to whole MCUs by repeating edge pixels.
+	pw, ph := mw*mcu, mh*mcu
+	var full [3][]float64
+	for c := range full {
+		full[c] = make([]float64, pw*ph)
+	}
+	for y := 0; y < ph; y++ {
+		row := img.Pix[min(y, h-1)*img.Stride:]
+		for x := 0; x < pw; x++ {
+			px := row[min(x, w-1)*4:]
+			yy, cb, cr := color.RGBToYCbCr(px[0], px[1], px[2])
+			i := y*pw + x
+			full[0][i], full[1][i], full[2][i] = float64(yy), float64(cb), float64(cr)
+		}
+	}
+
+	var planes [3]*jpegPlane
+	for c := range planes {
+		sub := 1
+		if c > 0 && !e.sub444 {
+			sub = 2
+		}
+		src, sw, sh := full[c], pw, ph
+		if sub == 2 {
+			sw, sh = pw/2, ph/2
+			half := make([]float64, sw*sh)
+			for y := 0; y < sh; y++ {
+				for x := 0; x < sw; x++ {
+					i := 2*y*pw + 2*x
+					half[y*sw+x] = (src[i] + src[i+1] + src[i+pw] + src[i+pw+1]) / 4
+				}
+			}
+			src = half
+		}
+		// Non-interleaved scans cover only the blocks of the image's extent
+		// in this component, not the MCU padding.
+		compW, compH := (w+sub-1)/sub, (h+sub-1)/sub
+		p := &jpegPlane{bw: sw / 8, bh: sh / 8, cw: (compW + 7) / 8, ch: (compH + 7) / 8}
+		p.coef = make([][64]int32, p.bw*p.bh)
+		q := &e.quant[min(c, 1)]
+		var blk, tmp [64]float64
+		for by := 0; by < p.bh; by++ {
+			for bx := 0; bx < p.bw; bx++ {
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						blk[y*8+x] = src[(by*8+y)*sw+bx*8+x] - 128
+					}
+				}
+				jpegFDCT(&blk, &tmp)
+				out := &p.coef[by*p.bw+bx]
+				for zz, k := range jpegZigzag {
+					out[zz] = int32(math.Round(blk[k] / float64(q[k])))
+				}
+			}
+		}
+		planes[c] = p
+	}
+	return planes
+}
+
+// jpegFDCT replaces blk with its 2-D DCT.
+func jpegFDCT(blk, tmp *[64]float64) {
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			s := 0.0
+			for x := 0; x < 8; x++ {
+				s += jpegDCT[u][x] * blk[y*8+x]
+			}
+			tmp[y*8+u] = s
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			s := 0.0
+			for y := 0; y < 8; y++ {
+				s += jpegDCT[v][y] * tmp[y*8+u]
+			}
+			blk[v*8+u] = s
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// testPhoto returns a w×h image at origin min with smooth color gradients
+// and a few hard edges, like a photo with text on it.
+func testPhoto(min image.Point, w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rectangle{Min: min, Max: min.Add(image.Pt(w, h))})
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8(128 + 60*math.Sin(float64(x+y)/9)), 255}
+			if (x/6+y/6)%5 == 0 {
+				c = color.NRGBA{230, 40, 40, 255}
+			}
+			img.SetNRGBA(min.X+x, min.Y+y, c)
+		}
+	}
+	return img
+}
+
+// psnr returns the peak signal-to-noise ratio of b against a in dB, over
+// the RGB channels.
+func psnr(a, b image.Image) float64 {
+	var sum float64
+	n := 0
+	ab, bb := a.Bounds(), b.Bounds()
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for _, d := range []float64{float64(r1>>8) - float64(r2>>8), float64(g1>>8) - float64(g2>>8), float64(b1>>8) - float64(b2>>8)} {
+				sum += d * d
+				n++
+			}
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(sum/float64(n)))
+}
+
+func TestEncodeJPEG(t *testing.T) {
+	for _, tc := range []struct {
+		o       EncodeOptions
+		minPSNR float64
+	}{
+		{EncodeOptions{}, 34},
+		{EncodeOptions{Quality: 75}, 23},
+		{EncodeOptions{Quality: 90, Progressive: true}, 29},
+		{EncodeOptions{Quality: 90, Subsampling: Subsampling444}, 33},
+		{EncodeOptions{Quality: 75, Progressive: true, Subsampling: Subsampling444}, 27},
+		{EncodeOptions{Quality: 100, Progressive: true, Subsampling: Subsampling444}, 48},
+	} {
+		for _, size := range []image.Point{{1, 1}, {7, 5}, {17, 33}, {101, 67}} {
+			name := fmt.Sprintf("q%d-prog%t-%s-%dx%d", tc.o.Quality, tc.o.Progressive, tc.o.Subsampling, size.X, size.Y)
+			t.Run(name, func(t *testing.T) {
+				src := testPhoto(image.Pt(3, -2), size.X, size.Y)
+				var buf bytes.Buffer
+				if err := EncodeJPEG(&buf, src, tc.o); err != nil {
+					t.Fatal(err)
+				}
+				data := buf.Bytes()
+				if progressive := bytes.Contains(data, []byte{0xff, 0xc2}); progressive != tc.o.Progressive {
+					t.Errorf("progressive = %t", progressive)
+				}
+				img, err := jpeg.Decode(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if img.Bounds().Size() != size {
+					t.Fatalf("decoded %v, want size %v", img.Bounds(), size)
+				}
+				want := image.YCbCrSubsampleRatio420
+				if tc.o.Subsampling == Subsampling444 {
+					want = image.YCbCrSubsampleRatio444
+				}
+				if ycc, ok := img.(*image.YCbCr); !ok || ycc.SubsampleRatio != want {
+					t.Errorf("decoded %T, want YCbCr at %v", img, want)
+				}
+				// Tiny images are mostly block edges; only judge the
+				// larger ones. Next to image/jpeg at the same quality,
+				// progressive output loses nothing and 4:4:4 only gains.
+				if size.X*size.Y >= 500 {
+					q := tc.o.Quality
+					if q == 0 {
+						q = 100
+					}
+					var ref bytes.Buffer
+					if err := jpeg.Encode(&ref, src, &jpeg.Options{Quality: q}); err != nil {
+						t.Fatal(err)
+					}
+					refImg, err := jpeg.Decode(&ref)
+					if err != nil {
+						t.Fatal(err)
+					}
+					p, refP := psnr(src, img), psnr(src, refImg)
+					if p < tc.minPSNR || p < refP-0.5 {
+						t.Errorf("PSNR %.1f dB, want at least %.0f and image/jpeg's %.1f", p, tc.minPSNR, refP)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeJPEGQuality(t *testing.T) {
+	src := testPhoto(image.Point{}, 101, 67)
+	for _, o := range []EncodeOptions{{}, {Progressive: true}, {Subsampling: Subsampling444}} {
+		var sizes []int
+		var quality []float64
+		for _, q := range []int{30, 60, 90} {
+			o.Quality = q
+			var buf bytes.Buffer
+			if err := EncodeJPEG(&buf, src, o); err != nil {
+				t.Fatal(err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sizes = append(sizes, buf.Len())
+			quality = append(quality, psnr(src, img))
+		}
+		for i := 1; i < len(sizes); i++ {
+			if sizes[i] <= sizes[i-1] || quality[i] <= quality[i-1] {
+				t.Errorf("progressive %t, %s: sizes %v and PSNRs %.1f do not grow with quality", o.Progressive, o.Subsampling, sizes, quality)
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// JPEG markers, the byte following 0xFF.
+const (
+	MarkerSOI  = 0xD8
+	MarkerEOI  = 0xD9
+	MarkerSOS  = 0xDA
+	MarkerAPP1 = 0xE1
+	MarkerAPP2 = 0xE2
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// Metadata returns the EXIF and XMP segments of a JPEG stream to copy into
+// an output. When the pixels were auto-oriented, the copied orientation tag
+// is reset so viewers don't rotate the output a second time.
+func Metadata(data []byte, oriented bool) [][]byte {
+	segs := readJPEGMetadata(data)
+	if oriented {
+		for _, seg := range segs {
+			resetOrientation(seg)
+		}
+	}
+	return segs
+}
+
+// readJPEGMetadata returns copies of the raw EXIF and XMP APP1 segments of a
+// JPEG stream, marker and length included. Non-JPEG data and anything after a
+// malformed segment yield no segments.
+func readJPEGMetadata(data []byte) [][]byte {
+	var segs [][]byte
+	WalkJPEGSegments(data, func(marker byte, seg []byte) {
+		payload := seg[4:]
+		if marker == MarkerAPP1 && (bytes.HasPrefix(payload, exifHeader) || bytes.HasPrefix(payload, xmpHeader)) {
+			segs = append(segs, append([]byte{}, seg...))
+		}
+	})
+	return segs
+}
+
+// WalkJPEGSegments calls fn with each marker segment of a JPEG stream before
+// the first scan, marker and length included, and stops at the first
+// malformed one.
+func WalkJPEGSegments(data []byte, fn func(marker byte, seg []byte)) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != MarkerSOI {
+		return
+	}
+	i := 2
+	for i+2 <= len(data) {
+		if data[i] != 0xFF {
+			return
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte; the next byte is the marker.
+			i++
+			continue
+		}
+		if marker == MarkerSOS || marker == MarkerEOI || i+4 > len(data) {
+			return
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + n
+		if n < 2 || end > len(data) {
+			return
+		}
+		fn(marker, data[i:end])
+		i = end
+	}
+}
+
+// InsertJPEGSegments places segs directly after the SOI marker of an encoded
+// JPEG stream.
+func InsertJPEGSegments(data []byte, segs [][]byte) []byte {
+	if len(segs) == 0 || len(data) < 2 {
+		return data
+	}
+	size := len(data)
+	for _, s := range segs {
+		size += len(s)
+	}
+	out := make([]byte, 0, size)
+	out = append(out, data[:2]...)
+	for _, s := range segs {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
+
+// resetOrientation rewrites the IFD0 orientation tag of an EXIF APP1 segment
+// to 1 (upright) in place. Other segments are left untouched.
+func resetOrientation(seg []byte) {
+	if v, order := orientationValue(seg); v != nil {
+		order.PutUint16(v, 1)
+	}
+}
+
+// JPEGOrientation returns the EXIF orientation of a JPEG stream, 1 to 8, or
+// 1 if it has none.
+func JPEGOrientation(data []byte) int {
+	for _, seg := range readJPEGMetadata(data) {
+		if v, order := orientationValue(seg); v != nil {
+			if o := int(order.Uint16(v)); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// orientationValue returns the bytes holding the IFD0 orientation tag of an
+// EXIF APP1 segment and their byte order, or nil if there is none.
+func orientationValue(seg []byte) ([]byte, binary.ByteOrder) {
+	if len(seg) < 4 || !bytes.HasPrefix(seg[4:], exifHeader) {
+		return nil, nil
+	}
+	tiff := seg[4+len(exifHeader):]
+	if len(tiff) < 8 {
+		return nil, nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil, nil
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			return nil, nil
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			// SHORT value stored inline in the first two value bytes.
+			return tiff[e+8 : e+10], order
+		}
+	}
+	return nil, nil
+}
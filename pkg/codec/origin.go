@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// originKeyword names the PNG text chunk, and starts the JPEG comment, that
+// record the checksum of the original in a marked image.
+const originKeyword = "watermark-origin"
+
+const markerCOM = 0xFE
+
+// OriginCheck is the result of VerifyOrigin.
+type OriginCheck struct {
+	// Recorded is the SHA-256 of the original the marked image records.
+	Recorded []byte
+	// Claimed is the SHA-256 of the claimed original.
+	Claimed []byte
+	// Match reports whether the two are equal.
+	Match bool
+}
+
+// OriginChecksum returns the SHA-256 of the original file that the marked
+// image at path was made from, as EmbedOrigin recorded it. Images without
+// one return ErrNoOrigin.
+func OriginChecksum(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadOrigin(data)
+}
+
+// VerifyOrigin checks whether the file at originalPath is, byte for byte,
+// the original the marked image at markedPath records with EmbedOrigin. A
+// re-encoded or edited copy of the original does not match.
+func VerifyOrigin(markedPath, originalPath string) (OriginCheck, error) {
+	recorded, err := OriginChecksum(markedPath)
+	if err != nil {
+		return OriginCheck{}, err
+	}
+	data, err := os.ReadFile(originalPath)
+	if err != nil {
+		return OriginCheck{}, err
+	}
+	claimed := OriginSum(data)
+	return OriginCheck{Recorded: recorded, Claimed: claimed, Match: bytes.Equal(recorded, claimed)}, nil
+}
+
+// OriginSum returns the SHA-256 of data.
+func OriginSum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// originValue is the recorded form of the checksum sum.
+func originValue(sum []byte) string {
+	return "sha256:" + hex.EncodeToString(sum)
+}
+
+// EmbedOrigin records sum in encoded, an image in format: as a comment
+// segment of a JPEG or a tEXt chunk of a PNG. Other formats have no place
+// the checksum survives in and fail with ErrUnsupportedFormat.
+func EmbedOrigin(encoded []byte, format Format, sum []byte) ([]byte, error) {
+	switch format {
+	case FormatJPEG:
+		payload := originKeyword + " " + originValue(sum)
+		seg := []byte{0xFF, markerCOM, 0, 0}
+		binary.BigEndian.PutUint16(seg[2:], uint16(2+len(payload)))
+		return InsertJPEGSegments(encoded, [][]byte{append(seg, payload...)}), nil
+	case FormatPNG:
+		return InsertPNGChunk(encoded, []byte("tEXt"+originKeyword+"\x00"+originValue(sum)))
+	}
+	return nil, CheckOriginFormat(format)
+}
+
+// CheckOriginFormat fails with ErrUnsupportedFormat unless format can
+// record an origin checksum.
+func CheckOriginFormat(format Format) error {
+	if format == FormatJPEG || format == FormatPNG {
+		return nil
+	}
+	return fmt.Errorf("%w: the origin checksum can only be recorded in JPEG and PNG outputs, not %s", ErrUnsupportedFormat, format)
+}
+
+// ReadOrigin returns the checksum EmbedOrigin recorded in a JPEG or PNG
+// stream.
+func ReadOrigin(data []byte) ([]byte, error) {
+	var value string
+	WalkJPEGSegments(data, func(marker byte, seg []byte) {
+		if p, ok := bytes.CutPrefix(seg[4:], []byte(originKeyword+" ")); marker == markerCOM && ok && value == "" {
+			value = string(p)
+		}
+	})
+	if value == "" && bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		for i := 8; i+12 <= len(data); {
+			n := int(binary.BigEndian.Uint32(data[i:]))
+			if n < 0 || i+12+n > len(data) {
+				break
+			}
+			body := data[i+8 : i+8+n]
+			if p, ok := bytes.CutPrefix(body, []byte(originKeyword+"\x00")); string(data[i+4:i+8]) == "tEXt" && ok {
+				value = string(p)
+				break
+			}
+			i += 12 + n
+		}
+	}
+	hexSum, ok := strings.CutPrefix(value, "sha256:")
+	if !ok {
+		return nil, ErrNoOrigin
+	}
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("%w: malformed checksum %q", ErrNoOrigin, value)
+	}
+	return sum, nil
+}
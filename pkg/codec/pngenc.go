@@ -0,0 +1,160 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// PNGCompression is a PNG compression level.
+type PNGCompression string
+
+const (
+	PNGCompressionDefault PNGCompression = "default"
+	PNGCompressionFast    PNGCompression = "fast"
+	PNGCompressionBest    PNGCompression = "best"
+	PNGCompressionNone    PNGCompression = "none"
+)
+
+// PNGColor chooses the color type and bit depth of PNG outputs.
+type PNGColor string
+
+const (
+	// PNGColorAuto writes the smallest color type that keeps every pixel:
+	// a palette of 1 to 8 bits when there are at most 256 colors, 8-bit
+	// grayscale when the image is opaque and gray, truecolor otherwise.
+	PNGColorAuto PNGColor = "auto"
+	// PNGColorTruecolor always writes 8-bit RGB, or RGBA with transparency.
+	PNGColorTruecolor PNGColor = "truecolor"
+	// PNGColorGray writes 8-bit grayscale, converting colors to their
+	// luminance. Images with transparency stay truecolor.
+	PNGColorGray PNGColor = "gray"
+)
+
+// ParsePNGCompression parses "default", "fast", "best" or "none".
+func ParsePNGCompression(s string) (PNGCompression, error) {
+	switch c := PNGCompression(s); c {
+	case PNGCompressionDefault, PNGCompressionFast, PNGCompressionBest, PNGCompressionNone:
+		return c, nil
+	}
+	return "", fmt.Errorf("invalid PNG compression %q, want default, fast, best or none", s)
+}
+
+// ParsePNGColor parses "auto", "truecolor" or "gray".
+func ParsePNGColor(s string) (PNGColor, error) {
+	switch c := PNGColor(s); c {
+	case PNGColorAuto, PNGColorTruecolor, PNGColorGray:
+		return c, nil
+	}
+	return "", fmt.Errorf("invalid PNG color type %q, want auto, truecolor or gray", s)
+}
+
+// validatePNG reports PNG options that are not known.
+func (o EncodeOptions) validatePNG() error {
+	if o.PNGCompression != "" {
+		if _, err := ParsePNGCompression(string(o.PNGCompression)); err != nil {
+			return err
+		}
+	}
+	if o.PNGColor != "" {
+		if _, err := ParsePNGColor(string(o.PNGColor)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodePNG writes img as a PNG with the compression and color type of o,
+// embedding icc if it fits the color type written.
+func EncodePNG(w io.Writer, img image.Image, o EncodeOptions, icc []byte) error {
+	enc := png.Encoder{}
+	switch o.PNGCompression {
+	case PNGCompressionFast:
+		enc.CompressionLevel = png.BestSpeed
+	case PNGCompressionBest:
+		enc.CompressionLevel = png.BestCompression
+	case PNGCompressionNone:
+		enc.CompressionLevel = png.NoCompression
+	}
+	m := pngImage(img, o.PNGColor)
+	gray := false
+	switch m.(type) {
+	case *image.Gray, *image.Gray16:
+		gray = true
+	}
+	if !ICCFits(icc, gray) {
+		return enc.Encode(w, m)
+	}
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, m); err != nil {
+		return err
+	}
+	data, err := insertPNGICC(buf.Bytes(), icc)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// pngImage converts img to the image type image/png writes with the color
+// type c asks for. Gray and paletted inputs are kept as they are.
+func pngImage(img image.Image, c PNGColor) image.Image {
+	if c == PNGColorTruecolor {
+		return img
+	}
+	m, ok := img.(*image.NRGBA)
+	if !ok {
+		return img
+	}
+	b := m.Bounds()
+	opaque, gray := true, true
+	// Collect a palette only while it still fits in 256 entries.
+	collect := c == PNGColorAuto
+	colors := make(map[color.NRGBA]uint8)
+	var palette color.Palette
+	var last color.NRGBA
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := m.Pix[(y-b.Min.Y)*m.Stride : (y-b.Min.Y)*m.Stride+b.Dx()*4]
+		for i := 0; i < len(row); i += 4 {
+			p := color.NRGBA{row[i], row[i+1], row[i+2], row[i+3]}
+			opaque = opaque && p.A == 255
+			gray = gray && p.R == p.G && p.G == p.B
+			if collect && (p != last || len(palette) == 0) {
+				last = p
+				if _, ok := colors[p]; !ok {
+					if len(palette) == 256 {
+						collect = false
+					} else {
+						colors[p] = uint8(len(palette))
+						palette = append(palette, p)
+					}
+				}
+			}
+			if !collect && (!opaque || !gray && c == PNGColorAuto) {
+				return img
+			}
+		}
+	}
+	if collect {
+		pm := image.NewPaletted(b, palette)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := m.Pix[(y-b.Min.Y)*m.Stride:]
+			out := pm.Pix[(y-b.Min.Y)*pm.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				out[x] = colors[color.NRGBA{row[4*x], row[4*x+1], row[4*x+2], row[4*x+3]}]
+			}
+		}
+		return pm
+	}
+	if opaque && (gray || c == PNGColorGray) {
+		g := image.NewGray(b)
+		draw.Draw(g, b, m, b.Min, draw.Src)
+		return g
+	}
+	return img
+}
@@ -0,0 +1,96 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"sync"
+
+	"watermark/internal/parallel"
+)
+
+// JPEGProof simulates writing marked, the image clean with a mark added,
+// as a JPEG per enc, flattened onto bg: it returns the image as it decodes
+// again and the share of the fine detail of the mark that survives, from 0
+// to 1, see markDetail.
+func JPEGProof(clean, marked image.Image, bg color.NRGBA, enc EncodeOptions, workers int) (image.Image, float64, error) {
+	flat := Flatten(marked, bg, workers).(*image.RGBA)
+	proof, err := jpegRoundTrip(flat, enc)
+	if err != nil {
+		return nil, 0, err
+	}
+	base := Flatten(clean, bg, workers).(*image.RGBA)
+	baseProof, err := jpegRoundTrip(base, enc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return proof, markDetail(base, flat, baseProof, proof, workers), nil
+}
+
+// jpegRoundTrip encodes img as a JPEG per enc and decodes it again.
+func jpegRoundTrip(img *image.RGBA, enc EncodeOptions) (*image.RGBA, error) {
+	var buf bytes.Buffer
+	if err := EncodeJPEG(&buf, img, enc); err != nil {
+		return nil, err
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		return nil, err
+	}
+	out := image.NewRGBA(img.Rect)
+	draw.Draw(out, out.Rect, decoded, decoded.Bounds().Min, draw.Src)
+	return out, nil
+}
+
+// markDetail measures how much of the mark, marked minus base, is left
+// after compression, proof minus baseProof; all four share bounds and
+// stride. Subtracting the compressed base keeps the artifacts of the image
+// itself out of the measure. The detail is the change in luma between
+// neighboring pixels, where thin strokes and edges live, so blurring them
+// away counts as a loss even when their average tone survives, and so does
+// ringing around them; chroma, which JPEG subsamples anyway, carries little
+// of what makes text legible. It returns 1 for a mark with no detail.
+func markDetail(base, marked, baseProof, proof *image.RGBA, workers int) float64 {
+	b := base.Rect
+	var mu sync.Mutex
+	var dot, norm0, norm1 float64
+	parallel.Rows(workers, image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Max.Y-1), func(band image.Rectangle) error {
+		var d, n0, n1 float64
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			i := base.PixOffset(b.Min.X, y)
+			for x := b.Min.X; x+1 < b.Max.X; x, i = x+1, i+4 {
+				m := luma(marked.Pix, i) - luma(base.Pix, i)
+				gx := luma(marked.Pix, i+4) - luma(base.Pix, i+4) - m
+				gy := luma(marked.Pix, i+base.Stride) - luma(base.Pix, i+base.Stride) - m
+				if gx == 0 && gy == 0 {
+					continue
+				}
+				p := luma(proof.Pix, i) - luma(baseProof.Pix, i)
+				px := luma(proof.Pix, i+4) - luma(baseProof.Pix, i+4) - p
+				py := luma(proof.Pix, i+base.Stride) - luma(baseProof.Pix, i+base.Stride) - p
+				d += gx*px + gy*py
+				n0 += gx*gx + gy*gy
+				n1 += px*px + py*py
+			}
+		}
+		mu.Lock()
+		dot, norm0, norm1 = dot+d, norm0+n0, norm1+n1
+		mu.Unlock()
+		return nil
+	})
+	if norm0 == 0 {
+		return 1
+	}
+	if norm1 == 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(1, dot/math.Sqrt(norm0*norm1)))
+}
+
+// luma is the Rec. 601 luma of the RGB pixel at offset i of pix.
+func luma(pix []uint8, i int) float64 {
+	return 0.299*float64(pix[i]) + 0.587*float64(pix[i+1]) + 0.114*float64(pix[i+2])
+}
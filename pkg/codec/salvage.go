@@ -0,0 +1,305 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// jpegHuffCode is a single canonical Huffman code.
+type jpegHuffCode struct {
+	bits uint32
+	size uint
+}
+
+// jpegHeader holds the parts of a JPEG header needed to synthesize filler
+// entropy-coded data for a damaged scan.
+type jpegHeader struct {
+	progressive bool
+	width       int
+	height      int
+	restart     int
+	// hs and vs map component id to its sampling factors.
+	hs, vs map[byte]int
+	hmax   int
+	vmax   int
+	// dc and ac hold, per table id, the code for DC diff 0 and the EOB code.
+	dc, ac map[byte]jpegHuffCode
+	// scanComps lists (component id, dc table, ac table) of the first scan.
+	scanComps [][3]byte
+	// scanData is the offset of the first scan's entropy-coded data.
+	scanData int
+}
+
+// salvageTrims is how many cut points are tried when completing a truncated
+// scan without restart markers.
+const salvageTrims = 16
+
+var errNotSalvageable = errors.New("jpeg: input cannot be salvaged")
+
+// salvageJPEG returns candidate repairs of a damaged JPEG stream, most
+// faithful first. Well-formed segments are kept, corrupt ones are skipped,
+// and truncated scans are completed with flat filler blocks.
+func salvageJPEG(data []byte) ([][]byte, error) {
+	head, hdr, err := parseJPEGHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	scan := data[hdr.scanData:]
+	if i := bytes.Index(scan, []byte{0xFF, MarkerEOI}); i >= 0 {
+		scan = scan[:i]
+	}
+	sos := data[len(head):hdr.scanData]
+
+	var candidates [][]byte
+	if hdr.progressive {
+		// Drop trailing scans one by one; earlier scans still decode into a
+		// coarser but complete image.
+		body := append(append([]byte{}, sos...), scan...)
+		cuts := []int{len(body)}
+		for i := len(body) - 1; i > 0; i-- {
+			if body[i-1] == 0xFF && body[i] == MarkerSOS {
+				cuts = append(cuts, i-1)
+			}
+		}
+		for _, cut := range cuts {
+			c := append(append([]byte{}, head...), body[:cut]...)
+			candidates = append(candidates, append(c, 0xFF, MarkerEOI))
+		}
+		return candidates, nil
+	}
+
+	total := hdr.mcuCount()
+	if hdr.restart > 0 {
+		// Cut back to the last restart marker; its position tells exactly
+		// how many MCUs are intact.
+		rsts := 0
+		last := -1
+		for i := 0; i+1 < len(scan); i++ {
+			if scan[i] == 0xFF && scan[i+1] >= 0xD0 && scan[i+1] <= 0xD7 {
+				rsts++
+				last = i + 2
+			}
+		}
+		if last > 0 {
+			done := rsts * hdr.restart
+			next := byte(0xD0 + rsts%8)
+			c := append(append(append([]byte{}, head...), sos...), scan[:last]...)
+			c = append(c, hdr.filler(total-done, next)...)
+			candidates = append(candidates, append(c, 0xFF, MarkerEOI))
+		}
+	}
+	// Without a reliable resync point, append filler for a whole image and
+	// let the decoder stop once every MCU is filled. The cut point is unknown
+	// to be on a code boundary, so a few shorter cuts are tried as well.
+	fill := hdr.filler(total, 0xD0)
+	for trim := 0; trim < salvageTrims && trim < len(scan); trim++ {
+		c := append(append(append([]byte{}, head...), sos...), scan[:len(scan)-trim]...)
+		c = append(c, fill...)
+		candidates = append(candidates, append(c, 0xFF, MarkerEOI))
+	}
+	return candidates, nil
+}
+
+// parseJPEGHeader walks the segments up to the first SOS and returns the
+// rebuilt header (every well-formed segment before SOS) plus the parsed
+// parameters.
+func parseJPEGHeader(data []byte) ([]byte, *jpegHeader, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != MarkerSOI {
+		return nil, nil, errNotSalvageable
+	}
+	hdr := &jpegHeader{
+		hs: map[byte]int{}, vs: map[byte]int{},
+		dc: map[byte]jpegHuffCode{}, ac: map[byte]jpegHuffCode{},
+	}
+	head := []byte{0xFF, MarkerSOI}
+	sawSOF := false
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF || !knownMarker(data[i+1]) {
+			i = resyncMarker(data, i+1)
+			continue
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + n
+		if n < 2 || end > len(data) || (end < len(data) && data[end] != 0xFF && marker != MarkerSOS) {
+			// Corrupt length: skip ahead to the next plausible marker.
+			i = resyncMarker(data, i+2)
+			continue
+		}
+		seg := data[i+4 : end]
+		switch {
+		case marker == 0xC0 || marker == 0xC1 || marker == 0xC2:
+			if err := hdr.parseSOF(seg, marker == 0xC2); err != nil {
+				return nil, nil, err
+			}
+			sawSOF = true
+		case marker == 0xC4:
+			hdr.parseDHT(seg)
+		case marker == 0xDD && len(seg) >= 2:
+			hdr.restart = int(binary.BigEndian.Uint16(seg))
+		case marker == MarkerSOS:
+			if !sawSOF {
+				return nil, nil, errNotSalvageable
+			}
+			if len(seg) < 1 || len(seg) < 1+2*int(seg[0]) {
+				return nil, nil, errNotSalvageable
+			}
+			for c := 0; c < int(seg[0]); c++ {
+				id, tbl := seg[1+2*c], seg[2+2*c]
+				hdr.scanComps = append(hdr.scanComps, [3]byte{id, tbl >> 4, tbl & 0x0F})
+			}
+			hdr.scanData = end
+			return head, hdr, nil
+		}
+		head = append(head, data[i:end]...)
+		i = end
+	}
+	return nil, nil, errNotSalvageable
+}
+
+// knownMarker reports whether m can legitimately start a header segment.
+func knownMarker(m byte) bool {
+	switch {
+	case m == 0xFF, m == 0xC4, m == 0xDB, m == 0xDD, m == MarkerSOS, m == 0xFE:
+		return true
+	case m >= 0xC0 && m <= 0xCF && m != 0xC4 && m != 0xC8 && m != 0xCC:
+		return true
+	case m >= 0xE0 && m <= 0xEF:
+		return true
+	}
+	return false
+}
+
+func resyncMarker(data []byte, from int) int {
+	for j := from; j+1 < len(data); j++ {
+		if data[j] == 0xFF && knownMarker(data[j+1]) && data[j+1] != 0xFF {
+			return j
+		}
+	}
+	return len(data)
+}
+
+func (h *jpegHeader) parseSOF(seg []byte, progressive bool) error {
+	if len(seg) < 6 {
+		return errNotSalvageable
+	}
+	h.progressive = progressive
+	h.height = int(binary.BigEndian.Uint16(seg[1:]))
+	h.width = int(binary.BigEndian.Uint16(seg[3:]))
+	n := int(seg[5])
+	if len(seg) < 6+3*n || h.width == 0 || h.height == 0 {
+		return errNotSalvageable
+	}
+	h.hmax, h.vmax = 1, 1
+	for c := 0; c < n; c++ {
+		id, sf := seg[6+3*c], seg[7+3*c]
+		h.hs[id], h.vs[id] = int(sf>>4), int(sf&0x0F)
+		h.hmax = max(h.hmax, int(sf>>4))
+		h.vmax = max(h.vmax, int(sf&0x0F))
+	}
+	return nil
+}
+
+// parseDHT records, for every table in seg, the code of symbol 0, which is
+// "DC diff 0" in DC tables and "end of block" in AC tables.
+func (h *jpegHeader) parseDHT(seg []byte) {
+	for len(seg) >= 17 {
+		class, id := seg[0]>>4, seg[0]&0x0F
+		var counts [16]int
+		total := 0
+		for k := 0; k < 16; k++ {
+			counts[k] = int(seg[1+k])
+			total += counts[k]
+		}
+		if len(seg) < 17+total {
+			return
+		}
+		vals := seg[17 : 17+total]
+		code, v := uint32(0), 0
+		for size := 1; size <= 16; size++ {
+			for k := 0; k < counts[size-1]; k++ {
+				if vals[v] == 0 {
+					hc := jpegHuffCode{bits: code, size: uint(size)}
+					if class == 0 {
+						h.dc[id] = hc
+					} else {
+						h.ac[id] = hc
+					}
+				}
+				code++
+				v++
+			}
+			code <<= 1
+		}
+		seg = seg[17+total:]
+	}
+}
+
+func (h *jpegHeader) mcuCount() int {
+	if len(h.scanComps) == 1 {
+		id := h.scanComps[0][0]
+		bw := (h.width*h.hs[id]/h.hmax + 7) / 8
+		bh := (h.height*h.vs[id]/h.vmax + 7) / 8
+		return bw * bh
+	}
+	mw := (h.width + 8*h.hmax - 1) / (8 * h.hmax)
+	mh := (h.height + 8*h.vmax - 1) / (8 * h.vmax)
+	return mw * mh
+}
+
+// filler encodes n MCUs of flat blocks (DC unchanged, immediate EOB),
+// emitting restart markers starting at rst when restart intervals are used.
+func (h *jpegHeader) filler(n int, rst byte) []byte {
+	var out []byte
+	var acc uint32
+	var nbits uint
+	put := func(c jpegHuffCode) {
+		acc = acc<<c.size | c.bits
+		nbits += c.size
+		for nbits >= 8 {
+			b := byte(acc >> (nbits - 8))
+			out = append(out, b)
+			if b == 0xFF {
+				out = append(out, 0x00)
+			}
+			nbits -= 8
+		}
+	}
+	flush := func() {
+		if nbits > 0 {
+			put(jpegHuffCode{bits: 1<<(8-nbits) - 1, size: 8 - nbits})
+		}
+	}
+	blocksPer := func(id byte) int {
+		if len(h.scanComps) == 1 {
+			return 1
+		}
+		return h.hs[id] * h.vs[id]
+	}
+	for m := 0; m < n; m++ {
+		for _, sc := range h.scanComps {
+			dc, okDC := h.dc[sc[1]]
+			ac, okAC := h.ac[sc[2]]
+			if !okDC || !okAC {
+				return out
+			}
+			for b := 0; b < blocksPer(sc[0]); b++ {
+				put(dc)
+				put(ac)
+			}
+		}
+		if h.restart > 0 && (m+1)%h.restart == 0 && m+1 < n {
+			flush()
+			out = append(out, 0xFF, rst)
+			rst = 0xD0 + (rst-0xD0+1)%8
+		}
+	}
+	flush()
+	return out
+}
@@ -0,0 +1,165 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+// SourceLimits bounds the inputs an ImageSource accepts, so an oversized or
+// hostile input is refused before its pixels are decoded. Zero fields mean
+// no limit.
+type SourceLimits struct {
+	// MaxBytes is the largest encoded input accepted.
+	MaxBytes int64
+	// MaxPixels is the largest width×height accepted; for ICO inputs it
+	// applies to the largest image.
+	MaxPixels int64
+}
+
+// ImageSource is an encoded input held in memory together with what its
+// headers tell without decoding the pixels.
+type ImageSource struct {
+	// Format is the sniffed encoding, e.g. FormatJPEG, FormatPDF or a
+	// format that can be read but not written, such as "gif" or "bmp".
+	Format Format
+	// Width and Height are the pixel size as stored, before EXIF
+	// orientation; for ICO inputs the size of the largest image. They are
+	// 0 for PDFs and for JPEGs whose header is too damaged to read.
+	Width, Height int
+	// Orientation is the EXIF orientation, 1 to 8, or 1 if there is none.
+	Orientation int
+	// EXIF holds the camera metadata of JPEG inputs, as for {exif.Name}.
+	EXIF map[string]string
+	// ICC is the embedded ICC color profile of JPEG and PNG inputs, or nil.
+	ICC []byte
+
+	data []byte
+}
+
+// NewImageSource sniffs data and checks it against limits. It returns
+// ErrUnsupportedFormat for data no decoder recognizes and ErrInputTooLarge
+// for data over a limit.
+func NewImageSource(data []byte, limits SourceLimits) (*ImageSource, error) {
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrInputTooLarge, len(data), limits.MaxBytes)
+	}
+	s := &ImageSource{data: data, Orientation: 1}
+	switch {
+	case isPDF(data):
+		s.Format = FormatPDF
+		return s, nil
+	case isICO(data):
+		s.Format = FormatICO
+		s.Width, s.Height = icoMaxSize(data)
+	default:
+		cfg, name, err := DecodeConfig(data)
+		switch {
+		case err == nil:
+			s.Format, s.Width, s.Height = Format(name), cfg.Width, cfg.Height
+		case len(data) >= 2 && data[0] == 0xFF && data[1] == MarkerSOI:
+			// Leave a damaged JPEG to the decoder, which may salvage it.
+			s.Format = FormatJPEG
+		default:
+			return nil, DecodeError(err)
+		}
+	}
+	if limits.MaxPixels > 0 && int64(s.Width)*int64(s.Height) > limits.MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels exceed the limit of %d", ErrInputTooLarge, s.Width, s.Height, limits.MaxPixels)
+	}
+	switch s.Format {
+	case FormatJPEG:
+		s.Orientation = JPEGOrientation(data)
+		s.EXIF = ReadEXIF(data)
+		s.ICC = JPEGICC(data)
+	case FormatPNG:
+		s.ICC = PNGICC(data)
+	}
+	return s, nil
+}
+
+// OpenImageSource reads the file at path into an ImageSource, checking
+// MaxBytes before reading.
+func OpenImageSource(path string, limits SourceLimits) (*ImageSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read input: %w", err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() && limits.MaxBytes > 0 && fi.Size() > limits.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrInputTooLarge, fi.Size(), limits.MaxBytes)
+	}
+	return ReadImageSource(f, limits)
+}
+
+// ReadImageSource reads r to EOF into an ImageSource, reading at most one
+// byte past MaxBytes.
+func ReadImageSource(r io.Reader, limits SourceLimits) (*ImageSource, error) {
+	if limits.MaxBytes > 0 {
+		r = io.LimitReader(r, limits.MaxBytes+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read input: %w", err)
+	}
+	return NewImageSource(data, limits)
+}
+
+// Bytes returns the encoded input. The caller must not modify it.
+func (s *ImageSource) Bytes() []byte {
+	return s.data
+}
+
+// Decode decodes the pixels, turned upright per the EXIF orientation. ICO
+// inputs decode to their largest image; PDFs return ErrUnsupportedFormat.
+func (s *ImageSource) Decode() (image.Image, error) {
+	switch s.Format {
+	case FormatPDF:
+		return nil, fmt.Errorf("%w: a PDF has no pixels to decode", ErrUnsupportedFormat)
+	case FormatICO:
+		entries, err := DecodeICO(s.data)
+		if err != nil {
+			return nil, DecodeError(err)
+		}
+		largest := entries[0].Image
+		for _, e := range entries[1:] {
+			if side(e.Image) > side(largest) {
+				largest = e.Image
+			}
+		}
+		return largest, nil
+	}
+	img, _, err := Decode(s.data, false, false)
+	return img, err
+}
+
+// icoMaxSize returns the size of the largest image in an ICO directory,
+// where a stored 0 means 256.
+func icoMaxSize(data []byte) (w, h int) {
+	n := int(binary.LittleEndian.Uint16(data[4:]))
+	for i := 0; i < n && 6+16*(i+1) <= len(data); i++ {
+		ew, eh := int(data[6+16*i]), int(data[6+16*i+1])
+		if ew == 0 {
+			ew = 256
+		}
+		if eh == 0 {
+			eh = 256
+		}
+		if ew*eh > w*h {
+			w, h = ew, eh
+		}
+	}
+	return w, h
+}
+
+// isPDF reports whether data starts with a PDF header.
+func isPDF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("%PDF-"))
+}
+
+func side(img image.Image) int {
+	return min(img.Bounds().Dx(), img.Bounds().Dy())
+}
@@ -0,0 +1,173 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+
+	"github.com/disintegration/imaging"
+)
+
+// EXIF tags locating the IFD1 thumbnail.
+const (
+	tagStripOffsets                = 0x0111
+	tagStripByteCounts             = 0x0117
+	tagJPEGInterchangeFormat       = 0x0201
+	tagJPEGInterchangeFormatLength = 0x0202
+)
+
+// exifThumbnail is where the thumbnail sits in the TIFF structure of an
+// EXIF segment.
+type exifThumbnail struct {
+	r *exifReader
+	// link holds the offset of IFD1 at the end of IFD0.
+	link []byte
+	// offset and length are the entries of the thumbnail data, or empty
+	// values if it cannot be located.
+	offset, length ifdEntry
+	jpeg           bool
+}
+
+// findThumbnail locates the thumbnail of an EXIF APP1 segment, marker and
+// length included, reading from tiff, the segment's TIFF structure or a
+// copy of it.
+func findThumbnail(seg, tiff []byte) (*exifThumbnail, bool) {
+	if len(seg) < 4 || !bytes.HasPrefix(seg[4:], exifHeader) {
+		return nil, false
+	}
+	r, ok := newEXIFReader(tiff)
+	if !ok {
+		return nil, false
+	}
+	ifd0 := int(r.order.Uint32(r.tiff[4:]))
+	if ifd0 < 8 || ifd0+2 > len(r.tiff) {
+		return nil, false
+	}
+	end := ifd0 + 2 + 12*int(r.order.Uint16(r.tiff[ifd0:]))
+	if end+4 > len(r.tiff) {
+		return nil, false
+	}
+	t := &exifThumbnail{r: r, link: r.tiff[end : end+4]}
+	ifd1 := r.ifd(int(r.order.Uint32(t.link)))
+	if len(ifd1) == 0 {
+		return nil, false
+	}
+	t.offset, t.length = ifd1[tagJPEGInterchangeFormat], ifd1[tagJPEGInterchangeFormatLength]
+	t.jpeg = t.offset.count == 1 && t.length.count == 1
+	if !t.jpeg {
+		t.offset, t.length = ifd1[tagStripOffsets], ifd1[tagStripByteCounts]
+	}
+	if t.offset.count != 1 || t.length.count != 1 {
+		t.offset, t.length = ifdEntry{}, ifdEntry{}
+	}
+	return t, true
+}
+
+// data returns the bounds of the thumbnail data in the TIFF structure.
+func (t *exifThumbnail) data() (start, end int, ok bool) {
+	off, ok1 := t.r.uint(t.offset)
+	n, ok2 := t.r.uint(t.length)
+	if !ok1 || !ok2 || off < 8 || n <= 0 || off > len(t.r.tiff)-n {
+		return 0, 0, false
+	}
+	return off, off + n, true
+}
+
+// EXIFThumbnail reports whether seg, an EXIF APP1 segment with marker and
+// length, has a thumbnail, and returns it if it is a JPEG stream.
+func EXIFThumbnail(seg []byte) (thumb []byte, ok bool) {
+	if len(seg) < 4+len(exifHeader) {
+		return nil, false
+	}
+	t, ok := findThumbnail(seg, seg[4+len(exifHeader):])
+	if !ok {
+		return nil, false
+	}
+	if start, end, found := t.data(); found && t.jpeg {
+		return seg[4+len(exifHeader)+start : 4+len(exifHeader)+end], true
+	}
+	return nil, true
+}
+
+// ReplaceEXIFThumbnail returns a copy of seg, an EXIF APP1 segment with a
+// thumbnail, with the thumbnail replaced by thumb, a JPEG stream. The old
+// thumbnail data is cleared. A nil thumb, a thumbnail that is not a JPEG
+// stream and one that would not fit the segment are removed instead.
+// Segments without a thumbnail are returned as they are.
+func ReplaceEXIFThumbnail(seg, thumb []byte) []byte {
+	if len(seg) < 4+len(exifHeader) {
+		return seg
+	}
+	tiff := append([]byte(nil), seg[4+len(exifHeader):]...)
+	t, ok := findThumbnail(seg, tiff)
+	if !ok {
+		return seg
+	}
+	if start, end, found := t.data(); found {
+		clear(tiff[start:end])
+		if end == len(tiff) {
+			tiff = tiff[:start]
+		}
+	}
+	const maxTIFF = 0xFFFF - 2 - 6 // the segment length counts itself and the header
+	if thumb != nil && t.jpeg && t.offset.typ == 4 && t.length.typ == 4 && len(tiff)+len(thumb) <= maxTIFF {
+		t.r.order.PutUint32(t.offset.value, uint32(len(tiff)))
+		t.r.order.PutUint32(t.length.value, uint32(len(thumb)))
+		tiff = append(tiff, thumb...)
+	} else {
+		t.r.order.PutUint32(t.link, 0)
+	}
+	out := make([]byte, 4, 4+len(exifHeader)+len(tiff))
+	out[0], out[1] = 0xFF, MarkerAPP1
+	binary.BigEndian.PutUint16(out[2:], uint16(2+len(exifHeader)+len(tiff)))
+	out = append(out, exifHeader...)
+	return append(out, tiff...)
+}
+
+// Thumbnails are redrawn at the size of the one they replace, or fit in
+// defaultThumbnailSize if that cannot be read, at thumbnailQuality.
+const (
+	defaultThumbnailSize = 160
+	thumbnailQuality     = 80
+)
+
+// refreshThumbnails returns segs with the EXIF thumbnail of each replaced by
+// one of img, so file browsers preview the output rather than the original
+// it came from. Thumbnails that cannot be redrawn are removed. segs itself
+// is not changed.
+func refreshThumbnails(segs [][]byte, img image.Image) [][]byte {
+	var out [][]byte
+	for i, seg := range segs {
+		old, ok := EXIFThumbnail(seg)
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = append([][]byte(nil), segs...)
+		}
+		var thumb []byte
+		if old != nil {
+			thumb = thumbnail(img, old)
+		}
+		out[i] = ReplaceEXIFThumbnail(seg, thumb)
+	}
+	if out == nil {
+		return segs
+	}
+	return out
+}
+
+// thumbnail encodes img shrunk to fit the size of old, a JPEG thumbnail, or
+// returns nil if it cannot.
+func thumbnail(img image.Image, old []byte) []byte {
+	w, h := defaultThumbnailSize, defaultThumbnailSize
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(old)); err == nil && cfg.Width > 0 && cfg.Height > 0 {
+		w, h = cfg.Width, cfg.Height
+	}
+	var buf bytes.Buffer
+	if err := EncodeJPEG(&buf, imaging.Fit(img, w, h, imaging.Lanczos), EncodeOptions{Quality: thumbnailQuality}); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,448 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// webpMaxSize is the largest width or height a VP8L header can hold.
+const webpMaxSize = 1 << 14
+
+// EncodeWebP writes img as a lossless WebP (VP8L), keeping its alpha. Pixels
+// go through the subtract-green transform and LZ77 with one set of prefix
+// codes for the whole image; there is no predictor transform, so photos come
+// out larger than from cwebp -lossless. Metadata and ICC profiles are not
+// written.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > webpMaxSize || b.Dy() > webpMaxSize {
+		return fmt.Errorf("%w: WebP images are 1 to %d pixels wide and high, got %dx%d", ErrUnsupportedFormat, webpMaxSize, b.Dx(), b.Dy())
+	}
+	m, ok := img.(*image.NRGBA)
+	if !ok || m.Rect.Min != (image.Point{}) {
+		m = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(m, m.Rect, img, b.Min, draw.Src)
+	}
+	width, height := b.Dx(), b.Dy()
+
+	// argb holds the pixels after subtract-green, row by row.
+	argb := make([]uint32, 0, width*height)
+	opaque := true
+	for y := 0; y < height; y++ {
+		row := m.Pix[y*m.Stride : y*m.Stride+4*width]
+		for i := 0; i < len(row); i += 4 {
+			r, g, bl, a := row[i], row[i+1], row[i+2], row[i+3]
+			opaque = opaque && a == 255
+			argb = append(argb, uint32(a)<<24|uint32(r-g)<<16|uint32(g)<<8|uint32(bl-g))
+		}
+	}
+
+	e := &webpBitWriter{}
+	e.write(0x2f, 8)
+	e.write(uint32(width-1), 14)
+	e.write(uint32(height-1), 14)
+	alpha := uint32(1)
+	if opaque {
+		alpha = 0
+	}
+	e.write(alpha, 1)
+	e.write(0, 3) // version
+	// One transform, subtract-green, then the end of the transform list.
+	e.write(1, 1)
+	e.write(2, 2)
+	e.write(0, 1)
+	// No color cache and no meta prefix codes.
+	e.write(0, 1)
+	e.write(0, 1)
+
+	toks := webpBackwardRefs(argb, width)
+	var hist [5][]int
+	for i, n := range webpAlphabetSizes {
+		hist[i] = make([]int, n)
+	}
+	for _, t := range toks {
+		if t.length == 0 {
+			hist[0][t.argb>>8&0xff]++
+			hist[1][t.argb>>16&0xff]++
+			hist[2][t.argb&0xff]++
+			hist[3][t.argb>>24]++
+			continue
+		}
+		code, _, _ := webpPrefix(t.length)
+		hist[0][256+code]++
+		code, _, _ = webpPrefix(t.dist)
+		hist[4][code]++
+	}
+	var codes [5][]webpCode
+	for i := range hist {
+		codes[i] = webpCodes(webpCodeLengths(hist[i], 15))
+		e.writeCodeLengths(codes[i])
+	}
+	for _, t := range toks {
+		if t.length == 0 {
+			e.writeCode(codes[0][t.argb>>8&0xff])
+			e.writeCode(codes[1][t.argb>>16&0xff])
+			e.writeCode(codes[2][t.argb&0xff])
+			e.writeCode(codes[3][t.argb>>24])
+			continue
+		}
+		code, n, extra := webpPrefix(t.length)
+		e.writeCode(codes[0][256+code])
+		e.write(extra, n)
+		code, n, extra = webpPrefix(t.dist)
+		e.writeCode(codes[4][code])
+		e.write(extra, n)
+	}
+	data := e.bytes()
+
+	// RIFF container: a single VP8L chunk, padded to an even length.
+	pad := len(data) & 1
+	head := make([]byte, 20)
+	copy(head, "RIFF")
+	binary.LittleEndian.PutUint32(head[4:], uint32(12+len(data)+pad))
+	copy(head[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(head[16:], uint32(len(data)))
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if pad != 0 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+// webpAlphabetSizes are the sizes of the green-and-length, red, blue, alpha
+// and distance alphabets.
+var webpAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+// webpCodeLengthOrder is the order code length code lengths are written in.
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpDistanceMap holds the pixel offsets of the short distance codes, as
+// yoffset<<4 | (8 - xoffset), in code order.
+var webpDistanceMap = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// webpToken is a literal pixel, or a backward reference when length is
+// set; dist is then its distance code, short codes counting from 1.
+type webpToken struct {
+	argb   uint32
+	length uint32
+	dist   uint32
+}
+
+const (
+	webpMinMatch  = 3
+	webpMaxMatch  = 4096
+	webpHashBits  = 16
+	webpMaxChain  = 32
+	webpMaxWindow = 1<<20 - 120
+)
+
+// webpBackwardRefs splits argb into literals and LZ77 references, trying
+// the pixel above and to the left first and then earlier pixels with the
+// same hash.
+func webpBackwardRefs(argb []uint32, width int) []webpToken {
+	// short maps pixel distances to their short distance codes.
+	short := map[int]uint32{}
+	for i := len(webpDistanceMap) - 1; i >= 0; i-- {
+		d := int(webpDistanceMap[i]>>4)*width + 8 - int(webpDistanceMap[i]&0xf)
+		if d >= 1 {
+			short[d] = uint32(i + 1)
+		}
+	}
+	hash := func(i int) uint32 {
+		return (argb[i]*0x9e3779b1 ^ argb[i+1]*0x85ebca6b) >> (32 - webpHashBits)
+	}
+	head := make([]int32, 1<<webpHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(argb))
+	insert := func(i int) {
+		if i+1 < len(argb) {
+			h := hash(i)
+			prev[i], head[h] = head[h], int32(i)
+		}
+	}
+	matchLen := func(i, j int) int {
+		n := 0
+		for limit := min(len(argb)-i, webpMaxMatch); n < limit && argb[i+n] == argb[j+n]; n++ {
+		}
+		return n
+	}
+
+	var toks []webpToken
+	for i := 0; i < len(argb); {
+		best, bestDist := 0, 0
+		for _, d := range [2]int{width, 1} {
+			if d <= i {
+				if n := matchLen(i, i-d); n > best {
+					best, bestDist = n, d
+				}
+			}
+		}
+		if i+1 < len(argb) {
+			for j, chain := head[hash(i)], 0; j >= 0 && chain < webpMaxChain && i-int(j) <= webpMaxWindow; j, chain = prev[j], chain+1 {
+				if n := matchLen(i, int(j)); n > best {
+					best, bestDist = n, i-int(j)
+				}
+			}
+		}
+		if best < webpMinMatch {
+			toks = append(toks, webpToken{argb: argb[i]})
+			insert(i)
+			i++
+			continue
+		}
+		code, ok := short[bestDist]
+		if !ok {
+			code = uint32(bestDist + len(webpDistanceMap))
+		}
+		toks = append(toks, webpToken{length: uint32(best), dist: code})
+		for end := i + best; i < end; i++ {
+			insert(i)
+		}
+	}
+	return toks
+}
+
+// webpPrefix returns the prefix code of a length or distance code v >= 1,
+// and the extra bits that follow it.
+func webpPrefix(v uint32) (code int, n uint, extra uint32) {
+	v--
+	if v < 4 {
+		return int(v), 0, 0
+	}
+	hb := bits.Len32(v) - 1
+	n = uint(hb - 1)
+	return 2*hb + int(v>>n&1), n, v & (1<<n - 1)
+}
+
+// webpCodeLengths returns Huffman code lengths of at most limit bits for
+// the symbol frequencies freq. An alphabet with no symbols in use gets a
+// zero-bit code for symbol 0.
+func webpCodeLengths(freq []int, limit int) []uint8 {
+	lengths := make([]uint8, len(freq))
+	type node struct {
+		weight      int
+		sym         int
+		left, right *node
+	}
+	f := append([]int(nil), freq...)
+	for {
+		var nodes []*node
+		for sym, w := range f {
+			if w > 0 {
+				nodes = append(nodes, &node{weight: w, sym: sym})
+			}
+		}
+		switch len(nodes) {
+		case 0:
+			lengths[0] = 1
+			return lengths
+		case 1:
+			lengths[nodes[0].sym] = 1
+			return lengths
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+		// Two queues: leaves in weight order, and merged nodes, which come
+		// out in weight order too.
+		var merged []*node
+		pop := func() *node {
+			if len(merged) == 0 || len(nodes) > 0 && nodes[0].weight <= merged[0].weight {
+				n := nodes[0]
+				nodes = nodes[1:]
+				return n
+			}
+			n := merged[0]
+			merged = merged[1:]
+			return n
+		}
+		for len(nodes)+len(merged) > 1 {
+			a, b := pop(), pop()
+			merged = append(merged, &node{weight: a.weight + b.weight, sym: -1, left: a, right: b})
+		}
+		fits := true
+		var walk func(n *node, depth int)
+		walk = func(n *node, depth int) {
+			if n.sym >= 0 {
+				lengths[n.sym] = uint8(depth)
+				fits = fits && depth <= limit
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk(merged[0], 0)
+		if fits {
+			return lengths
+		}
+		// Flatten the distribution and try again.
+		for sym, w := range f {
+			if w > 0 {
+				f[sym] = (w + 1) / 2
+			}
+		}
+		clear(lengths)
+	}
+}
+
+// webpCode is a canonical Huffman code: its code length, and the n bits
+// written for it, reversed to go out least significant bit first. n is
+// zero for the only symbol of a code.
+type webpCode struct {
+	length uint8
+	bits   uint32
+	n      uint8
+}
+
+// webpCodes assigns canonical codes to code lengths. A single symbol in use
+// takes no bits.
+func webpCodes(lengths []uint8) []webpCode {
+	codes := make([]webpCode, len(lengths))
+	used := 0
+	var count [16]uint32
+	for _, n := range lengths {
+		if n > 0 {
+			count[n]++
+			used++
+		}
+	}
+	var next [16]uint32
+	for n, code := 1, uint32(0); n < 16; n++ {
+		code = (code + count[n-1]) << 1
+		next[n] = code
+	}
+	for sym, n := range lengths {
+		if n == 0 {
+			continue
+		}
+		if used == 1 {
+			codes[sym] = webpCode{length: n}
+			continue
+		}
+		code := next[n]
+		next[n]++
+		codes[sym] = webpCode{length: n, bits: bits.Reverse32(code) >> (32 - n), n: n}
+	}
+	return codes
+}
+
+// webpBitWriter packs bits least significant first.
+type webpBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (e *webpBitWriter) write(v uint32, n uint) {
+	e.acc |= uint64(v) << e.nbits
+	e.nbits += n
+	for e.nbits >= 8 {
+		e.buf = append(e.buf, byte(e.acc))
+		e.acc >>= 8
+		e.nbits -= 8
+	}
+}
+
+func (e *webpBitWriter) writeCode(c webpCode) {
+	e.write(c.bits, uint(c.n))
+}
+
+func (e *webpBitWriter) bytes() []byte {
+	if e.nbits > 0 {
+		e.buf = append(e.buf, byte(e.acc))
+		e.acc, e.nbits = 0, 0
+	}
+	return e.buf
+}
+
+// writeCodeLengths writes the code lengths of codes as a normal prefix
+// code: the lengths run-length coded with symbols 16 to 18 and themselves
+// Huffman coded.
+func (e *webpBitWriter) writeCodeLengths(codes []webpCode) {
+	lengths := make([]uint8, len(codes))
+	for i, c := range codes {
+		lengths[i] = c.length
+	}
+	type rle struct {
+		sym   int
+		extra uint32
+		n     uint
+	}
+	var syms []rle
+	for i := 0; i < len(lengths); {
+		v := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == v {
+			run++
+		}
+		i += run
+		if v == 0 {
+			for run >= 3 {
+				if run >= 11 {
+					k := min(run, 138)
+					syms = append(syms, rle{18, uint32(k - 11), 7})
+					run -= k
+				} else {
+					k := min(run, 10)
+					syms = append(syms, rle{17, uint32(k - 3), 3})
+					run -= k
+				}
+			}
+		} else {
+			syms = append(syms, rle{sym: int(v)})
+			run--
+			for run >= 3 {
+				k := min(run, 6)
+				syms = append(syms, rle{16, uint32(k - 3), 2})
+				run -= k
+			}
+		}
+		for ; run > 0; run-- {
+			syms = append(syms, rle{sym: int(v)})
+		}
+	}
+
+	freq := make([]int, 19)
+	for _, s := range syms {
+		freq[s.sym]++
+	}
+	clCodes := webpCodes(webpCodeLengths(freq, 7))
+	n := len(webpCodeLengthOrder)
+	for n > 4 && clCodes[webpCodeLengthOrder[n-1]].length == 0 {
+		n--
+	}
+	e.write(0, 1) // normal, not simple, code
+	e.write(uint32(n-4), 4)
+	for _, sym := range webpCodeLengthOrder[:n] {
+		e.write(uint32(clCodes[sym].length), 3)
+	}
+	e.write(0, 1) // every symbol has a length
+	for _, s := range syms {
+		e.writeCode(clCodes[s.sym])
+		e.write(s.extra, s.n)
+	}
+}
@@ -0,0 +1,66 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	noise := image.NewNRGBA(image.Rect(0, 0, 61, 37))
+	rng.Read(noise.Pix)
+	flat := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for i := range flat.Pix {
+		flat.Pix[i] = 255
+	}
+	// Large enough for references past the short distance codes and for
+	// runs longer than the longest match.
+	stripes := image.NewNRGBA(image.Rect(0, 0, 1500, 900))
+	for y := 0; y < 900; y++ {
+		for x := 0; x < 1500; x++ {
+			stripes.SetNRGBA(x, y, color.NRGBA{uint8(x / 7), uint8(y % 13 * 19), 90, uint8(255 - x%3)})
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"photo", testPhoto(image.Pt(-5, 9), 200, 150)},
+		{"noise with alpha", noise},
+		{"one pixel", noise.SubImage(image.Rect(3, 4, 4, 5))},
+		{"flat", flat},
+		{"stripes", stripes},
+		{"gray", image.NewGray(image.Rect(0, 0, 17, 3))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeWebP(&buf, tc.img); err != nil {
+				t.Fatal(err)
+			}
+			got, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := tc.img.Bounds()
+			if got.Bounds().Size() != b.Size() {
+				t.Fatalf("size %v, want %v", got.Bounds().Size(), b.Size())
+			}
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tc.img.At(b.Min.X+x, b.Min.Y+y))
+					if c := color.NRGBAModel.Convert(got.At(x, y)); c != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, c, want)
+					}
+				}
+			}
+		})
+	}
+	if err := EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, webpMaxSize+1, 1))); err == nil {
+		t.Error("too wide: no error")
+	}
+}
@@ -0,0 +1,43 @@
+package compose
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// KeepAlpha recomposites marked, the mark drawn over src, as if the mark had
+// been drawn source-atop: every pixel keeps the alpha of src, so the mark
+// shows only where src is visible and fully transparent pixels come through
+// unchanged. For a mark of alpha ma over a source of alpha sa the result of
+// source-over has alpha ra = ma + sa(1-ma), and source-atop works out to the
+// color marked·ra + src·(1-ra) at alpha sa.
+func KeepAlpha(src, marked image.Image) image.Image {
+	if IsOpaque(src) {
+		return marked
+	}
+	s, m := imaging.Clone(src), imaging.Clone(marked)
+	out := image.NewNRGBA(s.Rect)
+	for i := 0; i < len(s.Pix); i += 4 {
+		sp, mp, op := s.Pix[i:i+4:i+4], m.Pix[i:i+4:i+4], out.Pix[i:i+4:i+4]
+		switch sa := sp[3]; sa {
+		case 0:
+			copy(op, sp)
+		case 255:
+			copy(op, mp)
+		default:
+			ra := int(mp[3])
+			for c := 0; c < 3; c++ {
+				op[c] = uint8((int(mp[c])*ra + int(sp[c])*(255-ra) + 127) / 255)
+			}
+			op[3] = sa
+		}
+	}
+	return out
+}
+
+// IsOpaque reports whether img is known to have no transparent pixels.
+func IsOpaque(img image.Image) bool {
+	o, ok := img.(interface{ Opaque() bool })
+	return ok && o.Opaque()
+}
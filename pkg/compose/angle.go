@@ -0,0 +1,68 @@
+package compose
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// autoAngleSize is the longest side an image is shrunk to before AutoAngle
+// measures its gradients.
+const autoAngleSize = 256
+
+// AutoAngle returns the Pattern angle, a multiple of 5 in [-85, 90], at
+// which text runs across the image's strongest edges rather than along
+// them, so an inpainting tool cannot follow an edge to paint the mark out.
+// An image whose edges favor no direction keeps fallback.
+func AutoAngle(img image.Image, fallback int) int {
+	small := imaging.Fit(img, autoAngleSize, autoAngleSize, imaging.Box)
+	w, h := small.Bounds().Dx(), small.Bounds().Dy()
+	if w < 3 || h < 3 {
+		return fallback
+	}
+	lum := lumaPlane(small)
+	// The structure tensor sums the squared gradient along each axis; the
+	// squared gradient along a direction d is then d·J·d.
+	var jxx, jyy, jxy float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			gx := lum[y*w+x+1] - lum[y*w+x-1]
+			gy := lum[(y+1)*w+x] - lum[(y-1)*w+x]
+			jxx += gx * gx
+			jyy += gy * gy
+			jxy += gx * gy
+		}
+	}
+	if jxx+jyy == 0 {
+		return fallback
+	}
+	best, bestScore, worstScore := fallback, math.Inf(-1), math.Inf(1)
+	for deg := -85; deg <= 90; deg += 5 {
+		// Angles turn the text counter-clockwise and y grows downward, so
+		// the baseline runs along (cos, -sin).
+		sin, cos := math.Sincos(float64(deg) * math.Pi / 180)
+		score := cos*cos*jxx + sin*sin*jyy - 2*sin*cos*jxy
+		if score > bestScore {
+			best, bestScore = deg, score
+		}
+		worstScore = math.Min(worstScore, score)
+	}
+	if bestScore-worstScore < 0.05*(jxx+jyy) {
+		return fallback
+	}
+	return best
+}
+
+// lumaPlane returns the Rec. 601 luma of img, row by row.
+func lumaPlane(img *image.NRGBA) []float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := img.Pix[y*img.Stride+4*x:]
+			out[y*w+x] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		}
+	}
+	return out
+}
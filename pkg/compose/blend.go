@@ -0,0 +1,106 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+
+	"watermark/internal/parallel"
+	"watermark/pkg/codec"
+)
+
+// BlendMode is how the colors of a layer mix with the image below it, as
+// the blend modes of the same name in image editors.
+type BlendMode string
+
+const (
+	// BlendNormal draws the layer over the image.
+	BlendNormal BlendMode = "normal"
+	// BlendMultiply darkens: white in the layer leaves the image as is.
+	BlendMultiply BlendMode = "multiply"
+	// BlendScreen lightens: black in the layer leaves the image as is.
+	BlendScreen BlendMode = "screen"
+	// BlendOverlay multiplies dark and screens light parts of the image,
+	// keeping its contrast.
+	BlendOverlay BlendMode = "overlay"
+)
+
+// ParseBlendMode parses a blend mode name such as "multiply".
+func ParseBlendMode(s string) (BlendMode, error) {
+	switch m := BlendMode(s); m {
+	case BlendNormal, BlendMultiply, BlendScreen, BlendOverlay:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid blend mode %q, want normal, multiply, screen or overlay", s)
+}
+
+// Blend composites layer, a mark alone on a transparent canvas of the size
+// of base, onto a copy of base with mode. With linear the colors are mixed
+// as light intensities rather than sRGB values, which keeps
+// semi-transparent and anti-aliased edges from darkening.
+func Blend(ctx context.Context, base image.Image, layer *image.NRGBA, mode BlendMode, linear bool, workers int) (*image.NRGBA, error) {
+	out := imaging.Clone(base)
+	err := parallel.Rows(workers, out.Bounds(), func(band image.Rectangle) error {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			if (y-band.Min.Y)%256 == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			i := out.PixOffset(0, y)
+			j := layer.PixOffset(0, y)
+			for x := 0; x < out.Bounds().Dx(); x, i, j = x+1, i+4, j+4 {
+				la := float64(layer.Pix[j+3]) / 255
+				if la == 0 {
+					continue
+				}
+				d := out.Pix[i : i+4 : i+4]
+				ba := float64(d[3]) / 255
+				oa := la + ba*(1-la)
+				for k := 0; k < 3; k++ {
+					cs, cb := float64(layer.Pix[j+k])/255, float64(d[k])/255
+					if linear {
+						cs, cb = codec.SRGBToLinear(layer.Pix[j+k]), codec.SRGBToLinear(d[k])
+					}
+					// Where the image is transparent the layer shows as is.
+					cr := (1-ba)*cs + ba*blendChannel(mode, cb, cs)
+					if c := (la*cr + ba*(1-la)*cb) / oa; linear {
+						d[k] = codec.LinearToSRGB(c)
+					} else {
+						d[k] = uint8(clampFloat(c*255+0.5, 0, 255))
+					}
+				}
+				d[3] = uint8(clampFloat(oa*255+0.5, 0, 255))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// blendChannel mixes the image value cb with the layer value cs, both in
+// [0, 1], per the separable blend modes of the W3C compositing spec.
+func blendChannel(mode BlendMode, cb, cs float64) float64 {
+	switch mode {
+	case BlendMultiply:
+		return cb * cs
+	case BlendScreen:
+		return cb + cs - cb*cs
+	case BlendOverlay:
+		if cb <= 0.5 {
+			return 2 * cb * cs
+		}
+		return 1 - 2*(1-cb)*(1-cs)
+	}
+	return cs
+}
+
+func clampFloat(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
@@ -0,0 +1,90 @@
+package compose
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	// chromeMaxRatio caps a status or navigation bar at this share of the
+	// image height. A uniform band reaching it is background, not a bar.
+	chromeMaxRatio = 0.12
+	// chromeMinRatio ignores bands thinner than this share, such as borders.
+	chromeMinRatio = 0.01
+	// chromeFill is the share of a row that must match the bar color; the
+	// rest may be icons, clock digits and the like.
+	chromeFill = 0.8
+	// chromeTolerance is the summed RGB difference still counted as a match.
+	chromeTolerance = 24
+)
+
+// DetectChrome returns the heights of the bars at the top and bottom of a
+// screenshot: bands of rows mostly filled with one color, the way phone
+// status and navigation bars are. A side without such a band reports 0.
+func DetectChrome(img *image.NRGBA) (top, bottom int) {
+	b := img.Bounds()
+	limit := int(float64(b.Dy()) * chromeMaxRatio)
+	minBar := max(int(float64(b.Dy())*chromeMinRatio), 2)
+	band := func(start, step int) int {
+		c := rowColor(img, start)
+		n := 0
+		for n < limit && rowMatches(img, start+n*step, c) {
+			n++
+		}
+		if n < minBar || n >= limit {
+			return 0
+		}
+		return n
+	}
+	return band(b.Min.Y, 1), band(b.Max.Y-1, -1)
+}
+
+// rowColor returns the per-channel median color of row y.
+func rowColor(img *image.NRGBA, y int) color.NRGBA {
+	b := img.Bounds()
+	return medianColor(b.Dx(), func(i int) color.NRGBA { return img.NRGBAAt(b.Min.X+i, y) })
+}
+
+// rowMatches reports whether at least chromeFill of row y is close to c.
+func rowMatches(img *image.NRGBA, y int, c color.NRGBA) bool {
+	b := img.Bounds()
+	return lineMatches(b.Dx(), func(i int) color.NRGBA { return img.NRGBAAt(b.Min.X+i, y) }, c, chromeFill)
+}
+
+// medianColor returns the per-channel median color of the pixels at(0) to
+// at(n-1).
+func medianColor(n int, at func(i int) color.NRGBA) color.NRGBA {
+	var hist [3][256]int
+	for i := 0; i < n; i++ {
+		c := at(i)
+		hist[0][c.R]++
+		hist[1][c.G]++
+		hist[2][c.B]++
+	}
+	var m [3]uint8
+	for ch := range hist {
+		seen := 0
+		for v, k := range hist[ch] {
+			seen += k
+			if seen*2 >= n {
+				m[ch] = uint8(v)
+				break
+			}
+		}
+	}
+	return color.NRGBA{m[0], m[1], m[2], 255}
+}
+
+// lineMatches reports whether at least fill of the pixels at(0) to
+// at(n-1) are within chromeTolerance of c.
+func lineMatches(n int, at func(i int) color.NRGBA, c color.NRGBA, fill float64) bool {
+	hits := 0
+	for i := 0; i < n; i++ {
+		p := at(i)
+		d := absInt(int(p.R)-int(c.R)) + absInt(int(p.G)-int(c.G)) + absInt(int(p.B)-int(c.B))
+		if d <= chromeTolerance {
+			hits++
+		}
+	}
+	return float64(hits) >= fill*float64(n)
+}
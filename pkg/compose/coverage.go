@@ -0,0 +1,89 @@
+package compose
+
+import (
+	"image"
+	"image/color"
+	"sync"
+
+	"watermark/internal/parallel"
+	"watermark/pkg/codec"
+)
+
+// heatStops is the color ramp of the coverage map, from the faintest change
+// the mark makes to the strongest.
+var heatStops = []color.NRGBA{
+	{40, 11, 84, 255},
+	{188, 55, 84, 255},
+	{249, 142, 9, 255},
+	{252, 255, 164, 255},
+}
+
+// Coverage compares marked with clean, both flattened onto bg: it returns
+// a heat map of how strongly the mark changes each pixel, over a dimmed
+// gray copy of clean, and the share of pixels it changes at all.
+func Coverage(clean, marked image.Image, bg color.NRGBA, workers int) (image.Image, float64) {
+	base := codec.Flatten(clean, bg, workers).(*image.RGBA)
+	flat := codec.Flatten(marked, bg, workers).(*image.RGBA)
+	b := base.Rect
+	// Summed RGB difference per pixel; its maximum scales the ramp.
+	diff := make([]uint16, b.Dx()*b.Dy())
+	var mu sync.Mutex
+	var peak, covered int
+	parallel.Rows(workers, b, func(band image.Rectangle) error {
+		p, n := 0, 0
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			i := base.PixOffset(b.Min.X, y)
+			row := diff[(y-b.Min.Y)*b.Dx():]
+			for x := 0; x < b.Dx(); x, i = x+1, i+4 {
+				d := absInt(int(flat.Pix[i])-int(base.Pix[i])) +
+					absInt(int(flat.Pix[i+1])-int(base.Pix[i+1])) +
+					absInt(int(flat.Pix[i+2])-int(base.Pix[i+2]))
+				row[x] = uint16(d)
+				if d > 0 {
+					n++
+					p = max(p, d)
+				}
+			}
+		}
+		mu.Lock()
+		peak, covered = max(peak, p), covered+n
+		mu.Unlock()
+		return nil
+	})
+
+	heat := image.NewRGBA(b)
+	parallel.Rows(workers, b, func(band image.Rectangle) error {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			i := base.PixOffset(b.Min.X, y)
+			row := diff[(y-b.Min.Y)*b.Dx():]
+			for x := 0; x < b.Dx(); x, i = x+1, i+4 {
+				c := heatColor(float64(row[x]) / float64(max(peak, 1)))
+				if row[x] == 0 {
+					g := uint8(luma(base.Pix, i) * 0.35)
+					c = color.NRGBA{g, g, g, 255}
+				}
+				heat.Pix[i], heat.Pix[i+1], heat.Pix[i+2], heat.Pix[i+3] = c.R, c.G, c.B, 255
+			}
+		}
+		return nil
+	})
+	if len(diff) == 0 {
+		return heat, 0
+	}
+	return heat, float64(covered) / float64(len(diff))
+}
+
+// heatColor returns the heatStops color at t in [0, 1].
+func heatColor(t float64) color.NRGBA {
+	t = clampFloat(t, 0, 1) * float64(len(heatStops)-1)
+	i := min(int(t), len(heatStops)-2)
+	f := t - float64(i)
+	a, b := heatStops[i], heatStops[i+1]
+	mix := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*f + 0.5) }
+	return color.NRGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 255}
+}
+
+// luma is the Rec. 601 luma of the RGB pixel at offset i of pix.
+func luma(pix []uint8, i int) float64 {
+	return 0.299*float64(pix[i]) + 0.587*float64(pix[i+1]) + 0.114*float64(pix[i+2])
+}
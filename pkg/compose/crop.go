@@ -0,0 +1,81 @@
+package compose
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// Crop selects the part of an image to keep. It is
+// either an explicit rectangle at X,Y or, when Gravity is set, a W×H box
+// anchored at one of the named positions.
+type Crop struct {
+	Gravity Position
+	X, Y    int
+	W, H    int
+}
+
+// ParseCrop parses "x,y,w,h" in pixels, e.g. "100,50,1080,1080", or
+// "gravity:WxH" with a named position, e.g. "center:1080x1080".
+func ParseCrop(s string) (Crop, error) {
+	if g, size, ok := strings.Cut(s, ":"); ok {
+		gravity := Position(strings.ToLower(strings.TrimSpace(g)))
+		if !gravity.Valid() {
+			return Crop{}, fmt.Errorf("invalid crop %q: unknown gravity %q", s, g)
+		}
+		ws, hs, ok := strings.Cut(strings.ToLower(strings.TrimSpace(size)), "x")
+		w, errW := strconv.Atoi(ws)
+		h, errH := strconv.Atoi(hs)
+		if !ok || errW != nil || errH != nil || w <= 0 || h <= 0 {
+			return Crop{}, fmt.Errorf("invalid crop %q: expected gravity:WxH", s)
+		}
+		return Crop{Gravity: gravity, W: w, H: h}, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return Crop{}, fmt.Errorf("invalid crop %q: expected x,y,w,h or gravity:WxH", s)
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return Crop{}, fmt.Errorf("invalid crop %q: bad value %q", s, p)
+		}
+		v[i] = n
+	}
+	if v[2] == 0 || v[3] == 0 {
+		return Crop{}, fmt.Errorf("invalid crop %q: width and height must be positive", s)
+	}
+	return Crop{X: v[0], Y: v[1], W: v[2], H: v[3]}, nil
+}
+
+func (c Crop) String() string {
+	if c.Gravity != "" {
+		return fmt.Sprintf("%s:%dx%d", c.Gravity, c.W, c.H)
+	}
+	return fmt.Sprintf("%d,%d,%d,%d", c.X, c.Y, c.W, c.H)
+}
+
+// Resolve returns the crop rectangle clipped to b. A gravity box larger
+// than the image shrinks to fit along that axis.
+func (c Crop) Resolve(b image.Rectangle) image.Rectangle {
+	if c.Gravity == "" {
+		return image.Rect(c.X, c.Y, c.X+c.W, c.Y+c.H).Add(b.Min).Intersect(b)
+	}
+	w, h := min(c.W, b.Dx()), min(c.H, b.Dy())
+	x, y := 0, 0
+	switch c.Gravity {
+	case TopRight, CenterRight, BottomRight:
+		x = b.Dx() - w
+	case TopCenter, Center, BottomCenter:
+		x = (b.Dx() - w) / 2
+	}
+	switch c.Gravity {
+	case BottomLeft, BottomCenter, BottomRight:
+		y = b.Dy() - h
+	case CenterLeft, Center, CenterRight:
+		y = (b.Dy() - h) / 2
+	}
+	return image.Rect(x, y, x+w, y+h).Add(b.Min)
+}
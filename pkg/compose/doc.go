@@ -0,0 +1,12 @@
+// Package compose lays rendered marks out on images: Pattern tiles a mark
+// across an image, Position, Offset, Region and Margins say where a single
+// mark goes, with helpers that keep it off busy edges, screenshot status
+// bars and letterbox borders, and Blend composites a layer onto an image
+// with a blend mode. Crop selects the part of an image to keep, Padding
+// pads it to an aspect ratio, and KeepAlpha carries transparency over.
+// For print, Stencil and Bilevel reduce a marked page to one ink, and
+// Coverage maps how much of an image a mark covers.
+//
+// It works on image.Image values and does not care how a mark was drawn,
+// so it can be used without package render.
+package compose
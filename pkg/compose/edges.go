@@ -0,0 +1,114 @@
+package compose
+
+import "image"
+
+// edgeThreshold is the mean per-pixel gradient below which a spot is
+// considered calm enough to hold the mark without nudging.
+const edgeThreshold = 12.0
+
+// NudgeAwayFromEdges shifts pt inward from the anchor corner pos, up to
+// maxShift pixels per axis, to the spot whose w×h box covers the least edge
+// energy.
+func NudgeAwayFromEdges(img *image.NRGBA, pt image.Point, w, h int, pos Position, maxShift int) image.Point {
+	dirX, dirY := inwardDirection(pos)
+	if (dirX == 0 && dirY == 0) || maxShift <= 0 || w <= 0 || h <= 0 {
+		return pt
+	}
+
+	// Gradient map covering every candidate box.
+	region := image.Rect(pt.X, pt.Y, pt.X+w, pt.Y+h)
+	region = region.Union(region.Add(image.Pt(dirX*maxShift, dirY*maxShift)))
+	region = region.Intersect(img.Bounds())
+	if region.Empty() {
+		return pt
+	}
+	sum := gradientIntegral(img, region)
+	score := func(p image.Point) float64 {
+		r := image.Rect(p.X, p.Y, p.X+w, p.Y+h).Intersect(region)
+		if r.Empty() {
+			return 0
+		}
+		x0, y0 := r.Min.X-region.Min.X, r.Min.Y-region.Min.Y
+		x1, y1 := r.Max.X-region.Min.X, r.Max.Y-region.Min.Y
+		// The table has an extra leading row and column of zeros.
+		stride := region.Dx() + 1
+		total := sum[y1*stride+x1] - sum[y0*stride+x1] - sum[y1*stride+x0] + sum[y0*stride+x0]
+		return float64(total) / float64(r.Dx()*r.Dy())
+	}
+
+	best := pt
+	bestScore := score(pt)
+	if bestScore <= edgeThreshold {
+		return pt
+	}
+	// Side anchors move along one axis only.
+	spanX, spanY := maxShift*absInt(dirX), maxShift*absInt(dirY)
+	step := max(1, maxShift/8)
+	for sy := 0; sy <= spanY; sy += step {
+		for sx := 0; sx <= spanX; sx += step {
+			cand := image.Pt(pt.X+dirX*sx, pt.Y+dirY*sy)
+			// Small distance penalty so equally calm spots prefer the anchor.
+			s := score(cand) + float64(sx+sy)/float64(maxShift)
+			if s < bestScore {
+				best, bestScore = cand, s
+			}
+		}
+	}
+	return best
+}
+
+// inwardDirection returns the unit step pointing from the anchor toward the
+// image center.
+func inwardDirection(pos Position) (int, int) {
+	switch pos {
+	case BottomRight:
+		return -1, -1
+	case BottomCenter:
+		return 0, -1
+	case BottomLeft:
+		return 1, -1
+	case TopRight:
+		return -1, 1
+	case TopCenter:
+		return 0, 1
+	case TopLeft:
+		return 1, 1
+	case CenterRight:
+		return -1, 0
+	case CenterLeft:
+		return 1, 0
+	default:
+		return 0, 0
+	}
+}
+
+// gradientIntegral builds a summed-area table of luminance gradient magnitude
+// over r. The table has (r.Dx()+1)×(r.Dy()+1) entries.
+func gradientIntegral(img *image.NRGBA, r image.Rectangle) []uint64 {
+	b := img.Bounds()
+	lum := func(x, y int) int {
+		if x >= b.Max.X {
+			x = b.Max.X - 1
+		}
+		if y >= b.Max.Y {
+			y = b.Max.Y - 1
+		}
+		c := img.NRGBAAt(x, y)
+		return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+	}
+
+	w, h := r.Dx(), r.Dy()
+	stride := w + 1
+	sum := make([]uint64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		var row uint64
+		for x := 0; x < w; x++ {
+			px, py := r.Min.X+x, r.Min.Y+y
+			l := lum(px, py)
+			g := absInt(lum(px+1, py)-l) + absInt(lum(px, py+1)-l)
+			row += uint64(g)
+			sum[(y+1)*stride+x+1] = sum[y*stride+x+1] + row
+		}
+	}
+	return sum
+}
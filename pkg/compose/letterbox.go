@@ -0,0 +1,52 @@
+package compose
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	// borderMaxRatio caps a border at this share of the image width or
+	// height. A uniform band reaching it is background, not a border.
+	borderMaxRatio = 0.4
+	// borderFill is the share of a row or column that must match the border
+	// color; it leaves room for compression noise, not for content.
+	borderFill = 0.98
+)
+
+// DetectContent returns the part of img inside uniform borders and
+// letterbox or pillarbox bars: on each side, the band of whole rows or
+// columns matching the color of the outermost one. A side without such a
+// band, or whose band reaches borderMaxRatio, is not trimmed.
+func DetectContent(img *image.NRGBA) image.Rectangle {
+	b := img.Bounds()
+	if b.Empty() {
+		return b
+	}
+	// border measures the band that starts with the line through from and
+	// moves inward by step; each line runs along dir for n pixels.
+	border := func(from, step, dir image.Point, n, size int) int {
+		line := func(k int) func(i int) color.NRGBA {
+			p := from.Add(step.Mul(k))
+			return func(i int) color.NRGBA { return img.NRGBAAt(p.X+i*dir.X, p.Y+i*dir.Y) }
+		}
+		c := medianColor(n, line(0))
+		limit := int(float64(size) * borderMaxRatio)
+		k := 0
+		for k < limit && lineMatches(n, line(k), c, borderFill) {
+			k++
+		}
+		if k >= limit {
+			return 0
+		}
+		return k
+	}
+	right, down := image.Pt(1, 0), image.Pt(0, 1)
+	last := b.Max.Sub(image.Pt(1, 1))
+	return image.Rect(
+		b.Min.X+border(b.Min, right, down, b.Dy(), b.Dx()),
+		b.Min.Y+border(b.Min, down, right, b.Dx(), b.Dy()),
+		b.Max.X-border(image.Pt(last.X, b.Min.Y), right.Mul(-1), down, b.Dy(), b.Dx()),
+		b.Max.Y-border(image.Pt(b.Min.X, last.Y), down.Mul(-1), right, b.Dx(), b.Dy()),
+	)
+}
@@ -0,0 +1,69 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Margins are the distances between a placed mark and each side of the
+// image, in pixels or as a percentage of the image width (left and
+// right) or height (top and bottom).
+type Margins struct {
+	Top, Right, Bottom, Left Coord
+}
+
+// UniformMargins returns margins of c on every side.
+func UniformMargins(c Coord) Margins {
+	return Margins{Top: c, Right: c, Bottom: c, Left: c}
+}
+
+func (m Margins) String() string {
+	return "top=" + m.Top.String() + ",right=" + m.Right.String() +
+		",bottom=" + m.Bottom.String() + ",left=" + m.Left.String()
+}
+
+// ParseMargins parses "top=2%,right=5%,bottom=3%,left=5%", each side in
+// pixels or, with a % suffix, relative to the image size. Sides s does not
+// name keep their value from base.
+func ParseMargins(s string, base Margins) (Margins, error) {
+	m := base
+	for _, part := range strings.Split(s, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return Margins{}, fmt.Errorf("invalid margins %q: expected side=value pairs such as top=2%%", s)
+		}
+		c, err := parseCoord(strings.TrimSpace(val))
+		if err != nil {
+			return Margins{}, fmt.Errorf("invalid margins %q: %w", s, err)
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "top":
+			m.Top = c
+		case "right":
+			m.Right = c
+		case "bottom":
+			m.Bottom = c
+		case "left":
+			m.Left = c
+		default:
+			return Margins{}, fmt.Errorf("invalid margins %q: unknown side %q", s, key)
+		}
+	}
+	return m, nil
+}
+
+// Validate rejects percentage margins that leave no room between opposite
+// sides; pixel margins depend on the image and are not checked.
+func (m Margins) Validate() error {
+	for _, pair := range [][2]Coord{{m.Left, m.Right}, {m.Top, m.Bottom}} {
+		if pair[0].Percent && pair[1].Percent && pair[0].Value+pair[1].Value >= 100 {
+			return fmt.Errorf("margins %s leave no room for the mark", m)
+		}
+	}
+	return nil
+}
+
+// Sides returns the margins of a w×h image in the units of w and h.
+func (m Margins) Sides(w, h float64) (top, right, bottom, left float64) {
+	return m.Top.Of(h), m.Right.Of(w), m.Bottom.Of(h), m.Left.Of(w)
+}
@@ -0,0 +1,174 @@
+package compose
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Coord is a position along one axis, in pixels or, when Percent is set, as
+// a percentage of the image size along that axis.
+type Coord struct {
+	Value   float64
+	Percent bool
+}
+
+// Px returns a pixel coordinate.
+func Px(v int) Coord { return Coord{Value: float64(v)} }
+
+// Pct returns a coordinate as a percentage of the image size.
+func Pct(v float64) Coord { return Coord{Value: v, Percent: true} }
+
+// Resolve returns c in pixels along an axis of size pixels.
+func (c Coord) Resolve(size int) int {
+	if c.Percent {
+		return int(c.Value / 100 * float64(size))
+	}
+	return int(c.Value)
+}
+
+// Of returns c in the units of size, the image size along its axis.
+func (c Coord) Of(size float64) float64 {
+	if c.Percent {
+		return c.Value / 100 * size
+	}
+	return c.Value
+}
+
+func (c Coord) String() string {
+	if c.Percent {
+		return strconv.FormatFloat(c.Value, 'g', -1, 64) + "%"
+	}
+	return strconv.FormatFloat(c.Value, 'g', -1, 64)
+}
+
+// Offset is an explicit mark location used instead of a named position.
+type Offset struct {
+	X, Y Coord
+}
+
+func (o Offset) String() string {
+	return "x=" + o.X.String() + ",y=" + o.Y.String()
+}
+
+// ParseOffset parses "x=120,y=40" (pixels) or "x=85%,y=92%" (percentages of
+// the image size). Units may be mixed.
+func ParseOffset(s string) (Offset, error) {
+	var off Offset
+	var seen [2]bool
+	for _, part := range strings.Split(s, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return Offset{}, fmt.Errorf("invalid offset %q: expected x=..,y=..", s)
+		}
+		c, err := parseCoord(strings.TrimSpace(val))
+		if err != nil {
+			return Offset{}, fmt.Errorf("invalid offset %q: %w", s, err)
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "x":
+			off.X, seen[0] = c, true
+		case "y":
+			off.Y, seen[1] = c, true
+		default:
+			return Offset{}, fmt.Errorf("invalid offset %q: unknown key %q", s, key)
+		}
+	}
+	if !seen[0] || !seen[1] {
+		return Offset{}, fmt.Errorf("invalid offset %q: both x and y are required", s)
+	}
+	return off, nil
+}
+
+func parseCoord(s string) (Coord, error) {
+	pct := strings.HasSuffix(s, "%")
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return Coord{}, fmt.Errorf("invalid coordinate %q", s)
+	}
+	if v < 0 || (pct && v > 100) {
+		return Coord{}, fmt.Errorf("coordinate %q out of range", s)
+	}
+	return Coord{Value: v, Percent: pct}, nil
+}
+
+// Place returns the top-left corner of a w×h mark whose anchor point sits
+// at o, kept inside an imgW×imgH image.
+func (o Offset) Place(anchor Position, w, h, imgW, imgH int) image.Point {
+	x, y := o.X.Resolve(imgW), o.Y.Resolve(imgH)
+	switch anchor {
+	case TopRight, CenterRight, BottomRight:
+		x -= w
+	case TopCenter, Center, BottomCenter:
+		x -= w / 2
+	}
+	switch anchor {
+	case BottomLeft, BottomCenter, BottomRight:
+		y -= h
+	case CenterLeft, Center, CenterRight:
+		y -= h / 2
+	}
+	return image.Point{
+		X: clampInt(x, 0, max(imgW-w, 0)),
+		Y: clampInt(y, 0, max(imgH-h, 0)),
+	}
+}
+
+// Region is a rectangle within the image, each corner in pixels or percent.
+type Region struct {
+	Min, Max Offset
+}
+
+// ParseRegion parses "x0,y0,x1,y1" where each value is in pixels or, with a
+// % suffix, relative to the image size, e.g. "50%,50%,100%,100%".
+func ParseRegion(s string) (Region, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return Region{}, fmt.Errorf("invalid region %q: expected x0,y0,x1,y1", s)
+	}
+	var c [4]Coord
+	for i, p := range parts {
+		v, err := parseCoord(strings.TrimSpace(p))
+		if err != nil {
+			return Region{}, fmt.Errorf("invalid region %q: %w", s, err)
+		}
+		c[i] = v
+	}
+	return Region{Min: Offset{X: c[0], Y: c[1]}, Max: Offset{X: c[2], Y: c[3]}}, nil
+}
+
+func (r Region) String() string {
+	return r.Min.X.String() + "," + r.Min.Y.String() + "," + r.Max.X.String() + "," + r.Max.Y.String()
+}
+
+// Resolve returns the region in pixels, clipped to an imgW×imgH image.
+func (r Region) Resolve(imgW, imgH int) image.Rectangle {
+	return image.Rect(
+		r.Min.X.Resolve(imgW), r.Min.Y.Resolve(imgH),
+		r.Max.X.Resolve(imgW), r.Max.Y.Resolve(imgH),
+	).Intersect(image.Rect(0, 0, imgW, imgH))
+}
+
+// RandomPlace picks the top-left corner of a w×h mark inside region, drawn
+// from a generator seeded with seed and the image content, so the same image
+// always gets the same spot while different images do not.
+func RandomPlace(img *image.NRGBA, region Region, seed int64, w, h int) image.Point {
+	imgW, imgH := img.Bounds().Dx(), img.Bounds().Dy()
+	r := region.Resolve(imgW, imgH)
+	hash := fnv.New64a()
+	hash.Write(img.Pix)
+	rng := rand.New(rand.NewSource(seed ^ int64(hash.Sum64())))
+	pick := func(lo, hi, size, limit int) int {
+		if span := hi - lo - size; span > 0 {
+			return lo + rng.Intn(span+1)
+		}
+		return clampInt(lo, 0, max(limit-size, 0))
+	}
+	return image.Point{
+		X: pick(r.Min.X, r.Max.X, w, imgW),
+		Y: pick(r.Min.Y, r.Max.Y, h, imgH),
+	}
+}
@@ -0,0 +1,69 @@
+package compose
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// padBlurScale is how much the blurred fill is shrunk before blurring, which
+// keeps padding of large images cheap; the fill has no detail to lose.
+const padBlurScale = 8
+
+// Padding extends the canvas of an image to an aspect ratio, filling the
+// added area with Color or, with Blur, with a blurred, enlarged copy of the
+// image, as photo apps do for social posts.
+type Padding struct {
+	Ratio float64 // width divided by height
+	Color color.NRGBA
+	Blur  bool
+}
+
+// ParseAspectRatio parses an aspect ratio as "W:H", e.g. "4:5" or
+// "1.91:1", or as a single number, and returns width divided by height.
+func ParseAspectRatio(s string) (float64, error) {
+	ws, hs, ok := strings.Cut(strings.TrimSpace(s), ":")
+	w, err := strconv.ParseFloat(strings.TrimSpace(ws), 64)
+	h := 1.0
+	if err == nil && ok {
+		h, err = strconv.ParseFloat(strings.TrimSpace(hs), 64)
+	}
+	if err != nil || !(w > 0) || !(h > 0) || math.IsInf(w/h, 0) {
+		return 0, fmt.Errorf("invalid aspect ratio %q: expected W:H, e.g. 4:5", s)
+	}
+	return w / h, nil
+}
+
+// Apply returns img centered on a canvas of p's aspect ratio, as large as
+// img on one side, with the rest filled. Images within a pixel of the ratio
+// are returned as they are.
+func (p Padding) Apply(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if float64(w)/float64(h) < p.Ratio {
+		w = int(math.Round(float64(h) * p.Ratio))
+	} else {
+		h = int(math.Round(float64(w) / p.Ratio))
+	}
+	if w <= b.Dx() && h <= b.Dy() {
+		return img
+	}
+	var canvas *image.NRGBA
+	if p.Blur {
+		small := imaging.Fill(img, max(1, w/padBlurScale), max(1, h/padBlurScale), imaging.Center, imaging.Linear)
+		small = imaging.Blur(small, float64(max(w, h))/padBlurScale/40)
+		canvas = imaging.Resize(small, w, h, imaging.Linear)
+	} else {
+		canvas = image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(p.Color), image.Point{}, draw.Src)
+	}
+	at := image.Pt((w-b.Dx())/2, (h-b.Dy())/2)
+	draw.Draw(canvas, image.Rectangle{Min: at, Max: at.Add(b.Size())}, img, b.Min, draw.Over)
+	return canvas
+}
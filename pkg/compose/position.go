@@ -0,0 +1,63 @@
+package compose
+
+import "image"
+
+// Position names where a mark is placed on an image.
+type Position string
+
+const (
+	BottomRight  Position = "bottom-right"
+	BottomCenter Position = "bottom-center"
+	BottomLeft   Position = "bottom-left"
+	TopRight     Position = "top-right"
+	TopCenter    Position = "top-center"
+	TopLeft      Position = "top-left"
+	CenterRight  Position = "center-right"
+	CenterLeft   Position = "center-left"
+	Center       Position = "center"
+)
+
+// Valid reports whether p is one of the named anchors.
+func (p Position) Valid() bool {
+	switch p {
+	case BottomRight, BottomCenter, BottomLeft, TopRight, TopCenter, TopLeft,
+		CenterRight, CenterLeft, Center:
+		return true
+	}
+	return false
+}
+
+// At returns the top-left corner of a mark placed at p when that corner may
+// range from left to right and from top to bottom, such as within margins.
+// Positions that are not Valid give the zero point.
+func (p Position) At(left, top, right, bottom int) image.Point {
+	centerX, centerY := (left+right)/2, (top+bottom)/2
+	return map[Position]image.Point{
+		BottomRight:  {X: right, Y: bottom},
+		BottomCenter: {X: centerX, Y: bottom},
+		BottomLeft:   {X: left, Y: bottom},
+		TopRight:     {X: right, Y: top},
+		TopCenter:    {X: centerX, Y: top},
+		TopLeft:      {X: left, Y: top},
+		CenterRight:  {X: right, Y: centerY},
+		CenterLeft:   {X: left, Y: centerY},
+		Center:       {X: centerX, Y: centerY},
+	}[p]
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
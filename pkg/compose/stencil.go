@@ -0,0 +1,79 @@
+package compose
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// stencilGamma boosts the coverage of anti-aliased mark edges so thin
+// strokes survive scanning, photocopying and bilevel conversion.
+const stencilGamma = 0.6
+
+// Stencil returns a grayscale copy of page, flattened onto white, with a
+// gray stencil of level where cover, a mark drawn at full strength on a
+// transparent canvas of the same size, has coverage. The stencil only
+// darkens pixels lighter than level, so black text on the page stays
+// untouched.
+func Stencil(ctx context.Context, page image.Image, cover *image.NRGBA, level uint8) (*image.Gray, error) {
+	b := page.Bounds()
+	out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	src := imaging.Clone(page)
+	gray := float64(level)
+	for y := 0; y < b.Dy(); y++ {
+		if y%256 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		for x := 0; x < b.Dx(); x++ {
+			i := src.PixOffset(x, y)
+			p := src.Pix[i : i+4 : i+4]
+			// Composite onto white, then take luminance.
+			a := float64(p[3]) / 255
+			lum := (0.299*float64(p[0])+0.587*float64(p[1])+0.114*float64(p[2]))*a + 255*(1-a)
+			if ca := cover.Pix[cover.PixOffset(x, y)+3]; ca > 0 {
+				t := math.Pow(float64(ca)/255, stencilGamma)
+				lum = math.Min(lum, 255-(255-gray)*t)
+			}
+			out.Pix[out.PixOffset(x, y)] = uint8(math.Round(lum))
+		}
+	}
+	return out, nil
+}
+
+// bayer4 is the 4×4 ordered-dither threshold matrix.
+var bayer4 = [4][4]uint8{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// Bilevel converts img to black and white with ordered dithering, so light
+// gray stencils survive as a dot pattern while black and white stay solid.
+// Transparent areas count as white. The result is a two-color paletted
+// image, which PNG writes as 1 bit per pixel.
+func Bilevel(img image.Image) *image.Paletted {
+	b := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), color.Palette{color.Black, color.White})
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		gray = image.NewGray(b)
+		draw.Draw(gray, b, image.White, image.Point{}, draw.Src)
+		draw.Draw(gray, b, img, b.Min, draw.Over)
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			g := gray.GrayAt(b.Min.X+x, b.Min.Y+y).Y
+			if int(g) > int(bayer4[y%4][x%4])*16+8 {
+				out.Pix[out.PixOffset(x, y)] = 1
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,191 @@
+package compose
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/disintegration/imaging"
+
+	"watermark/internal/parallel"
+)
+
+// Pattern tiles a mark across images in staggered rows, every other row
+// shifted by half a step, turned by an angle.
+type Pattern struct {
+	mark image.Image
+	tile image.Image // mark as pasted, rotated by the angle
+
+	space       int
+	angle       int
+	rotateTiles bool
+}
+
+// NewPattern returns the pattern of mark tiled space pixels apart and
+// turned counter-clockwise by angle degrees. By default the tiles are laid
+// out as one canvas that is turned as a whole; with rotateTiles each tile
+// is turned about its own center on an upright grid instead.
+func NewPattern(mark image.Image, space, angle int, rotateTiles bool) *Pattern {
+	p := &Pattern{mark: mark, tile: mark, space: space, angle: angle, rotateTiles: rotateTiles}
+	if !rotateTiles {
+		// The pattern used to be tiled onto a canvas that was pasted in
+		// turn, and pasting applies the alpha of the mark again; the tiles
+		// keep that look.
+		flat := image.NewNRGBA(image.Rect(0, 0, mark.Bounds().Dx(), mark.Bounds().Dy()))
+		pasteWithAlpha(flat, mark, 0, 0)
+		p.tile = flat
+	}
+	if angle%360 != 0 {
+		p.tile = imaging.Rotate(p.tile, float64(angle), color.NRGBA{0, 0, 0, 0})
+	}
+	return p
+}
+
+// Draw composites the pattern onto dst, which has the size of the image,
+// on workers goroutines; 0 means GOMAXPROCS. Each worker draws the tiles of
+// a band of rows, clipped to it.
+func (p *Pattern) Draw(ctx context.Context, dst *image.NRGBA, workers int) error {
+	var pts []image.Point
+	if !p.rotateTiles {
+		pts = p.placements(dst.Bounds().Dx(), dst.Bounds().Dy())
+	}
+	return parallel.Rows(workers, dst.Bounds(), func(band image.Rectangle) error {
+		sub := dst.SubImage(band).(*image.NRGBA)
+		if p.rotateTiles {
+			return p.drawRotatedTiles(ctx, sub)
+		}
+		return p.drawRotatedCanvas(ctx, sub, pts)
+	})
+}
+
+// Count returns how many tiles Draw pastes for an image of the given size,
+// without doing the work.
+func (p *Pattern) Count(width, height int) int {
+	if p.rotateTiles {
+		tw, th := p.tile.Bounds().Dx(), p.tile.Bounds().Dy()
+		return p.gridCount(image.Rect(0, 0, width, height), tw, th, -tw, -th)
+	}
+	return len(p.placements(width, height))
+}
+
+// drawRotatedCanvas draws the pattern of tile laid out on a square canvas
+// covering the image at any angle and turned about its center, without that
+// canvas: the rotated mark is pasted at each of pts, from placements, that
+// falls on dst.
+func (p *Pattern) drawRotatedCanvas(ctx context.Context, dst *image.NRGBA, pts []image.Point) error {
+	tw, th := p.tile.Bounds().Dx(), p.tile.Bounds().Dy()
+	for i, pt := range pts {
+		if i%256 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if image.Rect(pt.X, pt.Y, pt.X+tw, pt.Y+th).Overlaps(dst.Bounds()) {
+			pasteWithAlpha(dst, p.tile, pt.X, pt.Y)
+		}
+	}
+	return nil
+}
+
+// placements returns where drawRotatedCanvas pastes the rotated mark on a
+// width×height image: the top-left corners of the tiles that overlap it.
+func (p *Pattern) placements(width, height int) []image.Point {
+	mw, mh := p.mark.Bounds().Dx(), p.mark.Bounds().Dy()
+	tw, th := p.tile.Bounds().Dx(), p.tile.Bounds().Dy()
+	stepX, stepY := mw+p.space, mh+p.space
+	if stepX <= 0 || stepY <= 0 {
+		return nil
+	}
+	c := p.canvasSize(width, height)
+	// Where the center of the canvas lands when it is pasted centered.
+	cx := float64((width-c)/2) + float64(c)/2
+	cy := float64((height-c)/2) + float64(c)/2
+	sin, cos := math.Sincos(float64(p.angle) * math.Pi / 180)
+	bounds := image.Rect(0, 0, width, height)
+
+	var pts []image.Point
+	rowShift := 0
+	for y := 0; y < c; y += stepY {
+		x := -int(float64(stepX) * 0.5 * float64(rowShift))
+		rowShift ^= 1
+		for ; x < c; x += stepX {
+			// Turn the tile center about the canvas center counter-clockwise,
+			// as imaging.Rotate does with Y pointing down.
+			u := float64(x) + float64(mw)/2 - float64(c)/2
+			v := float64(y) + float64(mh)/2 - float64(c)/2
+			pt := image.Pt(
+				int(math.Round(cx+u*cos+v*sin-float64(tw)/2)),
+				int(math.Round(cy-u*sin+v*cos-float64(th)/2)),
+			)
+			if image.Rect(pt.X, pt.Y, pt.X+tw, pt.Y+th).Overlaps(bounds) {
+				pts = append(pts, pt)
+			}
+		}
+	}
+	return pts
+}
+
+// drawRotatedTiles rotates the mark once and tiles it upright over dst.
+func (p *Pattern) drawRotatedTiles(ctx context.Context, dst *image.NRGBA) error {
+	tw, th := p.tile.Bounds().Dx(), p.tile.Bounds().Dy()
+	// Start one tile up and left so the staggered rows also cover the edges.
+	return p.tileOver(ctx, dst, p.tile, -tw, -th)
+}
+
+// canvasSize is the side of the square the pattern is laid out on, which
+// still covers a width×height image after rotation.
+func (p *Pattern) canvasSize(width, height int) int {
+	mw, mh := p.mark.Bounds().Dx(), p.mark.Bounds().Dy()
+	return int(math.Hypot(float64(width), float64(height))) + max(mw, mh)*2
+}
+
+// gridCount counts the pastes tileOver makes for mw×mh tiles over r.
+func (p *Pattern) gridCount(r image.Rectangle, mw, mh, x0, y0 int) int {
+	stepX, stepY := mw+p.space, mh+p.space
+	if stepX <= 0 || stepY <= 0 {
+		return 0
+	}
+	n := 0
+	rowShift := 0
+	for y := y0; y < r.Max.Y; y += stepY {
+		x := x0 - int(float64(stepX)*0.5*float64(rowShift))
+		rowShift ^= 1
+		if x < r.Max.X {
+			n += (r.Max.X - x + stepX - 1) / stepX
+		}
+	}
+	return n
+}
+
+// tileOver pastes mark over dst in rows starting at x0, y0, shifting every
+// other row by half a step.
+func (p *Pattern) tileOver(ctx context.Context, dst *image.NRGBA, mark image.Image, x0, y0 int) error {
+	mw := mark.Bounds().Dx()
+	mh := mark.Bounds().Dy()
+	c := dst.Bounds()
+
+	y := y0
+	rowShift := 0
+	for y < c.Max.Y {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		x := x0 - int(float64(mw+p.space)*0.5*float64(rowShift))
+		rowShift ^= 1
+		for y+mh > c.Min.Y && x < c.Max.X {
+			pasteWithAlpha(dst, mark, x, y)
+			x += mw + p.space
+		}
+		y += mh + p.space
+	}
+	return nil
+}
+
+// pasteWithAlpha draws src over dst with its top-left corner at x, y,
+// masked by its own alpha, so translucent pixels are applied twice.
+func pasteWithAlpha(dst *image.NRGBA, src image.Image, x, y int) {
+	r := image.Rect(x, y, x+src.Bounds().Dx(), y+src.Bounds().Dy())
+	draw.DrawMask(dst, r, src, src.Bounds().Min, src, src.Bounds().Min, draw.Over)
+}
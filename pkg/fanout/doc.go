@@ -0,0 +1,7 @@
+// Package fanout records the copies of a fan-out, one uniquely marked copy
+// of an image per recipient: a Manifest says which recipient got which
+// copy, under which serial and with which invisible mark key, so a leaked
+// copy can be traced back to its recipient.
+//
+// Package watermark makes the copies; see watermark.Fanout.
+package fanout
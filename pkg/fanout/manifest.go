@@ -0,0 +1,78 @@
+package fanout
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"watermark/pkg/codec"
+)
+
+// ManifestName is the file a manifest is written to, inside the output
+// directory.
+const ManifestName = "manifest.json"
+
+// Manifest maps the copies of a fan-out to their recipients. Keep it
+// private: the keys are what robust mark detection needs to trace a leak.
+type Manifest struct {
+	Source  string    `json:"source"`
+	Created time.Time `json:"created"`
+	Copies  []Entry   `json:"copies"`
+}
+
+// Entry is one copy of a fan-out.
+type Entry struct {
+	Recipient string `json:"recipient"`
+	// Serial is a random code that also names the file and may appear in
+	// the visible text.
+	Serial string `json:"serial"`
+	// Key is the robust invisible mark's key, if one was embedded.
+	Key  string `json:"key,omitempty"`
+	Path string `json:"path"`
+}
+
+// Write writes m to path as indented JSON, atomically.
+func (m *Manifest) Write(path string) error {
+	return codec.WriteFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	})
+}
+
+// Read reads a manifest written by Write.
+func Read(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// NewSerial returns a random 8-digit hex serial in upper case.
+func NewSerial() string {
+	return strings.ToUpper(randomHex(4))
+}
+
+// NewKey returns a random key for a robust invisible mark.
+func NewKey() string {
+	return randomHex(16)
+}
+
+// randomHex returns n random bytes as lowercase hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,7 @@
+// Package invisible hides marks that do not show. Embed stores a payload
+// in the least-significant bits of an image, which lossless formats keep;
+// EmbedRobust adds a keyed spread-spectrum pattern that carries no payload
+// but survives JPEG recompression and mild resizing. HideFingerprint hides
+// an ID in the text of a visible mark instead, as zero-width characters
+// and the spacing between words.
+package invisible
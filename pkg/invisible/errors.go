@@ -0,0 +1,12 @@
+package invisible
+
+import "errors"
+
+// Sentinel errors returned (possibly wrapped) by this package. Use errors.Is
+// to test for them.
+var (
+	// ErrPayloadTooLarge means a payload does not fit in the image.
+	ErrPayloadTooLarge = errors.New("payload too large for image")
+	// ErrNoPayload means an image carries no intact payload.
+	ErrNoPayload = errors.New("no invisible watermark found")
+)
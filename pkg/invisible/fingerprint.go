@@ -0,0 +1,187 @@
+package invisible
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Characters of the fingerprint. The zero-width ones render as nothing and
+// survive copying the text; the en space renders a little wider than a
+// normal space, so the spacing survives into the image.
+const (
+	zwFrame  = '\u2060' // word joiner, brackets the zero-width layer
+	zwZero   = '\u200b' // zero-width space
+	zwOne    = '\u200c' // zero-width non-joiner
+	wideGap  = '\u2002' // en space
+	maxFPLen = 32
+)
+
+// Fingerprint is what DecodeFingerprint recovers from a marked text.
+type Fingerprint struct {
+	// ID is the fingerprint read from zero-width characters, or empty if
+	// they did not survive, as after OCR.
+	ID string
+	// SpaceBits has one '0' or '1' per word gap: '1' for a wide gap, read
+	// as an en space or, in OCR output that keeps interword spacing, as two
+	// or more spaces.
+	SpaceBits string
+}
+
+// HideFingerprint hides id in text twice: as zero-width characters after
+// the first character, and as the pattern of normal and en spaces between
+// words, which encodes a hash of id. Runs of spaces between words collapse
+// to one.
+func HideFingerprint(text, id string) string {
+	if text == "" {
+		return text
+	}
+	var zw strings.Builder
+	zw.WriteRune(zwFrame)
+	for _, b := range []byte(id) {
+		for i := 7; i >= 0; i-- {
+			if b>>i&1 == 1 {
+				zw.WriteRune(zwOne)
+			} else {
+				zw.WriteRune(zwZero)
+			}
+		}
+	}
+	zw.WriteRune(zwFrame)
+
+	bits := fingerprintBits(id)
+	runes := []rune(text)
+	var out strings.Builder
+	gap, skip := 0, 0
+	for i, r := range runes {
+		if i < skip {
+			continue
+		}
+		// A run of spaces followed by more text on the line is one gap,
+		// written as a single space that carries the bit.
+		if r == ' ' {
+			j := i
+			for j < len(runes) && runes[j] == ' ' {
+				j++
+			}
+			if j < len(runes) && runes[j] != '\n' && runes[j] != '\r' {
+				if bits>>(31-gap%32)&1 == 1 {
+					r = wideGap
+				}
+				gap, skip = gap+1, j
+			}
+		}
+		out.WriteRune(r)
+		if i == 0 {
+			out.WriteString(zw.String())
+		}
+	}
+	return out.String()
+}
+
+// fingerprintBits is the 32-bit hash of id the space layer repeats.
+func fingerprintBits(id string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32()
+}
+
+// DecodeFingerprint reads the fingerprint HideFingerprint hid in a
+// watermark text, e.g. text copied from a document or OCR'd from a leaked
+// image.
+func DecodeFingerprint(text string) Fingerprint {
+	var fp Fingerprint
+	if start := strings.IndexRune(text, zwFrame); start >= 0 {
+		rest := text[start+utf8.RuneLen(zwFrame):]
+		if end := strings.IndexRune(rest, zwFrame); end >= 0 {
+			var id []byte
+			var b byte
+			n := 0
+			for _, r := range rest[:end] {
+				b <<= 1
+				if r == zwOne {
+					b |= 1
+				}
+				if n++; n == 8 {
+					id = append(id, b)
+					b, n = 0, 0
+				}
+			}
+			fp.ID = string(id)
+		}
+	}
+
+	var bits strings.Builder
+	run, wide := 0, false
+	for _, r := range text + "\n" {
+		switch r {
+		case zwFrame, zwZero, zwOne:
+			continue
+		case ' ', '\u00a0':
+			run++
+			continue
+		case wideGap:
+			run, wide = run+1, true
+			continue
+		}
+		if run > 0 && r != '\n' && r != '\r' {
+			if wide || run > 1 {
+				bits.WriteByte('1')
+			} else {
+				bits.WriteByte('0')
+			}
+		}
+		run, wide = 0, false
+	}
+	fp.SpaceBits = bits.String()
+	return fp
+}
+
+// MatchFingerprint returns the id among ids that text was fingerprinted
+// with. A surviving zero-width ID must match exactly; otherwise the word
+// gaps are compared with each id's pattern, allowing one misread gap in
+// eight, and the closest id wins if it is the only one that close.
+func MatchFingerprint(text string, ids []string) (string, bool) {
+	fp := DecodeFingerprint(text)
+	if fp.ID != "" {
+		for _, id := range ids {
+			if id == fp.ID {
+				return id, true
+			}
+		}
+		return "", false
+	}
+	if fp.SpaceBits == "" {
+		return "", false
+	}
+	best, bestDist, ties := "", len(fp.SpaceBits)+1, 0
+	for _, id := range ids {
+		bits := fingerprintBits(id)
+		dist := 0
+		for i := 0; i < len(fp.SpaceBits); i++ {
+			if byte('0'+bits>>(31-i%32)&1) != fp.SpaceBits[i] {
+				dist++
+			}
+		}
+		switch {
+		case dist < bestDist:
+			best, bestDist, ties = id, dist, 0
+		case dist == bestDist:
+			ties++
+		}
+	}
+	if ties > 0 || bestDist > len(fp.SpaceBits)/8 {
+		return "", false
+	}
+	return best, true
+}
+
+// ValidateFingerprint fails unless id, a fingerprint to hide, is 1 to 32
+// bytes long.
+func ValidateFingerprint(id string) error {
+	if id == "" || len(id) > maxFPLen {
+		return fmt.Errorf("fingerprint must be 1 to %d bytes, got %d", maxFPLen, len(id))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package invisible
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+)
+
+// invisibleMagic starts every embedded payload, so extraction can tell a
+// marked image from noise in the low bits.
+var invisibleMagic = []byte("WMK1")
+
+// invisibleHeader is the magic plus a big-endian uint32 payload length; a
+// CRC-32 of the payload follows it.
+const invisibleHeader = 8
+
+// Embed stores payload, framed by invisibleMagic, its length and CRC-32,
+// in the lowest bit of the R, G and B channels of img, pixel by pixel in
+// row order. Alpha is left alone. A payload img cannot hold fails with
+// ErrPayloadTooLarge.
+func Embed(img *image.NRGBA, payload []byte) error {
+	msg := make([]byte, invisibleHeader+len(payload)+4)
+	copy(msg, invisibleMagic)
+	binary.BigEndian.PutUint32(msg[4:], uint32(len(payload)))
+	copy(msg[invisibleHeader:], payload)
+	binary.BigEndian.PutUint32(msg[invisibleHeader+len(payload):], crc32.ChecksumIEEE(payload))
+
+	if capacity := lsbCapacity(img); len(msg) > capacity {
+		return fmt.Errorf("%w: %d bytes, the image holds %d", ErrPayloadTooLarge, len(payload), max(capacity-invisibleHeader-4, 0))
+	}
+	bit := 0
+	for _, b := range msg {
+		for i := 7; i >= 0; i-- {
+			p := lsbOffset(img, bit)
+			img.Pix[p] = img.Pix[p]&^1 | b>>i&1
+			bit++
+		}
+	}
+	return nil
+}
+
+// Extract reads back a payload written by Embed. It returns ErrNoPayload
+// if img holds none, or one that was altered since.
+func Extract(img *image.NRGBA) ([]byte, error) {
+	capacity := lsbCapacity(img)
+	bit := 0
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		for i := range buf {
+			for j := 0; j < 8; j++ {
+				buf[i] = buf[i]<<1 | img.Pix[lsbOffset(img, bit)]&1
+				bit++
+			}
+		}
+		return buf
+	}
+	if capacity < invisibleHeader+4 {
+		return nil, ErrNoPayload
+	}
+	hdr := read(invisibleHeader)
+	if !bytes.Equal(hdr[:4], invisibleMagic) {
+		return nil, ErrNoPayload
+	}
+	n := int(binary.BigEndian.Uint32(hdr[4:]))
+	if n < 0 || n > capacity-invisibleHeader-4 {
+		return nil, fmt.Errorf("%w: bad length", ErrNoPayload)
+	}
+	payload := read(n)
+	if binary.BigEndian.Uint32(read(4)) != crc32.ChecksumIEEE(payload) {
+		return nil, fmt.Errorf("%w: checksum mismatch, the image was altered", ErrNoPayload)
+	}
+	return payload, nil
+}
+
+// lsbCapacity is the number of bytes Embed can store in img, framing
+// included.
+func lsbCapacity(img *image.NRGBA) int {
+	return img.Bounds().Dx() * img.Bounds().Dy() * 3 / 8
+}
+
+// lsbOffset returns the Pix index of the channel holding bit i.
+func lsbOffset(img *image.NRGBA, i int) int {
+	w := img.Bounds().Dx()
+	px := i / 3
+	return img.PixOffset(img.Bounds().Min.X+px%w, img.Bounds().Min.Y+px/w) + i%3
+}
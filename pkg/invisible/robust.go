@@ -0,0 +1,224 @@
+package invisible
+
+import (
+	"hash/fnv"
+	"image"
+	"math"
+	"math/rand"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// robustGrid is the side of the canonical luma plane the mark lives in.
+	// Embedding and detection both resample to it, so mild resizing of the
+	// marked image does not move the pattern.
+	robustGrid = 512
+	// robustThreshold is the detection score above which an image counts as
+	// marked; pure chance exceeds it about once in 30,000 tries.
+	robustThreshold = 4.0
+)
+
+// robustBand lists the mid-frequency DCT coefficients (u, v) of each 8×8
+// block that carry the mark: low enough to survive JPEG quantization, high
+// enough to stay out of sight.
+var robustBand = func() [][2]int {
+	var band [][2]int
+	for s := 3; s <= 5; s++ {
+		for u := 0; u <= s; u++ {
+			band = append(band, [2]int{u, s - u})
+		}
+	}
+	return band
+}()
+
+// dct8 holds the orthonormal 8-point DCT-II basis: dct8[k][n].
+var dct8 = func() (t [8][8]float64) {
+	for k := 0; k < 8; k++ {
+		a := math.Sqrt(2.0 / 8)
+		if k == 0 {
+			a = math.Sqrt(1.0 / 8)
+		}
+		for n := 0; n < 8; n++ {
+			t[k][n] = a * math.Cos(math.Pi*float64((2*n+1)*k)/16)
+		}
+	}
+	return t
+}()
+
+// Detection is the result of DetectRobust.
+type Detection struct {
+	// Score is the normalized correlation with the key's pattern. It is
+	// about standard-normal for unmarked images and grows with mark
+	// strength and image size.
+	Score float64
+	// PValue is the probability that an image without the mark, or marked
+	// with another key, scores at least Score: the false-positive rate of
+	// calling this image marked. Small values are strong evidence.
+	PValue float64
+	// Detected reports whether Score passed the detection threshold.
+	Detected bool
+}
+
+// DetectRobust checks img for the mark EmbedRobust adds for key. Mild
+// resizing and JPEG recompression of the marked image keep it detectable.
+func DetectRobust(img image.Image, key string) Detection {
+	src := imaging.Clone(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	grid := resampleLuma(lumaPlane(src), w, h, robustGrid, robustGrid)
+
+	signs := robustSigns(key)
+	var corr, energy float64
+	var block [64]float64
+	i := 0
+	for by := 0; by < robustGrid; by += 8 {
+		for bx := 0; bx < robustGrid; bx += 8 {
+			for y := 0; y < 8; y++ {
+				copy(block[8*y:8*y+8], grid[(by+y)*robustGrid+bx:])
+			}
+			for _, uv := range robustBand {
+				c := dctCoeff(&block, uv[0], uv[1])
+				corr += c * signs[i]
+				energy += c * c
+				i++
+			}
+		}
+	}
+	if energy == 0 {
+		return Detection{PValue: 1}
+	}
+	score := corr / math.Sqrt(energy)
+	return Detection{
+		Score:    score,
+		PValue:   0.5 * math.Erfc(score/math.Sqrt2),
+		Detected: score >= robustThreshold,
+	}
+}
+
+// EmbedRobust returns img with a mark derived from key added to mid-band
+// DCT coefficients of its luma, each moved by ±strength. The mark carries
+// no payload; DetectRobust tells whether an image carries the mark of a
+// given key.
+func EmbedRobust(img image.Image, key string, strength float64) *image.NRGBA {
+	// The pattern is built on the canonical grid, where every coefficient
+	// gets ±strength, and scaled up to the image: the DCT is linear, so the
+	// difference is all that needs resampling.
+	signs := robustSigns(key)
+	pattern := make([]float64, robustGrid*robustGrid)
+	i := 0
+	for by := 0; by < robustGrid; by += 8 {
+		for bx := 0; bx < robustGrid; bx += 8 {
+			for _, uv := range robustBand {
+				a := strength * signs[i]
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						pattern[(by+y)*robustGrid+bx+x] += a * dct8[uv[1]][y] * dct8[uv[0]][x]
+					}
+				}
+				i++
+			}
+		}
+	}
+
+	marked := imaging.Clone(img)
+	w, h := marked.Bounds().Dx(), marked.Bounds().Dy()
+	delta := resampleLuma(pattern, robustGrid, robustGrid, w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			d := delta[y*w+x]
+			p := marked.Pix[y*marked.Stride+4*x:]
+			for c := 0; c < 3; c++ {
+				p[c] = uint8(clampInt(int(math.Round(float64(p[c])+d)), 0, 255))
+			}
+		}
+	}
+	return marked
+}
+
+// robustSigns returns the ±1 pattern of key, one value per band coefficient
+// of every block of the canonical grid.
+func robustSigns(key string) []float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+	n := (robustGrid / 8) * (robustGrid / 8) * len(robustBand)
+	signs := make([]float64, n)
+	for i := range signs {
+		signs[i] = float64(rng.Intn(2)*2 - 1)
+	}
+	return signs
+}
+
+// dctCoeff returns the (u, v) coefficient of the orthonormal 2-D DCT of an
+// 8×8 block stored row by row; u is horizontal frequency.
+func dctCoeff(block *[64]float64, u, v int) float64 {
+	var sum float64
+	for y := 0; y < 8; y++ {
+		var row float64
+		for x := 0; x < 8; x++ {
+			row += block[8*y+x] * dct8[u][x]
+		}
+		sum += row * dct8[v][y]
+	}
+	return sum
+}
+
+// lumaPlane returns the Rec. 601 luma of img, row by row.
+func lumaPlane(img *image.NRGBA) []float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := img.Pix[y*img.Stride+4*x:]
+			out[y*w+x] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		}
+	}
+	return out
+}
+
+// resampleLuma resizes a sw×sh plane to dw×dh with a tent filter, widened
+// when shrinking so every source pixel contributes.
+func resampleLuma(src []float64, sw, sh, dw, dh int) []float64 {
+	tmp := make([]float64, dw*sh)
+	for y := 0; y < sh; y++ {
+		resampleLine(src[y*sw:(y+1)*sw], 1, tmp[y*dw:], 1, sw, dw)
+	}
+	out := make([]float64, dw*dh)
+	for x := 0; x < dw; x++ {
+		resampleLine(tmp[x:], dw, out[x:], dw, sh, dh)
+	}
+	return out
+}
+
+// resampleLine resizes n samples of src, stride apart, into m samples of dst.
+func resampleLine(src []float64, srcStride int, dst []float64, dstStride, n, m int) {
+	scale := float64(n) / float64(m)
+	support := math.Max(1, scale)
+	for i := 0; i < m; i++ {
+		center := (float64(i)+0.5)*scale - 0.5
+		lo := max(int(math.Ceil(center-support)), 0)
+		hi := min(int(math.Floor(center+support)), n-1)
+		var sum, weights float64
+		for j := lo; j <= hi; j++ {
+			wt := 1 - math.Abs(float64(j)-center)/support
+			if wt <= 0 {
+				continue
+			}
+			sum += src[j*srcStride] * wt
+			weights += wt
+		}
+		if weights > 0 {
+			dst[i*dstStride] = sum / weights
+		}
+	}
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
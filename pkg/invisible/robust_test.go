@@ -0,0 +1,75 @@
+package invisible
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// robustPhoto returns an 800×600 image with smooth gradients and fine
+// random texture, which the robust mark needs to hide in.
+func robustPhoto() *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 800, 600))
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 800; x++ {
+			n := rng.Intn(41) - 20
+			img.SetNRGBA(x, y, color.NRGBA{
+				uint8(clampInt(x*200/800+30+n, 0, 255)),
+				uint8(clampInt(y*200/600+30+n, 0, 255)),
+				uint8(clampInt(128+int(60*math.Sin(float64(x+y)/15))+n, 0, 255)),
+				255,
+			})
+		}
+	}
+	return img
+}
+
+func TestDetectRobust(t *testing.T) {
+	photo := robustPhoto()
+	marked := EmbedRobust(photo, "alice", 4)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, marked, &jpeg.Options{Quality: 75}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		img    image.Image
+		key    string
+		detect bool
+	}{
+		{"marked", marked, "alice", true},
+		{"JPEG q75", recompressed, "alice", true},
+		{"wrong key", marked, "bob", false},
+		{"wrong key after JPEG", recompressed, "bob", false},
+		{"unmarked", photo, "alice", false},
+	} {
+		d := DetectRobust(tc.img, tc.key)
+		t.Logf("%s: score %.2f, p %.3g", tc.name, d.Score, d.PValue)
+		if d.Detected != tc.detect {
+			t.Errorf("%s: detected %t, want %t", tc.name, d.Detected, tc.detect)
+		}
+		if tc.detect && d.PValue > 1e-4 {
+			t.Errorf("%s: p-value %g, want below 1e-4", tc.name, d.PValue)
+		}
+		if !tc.detect && d.PValue < 0.001 {
+			t.Errorf("%s: p-value %g, want not significant", tc.name, d.PValue)
+		}
+	}
+}
+
+func TestDetectRobustFlat(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if d := DetectRobust(img, "alice"); d.Detected || d.PValue != 1 {
+		t.Errorf("flat image: got %+v, want not detected with p-value 1", d)
+	}
+}
@@ -0,0 +1,7 @@
+// Package pdf holds just enough of a PDF reader and writer to stamp text
+// on pages: Parse reads the cross-reference data (tables and streams,
+// object streams included), Pages walks the page tree, and Update appends
+// changed objects as an incremental update, leaving the original bytes
+// untouched. Font sets text in an embedded TrueType font cut down to the
+// glyphs used.
+package pdf
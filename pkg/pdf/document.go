@@ -0,0 +1,658 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"watermark/pkg/codec"
+)
+
+// PDF object types. Integers are int, reals float64, booleans bool and null
+// nil.
+type (
+	Name   string
+	String []byte
+	Array  []interface{}
+	Dict   map[Name]interface{}
+	Ref    struct{ Num, Gen int }
+	Stream struct {
+		Dict Dict
+		Data []byte // as stored, still encoded
+	}
+)
+
+// xrefEntry locates an object: at a byte offset, or as the index-th object
+// of object stream stream.
+type xrefEntry struct {
+	offset int
+	gen    int
+	stream int
+	index  int
+}
+
+// Document is a parsed PDF file. Objects are read from its bytes as they
+// are resolved.
+type Document struct {
+	data       []byte
+	xref       map[int]xrefEntry
+	trailer    Dict
+	startxref  int
+	xrefStream bool
+	objects    map[int]interface{}
+}
+
+// Parse reads the cross-reference data of the PDF file data. Encrypted
+// documents fail with codec.ErrUnsupportedFormat.
+func Parse(data []byte) (*Document, error) {
+	i := bytes.LastIndex(data, []byte("startxref"))
+	if i < 0 {
+		return nil, errors.New("no startxref")
+	}
+	p := &parser{data: data, pos: i + len("startxref")}
+	v, err := p.object()
+	start, ok := v.(int)
+	if err != nil || !ok {
+		return nil, errors.New("bad startxref")
+	}
+	doc := &Document{data: data, xref: map[int]xrefEntry{}, startxref: start, objects: map[int]interface{}{}}
+	seen := map[int]bool{}
+	for off, first := start, true; ; first = false {
+		if seen[off] || off < 0 || off >= len(data) {
+			return nil, fmt.Errorf("bad cross-reference offset %d", off)
+		}
+		seen[off] = true
+		trailer, isStream, err := doc.readXref(off)
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			doc.trailer, doc.xrefStream = trailer, isStream
+		}
+		if hybrid, ok := trailer["XRefStm"].(int); ok && !seen[hybrid] {
+			seen[hybrid] = true
+			if _, _, err := doc.readXref(hybrid); err != nil {
+				return nil, err
+			}
+		}
+		prev, ok := trailer["Prev"].(int)
+		if !ok {
+			break
+		}
+		off = prev
+	}
+	if _, ok := doc.trailer["Encrypt"]; ok {
+		return nil, fmt.Errorf("%w: encrypted PDF", codec.ErrUnsupportedFormat)
+	}
+	return doc, nil
+}
+
+// readXref reads one cross-reference section. Entries already known, from
+// a newer section, are kept.
+func (d *Document) readXref(off int) (Dict, bool, error) {
+	if bytes.HasPrefix(d.data[off:], []byte("xref")) {
+		return d.readXrefTable(off + 4)
+	}
+	p := &parser{data: d.data, pos: off}
+	_, v, err := p.indirect(d)
+	if err != nil {
+		return nil, false, fmt.Errorf("cross-reference stream: %w", err)
+	}
+	s, ok := v.(*Stream)
+	if !ok || s.Dict["Type"] != Name("XRef") {
+		return nil, false, errors.New("cross-reference stream expected")
+	}
+	raw, err := d.decodeStream(s)
+	if err != nil {
+		return nil, false, fmt.Errorf("cross-reference stream: %w", err)
+	}
+	var w [3]int
+	wa, _ := s.Dict["W"].(Array)
+	if len(wa) != 3 {
+		return nil, false, errors.New("cross-reference stream without /W")
+	}
+	for i := range w {
+		w[i], _ = wa[i].(int)
+	}
+	index, _ := s.Dict["Index"].(Array)
+	if index == nil {
+		size, _ := s.Dict["Size"].(int)
+		index = Array{0, size}
+	}
+	field := func(b []byte, def int) int {
+		if len(b) == 0 {
+			return def
+		}
+		v := 0
+		for _, c := range b {
+			v = v<<8 | int(c)
+		}
+		return v
+	}
+	rec := w[0] + w[1] + w[2]
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		first, _ := index[i].(int)
+		count, _ := index[i+1].(int)
+		for n := first; n < first+count; n++ {
+			if pos+rec > len(raw) {
+				return nil, false, errors.New("truncated cross-reference stream")
+			}
+			e := raw[pos : pos+rec]
+			pos += rec
+			typ := field(e[:w[0]], 1)
+			a, b := field(e[w[0]:w[0]+w[1]], 0), field(e[w[0]+w[1]:], 0)
+			if _, known := d.xref[n]; known {
+				continue
+			}
+			switch typ {
+			case 0:
+				d.xref[n] = xrefEntry{offset: -1}
+			case 1:
+				d.xref[n] = xrefEntry{offset: a, gen: b}
+			case 2:
+				d.xref[n] = xrefEntry{offset: -1, stream: a, index: b}
+			}
+		}
+	}
+	return s.Dict, true, nil
+}
+
+func (d *Document) readXrefTable(pos int) (Dict, bool, error) {
+	p := &parser{data: d.data, pos: pos}
+	for {
+		p.skipSpace()
+		if bytes.HasPrefix(d.data[p.pos:], []byte("trailer")) {
+			p.pos += len("trailer")
+			v, err := p.object()
+			if err != nil {
+				return nil, false, fmt.Errorf("trailer: %w", err)
+			}
+			t, ok := v.(Dict)
+			if !ok {
+				return nil, false, errors.New("trailer is not a dictionary")
+			}
+			return t, false, nil
+		}
+		first, err1 := p.object()
+		count, err2 := p.object()
+		f, ok1 := first.(int)
+		c, ok2 := count.(int)
+		if err1 != nil || err2 != nil || !ok1 || !ok2 {
+			return nil, false, errors.New("bad cross-reference table")
+		}
+		for n := f; n < f+c; n++ {
+			off, err1 := p.object()
+			gen, err2 := p.object()
+			p.skipSpace()
+			if err1 != nil || err2 != nil || p.pos >= len(d.data) {
+				return nil, false, errors.New("truncated cross-reference table")
+			}
+			kind := d.data[p.pos]
+			p.pos++
+			o, _ := off.(int)
+			g, _ := gen.(int)
+			if _, known := d.xref[n]; known {
+				continue
+			}
+			if kind == 'n' {
+				d.xref[n] = xrefEntry{offset: o, gen: g}
+			} else {
+				d.xref[n] = xrefEntry{offset: -1, gen: g}
+			}
+		}
+	}
+}
+
+// size is the trailer /Size: one more than the highest object number.
+func (d *Document) size() int {
+	n, _ := d.trailer["Size"].(int)
+	for num := range d.xref {
+		n = max(n, num+1)
+	}
+	return n
+}
+
+// object returns object num, loading it on first use.
+func (d *Document) object(num int) (interface{}, error) {
+	if v, ok := d.objects[num]; ok {
+		return v, nil
+	}
+	e, ok := d.xref[num]
+	if !ok {
+		return nil, nil
+	}
+	// Mark as loading, so reference loops end in null.
+	d.objects[num] = nil
+	var v interface{}
+	var err error
+	switch {
+	case e.offset >= 0:
+		p := &parser{data: d.data, pos: e.offset}
+		_, v, err = p.indirect(d)
+	case e.stream > 0:
+		v, err = d.streamObject(e.stream, e.index)
+	}
+	if err != nil {
+		delete(d.objects, num)
+		return nil, fmt.Errorf("object %d: %w", num, err)
+	}
+	d.objects[num] = v
+	return v, nil
+}
+
+// streamObject reads the index-th object of object stream num.
+func (d *Document) streamObject(num, index int) (interface{}, error) {
+	v, err := d.object(num)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := v.(*Stream)
+	if !ok {
+		return nil, fmt.Errorf("object stream %d missing", num)
+	}
+	raw, err := d.decodeStream(s)
+	if err != nil {
+		return nil, err
+	}
+	n, _ := s.Dict["N"].(int)
+	first, _ := s.Dict["First"].(int)
+	if index >= n || first > len(raw) {
+		return nil, fmt.Errorf("object stream %d: bad index %d", num, index)
+	}
+	p := &parser{data: raw[:first]}
+	off := 0
+	for i := 0; i <= index; i++ {
+		p.object() // object number
+		o, err := p.object()
+		if err != nil {
+			return nil, fmt.Errorf("object stream %d: %w", num, err)
+		}
+		off, _ = o.(int)
+	}
+	if first+off > len(raw) {
+		return nil, fmt.Errorf("object stream %d: bad offset", num)
+	}
+	return (&parser{data: raw, pos: first + off}).object()
+}
+
+// Resolve follows v if it is a reference.
+func (d *Document) Resolve(v interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		r, ok := v.(Ref)
+		if !ok {
+			return v
+		}
+		v, _ = d.object(r.Num)
+	}
+	return nil
+}
+
+// decodeStream returns the data of s with its filters undone. Only
+// FlateDecode, with or without PNG predictors, is supported, which covers
+// cross-reference and object streams.
+func (d *Document) decodeStream(s *Stream) ([]byte, error) {
+	filters := d.Resolve(s.Dict["Filter"])
+	params := d.Resolve(s.Dict["DecodeParms"])
+	if f, ok := filters.(Name); ok {
+		filters, params = Array{f}, Array{params}
+	}
+	fa, _ := filters.(Array)
+	pa, _ := params.(Array)
+	data := s.Data
+	for i, f := range fa {
+		if d.Resolve(f) != Name("FlateDecode") {
+			return nil, fmt.Errorf("%w: PDF stream filter %v", codec.ErrUnsupportedFormat, f)
+		}
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		// Tolerate a missing checksum or trailing garbage, as readers do.
+		out, err := io.ReadAll(r)
+		if err != nil && len(out) == 0 {
+			return nil, err
+		}
+		data = out
+		var parm Dict
+		if i < len(pa) {
+			parm, _ = d.Resolve(pa[i]).(Dict)
+		}
+		if pred, _ := parm["Predictor"].(int); pred >= 10 {
+			cols, _ := parm["Columns"].(int)
+			if data, err = unpredictPNG(data, max(cols, 1)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
+
+// unpredictPNG undoes PNG row prediction on rows of cols bytes.
+func unpredictPNG(data []byte, cols int) ([]byte, error) {
+	var out []byte
+	prev := make([]byte, cols)
+	for len(data) > 0 {
+		if len(data) < cols+1 {
+			return nil, errors.New("truncated predicted row")
+		}
+		typ, row := data[0], append([]byte(nil), data[1:cols+1]...)
+		data = data[cols+1:]
+		for i := range row {
+			var left, upLeft byte
+			if i > 0 {
+				left, upLeft = row[i-1], prev[i-1]
+			}
+			up := prev[i]
+			switch typ {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				p := int(left) + int(up) - int(upLeft)
+				pa, pb, pc := absInt(p-int(left)), absInt(p-int(up)), absInt(p-int(upLeft))
+				switch {
+				case pa <= pb && pa <= pc:
+					row[i] += left
+				case pb <= pc:
+					row[i] += up
+				default:
+					row[i] += upLeft
+				}
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// parser reads PDF objects from data.
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isDelim(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '%' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// token reads a bare keyword or number.
+func (p *parser) token() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelim(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// indirect reads "num gen obj ... endobj", including a stream body.
+func (p *parser) indirect(d *Document) (Ref, interface{}, error) {
+	num, err1 := strconv.Atoi(p.token())
+	gen, err2 := strconv.Atoi(p.token())
+	if err1 != nil || err2 != nil || p.token() != "obj" {
+		return Ref{}, nil, errors.New("indirect object expected")
+	}
+	v, err := p.object()
+	if err != nil {
+		return Ref{}, nil, err
+	}
+	dict, isDict := v.(Dict)
+	save := p.pos
+	if isDict && p.token() == "stream" {
+		if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+			p.pos++
+		}
+		if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+			p.pos++
+		}
+		start := p.pos
+		end := -1
+		var length interface{} = dict["Length"]
+		if r, ok := length.(Ref); ok && d != nil && r.Num != num {
+			length = d.Resolve(r)
+		}
+		if n, ok := length.(int); ok && n >= 0 && start+n <= len(p.data) {
+			rest := bytes.TrimLeft(p.data[start+n:min(start+n+32, len(p.data))], " \r\n\t")
+			if bytes.HasPrefix(rest, []byte("endstream")) {
+				end = start + n
+			}
+		}
+		if end < 0 {
+			i := bytes.Index(p.data[start:], []byte("endstream"))
+			if i < 0 {
+				return Ref{}, nil, errors.New("unterminated stream")
+			}
+			end = start + i
+			for end > start && (p.data[end-1] == '\n' || p.data[end-1] == '\r') {
+				end--
+			}
+		}
+		return Ref{num, gen}, &Stream{Dict: dict, Data: p.data[start:end]}, nil
+	}
+	p.pos = save
+	return Ref{num, gen}, v, nil
+}
+
+// object reads one direct object.
+func (p *parser) object() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelim(p.data[p.pos]) {
+			p.pos++
+		}
+		return Name(unescapeName(p.data[start:p.pos])), nil
+	case c == '(':
+		return p.literal()
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		p.pos += 2
+		dict := Dict{}
+		for {
+			p.skipSpace()
+			if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+				p.pos += 2
+				return dict, nil
+			}
+			k, err := p.object()
+			if err != nil {
+				return nil, err
+			}
+			name, ok := k.(Name)
+			if !ok {
+				return nil, errors.New("dictionary key is not a name")
+			}
+			v, err := p.object()
+			if err != nil {
+				return nil, err
+			}
+			dict[name] = v
+		}
+	case c == '<':
+		p.pos++
+		end := bytes.IndexByte(p.data[p.pos:], '>')
+		if end < 0 {
+			return nil, errors.New("unterminated hex string")
+		}
+		var digits []byte
+		for _, h := range p.data[p.pos : p.pos+end] {
+			if !isSpace(h) {
+				digits = append(digits, h)
+			}
+		}
+		p.pos += end + 1
+		if len(digits)%2 == 1 {
+			digits = append(digits, '0')
+		}
+		s := make([]byte, len(digits)/2)
+		for i := range s {
+			v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+			if err != nil {
+				return nil, errors.New("bad hex string")
+			}
+			s[i] = byte(v)
+		}
+		return String(s), nil
+	case c == '[':
+		p.pos++
+		var arr Array
+		for {
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == ']' {
+				p.pos++
+				return arr, nil
+			}
+			v, err := p.object()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	}
+
+	tok := p.token()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected %q", p.data[p.pos])
+	case "true", "false":
+		return tok == "true", nil
+	case "null":
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(tok); err == nil {
+		// "num gen R" is a reference.
+		save := p.pos
+		if g, err := strconv.Atoi(p.token()); err == nil && p.token() == "R" {
+			return Ref{n, g}, nil
+		}
+		p.pos = save
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", tok)
+}
+
+func (p *parser) literal() (interface{}, error) {
+	p.pos++
+	var s []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return String(s), nil
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				break
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return nil, errors.New("unterminated string")
+}
+
+func unescapeName(b []byte) string {
+	if bytes.IndexByte(b, '#') < 0 {
+		return string(b)
+	}
+	var out []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] == '#' && i+2 < len(b) {
+			if v, err := strconv.ParseUint(string(b[i+1:i+3]), 16, 8); err == nil {
+				out = append(out, byte(v))
+				i += 2
+				continue
+			}
+		}
+		out = append(out, b[i])
+	}
+	return string(out)
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,291 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+
+	"watermark/pkg/render"
+)
+
+// Font is a TrueType font stamps are set in, embedded as a Type 0 font
+// whose character codes are glyph IDs. It records the glyphs used so the
+// embedded font can be cut down to them and their widths and text mapping
+// written at the end.
+type Font struct {
+	data    []byte
+	fnt     *sfnt.Font
+	buf     sfnt.Buffer
+	metrics font.Metrics
+	used    map[sfnt.GlyphIndex]rune
+	widths  map[sfnt.GlyphIndex]float64
+}
+
+// emSize is the ppem glyphs are measured at: PDF glyph space has 1000 units
+// per em.
+var emSize = fixed.I(1000)
+
+// NewFont parses the font file data. CFF-flavored OpenType fonts are not
+// supported.
+func NewFont(data []byte) (*Font, error) {
+	if bytes.HasPrefix(data, []byte("OTTO")) {
+		return nil, errors.New("PDF watermarks need a font with TrueType outlines, not CFF")
+	}
+	fnt, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	f := &Font{data: data, fnt: fnt, used: map[sfnt.GlyphIndex]rune{}, widths: map[sfnt.GlyphIndex]float64{}}
+	if f.metrics, err = fnt.Metrics(&f.buf, emSize, font.HintingNone); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// textLine is a line of text as TJ operands, with its width in glyph space.
+type textLine struct {
+	runs  []textRun
+	width float64
+}
+
+// textRun is a TJ operand set at one size; small runs are synthesized small
+// capitals, set at render.SmallCapsScale times the size.
+type textRun struct {
+	tj    string
+	small bool
+}
+
+// layout sets text in lines, with the small caps of sc if it is not nil.
+// Runes the font lacks are left out.
+func (f *Font) layout(text string, sc *render.SmallCaps) ([]textLine, float64, error) {
+	var lines []textLine
+	maxW := 0.0
+	for _, line := range strings.Split(text, "\n") {
+		var l textLine
+		var tj strings.Builder
+		prev, hasPrev, open, small := sfnt.GlyphIndex(0), false, false, false
+		endRun := func() {
+			if open {
+				tj.WriteByte('>')
+				open = false
+			}
+			if tj.Len() > 0 {
+				l.runs = append(l.runs, textRun{tj: "[" + tj.String() + "]", small: small})
+				tj.Reset()
+			}
+		}
+		for _, r := range line {
+			idx, set, scale := sc.Glyph(r)
+			if idx == 0 {
+				var err error
+				if idx, err = f.fnt.GlyphIndex(&f.buf, set); err != nil {
+					return nil, 0, err
+				}
+			}
+			if idx == 0 {
+				continue
+			}
+			if isSmall := scale != 1; isSmall != small {
+				endRun()
+				small, hasPrev = isSmall, false
+			}
+			if hasPrev {
+				if k, err := f.fnt.Kern(&f.buf, prev, idx, emSize, font.HintingNone); err == nil && k != 0 {
+					if open {
+						tj.WriteByte('>')
+						open = false
+					}
+					fmt.Fprintf(&tj, " %s ", Nums(-float64(k)/64))
+					l.width += float64(k) / 64 * scale
+				}
+			}
+			adv, ok := f.widths[idx]
+			if !ok {
+				a, err := f.fnt.GlyphAdvance(&f.buf, idx, emSize, font.HintingNone)
+				if err != nil {
+					return nil, 0, err
+				}
+				adv = float64(a) / 64
+				f.widths[idx], f.used[idx] = adv, set
+			}
+			if !open {
+				tj.WriteByte('<')
+				open = true
+			}
+			fmt.Fprintf(&tj, "%04X", int(idx))
+			l.width += adv * scale
+			prev, hasPrev = idx, true
+		}
+		endRun()
+		lines = append(lines, l)
+		maxW = math.Max(maxW, l.width)
+	}
+	return lines, maxW, nil
+}
+
+// TextStyle is how Block sets text.
+type TextStyle struct {
+	Transform  render.TextTransform
+	LineHeight float64 // relative to the font's line height; 0 means 1
+	Align      render.Align
+	// Outline strokes the glyphs instead of filling them.
+	Outline bool
+}
+
+// Block lays out text at size points in the font, selected as resource key,
+// and returns the width and height of its box and a function writing it
+// centered on the current origin.
+func (f *Font) Block(key Name, text string, size float64, st TextStyle) (float64, float64, func(*bytes.Buffer), error) {
+	text = render.CaseText(text, st.Transform)
+	var sc *render.SmallCaps
+	if st.Transform == render.TextTransformSmallCaps {
+		var err error
+		if sc, err = render.NewSmallCaps(f.fnt, &f.buf, text); err != nil {
+			return 0, 0, nil, err
+		}
+		text = sc.Expand(text)
+	}
+	lines, maxW, err := f.layout(text, sc)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if maxW <= 0 {
+		return 0, 0, nil, fmt.Errorf("%w: text bounds are empty", render.ErrEmptyMark)
+	}
+	scale := size / 1000
+	ascent := float64(f.metrics.Ascent) / 64
+	descent := float64(f.metrics.Descent) / 64
+	step := float64(render.LineAdvance(f.metrics, st.LineHeight)) / 64
+	w := maxW * scale
+	h := (ascent + descent + step*float64(len(lines)-1)) * scale
+	draw := func(buf *bytes.Buffer) {
+		buf.WriteString("BT\n")
+		fmt.Fprintf(buf, "/%s %s Tf\n", key, Nums(size))
+		if st.Outline {
+			buf.WriteString("2 Tr\n")
+		}
+		small := false
+		for i, l := range lines {
+			dx := float64(st.Align.Offset(fixed.Int26_6(maxW*64), fixed.Int26_6(l.width*64))) / 64
+			x := -w/2 + dx*scale
+			y := h/2 - (ascent+step*float64(i))*scale
+			fmt.Fprintf(buf, "1 0 0 1 %s Tm\n", Nums(x, y))
+			for _, run := range l.runs {
+				if run.small != small {
+					runSize := size
+					if run.small {
+						runSize *= render.SmallCapsScale
+					}
+					fmt.Fprintf(buf, "/%s %s Tf\n", key, Nums(runSize))
+					small = run.small
+				}
+				fmt.Fprintf(buf, "%s TJ\n", run.tj)
+			}
+		}
+		buf.WriteString("ET\n")
+	}
+	return w, h, draw, nil
+}
+
+// WriteObjects stores the font objects in u, with the Type 0 font at ref,
+// embedding the font cut down to the glyphs Block has set.
+func (f *Font) WriteObjects(u *Update, ref Ref) {
+	name := "Watermark"
+	if n, err := f.fnt.Name(&f.buf, sfnt.NameIDPostScript); err == nil {
+		n = strings.Map(func(r rune) rune {
+			if r > ' ' && r < 127 && !isDelim(byte(r)) {
+				return r
+			}
+			return -1
+		}, n)
+		if n != "" {
+			name = n
+		}
+	}
+
+	gids := make([]int, 0, len(f.used))
+	for g := range f.used {
+		gids = append(gids, int(g))
+	}
+	sort.Ints(gids)
+
+	// A font that cannot be subset, such as one with broken tables that
+	// still parses, is embedded whole.
+	data := f.data
+	if sub, err := subsetTrueType(f.data, f.used); err == nil {
+		data, name = sub, subsetTag(gids)+"+"+name
+	}
+	file := Flate(data)
+	file.Dict["Length1"] = len(data)
+	bbox := Array{0, 0, 1000, 1000}
+	if b, err := f.fnt.Bounds(&f.buf, emSize, font.HintingNone); err == nil {
+		bbox = Array{b.Min.X.Round(), -b.Max.Y.Round(), b.Max.X.Round(), -b.Min.Y.Round()}
+	}
+	ascent, descent := f.metrics.Ascent.Round(), -f.metrics.Descent.Round()
+	descriptor := u.Add(Dict{
+		"Type":        Name("FontDescriptor"),
+		"FontName":    Name(name),
+		"Flags":       4,
+		"FontBBox":    bbox,
+		"ItalicAngle": 0,
+		"Ascent":      ascent,
+		"Descent":     descent,
+		"CapHeight":   ascent,
+		"StemV":       80,
+		"FontFile2":   u.Add(file),
+	})
+
+	var widths Array
+	for _, g := range gids {
+		widths = append(widths, g, Array{math.Round(f.widths[sfnt.GlyphIndex(g)])})
+	}
+	cid := u.Add(Dict{
+		"Type":     Name("Font"),
+		"Subtype":  Name("CIDFontType2"),
+		"BaseFont": Name(name),
+		"CIDSystemInfo": Dict{
+			"Registry":   String("Adobe"),
+			"Ordering":   String("Identity"),
+			"Supplement": 0,
+		},
+		"FontDescriptor": descriptor,
+		"W":              widths,
+		"CIDToGIDMap":    Name("Identity"),
+	})
+
+	var cmap bytes.Buffer
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for i := 0; i < len(gids); i += 100 {
+		chunk := gids[i:min(i+100, len(gids))]
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", len(chunk))
+		for _, g := range chunk {
+			fmt.Fprintf(&cmap, "<%04X> <", g)
+			for _, c := range utf16.Encode([]rune{f.used[sfnt.GlyphIndex(g)]}) {
+				fmt.Fprintf(&cmap, "%04X", c)
+			}
+			cmap.WriteString(">\n")
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+
+	u.Replace(ref, Dict{
+		"Type":            Name("Font"),
+		"Subtype":         Name("Type0"),
+		"BaseFont":        Name(name),
+		"Encoding":        Name("Identity-H"),
+		"DescendantFonts": Array{cid},
+		"ToUnicode":       u.Add(Flate(cmap.Bytes())),
+	})
+}
@@ -0,0 +1,163 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Page is a leaf of the page tree with its inherited attributes resolved.
+type Page struct {
+	Ref       Ref
+	Dict      Dict
+	Resources Dict
+	// Box is the crop box, else the media box, as lower-left and
+	// upper-right corners.
+	Box [4]float64
+	// Rotate is the clockwise turn of the page for display: 0, 90, 180 or
+	// 270.
+	Rotate int
+}
+
+// Pages walks the page tree in document order.
+func (d *Document) Pages() ([]Page, error) {
+	root, _ := d.Resolve(d.trailer["Root"]).(Dict)
+	if root == nil {
+		return nil, errors.New("no document catalog")
+	}
+	var pages []Page
+	seen := map[int]bool{}
+	var walk func(v interface{}, inherited Dict) error
+	walk = func(v interface{}, inherited Dict) error {
+		ref, ok := v.(Ref)
+		if !ok || seen[ref.Num] {
+			return errors.New("malformed page tree")
+		}
+		seen[ref.Num] = true
+		node, _ := d.Resolve(ref).(Dict)
+		if node == nil {
+			return errors.New("malformed page tree")
+		}
+		attrs := Dict{}
+		for k, v := range inherited {
+			attrs[k] = v
+		}
+		for _, k := range []Name{"Resources", "MediaBox", "CropBox", "Rotate"} {
+			if v, ok := node[k]; ok {
+				attrs[k] = v
+			}
+		}
+		if kids, ok := d.Resolve(node["Kids"]).(Array); ok && node["Type"] != Name("Page") {
+			for _, kid := range kids {
+				if err := walk(kid, attrs); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		page := Page{Ref: ref, Dict: node}
+		page.Resources, _ = d.Resolve(attrs["Resources"]).(Dict)
+		box, ok := d.rect(attrs["CropBox"])
+		if !ok {
+			if box, ok = d.rect(attrs["MediaBox"]); !ok {
+				box = [4]float64{0, 0, 612, 792}
+			}
+		}
+		page.Box = box
+		rot, _ := d.Resolve(attrs["Rotate"]).(int)
+		page.Rotate = ((rot % 360) + 360) % 360
+		pages = append(pages, page)
+		return nil
+	}
+	if err := walk(root["Pages"], nil); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// rect reads a rectangle array, normalized so the first corner is the
+// lower left.
+func (d *Document) rect(v interface{}) ([4]float64, bool) {
+	a, ok := d.Resolve(v).(Array)
+	if !ok || len(a) != 4 {
+		return [4]float64{}, false
+	}
+	var r [4]float64
+	for i, x := range a {
+		f, ok := number(d.Resolve(x))
+		if !ok {
+			return [4]float64{}, false
+		}
+		r[i] = f
+	}
+	if r[0] > r[2] {
+		r[0], r[2] = r[2], r[0]
+	}
+	if r[1] > r[3] {
+		r[1], r[3] = r[3], r[1]
+	}
+	return r, r[2] > r[0] && r[3] > r[1]
+}
+
+// Matrix maps the page as displayed, with the origin at the lower left of
+// its visible box, to its user space, as cm operands.
+func (p Page) Matrix() string {
+	x0, y0 := p.Box[0], p.Box[1]
+	w, h := p.Box[2]-x0, p.Box[3]-y0
+	m := [6]float64{1, 0, 0, 1, x0, y0}
+	switch p.Rotate {
+	case 90:
+		m = [6]float64{0, 1, -1, 0, x0 + w, y0}
+	case 180:
+		m = [6]float64{-1, 0, 0, -1, x0 + w, y0 + h}
+	case 270:
+		m = [6]float64{0, -1, 1, 0, x0, y0 + h}
+	}
+	return Nums(m[:]...)
+}
+
+// PageRange is an inclusive range of 1-based page numbers; last 0 means
+// to the end.
+type PageRange struct{ First, Last int }
+
+// ParsePageRanges parses a page list like "1-3,5,8-".
+func ParsePageRanges(spec string) ([]PageRange, error) {
+	var ranges []PageRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(strings.TrimSpace(lo))
+		r := PageRange{First: first, Last: first}
+		if err == nil && isRange {
+			r.Last = 0
+			if hi = strings.TrimSpace(hi); hi != "" {
+				r.Last, err = strconv.Atoi(hi)
+			}
+		}
+		if err != nil || r.First < 1 || (r.Last != 0 && r.Last < r.First) {
+			return nil, fmt.Errorf("invalid page range %q", part)
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("invalid page list %q", spec)
+	}
+	return ranges, nil
+}
+
+// InPageRanges reports whether page is in ranges; no ranges means all pages.
+func InPageRanges(ranges []PageRange, page int) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if page >= r.First && (r.Last == 0 || page <= r.Last) {
+			return true
+		}
+	}
+	return false
+}
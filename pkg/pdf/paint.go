@@ -0,0 +1,41 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Paint is the paint of a stamp: RGB fill and stroke colors with components
+// from 0 to 1, their opacities, which go in an ExtGState, and whether text
+// is also stroked, and how wide.
+type Paint struct {
+	Fill, Stroke           [3]float64
+	FillAlpha, StrokeAlpha float64
+	Outline                bool
+	OutlineWidth           float64
+}
+
+// WriteColors writes the operators setting the fill color and, with an
+// outline, the stroke color and line width.
+func (s *Paint) WriteColors(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "%s rg\n", Nums(s.Fill[:]...))
+	if s.Outline {
+		fmt.Fprintf(buf, "%s RG\n%s w\n", Nums(s.Stroke[:]...), Nums(s.OutlineWidth))
+	}
+}
+
+// Nums formats numbers for a content stream, to 1/1000 of a point.
+func Nums(v ...float64) string {
+	s := make([]string, len(v))
+	for i, f := range v {
+		f = math.Round(f*1000) / 1000
+		if f == 0 {
+			f = 0 // no "-0"
+		}
+		s[i] = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strings.Join(s, " ")
+}
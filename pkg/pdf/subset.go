@@ -0,0 +1,278 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"sort"
+
+	"golang.org/x/image/font/sfnt"
+
+	"watermark/pkg/render"
+)
+
+// subsetTables are the tables a TrueType font embedded in a PDF needs. The
+// others, such as name and the layout tables, are left out: PDF text is
+// shown by glyph index and measured by the W array. A cmap of the glyphs
+// kept and a post table without glyph names are added for tools that read
+// the font on its own.
+var subsetTables = []string{"cvt ", "fpgm", "glyf", "head", "hhea", "hmtx", "loca", "maxp", "prep"}
+
+// subsetTrueType returns the TrueType font data with the outlines of only
+// the glyphs in keep, the glyphs their composites are built from and glyph
+// 0. Glyph indices are unchanged, as the stamps use them as character
+// codes; the loca and hmtx entries of the glyphs left out are emptied,
+// which compresses to almost nothing even for CJK fonts.
+func subsetTrueType(data []byte, keep map[sfnt.GlyphIndex]rune) ([]byte, error) {
+	be := binary.BigEndian
+	head, maxp, hhea := render.FontTable(data, "head"), render.FontTable(data, "maxp"), render.FontTable(data, "hhea")
+	loca, glyf, hmtx := render.FontTable(data, "loca"), render.FontTable(data, "glyf"), render.FontTable(data, "hmtx")
+	if len(head) < 54 || len(maxp) < 6 || len(hhea) < 36 || loca == nil || glyf == nil {
+		return nil, errors.New("font has no TrueType outlines to subset")
+	}
+	numGlyphs := int(be.Uint16(maxp[4:]))
+	long := be.Uint16(head[50:]) != 0
+	entry := 2
+	if long {
+		entry = 4
+	}
+	if len(loca) < (numGlyphs+1)*entry {
+		return nil, errors.New("loca table too short")
+	}
+	offset := func(g int) int {
+		if long {
+			return int(be.Uint32(loca[4*g:]))
+		}
+		return 2 * int(be.Uint16(loca[2*g:]))
+	}
+	glyph := func(g int) []byte {
+		start, end := offset(g), offset(g+1)
+		if start >= end || end > len(glyf) {
+			return nil
+		}
+		return glyf[start:end]
+	}
+
+	used := make([]bool, numGlyphs)
+	var stack []int
+	mark := func(g int) {
+		if g < numGlyphs && !used[g] {
+			used[g] = true
+			stack = append(stack, g)
+		}
+	}
+	mark(0)
+	for g := range keep {
+		mark(int(g))
+	}
+	for len(stack) > 0 {
+		g := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, c := range glyphComponents(glyph(g)) {
+			mark(c)
+		}
+	}
+
+	// Glyphs are padded to keep short loca offsets even.
+	var newGlyf []byte
+	newLoca := make([]byte, (numGlyphs+1)*entry)
+	for g := 0; g <= numGlyphs; g++ {
+		if long {
+			be.PutUint32(newLoca[4*g:], uint32(len(newGlyf)))
+		} else {
+			be.PutUint16(newLoca[2*g:], uint16(len(newGlyf)/2))
+		}
+		if g < numGlyphs && used[g] {
+			newGlyf = append(newGlyf, glyph(g)...)
+			for len(newGlyf)%entry != 0 {
+				newGlyf = append(newGlyf, 0)
+			}
+		}
+	}
+
+	newHmtx := append([]byte(nil), hmtx...)
+	numMetrics := int(be.Uint16(hhea[34:]))
+	for g := 0; g < numGlyphs; g++ {
+		at, n := 4*g, 4
+		if g >= numMetrics {
+			at, n = 4*numMetrics+2*(g-numMetrics), 2
+		}
+		if !used[g] && at+n <= len(newHmtx) {
+			clear(newHmtx[at : at+n])
+		}
+	}
+
+	newHead := append([]byte(nil), head...)
+	be.PutUint32(newHead[8:], 0) // checkSumAdjustment, set below
+	post := make([]byte, 32)
+	copy(post, render.FontTable(data, "post"))
+	be.PutUint32(post, 0x00030000) // version 3: no glyph names
+	tables := map[string][]byte{"head": newHead, "loca": newLoca, "glyf": newGlyf, "hmtx": newHmtx, "cmap": subsetCmap(keep), "post": post}
+	for _, tag := range subsetTables {
+		if _, ok := tables[tag]; !ok {
+			if t := render.FontTable(data, tag); t != nil {
+				tables[tag] = t
+			}
+		}
+	}
+	out, headAt := writeSFNT(tables)
+	be.PutUint32(out[headAt+8:], 0xB1B0AFBA-sfntChecksum(out))
+	return out, nil
+}
+
+// subsetCmap returns a cmap table mapping the runes of keep in the Basic
+// Multilingual Plane to their glyphs, as a format 4 subtable with one
+// segment per rune.
+func subsetCmap(keep map[sfnt.GlyphIndex]rune) []byte {
+	be := binary.BigEndian
+	type pair struct {
+		r uint16
+		g sfnt.GlyphIndex
+	}
+	var pairs []pair
+	for g, r := range keep {
+		if r > 0 && r < 0xFFFF {
+			pairs = append(pairs, pair{uint16(r), g})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].r < pairs[j].r || pairs[i].r == pairs[j].r && pairs[i].g < pairs[j].g
+	})
+	// A rune drawn from two glyphs, as in small caps, maps to the first.
+	uniq := pairs[:0]
+	for i, p := range pairs {
+		if i == 0 || p.r != pairs[i-1].r {
+			uniq = append(uniq, p)
+		}
+	}
+	// The last segment maps 0xFFFF to glyph 0, as the format requires.
+	pairs = append(uniq, pair{0xFFFF, 0})
+	n := len(pairs)
+	log2 := 0
+	for 2<<log2 <= n {
+		log2++
+	}
+	length := 16 + 8*n
+	t := make([]byte, 12+length)
+	be.PutUint16(t[2:], 1)  // one encoding record
+	be.PutUint16(t[4:], 3)  // Windows
+	be.PutUint16(t[6:], 1)  // Unicode BMP
+	be.PutUint32(t[8:], 12) // subtable offset
+	sub := t[12:]
+	be.PutUint16(sub, 4)
+	be.PutUint16(sub[2:], uint16(length))
+	be.PutUint16(sub[6:], uint16(2*n))
+	be.PutUint16(sub[8:], uint16(2<<log2))
+	be.PutUint16(sub[10:], uint16(log2))
+	be.PutUint16(sub[12:], uint16(2*n-2<<log2))
+	for i, p := range pairs {
+		be.PutUint16(sub[14+2*i:], p.r)                 // endCode
+		be.PutUint16(sub[16+2*n+2*i:], p.r)             // startCode
+		be.PutUint16(sub[16+4*n+2*i:], uint16(p.g)-p.r) // idDelta
+	}
+	return t
+}
+
+// glyphComponents returns the glyphs a composite glyph is built from, or
+// nil for a simple glyph.
+func glyphComponents(g []byte) []int {
+	be := binary.BigEndian
+	if len(g) < 10 || int16(be.Uint16(g)) >= 0 {
+		return nil
+	}
+	const (
+		argsAreWords   = 0x0001
+		haveScale      = 0x0008
+		moreComponents = 0x0020
+		haveXYScale    = 0x0040
+		haveTwoByTwo   = 0x0080
+	)
+	var comps []int
+	for p := 10; p+4 <= len(g); {
+		flags := be.Uint16(g[p:])
+		comps = append(comps, int(be.Uint16(g[p+2:])))
+		p += 6
+		if flags&argsAreWords != 0 {
+			p += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			p += 2
+		case flags&haveXYScale != 0:
+			p += 4
+		case flags&haveTwoByTwo != 0:
+			p += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return comps
+}
+
+// writeSFNT lays tables out as a TrueType font file, in tag order and
+// padded to four bytes, and returns it with the offset of the head table.
+func writeSFNT(tables map[string][]byte) ([]byte, int) {
+	be := binary.BigEndian
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	n := len(tags)
+	log2 := 0
+	for 2<<log2 <= n {
+		log2++
+	}
+	out := make([]byte, 12+16*n)
+	be.PutUint32(out, 0x00010000)
+	be.PutUint16(out[4:], uint16(n))
+	be.PutUint16(out[6:], uint16(16<<log2))
+	be.PutUint16(out[8:], uint16(log2))
+	be.PutUint16(out[10:], uint16(16*n-16<<log2))
+	headAt := 0
+	for i, tag := range tags {
+		t := tables[tag]
+		rec := out[12+16*i:]
+		copy(rec, tag)
+		be.PutUint32(rec[8:], uint32(len(out)))
+		be.PutUint32(rec[12:], uint32(len(t)))
+		if tag == "head" {
+			headAt = len(out)
+		}
+		start := len(out)
+		out = append(out, t...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+		be.PutUint32(out[12+16*i+4:], sfntChecksum(out[start:]))
+	}
+	return out, headAt
+}
+
+// sfntChecksum sums data, padded to four bytes, as big-endian uint32s.
+func sfntChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var w [4]byte
+		copy(w[:], data[i:])
+		sum += binary.BigEndian.Uint32(w[:])
+	}
+	return sum
+}
+
+// subsetTag returns the six capital letters that prefix the name of a
+// font subset in a PDF, derived from the glyphs it holds.
+func subsetTag(gids []int) string {
+	h := fnv.New64a()
+	for _, g := range gids {
+		h.Write([]byte{byte(g >> 8), byte(g)})
+	}
+	v := h.Sum64()
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + byte(v%26)
+		v /= 26
+	}
+	return string(tag)
+}
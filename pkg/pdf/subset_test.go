@@ -0,0 +1,66 @@
+package pdf
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestSubsetTrueType(t *testing.T) {
+	full, err := sfnt.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf, subBuf sfnt.Buffer
+	keep := map[sfnt.GlyphIndex]rune{}
+	for _, r := range "DRAFT é©" {
+		g, err := full.GlyphIndex(&buf, r)
+		if err != nil || g == 0 {
+			t.Fatalf("%q: glyph %d, %v", r, g, err)
+		}
+		keep[g] = r
+	}
+	data, err := subsetTrueType(goregular.TTF, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) >= len(goregular.TTF)/2 {
+		t.Errorf("subset is %d bytes of %d", len(data), len(goregular.TTF))
+	}
+	sub, err := sfnt.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.NumGlyphs() != full.NumGlyphs() {
+		t.Fatalf("subset has %d glyphs, want %d", sub.NumGlyphs(), full.NumGlyphs())
+	}
+	for g, r := range keep {
+		want, err := full.LoadGlyph(&buf, g, fixed.I(1000), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(sfnt.Segments(nil), want...)
+		got, err := sub.LoadGlyph(&subBuf, g, fixed.I(1000), nil)
+		if err != nil {
+			t.Fatalf("%q: %v", r, err)
+		}
+		if len(want)+len(got) > 0 && !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: outline differs", r)
+		}
+		wantAdv, _ := full.GlyphAdvance(&buf, g, fixed.I(1000), font.HintingNone)
+		if gotAdv, _ := sub.GlyphAdvance(&subBuf, g, fixed.I(1000), font.HintingNone); gotAdv != wantAdv {
+			t.Errorf("%q: advance %v, want %v", r, gotAdv, wantAdv)
+		}
+		if idx, err := sub.GlyphIndex(&subBuf, r); err != nil || idx != g {
+			t.Errorf("%q: cmap gives glyph %d, %v, want %d", r, idx, err, g)
+		}
+	}
+	g, _ := full.GlyphIndex(&buf, 'Z')
+	if segs, err := sub.LoadGlyph(&subBuf, g, fixed.I(1000), nil); err != nil || len(segs) != 0 {
+		t.Errorf("'Z' left in the subset: %d segments, %v", len(segs), err)
+	}
+}
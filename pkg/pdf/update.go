@@ -0,0 +1,202 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Update collects the objects of an incremental update.
+type Update struct {
+	doc     *Document
+	next    int
+	objects map[int]interface{}
+}
+
+// NewUpdate starts an incremental update of doc.
+func NewUpdate(doc *Document) *Update {
+	return &Update{doc: doc, next: doc.size(), objects: map[int]interface{}{}}
+}
+
+// Add stores a new object and returns a reference to it.
+func (u *Update) Add(v interface{}) Ref {
+	num := u.next
+	u.next++
+	u.objects[num] = v
+	return Ref{Num: num}
+}
+
+// Replace stores a new version of an existing object.
+func (u *Update) Replace(ref Ref, v interface{}) {
+	u.objects[ref.Num] = v
+}
+
+// Bytes returns the original file followed by the update.
+func (u *Update) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(u.doc.data)
+	if !bytes.HasSuffix(u.doc.data, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	nums := make([]int, 0, len(u.objects)+1)
+	for num := range u.objects {
+		nums = append(nums, num)
+	}
+	offsets := map[int]int{}
+	sort.Ints(nums)
+	for _, num := range nums {
+		offsets[num] = buf.Len()
+		fmt.Fprintf(&buf, "%d %d obj\n", num, u.gen(num))
+		writeObject(&buf, u.objects[num])
+		buf.WriteString("\nendobj\n")
+	}
+
+	trailer := Dict{"Size": u.next, "Prev": u.doc.startxref}
+	for _, k := range []Name{"Root", "Info", "ID"} {
+		if v, ok := u.doc.trailer[k]; ok {
+			trailer[k] = v
+		}
+	}
+	xrefAt := buf.Len()
+	if u.doc.xrefStream {
+		// The cross-reference stream describes itself too.
+		self := u.next
+		trailer["Size"] = self + 1
+		offsets[self] = xrefAt
+		nums = append(nums, self)
+		var index Array
+		var rows bytes.Buffer
+		for i := 0; i < len(nums); {
+			j := i
+			for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+				j++
+			}
+			index = append(index, nums[i], j-i+1)
+			for _, num := range nums[i : j+1] {
+				off := offsets[num]
+				rows.Write([]byte{1, byte(off >> 24), byte(off >> 16), byte(off >> 8), byte(off), byte(u.gen(num) >> 8), byte(u.gen(num))})
+			}
+			i = j + 1
+		}
+		trailer["Type"] = Name("XRef")
+		trailer["W"] = Array{1, 4, 2}
+		trailer["Index"] = index
+		fmt.Fprintf(&buf, "%d 0 obj\n", self)
+		writeObject(&buf, &Stream{Dict: trailer, Data: rows.Bytes()})
+		buf.WriteString("\nendobj\n")
+	} else {
+		buf.WriteString("xref\n")
+		for i := 0; i < len(nums); {
+			j := i
+			for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+				j++
+			}
+			fmt.Fprintf(&buf, "%d %d\n", nums[i], j-i+1)
+			for _, num := range nums[i : j+1] {
+				fmt.Fprintf(&buf, "%010d %05d n\r\n", offsets[num], u.gen(num))
+			}
+			i = j + 1
+		}
+		buf.WriteString("trailer\n")
+		writeObject(&buf, trailer)
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xrefAt)
+	return buf.Bytes()
+}
+
+// gen is the generation number an updated object keeps.
+func (u *Update) gen(num int) int {
+	if e, ok := u.doc.xref[num]; ok && e.offset >= 0 {
+		return e.gen
+	}
+	return 0
+}
+
+// writeObject serializes a PDF object.
+func writeObject(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case Name:
+		buf.WriteByte('/')
+		for _, c := range []byte(v) {
+			if c < '!' || c > '~' || bytes.IndexByte([]byte("#/()<>[]{}%"), c) >= 0 {
+				fmt.Fprintf(buf, "#%02X", c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	case String:
+		fmt.Fprintf(buf, "<%X>", []byte(v))
+	case Ref:
+		fmt.Fprintf(buf, "%d %d R", v.Num, v.Gen)
+	case Array:
+		buf.WriteByte('[')
+		for i, x := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeObject(buf, x)
+		}
+		buf.WriteByte(']')
+	case Dict:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		buf.WriteString("<<")
+		for _, k := range keys {
+			writeObject(buf, Name(k))
+			buf.WriteByte(' ')
+			writeObject(buf, v[Name(k)])
+		}
+		buf.WriteString(">>")
+	case *Stream:
+		dict := Dict{}
+		for k, x := range v.Dict {
+			dict[k] = x
+		}
+		dict["Length"] = len(v.Data)
+		writeObject(buf, dict)
+		buf.WriteString("\nstream\n")
+		buf.Write(v.Data)
+		buf.WriteString("\nendstream")
+	}
+}
+
+// Flate returns a FlateDecode stream of data.
+func Flate(data []byte) *Stream {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return &Stream{Dict: Dict{"Filter": Name("FlateDecode")}, Data: buf.Bytes()}
+}
+
+// CopyDict returns a shallow copy of d, which may be nil.
+func CopyDict(d Dict) Dict {
+	c := Dict{}
+	for k, v := range d {
+		c[k] = v
+	}
+	return c
+}
+
+// FreshName returns prefix followed by the lowest number not yet a key of d.
+func FreshName(d Dict, prefix string) Name {
+	for i := 0; ; i++ {
+		if n := Name(prefix + strconv.Itoa(i)); d[n] == nil {
+			return n
+		}
+	}
+}
@@ -0,0 +1,8 @@
+// Package preset holds named watermark configurations: the built-in ones,
+// those registered by library code, and those loaded from a presets file,
+// each either shared or scoped to one tenant, such as a customer of a
+// shared service identified by API key.
+//
+// A Preset is plain data whose YAML keys match the CLI flag names; package
+// watermark turns one into options and applies it.
+package preset
@@ -0,0 +1,207 @@
+package preset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"watermark/pkg/compose"
+	"watermark/pkg/render"
+)
+
+// Preset is a named watermark configuration. Field names in YAML match the
+// CLI flag names, so a presets file doubles as a CLI config file.
+type Preset struct {
+	Name           string           `yaml:"-"`
+	Mode           string           `yaml:"mode,omitempty"`
+	Text           string           `yaml:"text,omitempty"`
+	LineHeight     *float64         `yaml:"line-height,omitempty"`
+	Align          render.Align     `yaml:"align,omitempty"`
+	Font           string           `yaml:"font,omitempty"`
+	Color          *string          `yaml:"color,omitempty"`
+	Opacity        *float64         `yaml:"opacity,omitempty"`
+	Space          *int             `yaml:"space,omitempty"`
+	Angle          *int             `yaml:"angle,omitempty"`
+	FontSize       *int             `yaml:"font-size,omitempty"`
+	FontHeightCrop *float64         `yaml:"font-height-crop,omitempty"`
+	RotateTiles    bool             `yaml:"rotate-tiles,omitempty"`
+	Position       compose.Position `yaml:"position,omitempty"`
+	PositionSize   *int             `yaml:"position-font-size,omitempty"`
+	FontSizeRatio  *float64         `yaml:"font-size-ratio,omitempty"`
+	WidthRatio     float64          `yaml:"width-ratio,omitempty"`
+	PositionAngle  float64          `yaml:"position-angle,omitempty"`
+	FillColor      string           `yaml:"fill-color,omitempty"`
+	OutlineColor   string           `yaml:"outline-color,omitempty"`
+	MarginRatio    *float64         `yaml:"margin-ratio,omitempty"`
+	OffsetX        int              `yaml:"offset-x,omitempty"`
+	OffsetY        int              `yaml:"offset-y,omitempty"`
+	Stencil        bool             `yaml:"stencil,omitempty"`
+	StencilGray    *uint8           `yaml:"stencil-gray,omitempty"`
+	Bilevel        bool             `yaml:"bilevel,omitempty"`
+	Transforms     []string         `yaml:"transform,omitempty"`
+}
+
+var (
+	presetsMu sync.RWMutex
+	presets   = map[string]Preset{}
+	// tenantPresets holds presets scoped to one tenant, such as a customer
+	// identified by API key, by tenant and then by preset name.
+	tenantPresets = map[string]map[string]Preset{}
+)
+
+func init() {
+	for _, p := range []Preset{
+		{Name: "confidential", Mode: "position", Text: "CONFIDENTIAL", Position: compose.Center, Opacity: floatPtr(0.6)},
+		{Name: "draft", Mode: "position", Text: "DRAFT", Position: compose.Center, PositionAngle: 45, WidthRatio: 0.6, Opacity: floatPtr(0.5)},
+		{Name: "copyright", Mode: "position", Text: "©", Position: compose.BottomRight, Opacity: floatPtr(0.7)},
+	} {
+		presets[p.Name] = p
+	}
+}
+
+// Register adds or replaces a preset under p.Name.
+func Register(p Preset) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	presets[p.Name] = p
+	return nil
+}
+
+// RegisterTenant adds or replaces a preset under p.Name for tenant
+// only, so each customer of a shared service, keyed for instance by API
+// key, can have its own fonts, text and styles. Presets kept in a backing
+// store can be registered as they are loaded or changed.
+func RegisterTenant(tenant string, p Preset) error {
+	if strings.TrimSpace(tenant) == "" {
+		return errors.New("tenant must not be empty")
+	}
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("tenant %q: %w", tenant, err)
+	}
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	if tenantPresets[tenant] == nil {
+		tenantPresets[tenant] = map[string]Preset{}
+	}
+	tenantPresets[tenant][p.Name] = p
+	return nil
+}
+
+// UnregisterTenant removes every preset of tenant, e.g. when its API key
+// is revoked.
+func UnregisterTenant(tenant string) {
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	delete(tenantPresets, tenant)
+}
+
+// Validate fails unless p has a name and a mode of "", "repeat" or
+// "position".
+func (p Preset) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("preset name must not be empty")
+	}
+	switch strings.ToLower(p.Mode) {
+	case "", "repeat", "position":
+	default:
+		return fmt.Errorf("preset %q: unsupported mode %q", p.Name, p.Mode)
+	}
+	return nil
+}
+
+// Lookup returns the preset registered under name.
+func Lookup(name string) (Preset, bool) {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	p, ok := presets[name]
+	return p, ok
+}
+
+// LookupTenant returns the preset tenant registered under name, or
+// else the shared preset of that name. Presets of other tenants are never
+// returned.
+func LookupTenant(tenant, name string) (Preset, bool) {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	if p, ok := tenantPresets[tenant][name]; ok {
+		return p, true
+	}
+	p, ok := presets[name]
+	return p, ok
+}
+
+// TenantNames lists the presets registered for tenant alone in sorted
+// order, or nil if the tenant has none.
+func TenantNames(tenant string) []string {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	var names []string
+	for name := range tenantPresets[tenant] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Names lists the registered presets in sorted order.
+func Names() []string {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load registers every preset under the top-level "presets" mapping
+// of a YAML file, and the presets under "tenants" for their tenant:
+//
+//	presets:
+//	  draft: {text: DRAFT}
+//	tenants:
+//	  acme-api-key:
+//	    draft: {text: ACME DRAFT, font: /fonts/acme.ttf}
+//
+// Unknown keys are ignored so CLI config files can be used.
+func Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Presets map[string]Preset            `yaml:"presets"`
+		Tenants map[string]map[string]Preset `yaml:"tenants"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, p := range file.Presets {
+		p.Name = name
+		if err := Register(p); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for tenant, ps := range file.Tenants {
+		for name, p := range ps {
+			p.Name = name
+			if err := RegisterTenant(tenant, p); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,46 @@
+package render
+
+import (
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Align is the horizontal alignment of multi-line text.
+type Align string
+
+const (
+	AlignLeft   Align = "left"
+	AlignCenter Align = "center"
+	AlignRight  Align = "right"
+)
+
+// Valid reports whether a is one of the alignments above.
+func (a Align) Valid() bool {
+	switch a {
+	case AlignLeft, AlignCenter, AlignRight:
+		return true
+	}
+	return false
+}
+
+// Offset returns the x offset of a line of width w in a block of width maxW.
+func (a Align) Offset(maxW, w fixed.Int26_6) fixed.Int26_6 {
+	switch a {
+	case AlignCenter:
+		return (maxW - w) / 2
+	case AlignRight:
+		return maxW - w
+	}
+	return 0
+}
+
+// LineAdvance returns the distance between baselines for a line height
+// factor f (0 means 1).
+func LineAdvance(m font.Metrics, f float64) fixed.Int26_6 {
+	if f <= 0 {
+		f = 1
+	}
+	return fixed.Int26_6(math.Round(float64(m.Height) * f))
+}
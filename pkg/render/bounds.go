@@ -0,0 +1,64 @@
+package render
+
+import "image"
+
+// TightAlphaBounds returns the smallest rectangle holding every pixel of
+// img that is not fully transparent. Rows are scanned from the top and the
+// bottom until they hit the mark, and the rows between only as far in from
+// either side as the columns found so far.
+func TightAlphaBounds(img *image.NRGBA) (image.Rectangle, bool) {
+	b := img.Bounds()
+	if b.Empty() {
+		return image.Rectangle{}, false
+	}
+	// alpha returns the offset of the alpha byte of (x, y) in img.Pix.
+	alpha := func(x, y int) int { return img.PixOffset(x, y) + 3 }
+	// rowSpan returns the first and last non-transparent x of row y, or
+	// false if the row has none.
+	rowSpan := func(y int) (int, int, bool) {
+		row := img.Pix[alpha(b.Min.X, y) : alpha(b.Max.X-1, y)+1]
+		for i := 0; i < len(row); i += 4 {
+			if row[i] != 0 {
+				for j := len(row) - 1; ; j -= 4 {
+					if row[j] != 0 {
+						return b.Min.X + i/4, b.Min.X + j/4, true
+					}
+				}
+			}
+		}
+		return 0, 0, false
+	}
+
+	minY := b.Min.Y
+	minX, maxX, ok := 0, 0, false
+	for ; minY < b.Max.Y; minY++ {
+		if minX, maxX, ok = rowSpan(minY); ok {
+			break
+		}
+	}
+	if !ok {
+		return image.Rectangle{}, false
+	}
+	maxY := b.Max.Y - 1
+	for ; maxY > minY; maxY-- {
+		if x0, x1, ok := rowSpan(maxY); ok {
+			minX, maxX = min(minX, x0), max(maxX, x1)
+			break
+		}
+	}
+	for y := minY + 1; y < maxY; y++ {
+		for x, i := b.Min.X, alpha(b.Min.X, y); x < minX; x, i = x+1, i+4 {
+			if img.Pix[i] != 0 {
+				minX = x
+				break
+			}
+		}
+		for x, i := b.Max.X-1, alpha(b.Max.X-1, y); x > maxX; x, i = x-1, i-4 {
+			if img.Pix[i] != 0 {
+				maxX = x
+				break
+			}
+		}
+	}
+	return image.Rect(minX, minY, maxX+1, maxY+1), true
+}
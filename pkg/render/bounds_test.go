@@ -0,0 +1,64 @@
+package render
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	"watermark/internal/imagetest"
+)
+
+// naiveTightAlphaBounds is TightAlphaBounds as a plain NRGBAAt loop over
+// every pixel.
+func naiveTightAlphaBounds(img *image.NRGBA) (image.Rectangle, bool) {
+	var r image.Rectangle
+	found := false
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.NRGBAAt(x, y).A == 0 {
+				continue
+			}
+			p := image.Rect(x, y, x+1, y+1)
+			if found {
+				r = r.Union(p)
+			} else {
+				r, found = p, true
+			}
+		}
+	}
+	return r, found
+}
+
+func TestTightAlphaBounds(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		min := image.Pt(rng.Intn(21)-10, rng.Intn(21)-10)
+		w, h := 1+rng.Intn(40), 1+rng.Intn(40)
+		n := rng.Intn(4)
+		img := imagetest.RandomMark(rng, min, w, h, n)
+		got, gotOK := TightAlphaBounds(img)
+		want, wantOK := naiveTightAlphaBounds(img)
+		if got != want || gotOK != wantOK {
+			t.Fatalf("%v with %d pixels: got %v, %v, want %v, %v", img.Bounds(), n, got, gotOK, want, wantOK)
+		}
+	}
+	got, _ := TightAlphaBounds(imagetest.TextLine())
+	if want, _ := naiveTightAlphaBounds(imagetest.TextLine()); got != want {
+		t.Fatalf("4000x1000 mark: got %v, want %v", got, want)
+	}
+}
+
+func BenchmarkTightAlphaBounds(b *testing.B) {
+	img := imagetest.TextLine()
+	b.Run("Pix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			TightAlphaBounds(img)
+		}
+	})
+	b.Run("NRGBAAt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			naiveTightAlphaBounds(img)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+)
+
+// collectionTag starts the data of a font collection.
+const collectionTag = "ttcf"
+
+var errCorruptCollection = errors.New("corrupt font collection")
+
+// splitCollectionPath splits a font path into the font file and the
+// selector of a font in it. A path picks a font from a TrueType or OpenType
+// collection, as system CJK fonts such as PingFang and Microsoft YaHei
+// ship, after '#': "msgothic.ttc#1" by index from 0, or
+// "msgothic.ttc#MS PGothic" by family or full name, ignoring case; without
+// a selector it is the first font. Only .ttc and .otc files have
+// selectors, so other paths are returned whole even if they contain '#'.
+func splitCollectionPath(path string) (file, selector string) {
+	i := strings.LastIndexByte(path, '#')
+	if i < 0 {
+		return path, ""
+	}
+	switch strings.ToLower(filepath.Ext(path[:i])) {
+	case ".ttc", ".otc":
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+// collectionMember returns the font of data chosen by selector as a
+// standalone font file, so the PDF embedding, shaping and table lookups see
+// it like any other font. Data that is not a collection is returned as it
+// is, unless a selector asks for a font in it. name is for errors.
+func collectionMember(data []byte, selector, name string) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(collectionTag)) {
+		if selector != "" {
+			return nil, fmt.Errorf("%w: %s: not a font collection, so it has no font %q", ErrFontLoad, name, selector)
+		}
+		return data, nil
+	}
+	coll, err := opentype.ParseCollection(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrFontLoad, name, err)
+	}
+	idx, err := selectCollectionFont(coll, selector)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrFontLoad, name, err)
+	}
+	member, err := extractCollectionFont(data, idx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrFontLoad, name, err)
+	}
+	return member, nil
+}
+
+// selectCollectionFont returns the index of the font in coll that selector
+// names, by index or by name.
+func selectCollectionFont(coll *sfnt.Collection, selector string) (int, error) {
+	n := coll.NumFonts()
+	selector = strings.TrimSpace(selector)
+	if selector == "" {
+		return 0, nil
+	}
+	if i, err := strconv.Atoi(selector); err == nil {
+		if i < 0 || i >= n {
+			return 0, fmt.Errorf("no font %d in the collection, it has %d (0 to %d)", i, n, n-1)
+		}
+		return i, nil
+	}
+	var buf sfnt.Buffer
+	names := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		f, err := coll.Font(i)
+		if err != nil {
+			return 0, err
+		}
+		family, _ := f.Name(&buf, sfnt.NameIDFamily)
+		for _, id := range []sfnt.NameID{sfnt.NameIDFamily, sfnt.NameIDTypographicFamily, sfnt.NameIDFull} {
+			if s, err := f.Name(&buf, id); err == nil && strings.EqualFold(s, selector) {
+				return i, nil
+			}
+		}
+		names = append(names, fmt.Sprintf("%d %q", i, family))
+	}
+	return 0, fmt.Errorf("no font named %q in the collection (fonts: %s)", selector, strings.Join(names, ", "))
+}
+
+// extractCollectionFont copies font idx of the collection data into a font
+// file of its own: its table directory, with offsets rewritten, followed by
+// the tables it uses, which the collection may share between fonts.
+func extractCollectionFont(data []byte, idx int) ([]byte, error) {
+	be := binary.BigEndian
+	if len(data) < 12+4*(idx+1) {
+		return nil, errCorruptCollection
+	}
+	off := int(be.Uint32(data[12+4*idx:]))
+	if off+12 > len(data) {
+		return nil, errCorruptCollection
+	}
+	numTables := int(be.Uint16(data[off+4:]))
+	dirLen := 12 + 16*numTables
+	if off+dirLen > len(data) {
+		return nil, errCorruptCollection
+	}
+	out := make([]byte, dirLen, dirLen+len(data)/4)
+	copy(out, data[off:off+dirLen])
+	for i := 0; i < numTables; i++ {
+		rec := 12 + 16*i
+		start, length := uint64(be.Uint32(out[rec+8:])), uint64(be.Uint32(out[rec+12:]))
+		if start+length > uint64(len(data)) {
+			return nil, errCorruptCollection
+		}
+		be.PutUint32(out[rec+8:], uint32(len(out)))
+		out = append(out, data[start:start+length]...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	return out, nil
+}
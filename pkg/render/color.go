@@ -0,0 +1,78 @@
+package render
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+
+	"watermark/internal/parallel"
+)
+
+// ScaleAlpha returns c with its alpha multiplied by f.
+func ScaleAlpha(c color.NRGBA, f float64) color.NRGBA {
+	c.A = uint8(clampInt(int(math.Round(float64(c.A)*f)), 0, 255))
+	return c
+}
+
+// ParseHexColor parses a color as "#rgb", "#rrggbb" or "#rrggbbaa", with
+// or without the '#', or as a "palette:color" reference such as
+// "okabe-ito:vermillion".
+func ParseHexColor(s string) (color.NRGBA, error) {
+	str := strings.TrimSpace(s)
+	if str == "" {
+		return color.NRGBA{}, errors.New("color must not be empty")
+	}
+	if strings.Contains(str, ":") {
+		h, err := paletteHex(str)
+		if err != nil {
+			return color.NRGBA{}, err
+		}
+		str = h
+	}
+	str = strings.TrimPrefix(str, "#")
+	if len(str) == 3 {
+		str = string([]byte{str[0], str[0], str[1], str[1], str[2], str[2]})
+	}
+	v, err := hex.DecodeString(str)
+	if err != nil || (len(v) != 3 && len(v) != 4) {
+		return color.NRGBA{}, fmt.Errorf("invalid color format: %q", s)
+	}
+	c := color.NRGBA{R: v[0], G: v[1], B: v[2], A: 255}
+	if len(v) == 4 {
+		c.A = v[3]
+	}
+	return c, nil
+}
+
+// SetOpacity returns a copy of img with its alpha multiplied by opacity,
+// which must lie in [0, 1], using up to workers goroutines (0 means
+// GOMAXPROCS).
+func SetOpacity(img image.Image, opacity float64, workers int) (image.Image, error) {
+	if opacity < 0 || opacity > 1 {
+		return nil, ErrInvalidOpacity
+	}
+	out := imaging.Clone(img)
+	parallel.Rows(workers, out.Bounds(), func(band image.Rectangle) error {
+		for i := out.PixOffset(0, band.Min.Y); i < out.PixOffset(0, band.Max.Y); i += 4 {
+			out.Pix[i+3] = uint8(math.Round(float64(out.Pix[i+3]) * opacity))
+		}
+		return nil
+	})
+	return out, nil
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,384 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+
+	"github.com/disintegration/imaging"
+	gotext "github.com/go-text/typesetting/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/vector"
+)
+
+// colorFont is what a font has to draw glyphs in color, such as emoji:
+// COLR layers colored from the first CPAL palette, and CBDT or sbix
+// bitmaps. COLR version 1 paint graphs are not supported; their glyphs are
+// drawn from their outlines in the text color.
+type colorFont struct {
+	layers  map[sfnt.GlyphIndex][]colrLayer
+	palette []color.NRGBA
+	bitmaps bool
+}
+
+// colrLayer is a layer of a COLR glyph: the glyph drawn and its palette
+// index, foregroundIndex for the text color.
+type colrLayer struct {
+	glyph sfnt.GlyphIndex
+	index uint16
+}
+
+const foregroundIndex = 0xFFFF
+
+// colorGlyph is a glyph drawn in its own colors, as contours in pixels with
+// y pointing down like Outline.
+type colorGlyph struct {
+	// img, for a bitmap glyph, is drawn with its top-left corner at at.
+	img    *image.NRGBA
+	at     fixed.Point26_6
+	layers []colorLayer
+}
+
+// colorLayer is a layer of a color glyph, filled with color or, if
+// foreground is set, with the text color.
+type colorLayer struct {
+	segs       []sfnt.Segment
+	color      color.NRGBA
+	foreground bool
+}
+
+// colors returns the color tables of f, parsing them on first use, or nil
+// if it has none. Tables that fail to parse are treated as missing.
+func (f *Font) colors() *colorFont {
+	f.colorOnce.Do(func() {
+		cf := &colorFont{}
+		if colr, cpal := FontTable(f.data, "COLR"), FontTable(f.data, "CPAL"); colr != nil && cpal != nil {
+			cf.layers = parseCOLR(colr)
+			cf.palette = parseCPAL(cpal)
+		}
+		if FontTable(f.data, "CBDT") != nil || FontTable(f.data, "sbix") != nil {
+			_, err := f.shapingFont()
+			cf.bitmaps = err == nil
+		}
+		if len(cf.layers) > 0 || cf.bitmaps {
+			f.colorTables = cf
+		}
+	})
+	return f.colorTables
+}
+
+// FontTable returns the table of an OpenType font file with the given tag,
+// or nil if it has none.
+func FontTable(data []byte, tag string) []byte {
+	if len(data) < 12 {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < n && 12+16*(i+1) <= len(data); i++ {
+		rec := data[12+16*i:]
+		if string(rec[:4]) != tag {
+			continue
+		}
+		off, length := binary.BigEndian.Uint32(rec[8:]), binary.BigEndian.Uint32(rec[12:])
+		if uint64(off)+uint64(length) > uint64(len(data)) {
+			return nil
+		}
+		return data[off : off+length]
+	}
+	return nil
+}
+
+// parseCOLR returns the version 0 base glyphs of a COLR table with their
+// layers.
+func parseCOLR(t []byte) map[sfnt.GlyphIndex][]colrLayer {
+	if len(t) < 14 {
+		return nil
+	}
+	be := binary.BigEndian
+	numBase, baseOff := int(be.Uint16(t[2:])), int(be.Uint32(t[4:]))
+	layerOff, numLayers := int(be.Uint32(t[8:])), int(be.Uint16(t[12:]))
+	if baseOff+6*numBase > len(t) || layerOff+4*numLayers > len(t) {
+		return nil
+	}
+	out := make(map[sfnt.GlyphIndex][]colrLayer, numBase)
+	for i := 0; i < numBase; i++ {
+		rec := t[baseOff+6*i:]
+		first, n := int(be.Uint16(rec[2:])), int(be.Uint16(rec[4:]))
+		if n == 0 || first+n > numLayers {
+			continue
+		}
+		layers := make([]colrLayer, n)
+		for j := range layers {
+			l := t[layerOff+4*(first+j):]
+			layers[j] = colrLayer{glyph: sfnt.GlyphIndex(be.Uint16(l)), index: be.Uint16(l[2:])}
+		}
+		out[sfnt.GlyphIndex(be.Uint16(rec))] = layers
+	}
+	return out
+}
+
+// parseCPAL returns the first palette of a CPAL table.
+func parseCPAL(t []byte) []color.NRGBA {
+	if len(t) < 14 {
+		return nil
+	}
+	be := binary.BigEndian
+	n, numRecords := int(be.Uint16(t[2:])), int(be.Uint16(t[6:]))
+	recOff, first := int(be.Uint32(t[8:])), int(be.Uint16(t[12:]))
+	if be.Uint16(t[4:]) == 0 || first+n > numRecords || recOff+4*numRecords > len(t) {
+		return nil
+	}
+	out := make([]color.NRGBA, n)
+	for i := range out {
+		// Records are stored as B, G, R, A.
+		c := t[recOff+4*(first+i):]
+		out[i] = color.NRGBA{R: c[2], G: c[1], B: c[0], A: c[3]}
+	}
+	return out
+}
+
+// hasColorGlyphs reports whether any rune of text is drawn in color by the
+// first of fonts with a glyph for it.
+func hasColorGlyphs(fonts []*Font, text string) bool {
+	colored := false
+	for _, f := range fonts {
+		colored = colored || f.colors() != nil
+	}
+	if !colored {
+		return false
+	}
+	var buf sfnt.Buffer
+	for _, r := range text {
+		for _, f := range fonts {
+			idx, err := f.font.GlyphIndex(&buf, r)
+			if err != nil || idx == 0 {
+				continue
+			}
+			if f.isColorGlyph(idx) {
+				return true
+			}
+			break
+		}
+	}
+	return false
+}
+
+// isColorGlyph reports whether f draws glyph idx in color.
+func (f *Font) isColorGlyph(idx sfnt.GlyphIndex) bool {
+	cf := f.colors()
+	if cf == nil {
+		return false
+	}
+	if _, ok := cf.layers[idx]; ok {
+		return true
+	}
+	if !cf.bitmaps {
+		return false
+	}
+	bm, ok := f.bitmapGlyph(idx, 0)
+	return ok && bm.Format != gotext.BlackAndWhite
+}
+
+// bitmapGlyph returns the bitmap of glyph idx in the strike closest to ppem
+// pixels per em.
+func (f *Font) bitmapGlyph(idx sfnt.GlyphIndex, ppem uint16) (gotext.GlyphBitmap, bool) {
+	sf, err := f.shapingFont()
+	if err != nil {
+		return gotext.GlyphBitmap{}, false
+	}
+	face := gotext.NewFace(sf)
+	face.SetPpem(ppem, ppem)
+	bm, ok := face.GlyphData(gotext.GID(idx)).(gotext.GlyphBitmap)
+	return bm, ok
+}
+
+// colorGlyph returns glyph idx of f at ppem in color with its origin at dot,
+// or false if f draws it from its outline.
+func (f *Font) colorGlyph(buf *sfnt.Buffer, idx sfnt.GlyphIndex, ppem fixed.Int26_6, dot fixed.Point26_6) (colorGlyph, bool, error) {
+	cf := f.colors()
+	if cf == nil {
+		return colorGlyph{}, false, nil
+	}
+	if layers, ok := cf.layers[idx]; ok {
+		g := colorGlyph{layers: make([]colorLayer, len(layers))}
+		for i, l := range layers {
+			segs, err := f.font.LoadGlyph(buf, l.glyph, ppem, nil)
+			if err != nil {
+				return colorGlyph{}, false, err
+			}
+			// segs belongs to buf, so it is copied before the next load.
+			g.layers[i].segs = append([]sfnt.Segment(nil), segs...)
+			translateSegments(g.layers[i].segs, dot)
+			if l.index != foregroundIndex && int(l.index) < len(cf.palette) {
+				g.layers[i].color = cf.palette[l.index]
+			} else {
+				g.layers[i].foreground = true
+			}
+		}
+		return g, true, nil
+	}
+	if !cf.bitmaps {
+		return colorGlyph{}, false, nil
+	}
+	return f.bitmapColorGlyph(idx, ppem, dot)
+}
+
+// bitmapColorGlyph returns the bitmap of glyph idx scaled to ppem and
+// placed by its bearings, with its origin at dot.
+func (f *Font) bitmapColorGlyph(idx sfnt.GlyphIndex, ppem fixed.Int26_6, dot fixed.Point26_6) (colorGlyph, bool, error) {
+	px := uint16(clampInt(ppem.Round(), 1, math.MaxUint16))
+	bm, ok := f.bitmapGlyph(idx, px)
+	if !ok || bm.Format == gotext.BlackAndWhite {
+		return colorGlyph{}, false, nil
+	}
+	var img image.Image
+	var err error
+	switch bm.Format {
+	case gotext.PNG:
+		img, err = png.Decode(bytes.NewReader(bm.Data))
+	case gotext.JPG:
+		img, err = jpeg.Decode(bytes.NewReader(bm.Data))
+	case gotext.TIFF:
+		img, err = tiff.Decode(bytes.NewReader(bm.Data))
+	default:
+		return colorGlyph{}, false, nil
+	}
+	if err != nil {
+		return colorGlyph{}, false, err
+	}
+	if img.Bounds().Empty() {
+		return colorGlyph{}, false, nil
+	}
+
+	sf, _ := f.shapingFont()
+	face := gotext.NewFace(sf)
+	face.SetPpem(px, px)
+	scale := float64(ppem) / 64 / float64(sf.Upem())
+	var x, y, w, h float64
+	if ext, ok := face.GlyphExtents(gotext.GID(idx)); ok && ext.Width > 0 && ext.Height < 0 {
+		x, y = float64(ext.XBearing)*scale, -float64(ext.YBearing)*scale
+		w, h = float64(ext.Width)*scale, -float64(ext.Height)*scale
+	} else {
+		// Without metrics the bitmap spans the advance and sits on the
+		// baseline.
+		w = float64(face.HorizontalAdvance(gotext.GID(idx))) * scale
+		h = w * float64(img.Bounds().Dy()) / float64(img.Bounds().Dx())
+		y = -h
+	}
+	sw, sh := int(math.Round(w)), int(math.Round(h))
+	if sw <= 0 || sh <= 0 {
+		return colorGlyph{}, false, nil
+	}
+	return colorGlyph{
+		img: imaging.Resize(img, sw, sh, imaging.Lanczos),
+		at: fixed.Point26_6{
+			X: dot.X + fixed.Int26_6(math.Round(x*64)),
+			Y: dot.Y + fixed.Int26_6(math.Round(y*64)),
+		},
+	}, true, nil
+}
+
+// translate moves g by d.
+func (g *colorGlyph) translate(d fixed.Point26_6) {
+	g.at = g.at.Add(d)
+	for _, l := range g.layers {
+		translateSegments(l.segs, d)
+	}
+}
+
+// rotate returns g turned counter-clockwise by deg degrees, with turn
+// doing the same to a point. A bitmap is turned about its center.
+func (g *colorGlyph) rotate(deg float64, turn func(fixed.Point26_6) fixed.Point26_6) colorGlyph {
+	if g.img != nil {
+		size := g.img.Bounds().Size()
+		c := turn(g.at.Add(fixed.P(size.X, size.Y).Div(fixed.I(2))))
+		img := imaging.Rotate(g.img, deg, color.Transparent)
+		size = img.Bounds().Size()
+		return colorGlyph{img: img, at: c.Sub(fixed.P(size.X, size.Y).Div(fixed.I(2)))}
+	}
+	out := colorGlyph{layers: make([]colorLayer, len(g.layers))}
+	for i, l := range g.layers {
+		l.segs = append([]sfnt.Segment(nil), l.segs...)
+		for j := range l.segs {
+			for k := range l.segs[j].Args {
+				l.segs[j].Args[k] = turn(l.segs[j].Args[k])
+			}
+		}
+		out.layers[i] = l
+	}
+	return out
+}
+
+// bounds returns the pixels g covers.
+func (g *colorGlyph) bounds() image.Rectangle {
+	if g.img != nil {
+		p := image.Pt(g.at.X.Round(), g.at.Y.Round())
+		return image.Rectangle{p, p.Add(g.img.Bounds().Size())}
+	}
+	var r image.Rectangle
+	for _, l := range g.layers {
+		r = r.Union(segmentBounds(l.segs))
+	}
+	return r
+}
+
+// draw draws g with its origin at dot, taking the alpha of fill, the text
+// color, which foreground layers are filled with.
+func (g *colorGlyph) draw(dst *image.NRGBA, dot image.Point, fill color.NRGBA) {
+	if g.img != nil {
+		r := g.bounds().Add(dot)
+		draw.DrawMask(dst, r, g.img, image.Point{}, image.NewUniform(color.Alpha{A: fill.A}), image.Point{}, draw.Over)
+		return
+	}
+	for _, l := range g.layers {
+		col := fill
+		if !l.foreground {
+			col = ScaleAlpha(l.color, float64(fill.A)/255)
+		}
+		r := segmentBounds(l.segs)
+		if r.Empty() {
+			continue
+		}
+		z := vector.NewRasterizer(r.Dx(), r.Dy())
+		fillContours(z, l.segs, [2]float32{float32(-r.Min.X), float32(-r.Min.Y)})
+		drawMask(dst, z, r.Add(dot), col)
+	}
+}
+
+// glyphContours returns the contours of glyph idx of fnt at ppem. Glyphs of
+// bitmap fonts have none, including those without a bitmap, such as
+// joiners inside emoji sequences.
+func glyphContours(fnt *opentype.Font, buf *sfnt.Buffer, idx sfnt.GlyphIndex, ppem fixed.Int26_6) ([]sfnt.Segment, error) {
+	segs, err := fnt.LoadGlyph(buf, idx, ppem, nil)
+	if errors.Is(err, sfnt.ErrColoredGlyph) {
+		return nil, nil
+	}
+	return segs, err
+}
+
+func translateSegments(segs []sfnt.Segment, d fixed.Point26_6) {
+	for i := range segs {
+		for j := range segs[i].Args {
+			segs[i].Args[j] = segs[i].Args[j].Add(d)
+		}
+	}
+}
+
+// segmentBounds returns the pixels contours cover, or an empty rectangle
+// for none.
+func segmentBounds(segs []sfnt.Segment) image.Rectangle {
+	if len(segs) == 0 {
+		return image.Rectangle{}
+	}
+	b := sfnt.Segments(segs).Bounds()
+	return image.Rect(b.Min.X.Floor(), b.Min.Y.Floor(), b.Max.X.Ceil(), b.Max.Y.Ceil())
+}
@@ -0,0 +1,109 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// MinDeltaE is the CIE76 color difference below which LowContrast reports
+// a mark as hard to see against its background.
+const MinDeltaE = 20.0
+
+// cvdMatrices simulate full dichromacy on linear RGB (Machado, Oliveira &
+// Fernandes 2009, severity 1.0).
+var cvdMatrices = []struct {
+	name string
+	m    [9]float64
+}{
+	{"typical vision", [9]float64{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+	{"protanopia", [9]float64{0.152286, 1.052583, -0.204868, 0.114503, 0.786281, 0.099216, -0.003882, -0.048116, 1.051998}},
+	{"deuteranopia", [9]float64{0.367322, 0.860646, -0.227968, 0.280085, 0.672501, 0.047413, -0.011820, 0.042940, 0.968881}},
+	{"tritanopia", [9]float64{1.255528, -0.076749, -0.178779, -0.078411, 0.930809, 0.147602, 0.004733, 0.691367, 0.303900}},
+}
+
+// LowContrast reports whether mark, blended over the mean color of img
+// within r, is hard to tell from that background for typical vision or any
+// of the simulated color-vision deficiencies. If so, it returns the first
+// such vision, e.g. "deuteranopia", and the CIE76 color difference, which
+// is below MinDeltaE.
+func LowContrast(img image.Image, r image.Rectangle, mark color.NRGBA) (vision string, deltaE float64, low bool) {
+	bg := meanColor(img, r)
+	a := float64(mark.A) / 255
+	blend := [3]float64{
+		float64(mark.R)*a + bg[0]*(1-a),
+		float64(mark.G)*a + bg[1]*(1-a),
+		float64(mark.B)*a + bg[2]*(1-a),
+	}
+	for _, v := range cvdMatrices {
+		if d := labDistance(simulateCVD(v.m, blend), simulateCVD(v.m, bg)); d < MinDeltaE {
+			return v.name, d, true
+		}
+	}
+	return "", 0, false
+}
+
+// meanColor returns the average sRGB color of img within r.
+func meanColor(img image.Image, r image.Rectangle) [3]float64 {
+	r = r.Intersect(img.Bounds())
+	var sum [3]float64
+	if r.Empty() {
+		return sum
+	}
+	step := max(1, int(math.Sqrt(float64(r.Dx()*r.Dy())/65536)))
+	n := 0
+	for y := r.Min.Y; y < r.Max.Y; y += step {
+		for x := r.Min.X; x < r.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			sum[0] += float64(c.R)
+			sum[1] += float64(c.G)
+			sum[2] += float64(c.B)
+			n++
+		}
+	}
+	for i := range sum {
+		sum[i] /= float64(n)
+	}
+	return sum
+}
+
+// simulateCVD applies a dichromacy matrix to an sRGB color (0..255 per
+// channel) and returns the result in CIELAB.
+func simulateCVD(m [9]float64, c [3]float64) [3]float64 {
+	var lin [3]float64
+	for i, v := range c {
+		lin[i] = srgbToLinear(v / 255)
+	}
+	var s [3]float64
+	for i := range s {
+		s[i] = math.Max(0, math.Min(1, m[3*i]*lin[0]+m[3*i+1]*lin[1]+m[3*i+2]*lin[2]))
+	}
+	return linearToLab(s)
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToLab converts linear sRGB to CIELAB under D65.
+func linearToLab(c [3]float64) [3]float64 {
+	x := (0.4124*c[0] + 0.3576*c[1] + 0.1805*c[2]) / 0.95047
+	y := 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+	z := (0.0193*c[0] + 0.1192*c[1] + 0.9505*c[2]) / 1.08883
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// labDistance is the CIE76 color difference of two CIELAB colors.
+func labDistance(a, b [3]float64) float64 {
+	return math.Sqrt((a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2]))
+}
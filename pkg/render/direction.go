@@ -0,0 +1,30 @@
+package render
+
+import "fmt"
+
+// TextDirection is the writing mode of the mark text.
+type TextDirection string
+
+const (
+	// TextDirectionHorizontal sets lines left to right, one below the
+	// other.
+	TextDirectionHorizontal TextDirection = ""
+	// TextDirectionVertical sets each line as a column read top to bottom,
+	// with the columns from right to left, as Chinese and Japanese seals
+	// and captions are written. Glyphs stay upright; the font's vertical
+	// forms are used for punctuation and brackets, e.g. "。" and "「".
+	// Align places shorter columns at the top, middle or bottom.
+	TextDirectionVertical TextDirection = "vertical"
+)
+
+// ParseTextDirection parses "vertical", or "horizontal" or "" for
+// horizontal.
+func ParseTextDirection(s string) (TextDirection, error) {
+	switch d := TextDirection(s); d {
+	case TextDirectionHorizontal, TextDirectionVertical:
+		return d, nil
+	case "horizontal":
+		return TextDirectionHorizontal, nil
+	}
+	return "", fmt.Errorf("invalid text direction %q, want horizontal or vertical", s)
+}
@@ -0,0 +1,11 @@
+// Package render draws the marks watermark lays out: text through
+// TextRenderer, with shaping, fallback fonts, variable font axes, color
+// glyphs, case transforms and vertical writing, and images, SVG documents
+// and QR codes through their own MarkRenderers. It also loads and caches
+// the fonts they use, parses the colors and palettes they draw with,
+// varies them per input with ColorJitter, and checks with LowContrast that
+// a mark still stands out for color-blind viewers.
+//
+// Where the marks go on an image is left to the caller, so it depends on
+// no other package of this module but internal/parallel.
+package render
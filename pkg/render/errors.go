@@ -0,0 +1,14 @@
+package render
+
+import "errors"
+
+// Sentinel errors returned (possibly wrapped) by this package. Use errors.Is
+// to test for them.
+var (
+	// ErrFontLoad means a font file could not be read or parsed.
+	ErrFontLoad = errors.New("cannot load font")
+	// ErrEmptyMark means the text of a mark is empty or renders no pixels.
+	ErrEmptyMark = errors.New("empty watermark")
+	// ErrInvalidOpacity means an opacity outside [0, 1] was given.
+	ErrInvalidOpacity = errors.New("opacity must be between 0 and 1")
+)
@@ -0,0 +1,137 @@
+package render
+
+import (
+	"image"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// fallbackFace draws each rune with the first of its faces that has a glyph
+// for it, so text mixing scripts need not come from one font. Runes no face
+// has are left to the first face, as are the metrics.
+type fallbackFace struct {
+	faces []font.Face
+}
+
+// WithFallbacks returns face followed by the faces of fallbacks at size, or
+// face itself without fallbacks.
+func WithFallbacks(face font.Face, fallbacks []*Font, size int) (font.Face, error) {
+	if len(fallbacks) == 0 {
+		return face, nil
+	}
+	faces := []font.Face{face}
+	for _, f := range fallbacks {
+		// Fallback faces are not cached, so they need no lock; a face only
+		// rasterizes on demand, so making one is cheap.
+		ff, err := newFontFace(f.font, size)
+		if err != nil {
+			return nil, err
+		}
+		faces = append(faces, ff)
+	}
+	return fallbackFace{faces: faces}, nil
+}
+
+func (f fallbackFace) pick(r rune) font.Face {
+	for _, face := range f.faces {
+		if _, ok := face.GlyphAdvance(r); ok {
+			return face
+		}
+	}
+	return f.faces[0]
+}
+
+func (f fallbackFace) Close() error {
+	var first error
+	for _, face := range f.faces {
+		if err := face.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (f fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.pick(r).Glyph(dot, r)
+}
+
+func (f fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.pick(r).GlyphBounds(r)
+}
+
+func (f fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.pick(r).GlyphAdvance(r)
+}
+
+// Kern kerns only runes drawn from the same face.
+func (f fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	face := f.pick(r0)
+	if face != f.pick(r1) {
+		return 0
+	}
+	return face.Kern(r0, r1)
+}
+
+func (f fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
+
+// glyphFont returns the first of fnt and fallbacks that has a glyph for r,
+// and the glyph. Runes none has get the missing glyph of fnt.
+func glyphFont(fnt *opentype.Font, fallbacks []*Font, buf *sfnt.Buffer, r rune) (*opentype.Font, sfnt.GlyphIndex, error) {
+	idx, err := fnt.GlyphIndex(buf, r)
+	if err != nil || idx != 0 {
+		return fnt, idx, err
+	}
+	for _, f := range fallbacks {
+		if i, err := f.font.GlyphIndex(buf, r); err == nil && i != 0 {
+			return f.font, i, nil
+		}
+	}
+	return fnt, 0, nil
+}
+
+// defaultIgnorable holds the Unicode Default_Ignorable_Code_Point runes:
+// joiners, direction marks, variation selectors and the like, which never
+// show. Fonts that lack them would draw their missing glyph, a box, so
+// text drawn rune by rune skips them. Fingerprints hide in them.
+var defaultIgnorable = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00ad, Hi: 0x00ad, Stride: 1},
+		{Lo: 0x034f, Hi: 0x034f, Stride: 1},
+		{Lo: 0x061c, Hi: 0x061c, Stride: 1},
+		{Lo: 0x115f, Hi: 0x1160, Stride: 1},
+		{Lo: 0x17b4, Hi: 0x17b5, Stride: 1},
+		{Lo: 0x180b, Hi: 0x180f, Stride: 1},
+		{Lo: 0x200b, Hi: 0x200f, Stride: 1},
+		{Lo: 0x202a, Hi: 0x202e, Stride: 1},
+		{Lo: 0x2060, Hi: 0x206f, Stride: 1},
+		{Lo: 0x3164, Hi: 0x3164, Stride: 1},
+		{Lo: 0xfe00, Hi: 0xfe0f, Stride: 1},
+		{Lo: 0xfeff, Hi: 0xfeff, Stride: 1},
+		{Lo: 0xffa0, Hi: 0xffa0, Stride: 1},
+		{Lo: 0xfff0, Hi: 0xfff8, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1bca0, Hi: 0x1bca3, Stride: 1},
+		{Lo: 0x1d173, Hi: 0x1d17a, Stride: 1},
+		{Lo: 0xe0000, Hi: 0xe0fff, Stride: 1},
+	},
+}
+
+// LoadFonts returns the fonts at paths.
+func LoadFonts(paths []string) ([]*Font, error) {
+	fonts := make([]*Font, 0, len(paths))
+	for _, p := range paths {
+		f, err := LoadFont(p)
+		if err != nil {
+			return nil, err
+		}
+		fonts = append(fonts, f)
+	}
+	return fonts, nil
+}
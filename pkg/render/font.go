@@ -0,0 +1,77 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+)
+
+// defaultFont is the font drawn with when no font path is given; see
+// SetDefaultFont.
+var defaultFont []byte
+
+// SetDefaultFont makes data, a TrueType or OpenType font, the font used
+// whenever no font path is given, e.g. one compiled into the binary. Call
+// it before rendering, such as from an init function.
+func SetDefaultFont(data []byte) {
+	defaultFont = data
+}
+
+// HasDefaultFont reports whether SetDefaultFont set a font.
+func HasDefaultFont() bool {
+	return len(defaultFont) > 0
+}
+
+// LoadFace returns the cached face of the font at path at size, as
+// LoadFont finds it. Faces are not safe for concurrent use: hold its lock
+// while using it.
+func LoadFace(path string, size int) (*SharedFace, error) {
+	f, err := LoadFont(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Face(size)
+}
+
+// LoadFont returns the cached font at path, reading and parsing it on
+// first use. A path of a font collection can select a member by index or
+// name after a '#', as in "msgothic.ttc#1"; a path from Font.Path stands
+// for that font. An empty path means the default font, if one was set.
+func LoadFont(path string) (*Font, error) {
+	if strings.TrimSpace(path) == "" {
+		if HasDefaultFont() {
+			return BuiltinFont("default font", defaultFont)
+		}
+		return nil, fmt.Errorf("%w: font path is required", ErrFontLoad)
+	}
+	if isFontBytesPath(path) {
+		return loadedFont(path)
+	}
+	return cachedFontFile(path, path, func() ([]byte, error) {
+		file, selector := splitCollectionPath(path)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFontLoad, err)
+		}
+		return collectionMember(data, selector, file)
+	})
+}
+
+func parseFont(data []byte, name string) (*opentype.Font, error) {
+	fnt, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrFontLoad, name, err)
+	}
+	return fnt, nil
+}
+
+func newFontFace(fnt *opentype.Font, size int) (font.Face, error) {
+	return opentype.NewFace(fnt, &opentype.FaceOptions{
+		Size:    float64(size),
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
@@ -0,0 +1,81 @@
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// fontBytesPrefix starts the path that stands for a font loaded from bytes
+// wherever fonts are named by path, such as TextRenderer.FontPath.
+// It is followed by a hash of the data.
+const fontBytesPrefix = "\x00font:"
+
+// loadedFonts holds every font loaded from bytes by its path. Unlike the
+// font cache it never drops one, since options and renderers refer to them
+// by path.
+var loadedFonts sync.Map // of string to *Font
+
+// LoadFontFromBytes parses data, a TrueType or OpenType font. Loading the
+// same data again returns the same font without parsing it twice. A loaded
+// font stays in memory for the life of the process. From a font collection
+// it loads the first font.
+func LoadFontFromBytes(data []byte) (*Font, error) {
+	sum := sha256.Sum256(data)
+	path := fontBytesPrefix + hex.EncodeToString(sum[:16])
+	if f, ok := loadedFonts.Load(path); ok {
+		return f.(*Font), nil
+	}
+	// The parsed font reads from its data as it goes, so it gets a copy the
+	// caller cannot change.
+	data, err := collectionMember(append([]byte(nil), data...), "", "font data")
+	if err != nil {
+		return nil, err
+	}
+	fnt, err := parseFont(data, "font data")
+	if err != nil {
+		return nil, err
+	}
+	f, _ := loadedFonts.LoadOrStore(path, &Font{key: path, data: data, font: fnt})
+	return f.(*Font), nil
+}
+
+// Path returns the path that stands for f, which LoadFont and the Font
+// fields of renderers accept: the file it was read from, or a name for a
+// font loaded from bytes or built in.
+func (f *Font) Path() string {
+	if f == nil {
+		return ""
+	}
+	return f.key
+}
+
+// Data returns the file bytes of f. They must not be modified.
+func (f *Font) Data() []byte {
+	return f.data
+}
+
+// isFontBytesPath reports whether path stands for a font loaded from bytes.
+func isFontBytesPath(path string) bool {
+	return strings.HasPrefix(path, fontBytesPrefix)
+}
+
+// loadedFont returns the font loaded from bytes that path stands for.
+func loadedFont(path string) (*Font, error) {
+	f, ok := loadedFonts.Load(path)
+	if !ok {
+		return nil, fmt.Errorf("%w: font data was never loaded", ErrFontLoad)
+	}
+	return f.(*Font), nil
+}
+
+// FontName returns path, or a description of the font for fonts loaded from
+// bytes, for messages.
+func FontName(path string) string {
+	if isFontBytesPath(path) {
+		return "font data"
+	}
+	return path
+}
@@ -0,0 +1,173 @@
+package render
+
+import (
+	"container/list"
+	"sync"
+
+	gotext "github.com/go-text/typesetting/font"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+)
+
+// DefaultFontCacheSize is how many fonts and faces are cached until
+// SetFontCacheSize says otherwise.
+const DefaultFontCacheSize = 64
+
+// fonts caches parsed font files by path and their faces by path and size,
+// so marking many images does not parse the same font for each.
+var fonts = newFontCache(DefaultFontCacheSize)
+
+// ClearFontCache drops every cached font and face, e.g. after font files
+// were replaced on disk; the cache does not notice that by itself. Fonts
+// loaded from bytes stay loaded.
+func ClearFontCache() {
+	fonts.resize(-1)
+}
+
+// SetFontCacheSize caps the font cache at n entries, each a parsed font
+// file or a face of one at one size, dropping the least recently used
+// ones beyond that. 0 turns caching off.
+func SetFontCacheSize(n int) {
+	fonts.resize(max(n, 0))
+}
+
+// Font is a font file and its parse. The parsed font is safe for
+// concurrent use.
+type Font struct {
+	// key identifies the font in the cache: its path, or a NUL-prefixed
+	// name for built-in fonts.
+	key  string
+	data []byte
+	font *opentype.Font
+
+	shapeOnce sync.Once
+	shapeFont *gotext.Font
+	shapeErr  error
+
+	colorOnce   sync.Once
+	colorTables *colorFont
+}
+
+// SharedFace is a cached face. Faces are not safe for concurrent use, so
+// callers hold the lock while they use it.
+type SharedFace struct {
+	sync.Mutex
+	font.Face
+}
+
+type fontKey struct {
+	font string
+	// size is the face size, or 0 for the font itself.
+	size int
+}
+
+type fontCacheEntry struct {
+	key   fontKey
+	value interface{}
+}
+
+// fontCache is a size-capped LRU cache of *Font and *SharedFace
+// values. It is safe for concurrent use.
+type fontCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List // of *fontCacheEntry, most recently used first
+	entries map[fontKey]*list.Element
+}
+
+func newFontCache(n int) *fontCache {
+	return &fontCache{max: n, order: list.New(), entries: map[fontKey]*list.Element{}}
+}
+
+// resize caps the cache at n entries; n < 0 empties it and keeps the cap.
+func (c *fontCache) resize(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n < 0 {
+		c.order.Init()
+		c.entries = map[fontKey]*list.Element{}
+		return
+	}
+	c.max = n
+	c.trim()
+}
+
+// get returns the value cached under k, or calls load and caches its
+// result. load runs without the lock, so two callers may both load a
+// missing entry; the first to finish is kept.
+func (c *fontCache) get(k fontKey, load func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[k]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*fontCacheEntry).value, nil
+	}
+	c.mu.Unlock()
+
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[k]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*fontCacheEntry).value, nil
+	}
+	if c.max > 0 {
+		c.entries[k] = c.order.PushFront(&fontCacheEntry{key: k, value: v})
+		c.trim()
+	}
+	return v, nil
+}
+
+// trim drops the least recently used entries over the cap. The caller
+// holds the lock.
+func (c *fontCache) trim() {
+	for c.order.Len() > c.max {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.entries, e.Value.(*fontCacheEntry).key)
+	}
+}
+
+// cachedFontFile returns the font under key, calling read for the file on
+// a miss; name identifies the font in errors.
+func cachedFontFile(key, name string, read func() ([]byte, error)) (*Font, error) {
+	v, err := fonts.get(fontKey{font: key}, func() (interface{}, error) {
+		data, err := read()
+		if err != nil {
+			return nil, err
+		}
+		fnt, err := parseFont(data, name)
+		if err != nil {
+			return nil, err
+		}
+		return &Font{key: key, data: data, font: fnt}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Font), nil
+}
+
+// BuiltinFont returns the font compiled into the binary as data.
+func BuiltinFont(name string, data []byte) (*Font, error) {
+	return cachedFontFile("\x00"+name, name, func() ([]byte, error) { return data, nil })
+}
+
+// Face returns the cached face of f at size. Faces are not safe for
+// concurrent use: hold its lock while using it.
+func (f *Font) Face(size int) (*SharedFace, error) {
+	v, err := fonts.get(fontKey{font: f.key, size: size}, func() (interface{}, error) {
+		face, err := newFontFace(f.font, size)
+		if err != nil {
+			return nil, err
+		}
+		return &SharedFace{Face: face}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*SharedFace), nil
+}
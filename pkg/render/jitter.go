@@ -0,0 +1,98 @@
+package render
+
+import (
+	"hash/fnv"
+	"image/color"
+	"math"
+	"math/rand"
+)
+
+// ColorJitter varies the colors of a mark from input to input, so no two
+// outputs carry exactly the same mark: each input gets its own ColorShift,
+// of up to Hue degrees and Lightness, picked from Seed and the input.
+type ColorJitter struct {
+	Hue       float64 // degrees
+	Lightness float64 // HSL lightness, in [0, 1]
+	Seed      int64
+}
+
+// ColorShift moves colors around the hue circle and up or down in
+// lightness. The zero value leaves them as they are.
+type ColorShift struct {
+	Hue, Lightness float64
+}
+
+// Shift picks the shift for the input data, within the jitter amounts. The
+// same seed and data always give the same shift.
+func (j ColorJitter) Shift(data []byte) ColorShift {
+	hash := fnv.New64a()
+	hash.Write(data)
+	rng := rand.New(rand.NewSource(j.Seed ^ int64(hash.Sum64())))
+	return ColorShift{
+		Hue:       (2*rng.Float64() - 1) * j.Hue,
+		Lightness: (2*rng.Float64() - 1) * j.Lightness,
+	}
+}
+
+// Apply returns c shifted, keeping its alpha.
+func (s ColorShift) Apply(c color.NRGBA) color.NRGBA {
+	if s == (ColorShift{}) {
+		return c
+	}
+	h, sat, l := rgbToHSL(c)
+	h = math.Mod(h+s.Hue+360, 360)
+	l = clampFloat(l+s.Lightness, 0, 1)
+	out := hslToRGB(h, sat, l)
+	out.A = c.A
+	return out
+}
+
+// rgbToHSL returns the hue in degrees and the saturation and lightness in
+// [0, 1] of c, ignoring its alpha.
+func rgbToHSL(c color.NRGBA) (h, s, l float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	hi, lo := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l = (hi + lo) / 2
+	d := hi - lo
+	if d == 0 {
+		return 0, 0, l
+	}
+	s = d / (1 - math.Abs(2*l-1))
+	switch hi {
+	case r:
+		h = math.Mod((g-b)/d+6, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return h * 60, s, l
+}
+
+// hslToRGB is the inverse of rgbToHSL, with an opaque alpha.
+func hslToRGB(h, s, l float64) color.NRGBA {
+	ch := (1 - math.Abs(2*l-1)) * s
+	x := ch * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g = ch, x
+	case h < 120:
+		r, g = x, ch
+	case h < 180:
+		g, b = ch, x
+	case h < 240:
+		g, b = x, ch
+	case h < 300:
+		r, b = x, ch
+	default:
+		r, b = ch, x
+	}
+	m := l - ch/2
+	to8 := func(v float64) uint8 { return uint8(clampInt(int(math.Round((v+m)*255)), 0, 255)) }
+	return color.NRGBA{to8(r), to8(g), to8(b), 255}
+}
+
+func clampFloat(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
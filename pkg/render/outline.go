@@ -0,0 +1,459 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// Outline is a line of text as vector contours in pixels, with the
+// origin on the baseline at the start of the line and y pointing down.
+// Glyphs a font draws in color, such as emoji, are kept apart in color.
+type Outline struct {
+	segs   []sfnt.Segment
+	color  []colorGlyph
+	bounds image.Rectangle
+}
+
+// OutlineText lays out text with f at size px, using the same advances and
+// kerning as a HintingFull face. Runes f has no glyph for come from the
+// first of fallbacks that has one. Lines with runes of scripts that need
+// shaping or drawn in color are shaped instead, without small caps, so
+// emoji sequences join. Default-ignorable runes are not drawn. Lines are split at "\n",
+// spaced by lineHeight times the font's line height and aligned with align.
+// The case of the text is changed per tt. With TextDirectionVertical the
+// lines become columns, see verticalOutline. With vars every line is shaped
+// with the fonts set to them.
+func OutlineText(f *Font, fallbacks []*Font, text string, size int, lineHeight float64, align Align, tt TextTransform, dir TextDirection, vars []FontVariation) (*Outline, error) {
+	if err := f.checkVariations(vars); err != nil {
+		return nil, err
+	}
+	fnt := f.font
+	var buf sfnt.Buffer
+	ppem := fixed.I(size)
+	metrics, err := fnt.Metrics(&buf, ppem, font.HintingFull)
+	if err != nil {
+		return nil, err
+	}
+	lineStep := LineAdvance(metrics, lineHeight)
+	text = CaseText(text, tt)
+	fonts := append([]*Font{f}, fallbacks...)
+	if dir == TextDirectionVertical {
+		return verticalOutline(&buf, fonts, strings.Split(text, "\n"), ppem, lineStep, align, vars)
+	}
+	var sc *SmallCaps
+	if tt == TextTransformSmallCaps && len(vars) == 0 {
+		if sc, err = NewSmallCaps(fnt, &buf, text); err != nil {
+			return nil, err
+		}
+		text = sc.Expand(text)
+	}
+
+	lines := strings.Split(text, "\n")
+	laid := make([][]sfnt.Segment, len(lines))
+	colors := make([][]colorGlyph, len(lines))
+	widths := make([]fixed.Int26_6, len(lines))
+	var maxW fixed.Int26_6
+	for i, line := range lines {
+		if NeedsShaping(line) || hasColorGlyphs(fonts, line) || len(vars) > 0 {
+			if laid[i], colors[i], widths[i], err = shapeLine(&buf, fonts, line, ppem, vars); err != nil {
+				return nil, err
+			}
+			if widths[i] > maxW {
+				maxW = widths[i]
+			}
+			continue
+		}
+		var dot fixed.Int26_6
+		prev, prevPPEM, prevFont := sfnt.GlyphIndex(0), ppem, (*opentype.Font)(nil)
+		for _, r := range line {
+			if unicode.Is(defaultIgnorable, r) {
+				continue
+			}
+			idx, set, scale := sc.Glyph(r)
+			gf := fnt
+			if idx == 0 {
+				if gf, idx, err = glyphFont(fnt, fallbacks, &buf, set); err != nil {
+					return nil, err
+				}
+			}
+			gppem := fixed.Int26_6(math.Round(float64(ppem) * scale))
+			// Kerning only applies between glyphs of one font and size.
+			if prevFont == gf && gppem == prevPPEM {
+				if k, err := gf.Kern(&buf, prev, idx, gppem, font.HintingFull); err == nil {
+					dot += k
+				}
+			}
+			segs, err := glyphContours(gf, &buf, idx, gppem)
+			if err != nil {
+				return nil, err
+			}
+			for _, seg := range segs {
+				for j := range seg.Args {
+					seg.Args[j].X += dot
+				}
+				laid[i] = append(laid[i], seg)
+			}
+			adv, err := gf.GlyphAdvance(&buf, idx, gppem, font.HintingFull)
+			if err != nil {
+				return nil, err
+			}
+			dot += adv
+			prev, prevPPEM, prevFont = idx, gppem, gf
+		}
+		widths[i] = dot
+		if dot > maxW {
+			maxW = dot
+		}
+	}
+
+	out := &Outline{}
+	for i, segs := range laid {
+		dx, dy := align.Offset(maxW, widths[i]), lineStep*fixed.Int26_6(i)
+		for _, seg := range segs {
+			for j := range seg.Args {
+				seg.Args[j].X += dx
+				seg.Args[j].Y += dy
+			}
+			out.segs = append(out.segs, seg)
+		}
+		for _, g := range colors[i] {
+			g.translate(fixed.Point26_6{X: dx, Y: dy})
+			out.color = append(out.color, g)
+		}
+	}
+	out.bounds = out.glyphBounds()
+	return out, nil
+}
+
+// verticalOutline sets each of lines as a column, top to bottom and
+// without small caps, the first on the right and the others lineStep apart
+// to its left. Shorter columns are placed along the longest per align:
+// left at the top, right at the bottom. The origin is at the top of the
+// first column on its center line.
+func verticalOutline(buf *sfnt.Buffer, fonts []*Font, lines []string, ppem, lineStep fixed.Int26_6, align Align, vars []FontVariation) (*Outline, error) {
+	segs := make([][]sfnt.Segment, len(lines))
+	colors := make([][]colorGlyph, len(lines))
+	lengths := make([]fixed.Int26_6, len(lines))
+	var maxL fixed.Int26_6
+	for i, line := range lines {
+		var err error
+		if segs[i], colors[i], lengths[i], err = shapeColumn(buf, fonts, line, ppem, vars); err != nil {
+			return nil, err
+		}
+		if lengths[i] > maxL {
+			maxL = lengths[i]
+		}
+	}
+	out := &Outline{}
+	for i := range lines {
+		d := fixed.Point26_6{X: -lineStep * fixed.Int26_6(i), Y: align.Offset(maxL, lengths[i])}
+		translateSegments(segs[i], d)
+		out.segs = append(out.segs, segs[i]...)
+		for _, g := range colors[i] {
+			g.translate(d)
+			out.color = append(out.color, g)
+		}
+	}
+	out.bounds = out.glyphBounds()
+	return out, nil
+}
+
+// Bounds returns the pixels o covers, relative to its origin.
+func (o *Outline) Bounds() image.Rectangle {
+	return o.bounds
+}
+
+// HasColor reports whether o holds glyphs the font draws in color.
+func (o *Outline) HasColor() bool {
+	return len(o.color) > 0
+}
+
+// glyphBounds returns the pixels the contours and color glyphs of o cover.
+func (o *Outline) glyphBounds() image.Rectangle {
+	b := sfnt.Segments(o.segs).Bounds()
+	r := image.Rect(b.Min.X.Floor(), b.Min.Y.Floor(), b.Max.X.Ceil(), b.Max.Y.Ceil())
+	for _, g := range o.color {
+		r = r.Union(g.bounds())
+	}
+	return r
+}
+
+// Rotate returns o turned counter-clockwise by deg degrees about the center
+// of its bounds. Glyph curves stay exact since only the control points move.
+func (o *Outline) Rotate(deg float64) *Outline {
+	sin, cos := math.Sincos(deg * math.Pi / 180)
+	cx := float64(o.bounds.Min.X+o.bounds.Max.X) / 2 * 64
+	cy := float64(o.bounds.Min.Y+o.bounds.Max.Y) / 2 * 64
+	turn := func(p fixed.Point26_6) fixed.Point26_6 {
+		x, y := float64(p.X)-cx, float64(p.Y)-cy
+		// Y points down, so a visual counter-clockwise turn is
+		// (x, y) -> (x cos + y sin, y cos - x sin).
+		return fixed.Point26_6{
+			X: fixed.Int26_6(math.Round(x*cos + y*sin + cx)),
+			Y: fixed.Int26_6(math.Round(y*cos - x*sin + cy)),
+		}
+	}
+	out := &Outline{segs: make([]sfnt.Segment, len(o.segs))}
+	for i, seg := range o.segs {
+		for j := range seg.Args {
+			seg.Args[j] = turn(seg.Args[j])
+		}
+		out.segs[i] = seg
+	}
+	for _, g := range o.color {
+		out.color = append(out.color, g.rotate(deg, turn))
+	}
+	out.bounds = out.glyphBounds()
+	return out
+}
+
+// Stroke describes the outline drawn around text, Width pixels wide. Dash
+// alternates on and off lengths in pixels; an empty dash means a solid
+// stroke.
+type Stroke struct {
+	Width float64
+	Dash  []float64
+}
+
+// Shadow is a drop shadow of the glyph fill, offset by DX, DY and
+// blurred with a Gaussian of sigma Blur.
+type Shadow struct {
+	DX, DY int
+	Blur   float64
+	Color  color.NRGBA
+}
+
+// DrawOutlinedText draws o with its origin at dot: the shadow, then the
+// stroke in outline, then the glyph fill on top. Color glyphs come last,
+// unstroked, at the alpha of fill.
+func DrawOutlinedText(dst *image.NRGBA, o *Outline, dot image.Point, fill, outline color.NRGBA, stroke Stroke, shadow *Shadow) {
+	if shadow != nil && shadow.Color.A > 0 {
+		drawShadow(dst, o, dot, *shadow)
+	}
+	if stroke.Width > 0 && outline.A > 0 {
+		pad := int(math.Ceil(stroke.Width/2)) + 1
+		r := o.bounds.Inset(-pad)
+		z := vector.NewRasterizer(r.Dx(), r.Dy())
+		off := [2]float32{float32(-r.Min.X), float32(-r.Min.Y)}
+		for _, line := range dashLines(flattenContours(o.segs, off), stroke.Dash) {
+			strokePolyline(z, line, float32(stroke.Width/2))
+		}
+		drawMask(dst, z, r.Add(dot), outline)
+	}
+	r := o.bounds
+	z := vector.NewRasterizer(r.Dx(), r.Dy())
+	fillContours(z, o.segs, [2]float32{float32(-r.Min.X), float32(-r.Min.Y)})
+	drawMask(dst, z, r.Add(dot), fill)
+	for _, g := range o.color {
+		g.draw(dst, dot, fill)
+	}
+}
+
+func drawShadow(dst *image.NRGBA, o *Outline, dot image.Point, sh Shadow) {
+	pad := int(math.Ceil(3 * sh.Blur))
+	r := o.bounds.Inset(-pad)
+	z := vector.NewRasterizer(r.Dx(), r.Dy())
+	off := [2]float32{float32(-r.Min.X), float32(-r.Min.Y)}
+	fillContours(z, o.segs, off)
+	for _, g := range o.color {
+		for _, l := range g.layers {
+			fillContours(z, l.segs, off)
+		}
+	}
+	layer := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	drawMask(layer, z, layer.Bounds(), sh.Color)
+	for _, g := range o.color {
+		if g.img != nil {
+			draw.DrawMask(layer, g.bounds().Sub(r.Min), image.NewUniform(sh.Color), image.Point{}, g.img, image.Point{}, draw.Over)
+		}
+	}
+	var shadow image.Image = layer
+	if sh.Blur > 0 {
+		shadow = imaging.Blur(layer, sh.Blur)
+	}
+	draw.Draw(dst, r.Add(dot).Add(image.Pt(sh.DX, sh.DY)), shadow, image.Point{}, draw.Over)
+}
+
+// drawMask composites col through the coverage accumulated in z onto dst at
+// r, which has z's size.
+func drawMask(dst *image.NRGBA, z *vector.Rasterizer, r image.Rectangle, col color.NRGBA) {
+	mask := image.NewAlpha(image.Rect(0, 0, r.Dx(), r.Dy()))
+	z.DrawOp = draw.Src
+	z.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+	draw.DrawMask(dst, r, image.NewUniform(col), image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+func fixedToF32(v fixed.Int26_6) float32 {
+	return float32(v) / 64
+}
+
+func fillContours(z *vector.Rasterizer, segs []sfnt.Segment, off [2]float32) {
+	pt := func(p fixed.Point26_6) (float32, float32) {
+		return fixedToF32(p.X) + off[0], fixedToF32(p.Y) + off[1]
+	}
+	for _, seg := range segs {
+		switch seg.Op {
+		case sfnt.SegmentOpMoveTo:
+			z.ClosePath()
+			z.MoveTo(pt(seg.Args[0]))
+		case sfnt.SegmentOpLineTo:
+			z.LineTo(pt(seg.Args[0]))
+		case sfnt.SegmentOpQuadTo:
+			bx, by := pt(seg.Args[0])
+			cx, cy := pt(seg.Args[1])
+			z.QuadTo(bx, by, cx, cy)
+		case sfnt.SegmentOpCubeTo:
+			bx, by := pt(seg.Args[0])
+			cx, cy := pt(seg.Args[1])
+			dx, dy := pt(seg.Args[2])
+			z.CubeTo(bx, by, cx, cy, dx, dy)
+		}
+	}
+	z.ClosePath()
+}
+
+type point32 struct{ x, y float32 }
+
+// flattenContours turns glyph contours into closed polylines; the first
+// point of each is repeated at the end.
+func flattenContours(segs []sfnt.Segment, off [2]float32) [][]point32 {
+	pt := func(p fixed.Point26_6) point32 {
+		return point32{fixedToF32(p.X) + off[0], fixedToF32(p.Y) + off[1]}
+	}
+	var lines [][]point32
+	var cur []point32
+	closeCur := func() {
+		if len(cur) > 1 {
+			lines = append(lines, append(cur, cur[0]))
+		}
+		cur = nil
+	}
+	for _, seg := range segs {
+		switch seg.Op {
+		case sfnt.SegmentOpMoveTo:
+			closeCur()
+			cur = []point32{pt(seg.Args[0])}
+		case sfnt.SegmentOpLineTo:
+			cur = append(cur, pt(seg.Args[0]))
+		case sfnt.SegmentOpQuadTo:
+			a, b, c := cur[len(cur)-1], pt(seg.Args[0]), pt(seg.Args[1])
+			n := curveSteps(a, b, c)
+			for i := 1; i <= n; i++ {
+				t := float32(i) / float32(n)
+				u := 1 - t
+				cur = append(cur, point32{
+					u*u*a.x + 2*u*t*b.x + t*t*c.x,
+					u*u*a.y + 2*u*t*b.y + t*t*c.y,
+				})
+			}
+		case sfnt.SegmentOpCubeTo:
+			a, b, c, d := cur[len(cur)-1], pt(seg.Args[0]), pt(seg.Args[1]), pt(seg.Args[2])
+			n := curveSteps(a, b, c, d)
+			for i := 1; i <= n; i++ {
+				t := float32(i) / float32(n)
+				u := 1 - t
+				cur = append(cur, point32{
+					u*u*u*a.x + 3*u*u*t*b.x + 3*u*t*t*c.x + t*t*t*d.x,
+					u*u*u*a.y + 3*u*u*t*b.y + 3*u*t*t*c.y + t*t*t*d.y,
+				})
+			}
+		}
+	}
+	closeCur()
+	return lines
+}
+
+// curveSteps picks a segment count from the control polygon length, about
+// one segment per two pixels.
+func curveSteps(pts ...point32) int {
+	var l float32
+	for i := 1; i < len(pts); i++ {
+		l += dist32(pts[i-1], pts[i])
+	}
+	return clampInt(int(l/2), 2, 64)
+}
+
+func dist32(a, b point32) float32 {
+	return float32(math.Hypot(float64(b.x-a.x), float64(b.y-a.y)))
+}
+
+// dashLines splits polylines into the "on" runs of the dash pattern. An odd
+// pattern is repeated once, as in SVG.
+func dashLines(lines [][]point32, dash []float64) [][]point32 {
+	if len(dash) == 0 {
+		return lines
+	}
+	if len(dash)%2 == 1 {
+		dash = append(append([]float64{}, dash...), dash...)
+	}
+	var out [][]point32
+	for _, line := range lines {
+		i, left, on := 0, float32(dash[0]), true
+		cur := []point32{line[0]}
+		for k := 1; k < len(line); k++ {
+			a, b := line[k-1], line[k]
+			segLen := dist32(a, b)
+			for segLen > left {
+				t := left / segLen
+				p := point32{a.x + (b.x-a.x)*t, a.y + (b.y-a.y)*t}
+				if on {
+					out = append(out, append(cur, p))
+				}
+				cur = []point32{p}
+				a, segLen = p, segLen-left
+				i = (i + 1) % len(dash)
+				left, on = float32(dash[i]), !on
+			}
+			left -= segLen
+			cur = append(cur, b)
+		}
+		if on && len(cur) > 1 {
+			out = append(out, cur)
+		}
+	}
+	return out
+}
+
+// strokePolyline adds a round-joined stroke of half-width hw along line.
+// Every piece is wound the same way so overlaps add up instead of cancelling.
+func strokePolyline(z *vector.Rasterizer, line []point32, hw float32) {
+	for k, p := range line {
+		strokeDisc(z, p, hw)
+		if k == 0 {
+			continue
+		}
+		a := line[k-1]
+		l := dist32(a, p)
+		if l == 0 {
+			continue
+		}
+		nx, ny := -(p.y-a.y)/l*hw, (p.x-a.x)/l*hw
+		z.MoveTo(a.x+nx, a.y+ny)
+		z.LineTo(p.x+nx, p.y+ny)
+		z.LineTo(p.x-nx, p.y-ny)
+		z.LineTo(a.x-nx, a.y-ny)
+		z.ClosePath()
+	}
+}
+
+func strokeDisc(z *vector.Rasterizer, c point32, r float32) {
+	const n = 16
+	z.MoveTo(c.x+r, c.y)
+	for i := 1; i < n; i++ {
+		// Negative angles match the winding of the segment quads.
+		a := -2 * math.Pi * float64(i) / n
+		z.LineTo(c.x+r*float32(math.Cos(a)), c.y+r*float32(math.Sin(a)))
+	}
+	z.ClosePath()
+}
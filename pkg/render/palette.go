@@ -0,0 +1,88 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PaletteColor is one named color of a palette.
+type PaletteColor struct {
+	Name string
+	Hex  string
+}
+
+// palettes are color sets chosen to stay distinguishable under the common
+// color-vision deficiencies (protanopia, deuteranopia, tritanopia).
+var palettes = map[string][]PaletteColor{
+	// Okabe & Ito, "Color Universal Design" (2002).
+	"okabe-ito": {
+		{"black", "#000000"},
+		{"orange", "#E69F00"},
+		{"sky-blue", "#56B4E9"},
+		{"bluish-green", "#009E73"},
+		{"yellow", "#F0E442"},
+		{"blue", "#0072B2"},
+		{"vermillion", "#D55E00"},
+		{"reddish-purple", "#CC79A7"},
+	},
+	// Paul Tol's bright qualitative scheme.
+	"tol-bright": {
+		{"blue", "#4477AA"},
+		{"cyan", "#66CCEE"},
+		{"green", "#228833"},
+		{"yellow", "#CCBB44"},
+		{"red", "#EE6677"},
+		{"purple", "#AA3377"},
+		{"grey", "#BBBBBB"},
+	},
+	// Paul Tol's high-contrast scheme, also legible in grayscale.
+	"tol-high-contrast": {
+		{"white", "#FFFFFF"},
+		{"yellow", "#DDAA33"},
+		{"red", "#BB5566"},
+		{"blue", "#004488"},
+		{"black", "#000000"},
+	},
+	// IBM Design Library accessible palette.
+	"ibm": {
+		{"blue", "#648FFF"},
+		{"purple", "#785EF0"},
+		{"magenta", "#DC267F"},
+		{"orange", "#FE6100"},
+		{"yellow", "#FFB000"},
+	},
+}
+
+// PaletteNames returns the names of the built-in color-blind safe palettes.
+// Any option taking a hex color also accepts "palette:color", e.g.
+// "okabe-ito:vermillion".
+func PaletteNames() []string {
+	names := make([]string, 0, len(palettes))
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Palette returns the colors of a built-in palette.
+func Palette(name string) ([]PaletteColor, bool) {
+	p, ok := palettes[strings.ToLower(name)]
+	return append([]PaletteColor(nil), p...), ok
+}
+
+// paletteHex resolves a "palette:color" reference to its hex value.
+func paletteHex(ref string) (string, error) {
+	name, col, _ := strings.Cut(strings.ToLower(ref), ":")
+	p, ok := palettes[name]
+	if !ok {
+		return "", fmt.Errorf("unknown palette %q", name)
+	}
+	for _, c := range p {
+		if c.Name == col {
+			return c.Hex, nil
+		}
+	}
+	return "", fmt.Errorf("palette %q has no color %q", name, col)
+}
@@ -0,0 +1,492 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// QRLevel is a QR code error-correction level. Higher levels survive more
+// damage, such as a watermark partly covered or cropped, at the cost of a
+// denser code.
+type QRLevel string
+
+const (
+	QRLevelL QRLevel = "L" // about 7% of codewords can be restored
+	QRLevelM QRLevel = "M" // about 15%
+	QRLevelQ QRLevel = "Q" // about 25%
+	QRLevelH QRLevel = "H" // about 30%
+)
+
+// ParseQRLevel parses "L", "M", "Q" or "H", in either case.
+func ParseQRLevel(s string) (QRLevel, error) {
+	l := QRLevel(strings.ToUpper(strings.TrimSpace(s)))
+	if _, ok := qrLevelIndex[l]; !ok {
+		return "", fmt.Errorf("invalid QR error-correction level %q: want L, M, Q or H", s)
+	}
+	return l, nil
+}
+
+// qrLevelIndex maps levels to rows of the capacity tables below.
+var qrLevelIndex = map[QRLevel]int{QRLevelL: 0, QRLevelM: 1, QRLevelQ: 2, QRLevelH: 3}
+
+// qrFormatBits are the two bits each level contributes to the format word.
+var qrFormatBits = [4]int{1, 0, 3, 2}
+
+// qrECCPerBlock and qrBlocks give, per level and version, the number of
+// error-correction codewords in each block and the number of blocks
+// (ISO/IEC 18004 table 9). Index 0 is unused.
+var qrECCPerBlock = [4][41]int{
+	{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var qrBlocks = [4][41]int{
+	{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// errQRTooLong means a payload does not fit even a version 40 code.
+var errQRTooLong = errors.New("QR payload too long")
+
+// qrCode is a square grid of modules, true for dark.
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // finder, timing, alignment, format and version modules
+}
+
+// encodeQR encodes payload in byte mode at the smallest version that fits,
+// with the mask that scores the lowest penalty.
+func encodeQR(payload string, level QRLevel) (*qrCode, error) {
+	li, ok := qrLevelIndex[level]
+	if !ok {
+		return nil, fmt.Errorf("invalid QR error-correction level %q", level)
+	}
+	data := []byte(payload)
+	version := 0
+	for v := 1; v <= 40; v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*qrDataCodewords(v, li) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("%w: %d bytes", errQRTooLong, len(data))
+	}
+
+	// Mode indicator, character count, data, terminator and padding.
+	var bits qrBits
+	bits.append(0x4, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := 8 * qrDataCodewords(version, li)
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			codewords[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	q := newQRCode(version)
+	q.drawCodewords(qrAddECC(codewords, version, li))
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(li, mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // XOR again to undo
+	}
+	q.applyMask(best)
+	q.drawFormat(li, best)
+	return q, nil
+}
+
+// qrBits is a bit buffer, most significant bit first.
+type qrBits []bool
+
+func (b *qrBits) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, v>>i&1 == 1)
+	}
+}
+
+// qrRawModules is the number of modules available for data and error
+// correction in a code of the given version.
+func qrRawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+func qrDataCodewords(version, li int) int {
+	return qrRawModules(version)/8 - qrECCPerBlock[li][version]*qrBlocks[li][version]
+}
+
+// qrAddECC splits data into blocks, appends Reed-Solomon codewords to each
+// and interleaves the result.
+func qrAddECC(data []byte, version, li int) []byte {
+	numBlocks := qrBlocks[li][version]
+	eccLen := qrECCPerBlock[li][version]
+	raw := qrRawModules(version) / 8
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range blocks {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		dat := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(dat, divisor)
+		if i < numShort {
+			dat = append(dat, 0) // placeholder, skipped when interleaving
+		}
+		blocks[i] = append(dat, ecc...)
+	}
+	out := make([]byte, 0, raw)
+	for i := range blocks[0] {
+		for j, b := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				out = append(out, b[i])
+			}
+		}
+	}
+	return out
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given
+// degree, highest coefficient first, without the leading 1.
+func rsDivisor(degree int) []byte {
+	div := make([]byte, degree)
+	div[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range div {
+			div[j] = gfMul(div[j], root)
+			if j+1 < len(div) {
+				div[j] ^= div[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return div
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	rem := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i, d := range divisor {
+			rem[i] ^= gfMul(d, factor)
+		}
+	}
+	return rem
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// newQRCode returns a code of the given version with its function patterns
+// drawn and the format area reserved.
+func newQRCode(version int) *qrCode {
+	size := 4*version + 17
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					d := max(absInt(dx), absInt(dy))
+					q.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	align := qrAlignmentPositions(version)
+	for i, ay := range align {
+		for j, ax := range align {
+			last := len(align) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(ax+dx, ay+dy, max(absInt(dx), absInt(dy)) != 1)
+				}
+			}
+		}
+	}
+	q.drawFormat(0, 0) // reserve; redrawn once the mask is chosen
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			q.set(a, b, bits>>i&1 == 1)
+			q.set(b, a, bits>>i&1 == 1)
+		}
+	}
+	return q
+}
+
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*4 + n*2 + 1) / (n*2 - 2) * 2
+	if version == 32 {
+		step = 26
+	}
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, 4*version+17-7; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// set draws a function module at column x, row y.
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *qrCode) drawFormat(li, mask int) {
+	data := qrFormatBits[li]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// drawCodewords fills the non-function modules in the zigzag order of the
+// standard, two columns at a time from the bottom right.
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = data[i>>3]>>(7-i&7)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the current modules by the four mask evaluation rules of
+// the standard; lower is better.
+func (q *qrCode) penalty() int {
+	n := q.size
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+	finder := []bool{true, false, true, true, true, false, true}
+	score := 0
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			// Rule 1: runs of five or more modules of one color.
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+			// Rule 3: finder-like 1:1:3:1:1 patterns with four light modules
+			// on one side; modules outside the code count as light.
+			light := func(x int) bool { return x < 0 || x >= n || !at(x, y, vertical) }
+			for x := 0; x+7 <= n; x++ {
+				match := true
+				for k, d := range finder {
+					if at(x+k, y, vertical) != d {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				before, after := true, true
+				for k := 1; k <= 4; k++ {
+					before = before && light(x-k)
+					after = after && light(x+6+k)
+				}
+				if before {
+					score += 40
+				}
+				if after {
+					score += 40
+				}
+			}
+		}
+	}
+	// Rule 2: 2×2 blocks of one color.
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < n && y+1 < n {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	// Rule 4: deviation of the dark share from 50%, in 5% steps.
+	total := n * n
+	k := (absInt(dark*20-total*10)+total-1)/total - 1
+	return score + max(k, 0)*10
+}
+
+// image renders the code with scale pixels per module and a quiet zone of
+// quiet modules on each side.
+func (q *qrCode) image(scale, quiet int, dark, light color.NRGBA) *image.NRGBA {
+	side := (q.size + 2*quiet) * scale
+	img := image.NewNRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(img, img.Bounds(), image.NewUniform(light), image.Point{}, draw.Src)
+	fg := image.NewUniform(dark)
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				px, py := (x+quiet)*scale, (y+quiet)*scale
+				r := image.Rect(px, py, px+scale, py+scale)
+				draw.Draw(img, r, fg, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return img
+}
+
+// qrSizeRatio is the automatic QR code size relative to the shorter image
+// side.
+const qrSizeRatio = 0.2
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
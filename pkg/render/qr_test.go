@@ -0,0 +1,59 @@
+package render
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestQRAddECC(t *testing.T) {
+	// The 1-M example of ISO/IEC 18004 Annex I: "01234567" in numeric mode.
+	data := []byte{0x10, 0x20, 0x0c, 0x56, 0x61, 0x80, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11}
+	ecc := []byte{0xa5, 0x24, 0xd4, 0xc1, 0xed, 0x36, 0xc7, 0x87, 0x2c, 0x55}
+	got := qrAddECC(data, 1, qrLevelIndex[QRLevelM])
+	if want := append(append([]byte(nil), data...), ecc...); !bytes.Equal(got, want) {
+		t.Errorf("got % x\nwant % x", got, want)
+	}
+}
+
+func TestEncodeQRGolden(t *testing.T) {
+	// "HELLO WORLD" in byte mode as version 1-M; the penalty rules pick
+	// mask 4. Cross-checked with a separate encoder written from the
+	// standard.
+	want := []string{
+		"#######.##..#.#######",
+		"#.....#....#..#.....#",
+		"#.###.#..#.#..#.###.#",
+		"#.###.#.#..#..#.###.#",
+		"#.###.#.###.#.#.###.#",
+		"#.....#.#..#..#.....#",
+		"#######.#.#.#.#######",
+		"........#..##........",
+		"#...#.######.#####..#",
+		"...#....#.###....####",
+		"..######..##.##.#..#.",
+		"#####...##...#.......",
+		"#####.#.#.#.#.##..##.",
+		"........#.#.####.#.##",
+		"#######.###.#.#.##.#.",
+		"#.....#..#.###.##..##",
+		"#.###.#.##.#.##...##.",
+		"#.###.#..#..#...##.##",
+		"#.###.#..###...###...",
+		"#.....#....#.#.......",
+		"#######.#########.#.#",
+	}
+	q, err := encodeQR("HELLO WORLD", QRLevelM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.size != len(want) {
+		t.Fatalf("size %d, want %d", q.size, len(want))
+	}
+	for y, row := range want {
+		for x, c := range row {
+			if q.modules[y][x] != (c == '#') {
+				t.Errorf("module %d,%d is dark=%t", x, y, q.modules[y][x])
+			}
+		}
+	}
+}
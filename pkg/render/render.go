@@ -0,0 +1,258 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// MarkRenderer draws the watermark itself, which a layout then tiles or
+// places. Custom marks such as barcodes or signatures implement it.
+type MarkRenderer interface {
+	// Render returns the mark with opts.Opacity applied. A nil image and nil
+	// error mean the mark has no visible pixels.
+	Render(opts RenderOptions) (image.Image, error)
+}
+
+// RenderOptions is what a MarkRenderer is told about the mark to draw.
+type RenderOptions struct {
+	// Text is the watermark text with template variables filled in.
+	Text string
+	// Bounds are the bounds of the image being marked, for renderers that
+	// size themselves relative to it. It is empty when the mark is rendered
+	// before an image is seen.
+	Bounds image.Rectangle
+	// Opacity is the opacity to render with, in [0, 1].
+	Opacity float64
+	// Workers is how many goroutines a renderer may use for its pixel
+	// loops; 0 means GOMAXPROCS.
+	Workers int
+}
+
+// TextRenderer renders text in one color.
+// Glyphs a font draws in color, such as emoji, keep their own colors.
+type TextRenderer struct {
+	// FontPath is the font file; empty uses the font set by SetDefaultFont.
+	FontPath string
+	// Font, if set, is used instead of FontPath.
+	Font *Font
+	// FontFallbacks are font files to draw the runes the font has no glyph
+	// for, tried in order, e.g. a CJK font after a Latin one.
+	FontFallbacks []string
+	// Variations sets axes of a variable font.
+	Variations []FontVariation
+	// Size is the font size in pixels.
+	Size int
+	// Color is a hex color such as "#4db6ac".
+	Color string
+	// FontHeightCrop stretches the mark vertically to Size*lines*FontHeightCrop
+	// pixels; 0 and 1 keep its natural height.
+	FontHeightCrop float64
+	// LineHeight scales the distance between lines; 0 means 1.
+	LineHeight float64
+	// Align aligns the lines of multi-line text; empty means AlignLeft.
+	Align Align
+	// Transform changes the case of the text as it is drawn.
+	Transform TextTransform
+	// Direction sets the text horizontally or in vertical columns; empty
+	// means TextDirectionHorizontal.
+	Direction TextDirection
+}
+
+// Render implements MarkRenderer.
+func (r TextRenderer) Render(opts RenderOptions) (image.Image, error) {
+	colorVal, err := ParseHexColor(r.Color)
+	if err != nil {
+		return nil, err
+	}
+	var mark image.Image
+	if r.Transform == TextTransformSmallCaps || r.Direction == TextDirectionVertical || len(r.Variations) > 0 || NeedsShaping(opts.Text) || r.hasColorGlyphs(opts.Text) {
+		mark, err = r.drawOutline(opts.Text, colorVal)
+	} else {
+		mark, err = r.drawFace(CaseText(opts.Text, r.Transform), colorVal)
+	}
+	if err != nil || mark == nil {
+		return nil, err
+	}
+
+	hcrop := r.FontHeightCrop
+	if hcrop > 0 && hcrop != 1.0 {
+		lines := strings.Count(opts.Text, "\n") + 1
+		newH := int(math.Max(1, math.Round(float64(r.Size*lines)*hcrop)))
+		mark = imaging.Resize(mark, mark.Bounds().Dx(), newH, imaging.Lanczos)
+	}
+
+	return SetOpacity(mark, opts.Opacity, opts.Workers)
+}
+
+// fontPath returns the path of the font r draws with.
+func (r TextRenderer) fontPath() string {
+	if r.Font != nil {
+		return r.Font.Path()
+	}
+	return r.FontPath
+}
+
+// hasColorGlyphs reports whether r draws any rune of text in color. Fonts
+// that fail to load are reported when the text is drawn.
+func (r TextRenderer) hasColorGlyphs(text string) bool {
+	fnt, err := LoadFont(r.fontPath())
+	if err != nil {
+		return false
+	}
+	fallbacks, err := LoadFonts(r.FontFallbacks)
+	if err != nil {
+		return false
+	}
+	return hasColorGlyphs(append([]*Font{fnt}, fallbacks...), text)
+}
+
+// drawFace draws text with a hinted font face, cropped to its pixels.
+func (r TextRenderer) drawFace(text string, colorVal color.NRGBA) (image.Image, error) {
+	shared, err := LoadFace(r.fontPath(), r.Size)
+	if err != nil {
+		return nil, err
+	}
+	fallbacks, err := LoadFonts(r.FontFallbacks)
+	if err != nil {
+		return nil, err
+	}
+	shared.Lock()
+	defer shared.Unlock()
+	full, err := WithFallbacks(shared.Face, fallbacks, r.Size)
+	if err != nil {
+		return nil, err
+	}
+	face := newSubsetFace(full, text)
+
+	lines := strings.Split(text, "\n")
+	lineStep := LineAdvance(face.Metrics(), r.LineHeight)
+	widths := make([]fixed.Int26_6, len(lines))
+	var maxW fixed.Int26_6
+	for i, line := range lines {
+		widths[i] = font.MeasureString(face, line)
+		if widths[i] > maxW {
+			maxW = widths[i]
+		}
+	}
+	// The widest line and a glyph's worth of overhang; the crop below trims
+	// the rest.
+	tmpW := max(200, maxW.Ceil()+r.Size)
+	tmpH := max(64, int(float64(r.Size)*2.5)) + (len(lines)-1)*lineStep.Ceil()
+	canvas := image.NewNRGBA(image.Rect(0, 0, tmpW, tmpH))
+
+	d := &font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(colorVal),
+		Face: face,
+	}
+	for i, line := range lines {
+		d.Dot = fixed.Point26_6{
+			X: r.Align.Offset(maxW, widths[i]),
+			Y: face.Metrics().Ascent + lineStep*fixed.Int26_6(i),
+		}
+		d.DrawString(line)
+	}
+
+	bbox, ok := TightAlphaBounds(canvas)
+	if !ok {
+		return nil, nil
+	}
+	return imaging.Crop(canvas, bbox), nil
+}
+
+// drawOutline draws text from its glyph outlines, which small caps need to
+// mix glyph sizes, shaped and vertical text to place glyphs freely and color
+// glyphs to keep their colors, cropped to its bounds.
+func (r TextRenderer) drawOutline(text string, colorVal color.NRGBA) (image.Image, error) {
+	fnt, err := LoadFont(r.fontPath())
+	if err != nil {
+		return nil, err
+	}
+	fallbacks, err := LoadFonts(r.FontFallbacks)
+	if err != nil {
+		return nil, err
+	}
+	o, err := OutlineText(fnt, fallbacks, text, r.Size, r.LineHeight, r.Align, r.Transform, r.Direction, r.Variations)
+	if err != nil {
+		return nil, err
+	}
+	if o.bounds.Empty() {
+		return nil, nil
+	}
+	canvas := image.NewNRGBA(image.Rect(0, 0, o.bounds.Dx(), o.bounds.Dy()))
+	DrawOutlinedText(canvas, o, o.bounds.Min.Mul(-1), colorVal, color.NRGBA{}, Stroke{}, nil)
+	return canvas, nil
+}
+
+// ImageRenderer renders a fixed image, such as a logo. The text is ignored.
+type ImageRenderer struct {
+	Image image.Image
+	// Width scales the image to this many pixels wide, keeping its aspect
+	// ratio; 0 keeps its size.
+	Width int
+}
+
+// Render implements MarkRenderer.
+func (r ImageRenderer) Render(opts RenderOptions) (image.Image, error) {
+	if r.Image == nil || r.Image.Bounds().Empty() {
+		return nil, nil
+	}
+	img := r.Image
+	if r.Width > 0 && r.Width != img.Bounds().Dx() {
+		img = imaging.Resize(img, r.Width, 0, imaging.Lanczos)
+	}
+	return SetOpacity(img, opts.Opacity, opts.Workers)
+}
+
+// QRRenderer renders the text as a QR code.
+type QRRenderer struct {
+	// Level is the error-correction level; empty means QRLevelM.
+	Level QRLevel
+	// Size is the side in pixels, quiet zone included; 0 uses a fifth of the
+	// shorter side of RenderOptions.Bounds.
+	Size int
+	// QuietZone is the light border in modules; 0 means the standard 4 and a
+	// negative value none.
+	QuietZone int
+	// Color is the color of dark modules; the zero value means black.
+	Color color.NRGBA
+}
+
+// Render implements MarkRenderer.
+func (r QRRenderer) Render(opts RenderOptions) (image.Image, error) {
+	if opts.Text == "" {
+		return nil, nil
+	}
+	level := r.Level
+	if level == "" {
+		level = QRLevelM
+	}
+	code, err := encodeQR(opts.Text, level)
+	if err != nil {
+		return nil, err
+	}
+	quiet := r.QuietZone
+	if quiet == 0 {
+		quiet = 4
+	} else if quiet < 0 {
+		quiet = 0
+	}
+	side := r.Size
+	if side == 0 {
+		side = int(float64(min(opts.Bounds.Dx(), opts.Bounds.Dy())) * qrSizeRatio)
+	}
+	// Whole pixels per module keep the edges sharp enough to scan.
+	scale := max(side/(code.size+2*quiet), 1)
+	dark := r.Color
+	if dark == (color.NRGBA{}) {
+		dark = color.NRGBA{0, 0, 0, 255}
+	}
+	light := color.NRGBA{255, 255, 255, 255}
+	return code.image(scale, quiet, ScaleAlpha(dark, opts.Opacity), ScaleAlpha(light, opts.Opacity)), nil
+}
@@ -0,0 +1,38 @@
+package render
+
+import (
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestTextRendererSkipsIgnorable(t *testing.T) {
+	fnt, err := LoadFontFromBytes(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A fingerprint: word joiners around zero-width spaces and non-joiners.
+	const plain, marked = "CONFIDENTIAL", "C\u2060\u200b\u200c\u200c\u200b\u2060ONFIDENTIAL"
+	for _, tc := range []struct {
+		name string
+		r    TextRenderer
+	}{
+		{"face", TextRenderer{Font: fnt, Size: 40, Color: "#000000"}},
+		{"outline", TextRenderer{Font: fnt, Size: 40, Color: "#000000", Transform: TextTransformSmallCaps}},
+		{"shaped", TextRenderer{Font: fnt, Size: 40, Color: "#000000", Direction: TextDirectionVertical}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := tc.r.Render(RenderOptions{Text: plain, Opacity: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tc.r.Render(RenderOptions{Text: marked, Opacity: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != want.Bounds() {
+				t.Errorf("fingerprinted mark is %v, plain one %v", got.Bounds(), want.Bounds())
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package render
+
+import (
+	"bytes"
+	"unicode"
+
+	"github.com/go-text/typesetting/di"
+	gotext "github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/shaping"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/unicode/bidi"
+)
+
+// shapedScripts are the scripts whose letters change shape with their
+// neighbours, combine into ligatures, reorder or run right to left, which
+// setting one glyph per rune gets wrong.
+var shapedScripts = []*unicode.RangeTable{
+	unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko,
+	unicode.Devanagari, unicode.Bengali, unicode.Gurmukhi, unicode.Gujarati,
+	unicode.Oriya, unicode.Tamil, unicode.Telugu, unicode.Kannada,
+	unicode.Malayalam, unicode.Sinhala, unicode.Thai, unicode.Lao,
+	unicode.Tibetan, unicode.Myanmar, unicode.Khmer,
+}
+
+// NeedsShaping reports whether text has runes of a shapedScripts script.
+// Other text is set glyph by glyph as it always was.
+func NeedsShaping(text string) bool {
+	for _, r := range text {
+		if r >= 0x0590 && unicode.In(r, shapedScripts...) {
+			return true
+		}
+	}
+	return false
+}
+
+// shapingFont returns f parsed for shaping, parsing it on first use.
+func (f *Font) shapingFont() (*gotext.Font, error) {
+	f.shapeOnce.Do(func() {
+		face, err := gotext.ParseTTF(bytes.NewReader(f.data))
+		if err != nil {
+			f.shapeErr = err
+			return
+		}
+		f.shapeFont = face.Font
+	})
+	return f.shapeFont, f.shapeErr
+}
+
+// shapingFaces picks the first face with a glyph for a rune, like
+// fallbackFace.
+type shapingFaces []*gotext.Face
+
+func (fs shapingFaces) ResolveFace(r rune) *gotext.Face {
+	for _, f := range fs {
+		if _, ok := f.NominalGlyph(r); ok {
+			return f
+		}
+	}
+	return fs[0]
+}
+
+// shapedRun is a run of runes of one bidi level, script and font.
+type shapedRun struct {
+	in    shaping.Input
+	level uint8
+}
+
+// shapeLine sets line with HarfBuzz: it splits the line
+// into runs of one bidi level, script and font, the first of fonts with a
+// glyph for each rune, shapes each and puts the runs in display order. It
+// returns the glyph contours and color glyphs with the origin on the
+// baseline at the left end of the line, and the line's advance. The fonts
+// are set to vars.
+func shapeLine(buf *sfnt.Buffer, fonts []*Font, line string, ppem fixed.Int26_6, vars []FontVariation) ([]sfnt.Segment, []colorGlyph, fixed.Int26_6, error) {
+	faces, byFont, err := shapingFacesOf(fonts, vars)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	runes := []rune(line)
+	levels := bidiLevels(runes)
+	var seg shaping.Segmenter
+	var runs []shapedRun
+	for start := 0; start < len(runes); {
+		end := start + 1
+		for end < len(runes) && levels[end] == levels[start] {
+			end++
+		}
+		dir := di.DirectionLTR
+		if levels[start]%2 == 1 {
+			dir = di.DirectionRTL
+		}
+		in := shaping.Input{Text: runes, RunStart: start, RunEnd: end, Direction: dir, Size: ppem}
+		for _, r := range seg.Split(in, faces) {
+			r.Direction = dir
+			runs = append(runs, shapedRun{in: r, level: levels[start]})
+		}
+		start = end
+	}
+	reorderRuns(runs)
+
+	var shaper shaping.HarfbuzzShaper
+	var segs []sfnt.Segment
+	var colors []colorGlyph
+	var pen fixed.Point26_6
+	for _, r := range runs {
+		out := shaper.Shape(r.in)
+		var err error
+		segs, colors, pen, err = byFont[r.in.Face.Font].appendGlyphs(buf, r.in.Face, out.Glyphs, ppem, pen, segs, colors)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	return segs, colors, pen.X, nil
+}
+
+// shapeColumn sets line top to bottom with HarfBuzz, which picks the
+// vertical forms of the fonts' glyphs, each rune from the first of fonts
+// with a glyph for it. It returns the glyph contours and color glyphs with
+// the origin at the top of the column on its center line, and the column's
+// length. The fonts are set to vars.
+func shapeColumn(buf *sfnt.Buffer, fonts []*Font, line string, ppem fixed.Int26_6, vars []FontVariation) ([]sfnt.Segment, []colorGlyph, fixed.Int26_6, error) {
+	faces, byFont, err := shapingFacesOf(fonts, vars)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	runes := []rune(line)
+	var seg shaping.Segmenter
+	var shaper shaping.HarfbuzzShaper
+	var segs []sfnt.Segment
+	var colors []colorGlyph
+	var pen fixed.Point26_6
+	in := shaping.Input{Text: runes, RunEnd: len(runes), Direction: di.DirectionTTB, Size: ppem}
+	for _, r := range seg.Split(in, faces) {
+		r.Direction = di.DirectionTTB
+		out := shaper.Shape(r)
+		segs, colors, pen, err = byFont[r.Face.Font].appendGlyphs(buf, r.Face, out.Glyphs, ppem, pen, segs, colors)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	return segs, colors, pen.Y, nil
+}
+
+// shapingFacesOf returns a shaping face for each of fonts set to vars, and
+// the font each face's glyphs come from.
+func shapingFacesOf(fonts []*Font, vars []FontVariation) (shapingFaces, map[*gotext.Font]*Font, error) {
+	faces := make(shapingFaces, len(fonts))
+	byFont := map[*gotext.Font]*Font{}
+	for i, f := range fonts {
+		sf, err := f.shapingFont()
+		if err != nil {
+			return nil, nil, err
+		}
+		// Faces are not safe for concurrent use, so each line gets its own.
+		faces[i] = gotext.NewFace(sf)
+		if len(vars) > 0 {
+			faces[i].SetVariations(shapingVariations(vars))
+		}
+		byFont[sf] = f
+	}
+	return faces, byFont, nil
+}
+
+// appendGlyphs adds the contours and color glyphs of glyphs shaped with
+// face of f to segs and colors, starting at pen, and returns them with the
+// pen moved past the glyphs. Outlines come from face when it is set to
+// variations. Shaping offsets and advances point up; pen and contours have
+// y down.
+func (f *Font) appendGlyphs(buf *sfnt.Buffer, face *gotext.Face, glyphs []shaping.Glyph, ppem fixed.Int26_6, pen fixed.Point26_6, segs []sfnt.Segment, colors []colorGlyph) ([]sfnt.Segment, []colorGlyph, fixed.Point26_6, error) {
+	for _, g := range glyphs {
+		idx := sfnt.GlyphIndex(g.GlyphID)
+		at := fixed.Point26_6{X: pen.X + g.XOffset, Y: pen.Y - g.YOffset}
+		pen.X += g.XAdvance
+		pen.Y -= g.YAdvance
+		cg, ok, err := f.colorGlyph(buf, idx, ppem, at)
+		if err != nil {
+			return nil, nil, pen, err
+		}
+		if ok {
+			colors = append(colors, cg)
+			continue
+		}
+		var gs []sfnt.Segment
+		if len(face.Coords()) > 0 {
+			gs = variedContours(face, idx, ppem)
+		} else if gs, err = glyphContours(f.font, buf, idx, ppem); err != nil {
+			return nil, nil, pen, err
+		}
+		translateSegments(gs, at)
+		segs = append(segs, gs...)
+	}
+	return segs, colors, pen, nil
+}
+
+// bidiLevels resolves the embedding level of each rune by the implicit
+// rules of the Unicode bidirectional algorithm, for text without explicit
+// embeddings: the paragraph takes the direction of its first strong rune,
+// numbers follow the strong text before them and neutrals between runs of
+// one direction take it, others the paragraph's.
+func bidiLevels(runes []rune) []uint8 {
+	const (
+		clsL = iota
+		clsR
+		clsEN
+		clsAN
+		clsN
+	)
+	cls := make([]uint8, len(runes))
+	// clsL and clsR double as the paragraph levels 0 and 1.
+	para, found := uint8(clsL), false
+	for i, r := range runes {
+		p, _ := bidi.LookupRune(r)
+		switch p.Class() {
+		case bidi.L:
+			cls[i] = clsL
+		case bidi.R, bidi.AL:
+			cls[i] = clsR
+		case bidi.EN:
+			cls[i] = clsEN
+		case bidi.AN:
+			cls[i] = clsAN
+		case bidi.NSM:
+			cls[i] = clsN
+			if i > 0 {
+				cls[i] = cls[i-1]
+			}
+		default:
+			cls[i] = clsN
+		}
+		if !found && (cls[i] == clsL || cls[i] == clsR) {
+			para, found = cls[i], true
+		}
+	}
+	// European numbers after left-to-right text are left-to-right text.
+	strong := para
+	for i, c := range cls {
+		switch c {
+		case clsL, clsR:
+			strong = c
+		case clsEN:
+			if strong == clsL {
+				cls[i] = clsL
+			}
+		}
+	}
+	// Numbers count as right-to-left text next to neutrals.
+	strongOf := func(c uint8) uint8 {
+		if c == clsL {
+			return clsL
+		}
+		return clsR
+	}
+	for i := 0; i < len(cls); {
+		if cls[i] != clsN {
+			i++
+			continue
+		}
+		j := i
+		for j < len(cls) && cls[j] == clsN {
+			j++
+		}
+		before, after := para, para
+		if i > 0 {
+			before = strongOf(cls[i-1])
+		}
+		if j < len(cls) {
+			after = strongOf(cls[j])
+		}
+		dir := para
+		if before == after {
+			dir = before
+		}
+		for k := i; k < j; k++ {
+			cls[k] = dir
+		}
+		i = j
+	}
+	levels := make([]uint8, len(cls))
+	for i, c := range cls {
+		switch {
+		case para == clsL && c == clsR:
+			levels[i] = 1
+		case para == clsL && c != clsL:
+			levels[i] = 2
+		case para == clsR && c != clsR:
+			levels[i] = 2
+		default:
+			levels[i] = para
+		}
+	}
+	// Trailing white space goes back to the paragraph level.
+	for i := len(runes) - 1; i >= 0 && unicode.IsSpace(runes[i]); i-- {
+		levels[i] = para
+	}
+	return levels
+}
+
+// reorderRuns puts runs, in logical order, in display order: from the
+// highest level down to the lowest odd one, every sequence of runs at that
+// level or above is reversed.
+func reorderRuns(runs []shapedRun) {
+	var hi uint8
+	lowOdd := uint8(255)
+	for _, r := range runs {
+		if r.level > hi {
+			hi = r.level
+		}
+		if r.level%2 == 1 && r.level < lowOdd {
+			lowOdd = r.level
+		}
+	}
+	for lvl := hi; lvl >= lowOdd && lvl > 0; lvl-- {
+		for i := 0; i < len(runs); {
+			if runs[i].level < lvl {
+				i++
+				continue
+			}
+			j := i
+			for j < len(runs) && runs[j].level >= lvl {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				runs[a], runs[b] = runs[b], runs[a]
+			}
+			i = j
+		}
+	}
+}
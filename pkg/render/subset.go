@@ -0,0 +1,96 @@
+package render
+
+import (
+	"image"
+	"image/draw"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// subsetFace is a font.Face holding pre-rendered masks for a fixed set of
+// runes, so the glyphs of a mark are rasterized once rather than for every
+// outline pass. It saves no memory: the font cache keeps the parsed font.
+type subsetFace struct {
+	metrics font.Metrics
+	glyphs  map[rune]subsetGlyph
+	kerns   map[[2]rune]fixed.Int26_6
+}
+
+type subsetGlyph struct {
+	rect    image.Rectangle // mask bounds relative to a dot at the origin
+	mask    *image.Alpha
+	bounds  fixed.Rectangle26_6
+	advance fixed.Int26_6
+}
+
+// newSubsetFace renders the runes of text from face and closes face. Glyphs
+// missing from face and default-ignorable runes are left out, so drawing
+// skips them without a box or advance, as are kerning pairs between them.
+func newSubsetFace(face font.Face, text string) font.Face {
+	defer face.Close()
+	sf := &subsetFace{
+		metrics: face.Metrics(),
+		glyphs:  map[rune]subsetGlyph{},
+		kerns:   map[[2]rune]fixed.Int26_6{},
+	}
+	for _, r := range text {
+		if _, done := sf.glyphs[r]; done || unicode.Is(defaultIgnorable, r) {
+			continue
+		}
+		bounds, advance, ok := face.GlyphBounds(r)
+		if !ok {
+			continue
+		}
+		g := subsetGlyph{bounds: bounds, advance: advance}
+		dr, mask, maskp, _, ok := face.Glyph(fixed.Point26_6{}, r)
+		if ok && !dr.Empty() {
+			// The face reuses its mask buffer between calls, so copy it.
+			g.rect = dr
+			g.mask = image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+			draw.Draw(g.mask, g.mask.Bounds(), mask, maskp, draw.Src)
+		}
+		sf.glyphs[r] = g
+	}
+	for r0 := range sf.glyphs {
+		for r1 := range sf.glyphs {
+			if k := face.Kern(r0, r1); k != 0 {
+				sf.kerns[[2]rune{r0, r1}] = k
+			}
+		}
+	}
+	return sf
+}
+
+func (f *subsetFace) Close() error { return nil }
+
+func (f *subsetFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	g, ok := f.glyphs[r]
+	if !ok {
+		return image.Rectangle{}, nil, image.Point{}, 0, false
+	}
+	if g.mask == nil {
+		return image.Rectangle{}, image.Transparent, image.Point{}, g.advance, true
+	}
+	dr := g.rect.Add(image.Pt(dot.X.Round(), dot.Y.Round()))
+	return dr, g.mask, image.Point{}, g.advance, true
+}
+
+func (f *subsetFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	g, ok := f.glyphs[r]
+	return g.bounds, g.advance, ok
+}
+
+func (f *subsetFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	g, ok := f.glyphs[r]
+	return g.advance, ok
+}
+
+func (f *subsetFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	return f.kerns[[2]rune{r0, r1}]
+}
+
+func (f *subsetFace) Metrics() font.Metrics {
+	return f.metrics
+}
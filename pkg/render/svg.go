@@ -0,0 +1,990 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/vector"
+)
+
+// svgWidthRatio is the default width of an SVG mark relative to the width
+// of the image it marks.
+const svgWidthRatio = 0.2
+
+// SVG is a parsed SVG document, see ParseSVG. It keeps the shapes as curves
+// so they can be rasterized sharply at any size.
+type SVG struct {
+	width, height float64
+	shapes        []svgShape
+}
+
+// Size returns the natural size of the document in CSS pixels.
+func (s *SVG) Size() (w, h float64) {
+	return s.width, s.height
+}
+
+type svgPoint struct{ x, y float64 }
+
+// svgSeg is a path segment: 'M' and 'L' use pts[0], 'C' all three, 'Z'
+// none.
+type svgSeg struct {
+	op  byte
+	pts [3]svgPoint
+}
+
+// svgShape is a filled and/or stroked path in document pixels. A zero alpha
+// paints nothing.
+type svgShape struct {
+	path        []svgSeg
+	fill        color.NRGBA
+	stroke      color.NRGBA
+	strokeWidth float64
+}
+
+// SVGRenderer renders an SVG document, such as a vector logo, rasterized at
+// the size it is drawn at, so it stays sharp on both thumbnails and very
+// large originals. The text is ignored.
+type SVGRenderer struct {
+	SVG *SVG
+	// Width is the width in pixels to render at, keeping the aspect ratio;
+	// 0 uses WidthRatio.
+	Width int
+	// WidthRatio is the width relative to the width of the image being
+	// marked; 0 means a fifth. Without image bounds the document's own size
+	// is used.
+	WidthRatio float64
+}
+
+// Render implements MarkRenderer.
+func (r SVGRenderer) Render(opts RenderOptions) (image.Image, error) {
+	if r.SVG == nil || r.SVG.width <= 0 || r.SVG.height <= 0 {
+		return nil, nil
+	}
+	w := float64(r.Width)
+	if w == 0 && !opts.Bounds.Empty() {
+		ratio := r.WidthRatio
+		if ratio == 0 {
+			ratio = svgWidthRatio
+		}
+		w = float64(opts.Bounds.Dx()) * ratio
+	}
+	if w == 0 {
+		w = r.SVG.width
+	}
+	scale := w / r.SVG.width
+	canvas := r.SVG.rasterize(scale)
+	if _, ok := TightAlphaBounds(canvas); !ok {
+		return nil, nil
+	}
+	return SetOpacity(canvas, opts.Opacity, opts.Workers)
+}
+
+// rasterize draws the document at scale pixels per document pixel.
+func (s *SVG) rasterize(scale float64) *image.NRGBA {
+	w := max(int(math.Round(s.width*scale)), 1)
+	h := max(int(math.Round(s.height*scale)), 1)
+	canvas := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for _, sh := range s.shapes {
+		pad := 1.0
+		if sh.stroke.A > 0 {
+			pad += sh.strokeWidth * scale / 2
+		}
+		r := sh.bounds(scale, pad).Intersect(canvas.Bounds())
+		if r.Empty() {
+			continue
+		}
+		off := svgPoint{float64(-r.Min.X), float64(-r.Min.Y)}
+		pt := func(p svgPoint) (float32, float32) {
+			return float32(p.x*scale + off.x), float32(p.y*scale + off.y)
+		}
+		if sh.fill.A > 0 {
+			z := vector.NewRasterizer(r.Dx(), r.Dy())
+			for _, seg := range sh.path {
+				switch seg.op {
+				case 'M':
+					z.ClosePath()
+					z.MoveTo(pt(seg.pts[0]))
+				case 'L':
+					z.LineTo(pt(seg.pts[0]))
+				case 'C':
+					bx, by := pt(seg.pts[0])
+					cx, cy := pt(seg.pts[1])
+					dx, dy := pt(seg.pts[2])
+					z.CubeTo(bx, by, cx, cy, dx, dy)
+				case 'Z':
+					z.ClosePath()
+				}
+			}
+			z.ClosePath()
+			drawMask(canvas, z, r, sh.fill)
+		}
+		if sh.stroke.A > 0 && sh.strokeWidth > 0 {
+			z := vector.NewRasterizer(r.Dx(), r.Dy())
+			for _, line := range sh.flatten(scale, off) {
+				strokePolyline(z, line, float32(sh.strokeWidth*scale/2))
+			}
+			drawMask(canvas, z, r, sh.stroke)
+		}
+	}
+	return canvas
+}
+
+// bounds returns the pixel bounds of the shape's control points at scale,
+// grown by pad.
+func (sh *svgShape) bounds(scale, pad float64) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, seg := range sh.path {
+		n := map[byte]int{'M': 1, 'L': 1, 'C': 3}[seg.op]
+		for _, p := range seg.pts[:n] {
+			minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+			minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+		}
+	}
+	if minX > maxX {
+		return image.Rectangle{}
+	}
+	return image.Rect(
+		int(math.Floor(minX*scale-pad)), int(math.Floor(minY*scale-pad)),
+		int(math.Ceil(maxX*scale+pad)), int(math.Ceil(maxY*scale+pad)),
+	)
+}
+
+// flatten turns the path into polylines at scale, offset by off; closed
+// subpaths end at their first point.
+func (sh *svgShape) flatten(scale float64, off svgPoint) [][]point32 {
+	pt := func(p svgPoint) point32 {
+		return point32{float32(p.x*scale + off.x), float32(p.y*scale + off.y)}
+	}
+	var lines [][]point32
+	var cur []point32
+	flush := func() {
+		if len(cur) > 1 {
+			lines = append(lines, cur)
+		}
+		cur = nil
+	}
+	for _, seg := range sh.path {
+		switch seg.op {
+		case 'M':
+			flush()
+			cur = []point32{pt(seg.pts[0])}
+		case 'L':
+			cur = append(cur, pt(seg.pts[0]))
+		case 'C':
+			a, b, c, d := cur[len(cur)-1], pt(seg.pts[0]), pt(seg.pts[1]), pt(seg.pts[2])
+			n := curveSteps(a, b, c, d)
+			for i := 1; i <= n; i++ {
+				t := float32(i) / float32(n)
+				u := 1 - t
+				cur = append(cur, point32{
+					u*u*u*a.x + 3*u*u*t*b.x + 3*u*t*t*c.x + t*t*t*d.x,
+					u*u*u*a.y + 3*u*u*t*b.y + 3*u*t*t*c.y + t*t*t*d.y,
+				})
+			}
+		case 'Z':
+			if len(cur) > 0 {
+				start := cur[0]
+				cur = append(cur, start)
+				flush()
+				cur = []point32{start}
+			}
+		}
+	}
+	flush()
+	return lines
+}
+
+// svgAffine is a transform [a b c d e f]: x' = a x + c y + e,
+// y' = b x + d y + f.
+type svgAffine [6]float64
+
+var svgIdentity = svgAffine{1, 0, 0, 1, 0, 0}
+
+// then returns the transform applying m after t.
+func (t svgAffine) then(m svgAffine) svgAffine {
+	return svgAffine{
+		m[0]*t[0] + m[2]*t[1],
+		m[1]*t[0] + m[3]*t[1],
+		m[0]*t[2] + m[2]*t[3],
+		m[1]*t[2] + m[3]*t[3],
+		m[0]*t[4] + m[2]*t[5] + m[4],
+		m[1]*t[4] + m[3]*t[5] + m[5],
+	}
+}
+
+func (t svgAffine) apply(p svgPoint) svgPoint {
+	return svgPoint{t[0]*p.x + t[2]*p.y + t[4], t[1]*p.x + t[3]*p.y + t[5]}
+}
+
+// scale is the mean length scale of t, for stroke widths.
+func (t svgAffine) scale() float64 {
+	return math.Sqrt(math.Abs(t[0]*t[3] - t[1]*t[2]))
+}
+
+// svgStyle is the inherited painting state of an element.
+type svgStyle struct {
+	fill, stroke               *color.NRGBA // nil paints nothing
+	fillOpacity, strokeOpacity float64
+	strokeWidth                float64
+	opacity                    float64 // product of the ancestors' opacity
+	transform                  svgAffine
+	hidden                     bool
+	render                     bool // false inside defs and the like
+}
+
+// ParseSVG parses an SVG document. It supports the basic shapes and paths,
+// solid fills and strokes set by attributes or style attributes, group
+// opacity, transforms and the viewBox; gradients paint their first stop
+// color, and text, images, clipping, masks, filters and style sheets are
+// ignored.
+func ParseSVG(data []byte) (*SVG, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	black := color.NRGBA{0, 0, 0, 255}
+	stack := []svgStyle{{fill: &black, fillOpacity: 1, strokeOpacity: 1, strokeWidth: 1, opacity: 1, transform: svgIdentity, render: true}}
+	gradients := map[string]color.NRGBA{}
+	var gradient string
+	var doc *SVG
+	var deferred []func() // shapes filled by gradients defined later
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("svg: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			if t.Name.Local == "linearGradient" || t.Name.Local == "radialGradient" {
+				gradient = ""
+			}
+		case xml.StartElement:
+			attrs := map[string]string{}
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			for _, decl := range strings.Split(attrs["style"], ";") {
+				if k, v, ok := strings.Cut(decl, ":"); ok {
+					attrs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+				}
+			}
+			st := stack[len(stack)-1]
+			name := t.Name.Local
+
+			if name == "svg" && doc == nil {
+				var err error
+				if doc, st.transform, err = svgViewport(attrs); err != nil {
+					return nil, err
+				}
+				stack = append(stack, st)
+				continue
+			}
+			if doc == nil {
+				return nil, errors.New("svg: root element is not <svg>")
+			}
+			switch name {
+			case "linearGradient", "radialGradient":
+				gradient = attrs["id"]
+			case "stop":
+				if _, seen := gradients[gradient]; gradient != "" && !seen {
+					if c, ok := parseSVGColor(attrs["stop-color"]); ok {
+						c.A = uint8(math.Round(float64(c.A) * svgOpacity(attrs["stop-opacity"], 1)))
+						gradients[gradient] = c
+					} else if attrs["stop-color"] == "" {
+						gradients[gradient] = black
+					}
+				}
+			}
+
+			if tr, ok := attrs["transform"]; ok {
+				m, err := parseSVGTransform(tr)
+				if err != nil {
+					return nil, err
+				}
+				st.transform = m.then(st.transform)
+			}
+			switch name {
+			case "defs", "symbol", "clipPath", "mask", "pattern", "marker", "linearGradient", "radialGradient":
+				st.render = false
+			}
+			if attrs["display"] == "none" {
+				st.hidden = true
+			}
+			if v, ok := attrs["visibility"]; ok {
+				st.hidden = st.hidden || v == "hidden" || v == "collapse"
+			}
+			st.opacity *= svgOpacity(attrs["opacity"], 1)
+			st.fillOpacity = svgOpacity(attrs["fill-opacity"], st.fillOpacity)
+			st.strokeOpacity = svgOpacity(attrs["stroke-opacity"], st.strokeOpacity)
+			if v, ok := attrs["stroke-width"]; ok {
+				if f, err := parseSVGLength(v); err == nil && f >= 0 {
+					st.strokeWidth = f
+				}
+			}
+			var pending []string
+			for _, prop := range []string{"fill", "stroke"} {
+				v, ok := attrs[prop]
+				if !ok {
+					continue
+				}
+				paint := &st.fill
+				if prop == "stroke" {
+					paint = &st.stroke
+				}
+				if id, isURL := svgURL(v); isURL {
+					pending = append(pending, prop+"\x00"+id)
+					*paint = nil
+				} else if c, ok := parseSVGColor(v); ok {
+					*paint = &c
+				} else {
+					*paint = nil
+				}
+			}
+			stack = append(stack, st)
+
+			if !st.render || st.hidden {
+				continue
+			}
+			path, err := svgElementPath(name, attrs)
+			if err != nil {
+				return nil, err
+			}
+			if path == nil {
+				continue
+			}
+			for i := range path {
+				for j := range path[i].pts {
+					path[i].pts[j] = st.transform.apply(path[i].pts[j])
+				}
+			}
+			doc.shapes = append(doc.shapes, svgShape{path: path, strokeWidth: st.strokeWidth * st.transform.scale()})
+			idx := len(doc.shapes) - 1
+			fill, stroke := st.fill, st.stroke
+			fillAlpha, strokeAlpha := st.fillOpacity*st.opacity, st.strokeOpacity*st.opacity
+			paint := func() {
+				sh := &doc.shapes[idx]
+				if fill != nil {
+					sh.fill = ScaleAlpha(*fill, fillAlpha)
+				}
+				if stroke != nil {
+					sh.stroke = ScaleAlpha(*stroke, strokeAlpha)
+				}
+			}
+			paint()
+			for _, p := range pending {
+				prop, id, _ := strings.Cut(p, "\x00")
+				deferred = append(deferred, func() {
+					c, ok := gradients[id]
+					if !ok {
+						return
+					}
+					if prop == "fill" {
+						doc.shapes[idx].fill = ScaleAlpha(c, fillAlpha)
+					} else {
+						doc.shapes[idx].stroke = ScaleAlpha(c, strokeAlpha)
+					}
+				})
+			}
+		}
+	}
+	if doc == nil {
+		return nil, errors.New("svg: no <svg> element")
+	}
+	for _, fn := range deferred {
+		fn()
+	}
+	return doc, nil
+}
+
+// svgViewport reads the size of the root element and the transform from
+// its viewBox, centered and scaled to fit as for the default
+// preserveAspectRatio.
+func svgViewport(attrs map[string]string) (*SVG, svgAffine, error) {
+	var vb []float64
+	if v, ok := attrs["viewBox"]; ok {
+		var err error
+		if vb, err = parseSVGNumbers(v); err != nil || len(vb) != 4 || vb[2] <= 0 || vb[3] <= 0 {
+			return nil, svgAffine{}, fmt.Errorf("svg: invalid viewBox %q", v)
+		}
+	}
+	w, errW := parseSVGLength(attrs["width"])
+	h, errH := parseSVGLength(attrs["height"])
+	switch {
+	case errW == nil && errH == nil:
+	case vb != nil && errW == nil:
+		h = w * vb[3] / vb[2]
+	case vb != nil && errH == nil:
+		w = h * vb[2] / vb[3]
+	case vb != nil:
+		w, h = vb[2], vb[3]
+	default:
+		return nil, svgAffine{}, errors.New("svg: the root element needs a width and height or a viewBox")
+	}
+	if w <= 0 || h <= 0 {
+		return nil, svgAffine{}, fmt.Errorf("svg: invalid size %gx%g", w, h)
+	}
+	m := svgIdentity
+	if vb != nil {
+		s := math.Min(w/vb[2], h/vb[3])
+		m = svgAffine{s, 0, 0, s, (w-vb[2]*s)/2 - vb[0]*s, (h-vb[3]*s)/2 - vb[1]*s}
+	}
+	return &SVG{width: w, height: h}, m, nil
+}
+
+// svgElementPath returns the outline of a shape element in its own
+// coordinates, or nil for elements that draw nothing.
+func svgElementPath(name string, a map[string]string) ([]svgSeg, error) {
+	num := func(k string) float64 {
+		f, _ := parseSVGLength(a[k])
+		return f
+	}
+	switch name {
+	case "path":
+		return parseSVGPath(a["d"])
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		if w <= 0 || h <= 0 {
+			return nil, nil
+		}
+		rx, hasRX := a["rx"]
+		ry, hasRY := a["ry"]
+		if !hasRX {
+			rx = ry
+		}
+		if !hasRY {
+			ry = rx
+		}
+		cx, _ := parseSVGLength(rx)
+		cy, _ := parseSVGLength(ry)
+		cx, cy = math.Min(math.Max(cx, 0), w/2), math.Min(math.Max(cy, 0), h/2)
+		if cx == 0 || cy == 0 {
+			return svgPolygon([]float64{x, y, x + w, y, x + w, y + h, x, y + h}, true), nil
+		}
+		k := 1 - svgKappa
+		p := func(px, py float64) svgPoint { return svgPoint{px, py} }
+		return []svgSeg{
+			{op: 'M', pts: [3]svgPoint{p(x+cx, y)}},
+			{op: 'L', pts: [3]svgPoint{p(x+w-cx, y)}},
+			{op: 'C', pts: [3]svgPoint{p(x+w-cx*k, y), p(x+w, y+cy*k), p(x+w, y+cy)}},
+			{op: 'L', pts: [3]svgPoint{p(x+w, y+h-cy)}},
+			{op: 'C', pts: [3]svgPoint{p(x+w, y+h-cy*k), p(x+w-cx*k, y+h), p(x+w-cx, y+h)}},
+			{op: 'L', pts: [3]svgPoint{p(x+cx, y+h)}},
+			{op: 'C', pts: [3]svgPoint{p(x+cx*k, y+h), p(x, y+h-cy*k), p(x, y+h-cy)}},
+			{op: 'L', pts: [3]svgPoint{p(x, y+cy)}},
+			{op: 'C', pts: [3]svgPoint{p(x, y+cy*k), p(x+cx*k, y), p(x+cx, y)}},
+			{op: 'Z'},
+		}, nil
+	case "circle":
+		r := num("r")
+		return svgEllipse(num("cx"), num("cy"), r, r), nil
+	case "ellipse":
+		return svgEllipse(num("cx"), num("cy"), num("rx"), num("ry")), nil
+	case "line":
+		return []svgSeg{
+			{op: 'M', pts: [3]svgPoint{{num("x1"), num("y1")}}},
+			{op: 'L', pts: [3]svgPoint{{num("x2"), num("y2")}}},
+		}, nil
+	case "polyline", "polygon":
+		pts, err := parseSVGNumbers(a["points"])
+		if err != nil {
+			return nil, fmt.Errorf("svg: %s points: %w", name, err)
+		}
+		return svgPolygon(pts, name == "polygon"), nil
+	}
+	return nil, nil
+}
+
+// svgKappa places cubic control points to approximate a quarter circle.
+const svgKappa = 0.5522847498
+
+func svgEllipse(cx, cy, rx, ry float64) []svgSeg {
+	if rx <= 0 || ry <= 0 {
+		return nil
+	}
+	kx, ky := rx*svgKappa, ry*svgKappa
+	return []svgSeg{
+		{op: 'M', pts: [3]svgPoint{{cx + rx, cy}}},
+		{op: 'C', pts: [3]svgPoint{{cx + rx, cy + ky}, {cx + kx, cy + ry}, {cx, cy + ry}}},
+		{op: 'C', pts: [3]svgPoint{{cx - kx, cy + ry}, {cx - rx, cy + ky}, {cx - rx, cy}}},
+		{op: 'C', pts: [3]svgPoint{{cx - rx, cy - ky}, {cx - kx, cy - ry}, {cx, cy - ry}}},
+		{op: 'C', pts: [3]svgPoint{{cx + kx, cy - ry}, {cx + rx, cy - ky}, {cx + rx, cy}}},
+		{op: 'Z'},
+	}
+}
+
+func svgPolygon(coords []float64, closed bool) []svgSeg {
+	if len(coords) < 4 {
+		return nil
+	}
+	var path []svgSeg
+	for i := 0; i+1 < len(coords); i += 2 {
+		op := byte('L')
+		if i == 0 {
+			op = 'M'
+		}
+		path = append(path, svgSeg{op: op, pts: [3]svgPoint{{coords[i], coords[i+1]}}})
+	}
+	if closed {
+		path = append(path, svgSeg{op: 'Z'})
+	}
+	return path
+}
+
+// parseSVGPath parses path data into absolute moves, lines and cubics.
+func parseSVGPath(d string) ([]svgSeg, error) {
+	s := &svgScanner{s: d}
+	var path []svgSeg
+	var cur, start, ctrl svgPoint
+	var prevOp byte
+	op := byte(0)
+	for {
+		s.skip()
+		if s.done() {
+			return path, nil
+		}
+		if c := s.s[s.i]; strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0 {
+			op = c
+			s.i++
+		} else if op == 0 {
+			return nil, fmt.Errorf("svg: path %q: expected a command at offset %d", d, s.i)
+		}
+		rel := op >= 'a'
+		at := func(x, y float64) svgPoint {
+			if rel {
+				return svgPoint{cur.x + x, cur.y + y}
+			}
+			return svgPoint{x, y}
+		}
+		nums := func(n int) ([]float64, error) {
+			v := make([]float64, n)
+			for i := range v {
+				f, err := s.number()
+				if err != nil {
+					return nil, fmt.Errorf("svg: path %q: %w", d, err)
+				}
+				v[i] = f
+			}
+			return v, nil
+		}
+		upper := op &^ 0x20
+		switch upper {
+		case 'Z':
+			path = append(path, svgSeg{op: 'Z'})
+			cur = start
+			// Z takes no numbers, so a command must follow.
+			op = 0
+		case 'M', 'L', 'T':
+			v, err := nums(2)
+			if err != nil {
+				return nil, err
+			}
+			p := at(v[0], v[1])
+			switch {
+			case upper == 'M':
+				path = append(path, svgSeg{op: 'M', pts: [3]svgPoint{p}})
+				start = p
+				// Further pairs are implicit line-tos.
+				op = 'L' | op&0x20
+			case upper == 'T':
+				q := cur
+				if prevOp == 'Q' || prevOp == 'T' {
+					q = svgPoint{2*cur.x - ctrl.x, 2*cur.y - ctrl.y}
+				}
+				path = append(path, quadToCubic(cur, q, p))
+				ctrl = q
+			default:
+				path = append(path, svgSeg{op: 'L', pts: [3]svgPoint{p}})
+			}
+			cur = p
+		case 'H', 'V':
+			v, err := nums(1)
+			if err != nil {
+				return nil, err
+			}
+			p := cur
+			if upper == 'H' {
+				p.x = v[0]
+				if rel {
+					p.x += cur.x
+				}
+			} else {
+				p.y = v[0]
+				if rel {
+					p.y += cur.y
+				}
+			}
+			path = append(path, svgSeg{op: 'L', pts: [3]svgPoint{p}})
+			cur = p
+		case 'C', 'S':
+			n := 6
+			if upper == 'S' {
+				n = 4
+			}
+			v, err := nums(n)
+			if err != nil {
+				return nil, err
+			}
+			var c1 svgPoint
+			if upper == 'C' {
+				c1, v = at(v[0], v[1]), v[2:]
+			} else if prevOp == 'C' || prevOp == 'S' {
+				c1 = svgPoint{2*cur.x - ctrl.x, 2*cur.y - ctrl.y}
+			} else {
+				c1 = cur
+			}
+			c2, p := at(v[0], v[1]), at(v[2], v[3])
+			path = append(path, svgSeg{op: 'C', pts: [3]svgPoint{c1, c2, p}})
+			ctrl, cur = c2, p
+		case 'Q':
+			v, err := nums(4)
+			if err != nil {
+				return nil, err
+			}
+			q, p := at(v[0], v[1]), at(v[2], v[3])
+			path = append(path, quadToCubic(cur, q, p))
+			ctrl, cur = q, p
+		case 'A':
+			var v [7]float64
+			for i := range v {
+				var err error
+				if i == 3 || i == 4 {
+					v[i], err = s.flag()
+				} else {
+					v[i], err = s.number()
+				}
+				if err != nil {
+					return nil, fmt.Errorf("svg: path %q: %w", d, err)
+				}
+			}
+			p := at(v[5], v[6])
+			path = append(path, svgArc(cur, v[0], v[1], v[2], v[3] != 0, v[4] != 0, p)...)
+			cur = p
+		}
+		prevOp = upper
+	}
+}
+
+func quadToCubic(p0, q, p svgPoint) svgSeg {
+	return svgSeg{op: 'C', pts: [3]svgPoint{
+		{p0.x + 2*(q.x-p0.x)/3, p0.y + 2*(q.y-p0.y)/3},
+		{p.x + 2*(q.x-p.x)/3, p.y + 2*(q.y-p.y)/3},
+		p,
+	}}
+}
+
+// svgArc converts an elliptical arc to cubics, following the endpoint to
+// center conversion of the SVG specification.
+func svgArc(p0 svgPoint, rx, ry, phiDeg float64, large, sweep bool, p svgPoint) []svgSeg {
+	if p0 == p {
+		return nil
+	}
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		return []svgSeg{{op: 'L', pts: [3]svgPoint{p}}}
+	}
+	sinPhi, cosPhi := math.Sincos(phiDeg * math.Pi / 180)
+	dx, dy := (p0.x-p.x)/2, (p0.y-p.y)/2
+	x1 := cosPhi*dx + sinPhi*dy
+	y1 := -sinPhi*dx + cosPhi*dy
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := math.Sqrt(math.Max(num, 0) / den)
+	if large == sweep {
+		coef = -coef
+	}
+	cx1, cy1 := coef*rx*y1/ry, -coef*ry*x1/rx
+	cx := cosPhi*cx1 - sinPhi*cy1 + (p0.x+p.x)/2
+	cy := sinPhi*cx1 + cosPhi*cy1 + (p0.y+p.y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	n := int(math.Ceil(math.Abs(delta) / (math.Pi / 2)))
+	step := delta / float64(n)
+	k := 4.0 / 3 * math.Tan(step/4)
+	pt := func(t float64) (svgPoint, svgPoint) {
+		sin, cos := math.Sincos(t)
+		x, y := rx*cos, ry*sin
+		tx, ty := -rx*sin, ry*cos
+		return svgPoint{cosPhi*x - sinPhi*y + cx, sinPhi*x + cosPhi*y + cy},
+			svgPoint{cosPhi*tx - sinPhi*ty, sinPhi*tx + cosPhi*ty}
+	}
+	segs := make([]svgSeg, 0, n)
+	a, da := pt(theta)
+	for i := 1; i <= n; i++ {
+		b, db := pt(theta + step*float64(i))
+		if i == n {
+			b = p
+		}
+		segs = append(segs, svgSeg{op: 'C', pts: [3]svgPoint{
+			{a.x + k*da.x, a.y + k*da.y},
+			{b.x - k*db.x, b.y - k*db.y},
+			b,
+		}})
+		a, da = b, db
+	}
+	return segs
+}
+
+// svgScanner reads the numbers of path data and attribute lists.
+type svgScanner struct {
+	s string
+	i int
+}
+
+func (s *svgScanner) skip() {
+	for s.i < len(s.s) && strings.IndexByte(" \t\r\n,", s.s[s.i]) >= 0 {
+		s.i++
+	}
+}
+
+func (s *svgScanner) done() bool { return s.i >= len(s.s) }
+
+func (s *svgScanner) number() (float64, error) {
+	s.skip()
+	start := s.i
+	if s.i < len(s.s) && (s.s[s.i] == '+' || s.s[s.i] == '-') {
+		s.i++
+	}
+	digits, dot := 0, false
+	for s.i < len(s.s) {
+		c := s.s[s.i]
+		if c >= '0' && c <= '9' {
+			digits++
+		} else if c == '.' && !dot {
+			dot = true
+		} else {
+			break
+		}
+		s.i++
+	}
+	if digits > 0 && s.i < len(s.s) && (s.s[s.i] == 'e' || s.s[s.i] == 'E') {
+		j := s.i + 1
+		if j < len(s.s) && (s.s[j] == '+' || s.s[j] == '-') {
+			j++
+		}
+		if j < len(s.s) && s.s[j] >= '0' && s.s[j] <= '9' {
+			for j < len(s.s) && s.s[j] >= '0' && s.s[j] <= '9' {
+				j++
+			}
+			s.i = j
+		}
+	}
+	if digits == 0 {
+		return 0, fmt.Errorf("number expected at offset %d", start)
+	}
+	return strconv.ParseFloat(s.s[start:s.i], 64)
+}
+
+// flag reads an arc flag, which may be written without a separator.
+func (s *svgScanner) flag() (float64, error) {
+	s.skip()
+	if s.i < len(s.s) && (s.s[s.i] == '0' || s.s[s.i] == '1') {
+		s.i++
+		return float64(s.s[s.i-1] - '0'), nil
+	}
+	return 0, fmt.Errorf("arc flag expected at offset %d", s.i)
+}
+
+func parseSVGNumbers(v string) ([]float64, error) {
+	s := &svgScanner{s: v}
+	var out []float64
+	for s.skip(); !s.done(); s.skip() {
+		f, err := s.number()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// parseSVGLength parses a length in CSS pixels. Absolute units are
+// converted; percentages and font-relative units are rejected.
+func parseSVGLength(v string) (float64, error) {
+	v = strings.TrimSpace(v)
+	scale := 1.0
+	for unit, f := range map[string]float64{"px": 1, "pt": 4.0 / 3, "pc": 16, "mm": 96 / 25.4, "cm": 96 / 2.54, "in": 96} {
+		if strings.HasSuffix(v, unit) {
+			v, scale = strings.TrimSuffix(v, unit), f
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, err
+	}
+	return f * scale, nil
+}
+
+// svgOpacity parses an opacity, or returns def if v is empty or invalid.
+func svgOpacity(v string, def float64) float64 {
+	v = strings.TrimSpace(v)
+	scale := 1.0
+	if strings.HasSuffix(v, "%") {
+		v, scale = strings.TrimSuffix(v, "%"), 0.01
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+	return math.Max(0, math.Min(1, f*scale))
+}
+
+// svgURL returns the id of a url(#id) paint.
+func svgURL(v string) (string, bool) {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "url(") {
+		return "", false
+	}
+	id := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(v, "url("), ")"), `"' `)
+	return strings.TrimPrefix(id, "#"), true
+}
+
+var svgNamedColors = map[string]color.NRGBA{
+	"black":   {0, 0, 0, 255},
+	"white":   {255, 255, 255, 255},
+	"red":     {255, 0, 0, 255},
+	"green":   {0, 128, 0, 255},
+	"lime":    {0, 255, 0, 255},
+	"blue":    {0, 0, 255, 255},
+	"yellow":  {255, 255, 0, 255},
+	"cyan":    {0, 255, 255, 255},
+	"aqua":    {0, 255, 255, 255},
+	"magenta": {255, 0, 255, 255},
+	"fuchsia": {255, 0, 255, 255},
+	"gray":    {128, 128, 128, 255},
+	"grey":    {128, 128, 128, 255},
+	"silver":  {192, 192, 192, 255},
+	"maroon":  {128, 0, 0, 255},
+	"navy":    {0, 0, 128, 255},
+	"olive":   {128, 128, 0, 255},
+	"purple":  {128, 0, 128, 255},
+	"teal":    {0, 128, 128, 255},
+	"orange":  {255, 165, 0, 255},
+}
+
+// parseSVGColor parses a solid paint; ok is false for none and for
+// anything it does not understand.
+func parseSVGColor(v string) (color.NRGBA, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	switch {
+	case v == "" || v == "none" || v == "transparent":
+		return color.NRGBA{}, false
+	case v == "currentcolor":
+		return color.NRGBA{0, 0, 0, 255}, true
+	case strings.HasPrefix(v, "#"):
+		h := v[1:]
+		if len(h) == 3 || len(h) == 4 {
+			var b strings.Builder
+			for _, c := range h {
+				b.WriteRune(c)
+				b.WriteRune(c)
+			}
+			h = b.String()
+		}
+		c, err := ParseHexColor("#" + h)
+		return c, err == nil
+	case strings.HasPrefix(v, "rgb(") || strings.HasPrefix(v, "rgba("):
+		inner := strings.TrimSuffix(v[strings.IndexByte(v, '(')+1:], ")")
+		parts := strings.FieldsFunc(inner, func(r rune) bool { return r == ',' || r == ' ' || r == '/' })
+		if len(parts) < 3 {
+			return color.NRGBA{}, false
+		}
+		var ch [4]uint8
+		ch[3] = 255
+		for i, p := range parts[:min(len(parts), 4)] {
+			f := 0.0
+			var err error
+			if strings.HasSuffix(p, "%") {
+				f, err = strconv.ParseFloat(strings.TrimSuffix(p, "%"), 64)
+				f *= 2.55
+			} else if f, err = strconv.ParseFloat(p, 64); i == 3 {
+				f *= 255
+			}
+			if err != nil {
+				return color.NRGBA{}, false
+			}
+			ch[i] = uint8(math.Round(math.Max(0, math.Min(255, f))))
+		}
+		return color.NRGBA{ch[0], ch[1], ch[2], ch[3]}, true
+	}
+	c, ok := svgNamedColors[v]
+	return c, ok
+}
+
+// parseSVGTransform parses a transform list.
+func parseSVGTransform(v string) (svgAffine, error) {
+	m := svgIdentity
+	rest := strings.TrimSpace(v)
+	for rest != "" {
+		open := strings.IndexByte(rest, '(')
+		end := strings.IndexByte(rest, ')')
+		if open < 0 || end < open {
+			return m, fmt.Errorf("svg: invalid transform %q", v)
+		}
+		name := strings.TrimSpace(strings.Trim(rest[:open], " ,"))
+		args, err := parseSVGNumbers(rest[open+1 : end])
+		if err != nil {
+			return m, fmt.Errorf("svg: invalid transform %q", v)
+		}
+		rest = strings.TrimSpace(strings.TrimLeft(rest[end+1:], " ,"))
+		arg := func(i int, def float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return def
+		}
+		var t svgAffine
+		switch name {
+		case "matrix":
+			if len(args) != 6 {
+				return m, fmt.Errorf("svg: invalid transform %q", v)
+			}
+			copy(t[:], args)
+		case "translate":
+			t = svgAffine{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+		case "scale":
+			sx := arg(0, 1)
+			t = svgAffine{sx, 0, 0, arg(1, sx), 0, 0}
+		case "rotate":
+			sin, cos := math.Sincos(arg(0, 0) * math.Pi / 180)
+			cx, cy := arg(1, 0), arg(2, 0)
+			t = svgAffine{cos, sin, -sin, cos, cx - cos*cx + sin*cy, cy - sin*cx - cos*cy}
+		case "skewX":
+			t = svgAffine{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			t = svgAffine{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return m, fmt.Errorf("svg: unknown transform %q", name)
+		}
+		// Each transform in the list applies before the ones to its left.
+		m = t.then(m)
+	}
+	return m, nil
+}
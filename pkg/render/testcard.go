@@ -0,0 +1,100 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// colorChecker holds the sRGB values of the 24 patches of the X-Rite
+// ColorChecker Classic, in chart order: natural colors, primaries and
+// secondaries, then the gray row from white to black.
+var colorChecker = [24]color.NRGBA{
+	{115, 82, 68, 255}, {194, 150, 130, 255}, {98, 122, 157, 255}, {87, 108, 67, 255}, {133, 128, 177, 255}, {103, 189, 170, 255},
+	{214, 126, 44, 255}, {80, 91, 166, 255}, {193, 90, 99, 255}, {94, 60, 108, 255}, {157, 188, 64, 255}, {224, 163, 46, 255},
+	{56, 61, 150, 255}, {70, 148, 73, 255}, {175, 54, 60, 255}, {231, 199, 31, 255}, {187, 86, 149, 255}, {8, 133, 161, 255},
+	{243, 243, 242, 255}, {200, 200, 200, 255}, {160, 160, 160, 255}, {122, 122, 121, 255}, {85, 85, 85, 255}, {52, 52, 52, 255},
+}
+
+// clipLevels are the near-black and near-white gray levels of the test
+// card, where crushed shadows and blown highlights show first.
+var clipLevels = [2][4]uint8{{0, 4, 8, 16}, {239, 247, 251, 255}}
+
+// minTestCardSize is the smallest side TestCard draws.
+const minTestCardSize = 64
+
+// TestCard returns a calibration image of width×height pixels for judging
+// how a mark looks on different content, devices and formats: from top to
+// bottom a hue sweep from white through full color to black, a smooth gray
+// ramp, gray steps of 10%, red, green and blue ramps, then ColorChecker
+// patches beside near-black and near-white patches. Mark it like any other
+// image.
+func TestCard(width, height int) (*image.NRGBA, error) {
+	if width < minTestCardSize || height < minTestCardSize {
+		return nil, fmt.Errorf("test card must be at least %d×%d pixels", minTestCardSize, minTestCardSize)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	band := func(from, to float64) image.Rectangle {
+		return image.Rect(0, int(from*float64(height)), width, int(to*float64(height)))
+	}
+	// frac is the position of x across the width, 0 at the left edge and 1
+	// at the right.
+	frac := func(x int) float64 { return float64(x) / float64(width-1) }
+
+	hues := band(0, 0.3)
+	for y := hues.Min.Y; y < hues.Max.Y; y++ {
+		l := 1 - float64(y-hues.Min.Y)/float64(hues.Dy()-1)
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, hslToRGB(360*frac(x), 1, l))
+		}
+	}
+	ramp := func(r image.Rectangle, at func(v uint8) color.NRGBA) {
+		for x := 0; x < width; x++ {
+			fillRect(img, image.Rect(x, r.Min.Y, x+1, r.Max.Y), at(uint8(255*frac(x)+0.5)))
+		}
+	}
+	ramp(band(0.3, 0.42), func(v uint8) color.NRGBA { return color.NRGBA{v, v, v, 255} })
+	steps := band(0.42, 0.5)
+	for i := 0; i <= 10; i++ {
+		v := uint8(255*float64(i)/10 + 0.5)
+		r := image.Rect(i*width/11, steps.Min.Y, (i+1)*width/11, steps.Max.Y)
+		fillRect(img, r, color.NRGBA{v, v, v, 255})
+	}
+	primaries := band(0.5, 0.65)
+	third := primaries.Dy() / 3
+	ramp(image.Rect(0, primaries.Min.Y, width, primaries.Min.Y+third), func(v uint8) color.NRGBA { return color.NRGBA{v, 0, 0, 255} })
+	ramp(image.Rect(0, primaries.Min.Y+third, width, primaries.Max.Y-third), func(v uint8) color.NRGBA { return color.NRGBA{0, v, 0, 255} })
+	ramp(image.Rect(0, primaries.Max.Y-third, width, primaries.Max.Y), func(v uint8) color.NRGBA { return color.NRGBA{0, 0, v, 255} })
+
+	// The patches sit on mid gray with gaps between them.
+	patches := band(0.65, 1)
+	fillRect(img, patches, color.NRGBA{128, 128, 128, 255})
+	gap := max(1, width/200)
+	checker := image.Rect(0, patches.Min.Y, width*2/3, patches.Max.Y-gap)
+	grid(checker, 6, 4, gap, func(i int, r image.Rectangle) { fillRect(img, r, colorChecker[i]) })
+	clip := image.Rect(checker.Max.X, patches.Min.Y, width-gap, patches.Max.Y-gap)
+	grid(clip, 4, 2, gap, func(i int, r image.Rectangle) {
+		v := clipLevels[i/4][i%4]
+		fillRect(img, r, color.NRGBA{v, v, v, 255})
+	})
+	return img, nil
+}
+
+// grid splits r into cols×rows cells inset by gap and calls cell for each,
+// row by row.
+func grid(r image.Rectangle, cols, rows, gap int, cell func(i int, r image.Rectangle)) {
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			c := image.Rect(
+				r.Min.X+col*r.Dx()/cols+gap, r.Min.Y+row*r.Dy()/rows+gap,
+				r.Min.X+(col+1)*r.Dx()/cols, r.Min.Y+(row+1)*r.Dy()/rows,
+			)
+			cell(row*cols+col, c)
+		}
+	}
+}
+
+func fillRect(img *image.NRGBA, r image.Rectangle, c color.NRGBA) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
@@ -0,0 +1,134 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// TextTransform changes the case of the mark text as it is rendered, with
+// the full Unicode case mappings.
+type TextTransform string
+
+const (
+	// TextTransformNone renders the text as it is.
+	TextTransformNone TextTransform = ""
+	// TextTransformUpper renders every letter as a capital, per Unicode
+	// case mapping, e.g. "straße café" as "STRASSE CAFÉ".
+	TextTransformUpper TextTransform = "upper"
+	// TextTransformLower renders every letter in lowercase.
+	TextTransformLower TextTransform = "lower"
+	// TextTransformTitle capitalizes the first letter of each word and
+	// leaves the others as they are.
+	TextTransformTitle TextTransform = "title"
+	// TextTransformSmallCaps renders lowercase letters as small capitals:
+	// the font's own small-cap glyphs (named like "a.sc" or "a.smcp") where
+	// it has them, otherwise its capitals scaled to SmallCapsScale.
+	TextTransformSmallCaps TextTransform = "smallcaps"
+)
+
+// ParseTextTransform parses "upper", "lower", "title", "smallcaps", or
+// "none" or "" for none.
+func ParseTextTransform(s string) (TextTransform, error) {
+	switch t := TextTransform(s); t {
+	case TextTransformNone, TextTransformUpper, TextTransformLower, TextTransformTitle, TextTransformSmallCaps:
+		return t, nil
+	case "none":
+		return TextTransformNone, nil
+	}
+	return "", fmt.Errorf("invalid text transform %q, want upper, lower, title, smallcaps or none", s)
+}
+
+// SmallCapsScale is the size of synthesized small capitals relative to the
+// font size, as browsers synthesize them.
+const SmallCapsScale = 0.7
+
+// CaseText applies the case mappings of t that need no font, with the full
+// Unicode mappings, so "ß" uppercases to "SS". Small caps are left to the
+// layout, see SmallCaps.
+func CaseText(text string, t TextTransform) string {
+	switch t {
+	case TextTransformUpper:
+		return cases.Upper(language.Und).String(text)
+	case TextTransformLower:
+		return cases.Lower(language.Und).String(text)
+	case TextTransformTitle:
+		return cases.Title(language.Und, cases.NoLower).String(text)
+	}
+	return text
+}
+
+// SmallCaps maps lowercase letters to the glyphs small caps set them with.
+type SmallCaps struct {
+	// native holds the font's small-cap glyph of each lowercase letter that
+	// has one.
+	native map[rune]sfnt.GlyphIndex
+}
+
+// NewSmallCaps finds the small-cap glyphs fnt has for the letters of text.
+func NewSmallCaps(fnt *sfnt.Font, buf *sfnt.Buffer, text string) (*SmallCaps, error) {
+	sc := &SmallCaps{native: map[rune]sfnt.GlyphIndex{}}
+	want := map[string]rune{}
+	for _, r := range text {
+		if !unicode.IsLower(r) {
+			continue
+		}
+		idx, err := fnt.GlyphIndex(buf, r)
+		if err != nil || idx == 0 {
+			continue
+		}
+		if name, err := fnt.GlyphName(buf, idx); err == nil && name != "" {
+			want[name+".sc"] = r
+			want[name+".smcp"] = r
+		}
+	}
+	if len(want) == 0 {
+		return sc, nil
+	}
+	for i := 0; i < fnt.NumGlyphs(); i++ {
+		name, err := fnt.GlyphName(buf, sfnt.GlyphIndex(i))
+		if err != nil {
+			return nil, err
+		}
+		if r, ok := want[name]; ok {
+			sc.native[r] = sfnt.GlyphIndex(i)
+		}
+	}
+	return sc, nil
+}
+
+// Expand spells out lowercase letters without a native small-cap glyph
+// whose capital is several letters, such as "ß" as "ss", so each comes out
+// as a small capital.
+func (sc *SmallCaps) Expand(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if _, ok := sc.native[r]; !ok && unicode.IsLower(r) && unicode.ToUpper(r) == r {
+			if up := cases.Upper(language.Und).String(string(r)); up != string(r) {
+				b.WriteString(strings.ToLower(up))
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Glyph returns what r is set as: a native small-cap glyph (idx non-zero),
+// or the rune to set at scale times the font size.
+func (sc *SmallCaps) Glyph(r rune) (idx sfnt.GlyphIndex, set rune, scale float64) {
+	if sc == nil {
+		return 0, r, 1
+	}
+	if idx, ok := sc.native[r]; ok {
+		return idx, r, 1
+	}
+	if u := unicode.ToUpper(r); u != r && unicode.IsLower(r) {
+		return 0, u, SmallCapsScale
+	}
+	return 0, r, 1
+}
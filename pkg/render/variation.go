@@ -0,0 +1,157 @@
+package render
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	gotext "github.com/go-text/typesetting/font"
+	ot "github.com/go-text/typesetting/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// FontVariation sets an axis of a variable font to a value in the font's
+// design units, e.g. {"wght", 700} for bold from a font with a weight axis.
+type FontVariation struct {
+	// Tag is the four-letter axis tag, such as "wght", "wdth" or "slnt".
+	Tag   string
+	Value float64
+}
+
+// ParseFontVariations parses comma-separated axis settings such as
+// "wght=700,slnt=-10".
+func ParseFontVariations(s string) ([]FontVariation, error) {
+	var vars []FontVariation
+	for _, part := range strings.Split(s, ",") {
+		tag, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("font variation %q must be axis=value", part)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("font variation %q: invalid value", part)
+		}
+		vars = append(vars, FontVariation{Tag: strings.TrimSpace(tag), Value: v})
+	}
+	return vars, nil
+}
+
+// Valid reports whether the tag of v is four printable ASCII characters.
+func (v FontVariation) Valid() bool {
+	return validTag(v.Tag)
+}
+
+// validTag reports whether tag is four printable ASCII characters.
+func validTag(tag string) bool {
+	if len(tag) != 4 {
+		return false
+	}
+	for i := 0; i < 4; i++ {
+		if tag[i] < 0x20 || tag[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// fontAxis is a variation axis of a font, from its fvar table.
+type fontAxis struct {
+	tag           string
+	min, def, max float64
+}
+
+// parseFvar returns the axes of an fvar table.
+func parseFvar(t []byte) []fontAxis {
+	if len(t) < 16 {
+		return nil
+	}
+	be := binary.BigEndian
+	off, count, size := int(be.Uint16(t[4:])), int(be.Uint16(t[8:])), int(be.Uint16(t[10:]))
+	if size < 20 || off+count*size > len(t) {
+		return nil
+	}
+	fixed16 := func(b []byte) float64 { return float64(int32(be.Uint32(b))) / 65536 }
+	axes := make([]fontAxis, count)
+	for i := range axes {
+		rec := t[off+i*size:]
+		axes[i] = fontAxis{tag: string(rec[:4]), min: fixed16(rec[4:]), def: fixed16(rec[8:]), max: fixed16(rec[12:])}
+	}
+	return axes
+}
+
+// checkVariations returns an error unless f has an axis for each of vars.
+// Values outside an axis's range are clamped to it when drawing.
+func (f *Font) checkVariations(vars []FontVariation) error {
+	if len(vars) == 0 {
+		return nil
+	}
+	axes := parseFvar(FontTable(f.data, "fvar"))
+	if len(axes) == 0 {
+		return fmt.Errorf("font variations %s: the font is not a variable font", formatVariations(vars))
+	}
+	tags := make([]string, len(axes))
+	for i, a := range axes {
+		tags[i] = fmt.Sprintf("%s %g..%g", a.tag, a.min, a.max)
+	}
+	for _, v := range vars {
+		found := false
+		for _, a := range axes {
+			found = found || a.tag == v.Tag
+		}
+		if !found {
+			return fmt.Errorf("font has no %q axis (axes: %s)", v.Tag, strings.Join(tags, ", "))
+		}
+	}
+	return nil
+}
+
+func formatVariations(vars []FontVariation) string {
+	parts := make([]string, len(vars))
+	for i, v := range vars {
+		parts[i] = fmt.Sprintf("%s=%g", v.Tag, v.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// shapingVariations converts vars for go-text faces.
+func shapingVariations(vars []FontVariation) []gotext.Variation {
+	out := make([]gotext.Variation, len(vars))
+	for i, v := range vars {
+		out[i] = gotext.Variation{Tag: ot.MustNewTag(v.Tag), Value: float32(v.Value)}
+	}
+	return out
+}
+
+// variedContours returns the contours of glyph idx of face at its variation
+// coordinates, scaled to ppem with y down like glyphContours. Glyphs
+// without an outline have none.
+func variedContours(face *gotext.Face, idx sfnt.GlyphIndex, ppem fixed.Int26_6) []sfnt.Segment {
+	outline, ok := face.GlyphData(gotext.GID(idx)).(gotext.GlyphOutline)
+	if !ok {
+		return nil
+	}
+	scale := float64(ppem) / float64(face.Upem())
+	segs := make([]sfnt.Segment, len(outline.Segments))
+	for i, s := range outline.Segments {
+		switch s.Op {
+		case ot.SegmentOpMoveTo:
+			segs[i].Op = sfnt.SegmentOpMoveTo
+		case ot.SegmentOpLineTo:
+			segs[i].Op = sfnt.SegmentOpLineTo
+		case ot.SegmentOpQuadTo:
+			segs[i].Op = sfnt.SegmentOpQuadTo
+		case ot.SegmentOpCubeTo:
+			segs[i].Op = sfnt.SegmentOpCubeTo
+		}
+		for j, p := range s.ArgsSlice() {
+			segs[i].Args[j] = fixed.Point26_6{
+				X: fixed.Int26_6(math.Round(float64(p.X) * scale)),
+				Y: fixed.Int26_6(math.Round(-float64(p.Y) * scale)),
+			}
+		}
+	}
+	return segs
+}
@@ -0,0 +1,81 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// alphaImage returns a 64x48 image whose left third is fully transparent
+// with colors hidden under it, middle third half transparent and right
+// third opaque.
+func alphaImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			o := img.PixOffset(x, y)
+			a := uint8(255)
+			switch {
+			case x < 21:
+				a = 0
+			case x < 42:
+				a = 128
+			}
+			img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = uint8(x), uint8(y), uint8(x^y), a
+		}
+	}
+	return img
+}
+
+func TestPreserveAlpha(t *testing.T) {
+	src := alphaImage()
+	var in, out bytes.Buffer
+	if err := png.Encode(&in, src); err != nil {
+		t.Fatal(err)
+	}
+	_, err := AddPositionWatermarkStream(context.Background(), &in, &out, FormatPNG, "ALPHA",
+		WithPreserveAlpha(true), WithPosition(Center), WithFontSize(40), WithOpacity(1), WithColor("#ff0000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := decoded.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("decoded %T, want *image.NRGBA", decoded)
+	}
+	marked := 0
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			s, g := src.NRGBAAt(x, y), got.NRGBAAt(x, y)
+			if g.A != s.A {
+				t.Fatalf("alpha at %d,%d is %d, want %d", x, y, g.A, s.A)
+			}
+			if s.A == 0 && g != s {
+				t.Fatalf("transparent pixel at %d,%d is %v, want %v", x, y, g, s)
+			}
+			if s.A == 128 && g != s {
+				marked++
+			}
+		}
+	}
+	if marked == 0 {
+		t.Error("the mark does not show on the half-transparent pixels")
+	}
+}
+
+func TestPreserveAlphaJPEG(t *testing.T) {
+	var in bytes.Buffer
+	if err := png.Encode(&in, alphaImage()); err != nil {
+		t.Fatal(err)
+	}
+	_, err := AddPositionWatermarkStream(context.Background(), &in, &bytes.Buffer{}, FormatJPEG, "ALPHA", WithPreserveAlpha(true))
+	if !errors.Is(err, ErrAlphaLost) {
+		t.Errorf("err = %v, want ErrAlphaLost", err)
+	}
+}
@@ -0,0 +1,133 @@
+package watermark
+
+import (
+	"io"
+
+	"watermark/pkg/codec"
+)
+
+// The encoding types are those of package codec, so values pass between
+// the two packages unconverted.
+type (
+	// EncodeOptions tunes how outputs are encoded; see codec.EncodeOptions.
+	EncodeOptions = codec.EncodeOptions
+	// Subsampling is a JPEG chroma subsampling scheme.
+	Subsampling = codec.Subsampling
+	// PNGCompression is a PNG compression level.
+	PNGCompression = codec.PNGCompression
+	// PNGColor chooses the color type and bit depth of PNG outputs; see
+	// codec.PNGColor.
+	PNGColor = codec.PNGColor
+	// Format is an output image encoding.
+	Format = codec.Format
+	// ColorProfile says what happens to the embedded ICC color profile of
+	// an input; see codec.ColorProfile.
+	ColorProfile = codec.ColorProfile
+	// SourceLimits bounds the inputs an ImageSource accepts; see
+	// codec.SourceLimits.
+	SourceLimits = codec.SourceLimits
+	// ImageSource is an encoded input held in memory together with what its
+	// headers tell. The Add*, Fanout, extract and detect functions all read
+	// their inputs through one.
+	ImageSource = codec.ImageSource
+	// OriginCheck is the result of VerifyOrigin.
+	OriginCheck = codec.OriginCheck
+)
+
+const (
+	Subsampling420 = codec.Subsampling420
+	Subsampling444 = codec.Subsampling444
+
+	PNGCompressionDefault = codec.PNGCompressionDefault
+	PNGCompressionFast    = codec.PNGCompressionFast
+	PNGCompressionBest    = codec.PNGCompressionBest
+	PNGCompressionNone    = codec.PNGCompressionNone
+
+	PNGColorAuto      = codec.PNGColorAuto
+	PNGColorTruecolor = codec.PNGColorTruecolor
+	PNGColorGray      = codec.PNGColorGray
+
+	FormatJPEG = codec.FormatJPEG
+	FormatPNG  = codec.FormatPNG
+	FormatTIFF = codec.FormatTIFF
+	FormatICO  = codec.FormatICO
+	// FormatWebP is lossless WebP, written without metadata or a color
+	// profile.
+	FormatWebP = codec.FormatWebP
+	// FormatPDF is only valid for PDF inputs, which keep their format.
+	FormatPDF = codec.FormatPDF
+
+	ColorProfileKeep = codec.ColorProfileKeep
+	ColorProfileSRGB = codec.ColorProfileSRGB
+)
+
+// ParseSubsampling parses "420", "4:2:0", "444" or "4:4:4".
+func ParseSubsampling(s string) (Subsampling, error) {
+	return codec.ParseSubsampling(s)
+}
+
+// ParsePNGCompression parses "default", "fast", "best" or "none".
+func ParsePNGCompression(s string) (PNGCompression, error) {
+	return codec.ParsePNGCompression(s)
+}
+
+// ParsePNGColor parses "auto", "truecolor" or "gray".
+func ParsePNGColor(s string) (PNGColor, error) {
+	return codec.ParsePNGColor(s)
+}
+
+// ParseFormat parses a format name such as "png", "jpg", "jpeg" or "tif".
+func ParseFormat(name string) (Format, error) {
+	return codec.ParseFormat(name)
+}
+
+// FormatFromPath picks the output format from the file extension of path.
+func FormatFromPath(path string) (Format, error) {
+	return codec.FormatFromPath(path)
+}
+
+// CheckFormat returns ErrFormatMismatch if the extension of path names no
+// image format, so writing format to it would hide what the file holds. An
+// extension of another image format is fine: an explicit format wins.
+func CheckFormat(path string, format Format) error {
+	return codec.CheckFormat(path, format)
+}
+
+// ParseColorProfile parses "keep" or "srgb".
+func ParseColorProfile(s string) (ColorProfile, error) {
+	return codec.ParseColorProfile(s)
+}
+
+// NewImageSource sniffs data and checks it against limits. It returns
+// ErrUnsupportedFormat for data no decoder recognizes and ErrInputTooLarge
+// for data over a limit.
+func NewImageSource(data []byte, limits SourceLimits) (*ImageSource, error) {
+	return codec.NewImageSource(data, limits)
+}
+
+// OpenImageSource reads the file at path into an ImageSource, checking
+// MaxBytes before reading.
+func OpenImageSource(path string, limits SourceLimits) (*ImageSource, error) {
+	return codec.OpenImageSource(path, limits)
+}
+
+// ReadImageSource reads r to EOF into an ImageSource, reading at most one
+// byte past MaxBytes.
+func ReadImageSource(r io.Reader, limits SourceLimits) (*ImageSource, error) {
+	return codec.ReadImageSource(r, limits)
+}
+
+// OriginChecksum returns the SHA-256 of the original file that the marked
+// image at path was made from, as WithOriginChecksum recorded it. Images
+// without one return ErrNoOrigin.
+func OriginChecksum(path string) ([]byte, error) {
+	return codec.OriginChecksum(path)
+}
+
+// VerifyOrigin checks whether the file at originalPath is, byte for byte,
+// the original the marked image at markedPath was made from with
+// WithOriginChecksum. A re-encoded or edited copy of the original does not
+// match.
+func VerifyOrigin(markedPath, originalPath string) (OriginCheck, error) {
+	return codec.VerifyOrigin(markedPath, originalPath)
+}
@@ -0,0 +1,34 @@
+package watermark
+
+import (
+	"fmt"
+	"sort"
+
+	"watermark/internal/pipeline"
+)
+
+// WithDensities writes the output as pixel-density variants for app and
+// web asset pipelines, e.g. 1, 2, 3 for @1x, @2x and @3x. The input is the
+// asset at the largest scale: it is marked once and resampled for the
+// smaller ones, so the mark keeps the same size in points on every screen
+// and pixel sizes such as the font size apply to the largest variant.
+// Variants are named like Apple asset catalogs, "icon.png" at 1x and
+// "icon@2x.png" at 2x, after the output path, and listed in
+// Result.Variants. PDF and ICO inputs and streams have no variants.
+func WithDensities(scales ...int) Option {
+	return func(s *pipeline.Settings) error {
+		seen := map[int]bool{}
+		for _, scale := range scales {
+			if scale < 1 {
+				return fmt.Errorf("density scales must be at least 1, got %d", scale)
+			}
+			if seen[scale] {
+				return fmt.Errorf("density scale %d given twice", scale)
+			}
+			seen[scale] = true
+		}
+		s.Densities = append([]int(nil), scales...)
+		sort.Sort(sort.Reverse(sort.IntSlice(s.Densities)))
+		return nil
+	}
+}
@@ -0,0 +1,34 @@
+// Package watermark adds visible and invisible watermarks to images and
+// PDFs: text or custom marks tiled across the image (AddRepeatWatermark) or
+// placed once (AddPositionWatermark), configured with Option values.
+//
+// The work is done by the packages it is built on: package render draws
+// text and mark images, package compose tiles and places them on an image,
+// package codec decodes and encodes images, package pdf stamps PDFs,
+// package invisible hides marks that do not show, and packages preset and
+// fanout hold presets and fan-out manifests. Types this package shares
+// with them, such as TextRenderer, Position and EncodeOptions, are aliases
+// of their types.
+//
+// # Stability
+//
+// The module has not reached v1.0.0, but part of its API is stable
+// already: it is neither removed nor changed incompatibly before v1.0.0,
+// and v1.0.0 keeps it. That part is
+//
+//   - the Add*Watermark functions and their Stream variants,
+//     ExtractInvisibleWatermark, DetectRobustWatermark and SaveImage,
+//     SaveImageAs and SaveImageWithOptions;
+//   - Option and the With* functions: their names, argument types and
+//     meaning;
+//   - the Err* sentinel errors, matched with errors.Is;
+//   - the fields of Result.
+//
+// Minor versions may add options, Result fields and sentinel errors.
+//
+// Everything else may still change in any minor version: presets and
+// tenant presets, Fanout and its manifest, Watermarker, events and
+// EventKind, the plugin protocol, fingerprints, and all of packages
+// render, compose, codec, pdf, invisible, preset and fanout. Warning texts
+// and the exact pixels of a mark are not covered either.
+package watermark
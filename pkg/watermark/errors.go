@@ -0,0 +1,44 @@
+package watermark
+
+import (
+	"watermark/internal/pipeline"
+	"watermark/pkg/codec"
+	"watermark/pkg/invisible"
+	"watermark/pkg/render"
+)
+
+// Sentinel errors returned (possibly wrapped) by this package. Use errors.Is
+// to test for them.
+var (
+	// ErrUnsupportedFormat means an input could not be decoded as a known
+	// image format or an output format is not supported.
+	ErrUnsupportedFormat = codec.ErrUnsupportedFormat
+	// ErrCorruptInput means an input is in a known format but too damaged to
+	// decode.
+	ErrCorruptInput = codec.ErrCorruptInput
+	// ErrInputTooLarge means an input exceeds the SourceLimits in effect.
+	ErrInputTooLarge = codec.ErrInputTooLarge
+	// ErrFontLoad means a font file could not be read or parsed.
+	ErrFontLoad = render.ErrFontLoad
+	// ErrEmptyMark means the watermark text is empty or renders no pixels.
+	ErrEmptyMark = render.ErrEmptyMark
+	// ErrInvalidOpacity means an opacity outside [0, 1] was given.
+	ErrInvalidOpacity = render.ErrInvalidOpacity
+	// ErrTooManyTiles means repeat mode would paste more tiles than allowed.
+	ErrTooManyTiles = pipeline.ErrTooManyTiles
+	// ErrFormatMismatch means an explicit output format was given for a
+	// file whose extension names no image format.
+	ErrFormatMismatch = codec.ErrFormatMismatch
+	// ErrPayloadTooLarge means an invisible watermark payload does not fit
+	// in the image.
+	ErrPayloadTooLarge = invisible.ErrPayloadTooLarge
+	// ErrAlphaLost means WithPreserveAlpha is set and the output format
+	// cannot store the transparency of the image.
+	ErrAlphaLost = pipeline.ErrAlphaLost
+	// ErrColorProfile means an ICC color profile cannot be converted from.
+	ErrColorProfile = codec.ErrColorProfile
+	// ErrNoPayload means an image carries no intact invisible watermark.
+	ErrNoPayload = invisible.ErrNoPayload
+	// ErrNoOrigin means an image carries no origin checksum.
+	ErrNoOrigin = codec.ErrNoOrigin
+)
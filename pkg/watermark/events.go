@@ -0,0 +1,29 @@
+package watermark
+
+import "watermark/internal/pipeline"
+
+// Logger receives diagnostic messages. *log.Logger satisfies it.
+type Logger = pipeline.Logger
+
+// EventKind identifies a warning raised while watermarking.
+type EventKind = pipeline.EventKind
+
+// Event is a warning raised while watermarking.
+type Event = pipeline.Event
+
+const (
+	// EventEmptyMark means the rendered mark has no visible pixels.
+	EventEmptyMark = pipeline.EventEmptyMark
+	// EventFontFallback means the requested font could not be loaded and a
+	// fallback font was used instead.
+	EventFontFallback = pipeline.EventFontFallback
+	// EventInvisible means the result is identical to the source, as
+	// WithVerify checks, or that a video pattern is empty.
+	EventInvisible = pipeline.EventInvisible
+	// EventLowContrast means WithCVDCheck found the mark color hard to tell
+	// from the image for typical or color-deficient vision.
+	EventLowContrast = pipeline.EventLowContrast
+	// EventJPEGLoss means WithJPEGProof found that JPEG compression destroys
+	// most of the mark's fine detail.
+	EventJPEGLoss = pipeline.EventJPEGLoss
+)
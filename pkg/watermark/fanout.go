@@ -0,0 +1,38 @@
+package watermark
+
+import (
+	"context"
+
+	"watermark/internal/pipeline"
+	"watermark/pkg/fanout"
+)
+
+// Manifest maps the copies made by Fanout to their recipients; see
+// fanout.Manifest. Keep it private: the keys are what DetectRobustWatermark
+// needs to trace a leak.
+type Manifest = fanout.Manifest
+
+// ManifestEntry is one copy made by Fanout.
+type ManifestEntry = fanout.Entry
+
+// ManifestName is the file Fanout writes its manifest to, inside the output
+// directory.
+const ManifestName = fanout.ManifestName
+
+// ReadManifest reads a manifest written by Fanout.
+func ReadManifest(path string) (*Manifest, error) {
+	return fanout.Read(path)
+}
+
+// FanoutConfig describes the copies Fanout makes.
+type FanoutConfig = pipeline.FanoutConfig
+
+// Fanout writes one uniquely marked copy of the image at inputPath per
+// recipient into outputDir, for tracing leaks back to their source, and
+// records who got which in outputDir/manifest.json. Copies are named after
+// the input and their serial, keep the input's extension unless WithFormat
+// is given, and do not reveal the recipient in their name. An existing
+// manifest is never overwritten.
+func Fanout(ctx context.Context, inputPath, outputDir string, fc FanoutConfig, opts ...Option) (*Manifest, error) {
+	return pipeline.Fanout(ctx, inputPath, outputDir, fc, opts...)
+}
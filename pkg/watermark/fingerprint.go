@@ -0,0 +1,22 @@
+package watermark
+
+import "watermark/pkg/invisible"
+
+// Fingerprint is what DecodeFingerprint recovers from a marked text; see
+// invisible.Fingerprint.
+type Fingerprint = invisible.Fingerprint
+
+// DecodeFingerprint reads the fingerprint WithFingerprint hid in a
+// watermark text, e.g. text copied from a document or OCR'd from a leaked
+// image.
+func DecodeFingerprint(text string) Fingerprint {
+	return invisible.DecodeFingerprint(text)
+}
+
+// MatchFingerprint returns the id among ids that text was fingerprinted
+// with. A surviving zero-width ID must match exactly; otherwise the word
+// gaps are compared with each id's pattern, allowing one misread gap in
+// eight, and the closest id wins if it is the only one that close.
+func MatchFingerprint(text string, ids []string) (string, bool) {
+	return invisible.MatchFingerprint(text, ids)
+}
@@ -0,0 +1,11 @@
+//go:build embedfont
+
+package watermark
+
+import _ "embed"
+
+// embeddedFont is the font compiled in with -tags embedfont. Copy the TTF to
+// pkg/watermark/embedded.ttf before building.
+//
+//go:embed embedded.ttf
+var embeddedFont []byte
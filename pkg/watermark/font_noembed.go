@@ -0,0 +1,6 @@
+//go:build !embedfont
+
+package watermark
+
+// embeddedFont is empty unless built with -tags embedfont.
+var embeddedFont []byte
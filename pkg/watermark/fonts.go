@@ -0,0 +1,45 @@
+package watermark
+
+import "watermark/pkg/render"
+
+// Font is a font loaded from memory, such as one embedded with go:embed,
+// so a binary need not ship font files. See LoadFontFromBytes.
+type Font = render.Font
+
+// DefaultFontCacheSize is how many fonts and faces are cached until
+// SetFontCacheSize says otherwise.
+const DefaultFontCacheSize = render.DefaultFontCacheSize
+
+func init() {
+	if HasEmbeddedFont() {
+		render.SetDefaultFont(embeddedFont)
+	}
+}
+
+// LoadFontFromBytes parses data, a TrueType or OpenType font. Loading the
+// same data again returns the same font without parsing it twice. A loaded
+// font stays in memory for the life of the process. From a font collection
+// it loads the first font.
+func LoadFontFromBytes(data []byte) (*Font, error) {
+	return render.LoadFontFromBytes(data)
+}
+
+// ClearFontCache drops every cached font and face, e.g. after font files
+// were replaced on disk; the cache does not notice that by itself. Fonts
+// loaded from bytes stay loaded.
+func ClearFontCache() {
+	render.ClearFontCache()
+}
+
+// SetFontCacheSize caps the font cache at n entries, each a parsed font
+// file or a face of one at one size, dropping the least recently used
+// ones beyond that. 0 turns caching off.
+func SetFontCacheSize(n int) {
+	render.SetFontCacheSize(n)
+}
+
+// HasEmbeddedFont reports whether the binary was built with -tags embedfont.
+// The embedded font is then used whenever no font path is given.
+func HasEmbeddedFont() bool {
+	return len(embeddedFont) > 0
+}
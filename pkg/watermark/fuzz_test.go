@@ -0,0 +1,174 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"watermark/pkg/pdf"
+	"watermark/pkg/render"
+)
+
+// The fuzz targets take input the way a server would receive it from an
+// untrusted client; a panic, hang or runaway allocation in one is a bug.
+// Their seeds run with go test; fuzz one with e.g.
+//
+//	go test -fuzz=FuzzInput ./pkg/watermark
+//
+// They need no font file: position mode falls back to the built-in font.
+
+// fuzzLimits keep inputs small enough that the fuzzer spends its time in
+// the parsers, not on pixels.
+var fuzzLimits = SourceLimits{MaxBytes: 1 << 20, MaxPixels: 1 << 20}
+
+// fuzzImage returns a small NRGBA image with every byte different.
+func fuzzImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	return img
+}
+
+func encodeFuzzImage(t testing.TB, format Format) []byte {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatPNG:
+		err = png.Encode(&buf, fuzzImage())
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, fuzzImage(), nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// farTIFF is a 110-byte TIFF whose StripOffsets values lie
+// at a 4 GB offset. Read through image.DecodeConfig's bufio.Reader,
+// package tiff buffered up to the offset.
+func farTIFF() []byte {
+	le := binary.LittleEndian
+	type entry struct {
+		tag, typ     uint16
+		count, value uint32
+	}
+	const short, long = 3, 4
+	entries := []entry{
+		{256, short, 1, 16},        // ImageWidth
+		{257, short, 1, 16},        // ImageLength
+		{258, short, 1, 8},         // BitsPerSample
+		{259, short, 1, 1},         // Compression: none
+		{262, short, 1, 1},         // PhotometricInterpretation: black is zero
+		{273, long, 2, 0xF0000000}, // StripOffsets, stored at the offset
+		{278, short, 1, 8},         // RowsPerStrip
+		{279, long, 2, 0xF0000008}, // StripByteCounts, likewise
+	}
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	b = le.AppendUint16(b, uint16(len(entries)))
+	for _, e := range entries {
+		b = le.AppendUint16(b, e.tag)
+		b = le.AppendUint16(b, e.typ)
+		b = le.AppendUint32(b, e.count)
+		b = le.AppendUint32(b, e.value)
+	}
+	return le.AppendUint32(b, 0)
+}
+
+func FuzzInput(f *testing.F) {
+	f.Add(encodeFuzzImage(f, FormatPNG))
+	f.Add(encodeFuzzImage(f, FormatJPEG))
+	f.Add(farTIFF())
+	f.Add([]byte("%PDF-1.4\n"))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		src, err := NewImageSource(data, fuzzLimits)
+		if err != nil {
+			checkInputError(t, err)
+			return
+		}
+		// Marked in the format of data, or as PNG if it cannot be written.
+		format, err := ParseFormat(string(src.Format))
+		if err != nil {
+			format = FormatPNG
+		}
+		_, err = AddPositionWatermarkStream(context.Background(), bytes.NewReader(data), io.Discard, format, "fuzz",
+			WithLimits(fuzzLimits), WithTolerant(true), WithPositionFontSize(12))
+		if err != nil {
+			checkInputError(t, err)
+		}
+	})
+}
+
+// checkInputError fails unless err tells why an input cannot be read.
+func checkInputError(t *testing.T, err error) {
+	t.Helper()
+	for _, want := range []error{ErrUnsupportedFormat, ErrCorruptInput, ErrInputTooLarge} {
+		if errors.Is(err, want) {
+			return
+		}
+	}
+	t.Fatalf("untyped input error: %v", err)
+}
+
+func FuzzText(f *testing.F) {
+	for _, s := range []string{"fuzz", "", "Ünïcødé\nsecond line", "水印 テスト", "‮RTL̀", "{page}/{pages} {date}"} {
+		f.Add(s)
+	}
+	img := encodeFuzzImage(f, FormatPNG)
+	f.Fuzz(func(t *testing.T, text string) {
+		// Small caps draw from glyph outlines, so both paths are run.
+		for _, tt := range []TextTransform{TextTransformNone, TextTransformSmallCaps} {
+			_, err := AddPositionWatermarkStream(context.Background(), bytes.NewReader(img), io.Discard, FormatPNG, text,
+				WithTextTransform(tt), WithPositionFontSize(12))
+			if err != nil && !errors.Is(err, ErrEmptyMark) {
+				t.Fatalf("%q: %v", text, err)
+			}
+		}
+	})
+}
+
+func FuzzOptions(f *testing.F) {
+	for _, s := range []string{"#fff", "# f f f", "#12345g", "10,10,100,100", "center:1080x1080", "top=2%,left=5",
+		"x=10,y=20%", "50%,50%,100%,100%", "1-3,5,8-", "multiply", "smallcaps", "H", "srgb", "4:4:4", "best", "gray"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		// Each parser must return a value or an error, never panic.
+		render.ParseHexColor(value)
+		ParseCrop(value)
+		ParseMargins(value, Margins{})
+		ParseOffset(value)
+		ParseRegion(value)
+		pdf.ParsePageRanges(value)
+		ParseFormat(value)
+		ParseBlendMode(value)
+		ParseTextTransform(value)
+		ParseQRLevel(value)
+		ParseColorProfile(value)
+		ParseSubsampling(value)
+		ParsePNGCompression(value)
+		ParsePNGColor(value)
+	})
+}
+
+func FuzzSVG(f *testing.F) {
+	f.Add([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><circle cx="5" cy="5" r="4" fill="#c33"/></svg>`))
+	// Numbers after Z once looped forever, appending segments.
+	f.Add([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><path d="M0 0 L5 5 Z 1 2 3 4"/></svg>`))
+	f.Add([]byte(`<svg viewBox="0 0 0 0"/>`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, err := ParseSVG(data)
+		if err != nil {
+			return
+		}
+		SVGRenderer{SVG: doc, Width: 64}.Render(RenderOptions{Opacity: 1})
+	})
+}
@@ -0,0 +1,71 @@
+package watermark
+
+import (
+	"context"
+	"io"
+
+	"github.com/disintegration/imaging"
+
+	"watermark/internal/pipeline"
+	"watermark/pkg/invisible"
+)
+
+// AddInvisibleWatermark embeds payload, text or arbitrary bytes, in the
+// least-significant bits of the image and saves the output. Nothing visible
+// changes; ExtractInvisibleWatermark recovers the payload. The output must be
+// lossless (PNG, TIFF or BMP), since JPEG compression destroys the low bits.
+// The payload is embedded as given: template variables, plugins, transforms
+// and fingerprints are for visible text. Options that rewrite the pixels
+// after marking, such as WithStencil, WithBilevel, WithDensities and
+// grayscale PNG output, are rejected.
+func AddInvisibleWatermark(ctx context.Context, inputPath, outputPath, payload string, opts ...pipeline.Option) (*Result, error) {
+	cfg, err := pipeline.NewSettings(opts)
+	if err != nil {
+		return nil, err
+	}
+	format := cfg.Format
+	if format == "" {
+		format, _ = FormatFromPath(outputPath)
+	}
+	if err := pipeline.CheckLossless(format, outputPath); err != nil {
+		return nil, err
+	}
+	return pipeline.AddFile(ctx, "invisible", pipeline.InvisibleMark, inputPath, outputPath, payload, opts)
+}
+
+// AddInvisibleWatermarkStream is AddInvisibleWatermark reading the input from
+// r and writing the output to w in the given format.
+func AddInvisibleWatermarkStream(ctx context.Context, r io.Reader, w io.Writer, format Format, payload string, opts ...pipeline.Option) (*Result, error) {
+	if err := pipeline.CheckLossless(format, ""); err != nil {
+		return nil, err
+	}
+	return pipeline.AddStream(ctx, "invisible", pipeline.InvisibleMark, r, w, format, payload, opts)
+}
+
+// ExtractInvisibleWatermark returns the payload embedded in the image at
+// path by AddInvisibleWatermark. It returns ErrNoPayload if there is none.
+func ExtractInvisibleWatermark(path string) ([]byte, error) {
+	src, err := OpenImageSource(path, SourceLimits{})
+	if err != nil {
+		return nil, err
+	}
+	return extractInvisible(src)
+}
+
+// ExtractInvisibleWatermarkStream is ExtractInvisibleWatermark reading the
+// image from r.
+func ExtractInvisibleWatermarkStream(r io.Reader) ([]byte, error) {
+	src, err := ReadImageSource(r, SourceLimits{})
+	if err != nil {
+		return nil, err
+	}
+	return extractInvisible(src)
+}
+
+func extractInvisible(src *ImageSource) ([]byte, error) {
+	img, err := src.Decode()
+	if err != nil {
+		return nil, err
+	}
+	return invisible.Extract(imaging.Clone(img))
+}
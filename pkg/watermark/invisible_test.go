@@ -0,0 +1,43 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestInvisibleRoundTrip(t *testing.T) {
+	// Template variables, transforms and fingerprints are for visible text;
+	// the payload must come back byte for byte.
+	payload := "{filename} order 42\x00\xff"
+	var out bytes.Buffer
+	_, err := AddInvisibleWatermarkStream(context.Background(), bytes.NewReader(encodeFuzzImage(t, FormatPNG)), &out, FormatPNG, payload,
+		WithFilename("leak.png"), WithTextTransform(TextTransformUpper), WithFingerprint("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ExtractInvisibleWatermarkStream(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != payload {
+		t.Errorf("extracted %q, want %q", got, payload)
+	}
+}
+
+func TestInvisibleRejectsPixelRewrites(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"bilevel":   WithBilevel(true),
+		"stencil":   WithStencil(true),
+		"densities": WithDensities(2, 1),
+		"gray":      WithEncodeOptions(EncodeOptions{PNGColor: PNGColorGray}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := AddInvisibleWatermarkStream(context.Background(), bytes.NewReader(encodeFuzzImage(t, FormatPNG)), &bytes.Buffer{}, FormatPNG, "payload", opt)
+			if err == nil || !strings.Contains(err.Error(), "rewrites the pixels") {
+				t.Errorf("err = %v, want a conflict", err)
+			}
+		})
+	}
+}
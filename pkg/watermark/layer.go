@@ -0,0 +1,13 @@
+package watermark
+
+import "watermark/internal/pipeline"
+
+// Layer names one of the marks combined mode stacks.
+type Layer = pipeline.Layer
+
+const (
+	// LayerTiles is the repeated text or mark image.
+	LayerTiles = pipeline.LayerTiles
+	// LayerPosition is the single positioned mark.
+	LayerPosition = pipeline.LayerPosition
+)
@@ -0,0 +1,95 @@
+package watermark
+
+import (
+	"strings"
+
+	"watermark/pkg/compose"
+)
+
+// The layout types are those of package compose, so values pass between
+// the two packages unconverted.
+type (
+	// Position defines the watermark position.
+	Position = compose.Position
+	// Coord is a position along one axis, in pixels or, when Percent is
+	// set, as a percentage of the image size along that axis.
+	Coord = compose.Coord
+	// Offset is an explicit mark location used instead of a named position.
+	Offset = compose.Offset
+	// Region is a rectangle within the image, each corner in pixels or
+	// percent.
+	Region = compose.Region
+	// Margins are the position-mode distances between the mark and each
+	// side of the image; see compose.Margins.
+	Margins = compose.Margins
+	// Crop selects the part of the input to keep before watermarking; see
+	// compose.Crop.
+	Crop = compose.Crop
+	// BlendMode is how the colors of a layer mix with the image below it,
+	// as the blend modes of the same name in image editors.
+	BlendMode = compose.BlendMode
+)
+
+const (
+	BottomRight  = compose.BottomRight
+	BottomCenter = compose.BottomCenter
+	BottomLeft   = compose.BottomLeft
+	TopRight     = compose.TopRight
+	TopCenter    = compose.TopCenter
+	TopLeft      = compose.TopLeft
+	CenterRight  = compose.CenterRight
+	CenterLeft   = compose.CenterLeft
+	Center       = compose.Center
+
+	BlendNormal   = compose.BlendNormal
+	BlendMultiply = compose.BlendMultiply
+	BlendScreen   = compose.BlendScreen
+	BlendOverlay  = compose.BlendOverlay
+)
+
+// Px returns a pixel coordinate.
+func Px(v int) Coord { return compose.Px(v) }
+
+// Pct returns a coordinate as a percentage of the image size.
+func Pct(v float64) Coord { return compose.Pct(v) }
+
+// UniformMargins returns margins of c on every side.
+func UniformMargins(c Coord) Margins {
+	return compose.UniformMargins(c)
+}
+
+// ParseOffset parses "x=120,y=40" (pixels) or "x=85%,y=92%" (percentages of
+// the image size). Units may be mixed.
+func ParseOffset(s string) (Offset, error) {
+	return compose.ParseOffset(s)
+}
+
+// ParseRegion parses "x0,y0,x1,y1" where each value is in pixels or, with a
+// % suffix, relative to the image size, e.g. "50%,50%,100%,100%".
+func ParseRegion(s string) (Region, error) {
+	return compose.ParseRegion(s)
+}
+
+// ParseMargins parses "top=2%,right=5%,bottom=3%,left=5%", each side in
+// pixels or, with a % suffix, relative to the image size. Sides s does not
+// name keep their value from base.
+func ParseMargins(s string, base Margins) (Margins, error) {
+	return compose.ParseMargins(s, base)
+}
+
+// ParseCrop parses "x,y,w,h" in pixels, e.g. "100,50,1080,1080", or
+// "gravity:WxH" with a named position, e.g. "center:1080x1080".
+func ParseCrop(s string) (Crop, error) {
+	return compose.ParseCrop(s)
+}
+
+// ParseBlendMode parses a blend mode name such as "multiply".
+func ParseBlendMode(s string) (BlendMode, error) {
+	return compose.ParseBlendMode(s)
+}
+
+// isOffset reports whether a position string is an explicit offset rather
+// than a named anchor.
+func isOffset(s string) bool {
+	return strings.Contains(s, "=")
+}
@@ -0,0 +1,1027 @@
+package watermark
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+
+	"watermark/internal/pipeline"
+	"watermark/pkg/compose"
+	"watermark/pkg/invisible"
+	"watermark/pkg/pdf"
+	"watermark/pkg/render"
+)
+
+// Option configures AddRepeatWatermark and AddPositionWatermark. Values are
+// validated when the options are applied, before any image is read.
+// Options that only concern one mode are ignored by the other.
+type Option = pipeline.Option
+
+// WithColor sets the repeat-mode text color as #rgb, #rrggbb or #rrggbbaa,
+// or as a color-blind safe palette color such as "okabe-ito:blue".
+func WithColor(hex string) Option {
+	return func(s *pipeline.Settings) error {
+		if _, err := render.ParseHexColor(hex); err != nil {
+			return err
+		}
+		s.Color = hex
+		return nil
+	}
+}
+
+// WithTextTransforms applies the named text transformers, in order, to the
+// watermark text once template variables are filled in. Built in are
+// "upper", "lower", "trim" and "redact-emails"; see RegisterTextTransformer
+// for adding more.
+func WithTextTransforms(names ...string) Option {
+	return func(s *pipeline.Settings) error {
+		s.Transforms = nil
+		for _, name := range names {
+			fn, ok := lookupTextTransformer(strings.TrimSpace(name))
+			if !ok {
+				return fmt.Errorf("unknown text transformer %q (registered: %s)", name, strings.Join(TextTransformerNames(), ", "))
+			}
+			s.Transforms = append(s.Transforms, fn)
+		}
+		return nil
+	}
+}
+
+// WithTextTransform changes the case of the text as repeat, position and
+// combined mode and PDF stamps render it, e.g. TextTransformSmallCaps.
+// Unlike the "upper" and "lower" text transformers it also covers small
+// caps, which need the font.
+func WithTextTransform(t TextTransform) Option {
+	return func(s *pipeline.Settings) error {
+		if _, err := ParseTextTransform(string(t)); err != nil {
+			return err
+		}
+		s.TextTransform = t
+		return nil
+	}
+}
+
+// WithFontVariations sets axes of a variable font, such as weight, width
+// and slant, so one font file covers light, regular and bold marks in
+// repeat, position and combined mode. Text is then shaped and drawn from
+// outlines, without small caps. Each axis must exist in the font; PDF
+// stamps use its default instance.
+func WithFontVariations(vars ...FontVariation) Option {
+	return func(s *pipeline.Settings) error {
+		for _, v := range vars {
+			if !v.Valid() {
+				return fmt.Errorf("font variation axis %q must be a four-letter tag such as wght", v.Tag)
+			}
+		}
+		s.FontVariations = append([]FontVariation(nil), vars...)
+		return nil
+	}
+}
+
+// WithTextDirection sets the writing mode of the text in repeat, position
+// and combined mode, e.g. TextDirectionVertical for columns of Chinese or
+// Japanese text. PDF stamps are always horizontal.
+func WithTextDirection(d TextDirection) Option {
+	return func(s *pipeline.Settings) error {
+		if _, err := ParseTextDirection(string(d)); err != nil {
+			return err
+		}
+		s.TextDirection = d
+		return nil
+	}
+}
+
+// WithFingerprint hides id, such as a recipient code, in the watermark text:
+// as zero-width characters, which survive copying the text, and as a pattern
+// of normal and en spaces between words, which shows in the rendered image
+// and can be read back from OCR output that keeps interword spacing. See
+// DecodeFingerprint and MatchFingerprint.
+func WithFingerprint(id string) Option {
+	return func(s *pipeline.Settings) error {
+		if err := invisible.ValidateFingerprint(id); err != nil {
+			return err
+		}
+		s.Fingerprint = id
+		return nil
+	}
+}
+
+// WithCVDCheck warns through the Logger and OnEvent callback when the mark
+// color, blended at the set opacity, is hard to tell from the image beneath
+// it for typical vision or under simulated protanopia, deuteranopia or
+// tritanopia. Repeat mode compares against the whole image, position mode
+// against the area the mark covers. See PaletteNames for colors that hold
+// up well.
+func WithCVDCheck(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.CVDCheck = enabled
+		return nil
+	}
+}
+
+// WithJPEGProof re-encodes the marked image with the JPEG settings in
+// memory before anything is written, whatever the output format, and warns
+// with EventJPEGLoss when compression would wipe out most of the fine
+// strokes of the mark. Result.Proof holds the decoded preview and
+// Result.ProofDetail the share of detail that survives. Raise the font
+// size, opacity or quality when it warns.
+func WithJPEGProof(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.JPEGProof = enabled
+		return nil
+	}
+}
+
+// WithCoverageMap reports where the mark landed, to help tune the spacing,
+// angle and size of repeat mode: Result.CoverageMap shows how strongly the
+// mark changes each pixel as a heat map over a dimmed copy of the image,
+// and Result.Coverage is the share of pixels it changes.
+func WithCoverageMap(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.CoverageMap = enabled
+		return nil
+	}
+}
+
+// WithSpace sets the repeat-mode spacing between tiles in pixels.
+func WithSpace(px int) Option {
+	return func(s *pipeline.Settings) error {
+		if px < 0 {
+			return fmt.Errorf("space must not be negative, got %d", px)
+		}
+		s.Space = px
+		return nil
+	}
+}
+
+// WithAngle sets the repeat-mode rotation angle in degrees.
+func WithAngle(deg int) Option {
+	return func(s *pipeline.Settings) error {
+		s.Angle = deg
+		return nil
+	}
+}
+
+// WithAutoAngle has repeat mode choose the angle for each image, so the
+// text crosses the image's strongest edges, which makes the mark harder to
+// inpaint. Images without a dominant edge direction use the WithAngle angle.
+// PDF pages always use the WithAngle angle.
+func WithAutoAngle() Option {
+	return func(s *pipeline.Settings) error {
+		s.AutoAngle = true
+		return nil
+	}
+}
+
+// WithOpacity sets the watermark opacity in [0, 1].
+func WithOpacity(v float64) Option {
+	return func(s *pipeline.Settings) error {
+		if v < 0 || v > 1 {
+			return ErrInvalidOpacity
+		}
+		s.Opacity = v
+		return nil
+	}
+}
+
+// WithFont sets the font file (.ttf/.otf). Repeat mode requires it unless a
+// font is embedded (see HasEmbeddedFont); position mode falls back to the
+// embedded font, Arial or Go Regular without it.
+func WithFont(path string) Option {
+	return func(s *pipeline.Settings) error {
+		s.FontPath = path
+		return nil
+	}
+}
+
+// WithFontBytes sets the font from data, the contents of a .ttf or .otf
+// file such as one embedded with go:embed, instead of a font file. It
+// replaces WithFont and is replaced by it. See LoadFontFromBytes.
+func WithFontBytes(data []byte) Option {
+	f, err := LoadFontFromBytes(data)
+	return func(s *pipeline.Settings) error {
+		if err != nil {
+			return err
+		}
+		s.FontPath = f.Path()
+		return nil
+	}
+}
+
+// WithFontFallbacks sets font files to draw the runes the font has no glyph
+// for, tried in order, so text mixing e.g. Latin, CJK and symbols shows
+// every glyph. Repeat mode fails if one cannot be loaded; position mode
+// warns with EventFontFallback and leaves it out. PDF stamps use the font
+// alone.
+func WithFontFallbacks(paths ...string) Option {
+	paths = append([]string(nil), paths...)
+	return func(s *pipeline.Settings) error {
+		for _, p := range paths {
+			if strings.TrimSpace(p) == "" {
+				return fmt.Errorf("%w: font fallback path must not be empty", ErrFontLoad)
+			}
+		}
+		s.FontFallbacks = paths
+		return nil
+	}
+}
+
+// WithFontSize sets the repeat-mode font size.
+func WithFontSize(size int) Option {
+	return func(s *pipeline.Settings) error {
+		if size <= 0 {
+			return fmt.Errorf("font size must be positive, got %d", size)
+		}
+		s.FontSize = size
+		return nil
+	}
+}
+
+// WithFontHeightCrop sets the repeat-mode factor applied to the tile height.
+func WithFontHeightCrop(f float64) Option {
+	return func(s *pipeline.Settings) error {
+		if f <= 0 {
+			return fmt.Errorf("font height crop must be positive, got %g", f)
+		}
+		s.FontHeightCrop = f
+		return nil
+	}
+}
+
+// WithMaxTiles sets how many tiles repeat mode may paste before failing with
+// ErrTooManyTiles (default 250000); 0 removes the limit.
+func WithMaxTiles(n int) Option {
+	return func(s *pipeline.Settings) error {
+		if n < 0 {
+			return fmt.Errorf("max tiles must not be negative, got %d", n)
+		}
+		s.MaxTiles = n
+		return nil
+	}
+}
+
+// WithLineHeight sets the spacing of multi-line text ("\n" in the text) as a
+// factor of the font's line height (default 1).
+func WithLineHeight(f float64) Option {
+	return func(s *pipeline.Settings) error {
+		if f <= 0 {
+			return fmt.Errorf("line height must be positive, got %g", f)
+		}
+		s.LineHeight = f
+		return nil
+	}
+}
+
+// WithAlign sets the alignment of multi-line text: left, center or right.
+func WithAlign(a Align) Option {
+	a = Align(strings.ToLower(string(a)))
+	return func(s *pipeline.Settings) error {
+		if !a.Valid() {
+			return fmt.Errorf("unsupported alignment: %q", a)
+		}
+		s.Align = a
+		return nil
+	}
+}
+
+// WithRotateTiles rotates each repeat-mode tile about its own center and
+// lays the tiles on an upright grid, instead of rotating the whole pattern.
+func WithRotateTiles(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.RotateTiles = enabled
+		return nil
+	}
+}
+
+// WithPositionFontSize fixes the position-mode font size in pixels instead of
+// deriving it from the image size. Zero restores the automatic size.
+func WithPositionFontSize(px int) Option {
+	return func(s *pipeline.Settings) error {
+		if px < 0 {
+			return fmt.Errorf("position font size must not be negative, got %d", px)
+		}
+		s.PositionFontSize = px
+		return nil
+	}
+}
+
+// WithPositionAngle rotates the position-mode text counter-clockwise by deg
+// degrees; the rotated text's bounding box is what gets anchored.
+func WithPositionAngle(deg float64) Option {
+	return func(s *pipeline.Settings) error {
+		s.PositionAngle = math.Mod(deg, 360)
+		return nil
+	}
+}
+
+// WithFontSizeRatio sets the automatic position-mode font size relative to
+// the shorter image side (default 0.04, never below 16px).
+func WithFontSizeRatio(r float64) Option {
+	return func(s *pipeline.Settings) error {
+		if r <= 0 || r > 1 {
+			return fmt.Errorf("font size ratio must be in (0, 1], got %g", r)
+		}
+		s.FontSizeRatio = r
+		return nil
+	}
+}
+
+// WithWidthRatio sizes the position-mode text so it spans r of the image
+// width (e.g. 0.3 for 30%), keeping marks proportional across images of
+// different resolutions. It replaces WithFontSizeRatio; WithPositionFontSize
+// still takes precedence. Zero disables it.
+func WithWidthRatio(r float64) Option {
+	return func(s *pipeline.Settings) error {
+		if r < 0 || r > 1 {
+			return fmt.Errorf("width ratio must be in [0, 1], got %g", r)
+		}
+		s.WidthRatio = r
+		return nil
+	}
+}
+
+// WithFilename sets the value of the {filename} text variable. It defaults to
+// the base name of the input path, and to nothing for streams.
+func WithFilename(name string) Option {
+	return func(s *pipeline.Settings) error {
+		s.Filename = name
+		return nil
+	}
+}
+
+// WithCounter sets the value of the {counter} text variable, for callers
+// numbering the images of a batch (default 1).
+func WithCounter(n int) Option {
+	return func(s *pipeline.Settings) error {
+		s.Counter = n
+		return nil
+	}
+}
+
+// WithSequence numbers each file processed with these options from seq, in
+// place of a fixed WithCounter value, e.g. to number the proofs of a batch
+// from NewSequence(1). Fanout takes one number per copy.
+func WithSequence(seq *Sequence) Option {
+	return func(s *pipeline.Settings) error {
+		s.Sequence = seq
+		return nil
+	}
+}
+
+// WithPage sets the values of the {page} and {pages} text variables, for
+// callers marking the pages of a multi-page document one image at a time.
+func WithPage(page, pages int) Option {
+	return func(s *pipeline.Settings) error {
+		if pages < 1 || page < 1 || page > pages {
+			return fmt.Errorf("invalid page %d of %d", page, pages)
+		}
+		s.Page, s.Pages = page, pages
+		return nil
+	}
+}
+
+// WithFillColor sets the position-mode text color instead of picking black
+// or white from the background brightness. Its alpha is scaled by the
+// opacity.
+func WithFillColor(hex string) Option {
+	return func(s *pipeline.Settings) error {
+		c, err := render.ParseHexColor(hex)
+		if err != nil {
+			return err
+		}
+		s.FillColor = &c
+		return nil
+	}
+}
+
+// WithOutlineColor sets the position-mode outline color, like WithFillColor.
+func WithOutlineColor(hex string) Option {
+	return func(s *pipeline.Settings) error {
+		c, err := render.ParseHexColor(hex)
+		if err != nil {
+			return err
+		}
+		s.OutlineColor = &c
+		return nil
+	}
+}
+
+// WithPosition sets the position-mode anchor. Besides the named anchors it
+// accepts an explicit offset such as "x=120,y=40" or "x=85%,y=92%", see
+// ParseOffset; the mark's top-left corner is then placed there.
+func WithPosition(p Position) Option {
+	return func(s *pipeline.Settings) error {
+		if isOffset(string(p)) {
+			off, err := ParseOffset(string(p))
+			if err != nil {
+				return err
+			}
+			s.Offset = &off
+			return nil
+		}
+		p := Position(strings.ToLower(string(p)))
+		if !p.Valid() {
+			return fmt.Errorf("unsupported position: %q", p)
+		}
+		s.Position = p
+		s.Offset = nil
+		return nil
+	}
+}
+
+// WithOffset places the position-mode mark at explicit coordinates. anchor
+// selects which point of the mark sits at off, using the same names as the
+// anchor grid. The mark is kept inside the image and WithAvoidEdges does not
+// move it.
+func WithOffset(off Offset, anchor Position) Option {
+	anchor = Position(strings.ToLower(string(anchor)))
+	if anchor == "" {
+		anchor = TopLeft
+	}
+	return func(s *pipeline.Settings) error {
+		if off.X.Value < 0 || off.Y.Value < 0 {
+			return fmt.Errorf("offset must not be negative, got %s", off)
+		}
+		if !anchor.Valid() {
+			return fmt.Errorf("unsupported anchor: %q", anchor)
+		}
+		s.Offset = &off
+		s.Anchor = anchor
+		return nil
+	}
+}
+
+// WithRandomPosition places the position-mode mark at a pseudo-random spot
+// inside region, so a batch does not share one trivially croppable corner.
+// The spot depends on seed and the image content: rerunning on the same
+// image gives the same result. WithAvoidEdges does not move it.
+func WithRandomPosition(region Region, seed int64) Option {
+	return func(s *pipeline.Settings) error {
+		if region.Min.X.Value < 0 || region.Min.Y.Value < 0 {
+			return fmt.Errorf("region must not be negative, got %s", region)
+		}
+		s.RandomRegion = &region
+		s.RandomSeed = seed
+		return nil
+	}
+}
+
+// WithColorJitter shifts the watermark colors by up to hue degrees around
+// the color wheel and up to lightness (in [0, 1]) lighter or darker, by an
+// amount that depends on seed and the input, so one color-keyed filter
+// cannot strip the mark from a whole batch. Rerunning on the same input
+// gives the same colors.
+func WithColorJitter(hue, lightness float64, seed int64) Option {
+	return func(s *pipeline.Settings) error {
+		if hue < 0 || hue > 180 {
+			return fmt.Errorf("color jitter hue must be in [0, 180], got %g", hue)
+		}
+		if lightness < 0 || lightness > 1 {
+			return fmt.Errorf("color jitter lightness must be in [0, 1], got %g", lightness)
+		}
+		s.ColorJitter = &render.ColorJitter{Hue: hue, Lightness: lightness, Seed: seed}
+		return nil
+	}
+}
+
+// WithShift moves the position-mode mark by dx, dy pixels (right and down are
+// positive) after it has been anchored.
+func WithShift(dx, dy int) Option {
+	return func(s *pipeline.Settings) error {
+		s.ShiftX, s.ShiftY = dx, dy
+		return nil
+	}
+}
+
+// WithOutlineWidth sets how far the position-mode outline reaches beyond the
+// glyph edges, in pixels (default 2). Zero disables the outline.
+func WithOutlineWidth(px float64) Option {
+	return func(s *pipeline.Settings) error {
+		if px < 0 {
+			return fmt.Errorf("outline width must not be negative, got %g", px)
+		}
+		s.OutlineWidth = px
+		return nil
+	}
+}
+
+// WithShadow adds a drop shadow under the position-mode text, offset by dx,
+// dy pixels and blurred with a Gaussian of sigma blur. The color's alpha is
+// scaled by the opacity.
+func WithShadow(dx, dy int, blur float64, hex string) Option {
+	return func(s *pipeline.Settings) error {
+		if blur < 0 {
+			return fmt.Errorf("shadow blur must not be negative, got %g", blur)
+		}
+		c, err := render.ParseHexColor(hex)
+		if err != nil {
+			return err
+		}
+		s.Shadow = &render.Shadow{DX: dx, DY: dy, Blur: blur, Color: c}
+		return nil
+	}
+}
+
+// WithOutlineDash draws the position-mode outline as a dashed stroke,
+// alternating on and off lengths in pixels. An odd count is repeated, as in
+// SVG; no lengths means a solid outline.
+func WithOutlineDash(lengths ...float64) Option {
+	return func(s *pipeline.Settings) error {
+		total := 0.0
+		for _, l := range lengths {
+			if l < 0 {
+				return fmt.Errorf("outline dash lengths must not be negative, got %g", l)
+			}
+			total += l
+		}
+		if len(lengths) > 0 && total == 0 {
+			return fmt.Errorf("outline dash lengths must not all be zero")
+		}
+		s.OutlineDash = lengths
+		return nil
+	}
+}
+
+// WithMarginRatio sets the position-mode margin relative to the image size,
+// the same on every side. It replaces margins set with WithMargins.
+func WithMarginRatio(r float64) Option {
+	return func(s *pipeline.Settings) error {
+		if r < 0 || r >= 0.5 {
+			return fmt.Errorf("margin ratio must be in [0, 0.5), got %g", r)
+		}
+		s.MarginRatio = r
+		s.Margins = nil
+		return nil
+	}
+}
+
+// WithMargins sets a separate position-mode margin for each side, e.g. to
+// keep the mark off a border or letterbox bars built into the image. It
+// replaces the WithMarginRatio margin.
+func WithMargins(m Margins) Option {
+	return func(s *pipeline.Settings) error {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		s.Margins = &m
+		return nil
+	}
+}
+
+// WithJPGBackground sets the color transparent areas are flattened onto when
+// writing JPEG.
+func WithJPGBackground(c color.NRGBA) Option {
+	return func(s *pipeline.Settings) error {
+		s.JPGBackground = c
+		return nil
+	}
+}
+
+// WithEncodeOptions sets the quality, progressive scan and chroma
+// subsampling of JPEG outputs and the compression level and color type of
+// PNG outputs. Other formats ignore it.
+func WithEncodeOptions(o EncodeOptions) Option {
+	return func(s *pipeline.Settings) error {
+		if err := o.Validate(); err != nil {
+			return err
+		}
+		s.Encoding = o
+		return nil
+	}
+}
+
+// WithPreserveAlpha keeps the alpha channel of transparent inputs exactly:
+// the mark is drawn only where the input is visible, scaled by its opacity,
+// and writing the result in a format without transparency, such as JPEG,
+// fails with ErrAlphaLost instead of flattening onto the JPEG background.
+func WithPreserveAlpha(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.PreserveAlpha = enabled
+		return nil
+	}
+}
+
+// WithColorProfile sets what happens to the ICC color profile embedded in
+// an input; the default is ColorProfileKeep. Converting from a profile that
+// is not a plain matrix/TRC RGB or gray profile fails with ErrColorProfile.
+func WithColorProfile(p ColorProfile) Option {
+	return func(s *pipeline.Settings) error {
+		if _, err := ParseColorProfile(string(p)); err != nil {
+			return err
+		}
+		s.ColorProfile = p
+		return nil
+	}
+}
+
+// WithLayerOrder stacks the combined-mode layers in this order, bottom
+// first; both must be named. By default the position mark sits on top.
+func WithLayerOrder(layers ...pipeline.Layer) Option {
+	return func(s *pipeline.Settings) error {
+		if len(layers) != 2 || layers[0] == layers[1] || !layers[0].Valid() || !layers[1].Valid() {
+			return fmt.Errorf("layer order must name %s and %s once each, got %v", LayerTiles, LayerPosition, layers)
+		}
+		s.LayerOrder = layers
+		return nil
+	}
+}
+
+// WithLayerStyle draws the combined-mode layer l at opacity, instead of the
+// WithOpacity one, and mixes it into the image below with blend, e.g. a
+// faint multiplied tile pattern under an opaque corner logo.
+func WithLayerStyle(l Layer, opacity float64, blend BlendMode) Option {
+	if blend == "" {
+		blend = BlendNormal
+	}
+	return func(s *pipeline.Settings) error {
+		if !l.Valid() {
+			return fmt.Errorf("unknown layer %q, want %s or %s", l, LayerTiles, LayerPosition)
+		}
+		if opacity < 0 || opacity > 1 {
+			return fmt.Errorf("%w: %s layer got %g", ErrInvalidOpacity, l, opacity)
+		}
+		if _, err := ParseBlendMode(string(blend)); err != nil {
+			return err
+		}
+		if s.LayerStyles == nil {
+			s.LayerStyles = make(map[Layer]pipeline.LayerStyle)
+		}
+		s.LayerStyles[l] = pipeline.LayerStyle{Opacity: opacity, Blend: blend}
+		return nil
+	}
+}
+
+// WithLinearBlend composites the mark in linear light instead of on sRGB
+// values, so semi-transparent text and its anti-aliased edges come out as
+// light as their opacity says rather than muddy and dark. It applies to
+// repeat, position, combined, QR and renderer marks; PDF pages are
+// composited by the viewer.
+func WithLinearBlend(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.LinearBlend = enabled
+		return nil
+	}
+}
+
+// WithWorkers composites bands of the image on n goroutines at once; 0,
+// the default, uses GOMAXPROCS and 1 keeps all the work on the calling
+// goroutine, e.g. when a server already marks several images in parallel.
+func WithWorkers(n int) Option {
+	return func(s *pipeline.Settings) error {
+		if n < 0 {
+			return fmt.Errorf("workers must not be negative, got %d", n)
+		}
+		s.Workers = n
+		return nil
+	}
+}
+
+// WithVerify compares each repeat-mode result with its source and warns
+// with EventInvisible when the mark left the image unchanged, e.g. at a
+// near-zero opacity. It is off by default since it costs a full pass over
+// the image.
+func WithVerify(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.Verify = enabled
+		return nil
+	}
+}
+
+// WithAvoidEdges nudges the position-mode mark inward when the chosen corner
+// covers strong edges, e.g. a subject reaching into the corner.
+func WithAvoidEdges(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.AvoidEdges = enabled
+		return nil
+	}
+}
+
+// WithAvoidChrome keeps the position-mode mark off the status and
+// navigation bars of screenshots: bands of near-uniform rows at the top and
+// bottom edges are detected and the named anchors measured from inside them.
+// Explicit offsets and random positions are not affected.
+func WithAvoidChrome(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.AvoidChrome = enabled
+		return nil
+	}
+}
+
+// WithAvoidBorders places the position-mode mark within the content of the
+// image rather than the whole canvas: uniform borders and letterbox or
+// pillarbox bars along the edges are detected, and the named anchors and
+// margins measured from inside them. Explicit offsets and random positions
+// are not affected, nor are PDF pages.
+func WithAvoidBorders(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.AvoidBorders = enabled
+		return nil
+	}
+}
+
+// WithMaxNudgeRatio limits the WithAvoidEdges shift relative to the shorter
+// image side.
+func WithMaxNudgeRatio(r float64) Option {
+	return func(s *pipeline.Settings) error {
+		if r < 0 || r > 1 {
+			return fmt.Errorf("max nudge ratio must be in [0, 1], got %g", r)
+		}
+		s.MaxNudgeRatio = r
+		return nil
+	}
+}
+
+// WithStripMetadata drops EXIF/XMP instead of copying it into JPEG outputs.
+func WithStripMetadata(strip bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.StripMetadata = strip
+		return nil
+	}
+}
+
+// WithOriginChecksum records the SHA-256 of the input file in the output,
+// as a comment in JPEGs and a text chunk in PNGs, so VerifyOrigin can later
+// tell whether a claimed original is the file it was made from. Other
+// output formats fail with ErrUnsupportedFormat. The record is plain
+// metadata: stripping it is easy, forging it for another original is not.
+func WithOriginChecksum(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.OriginChecksum = enabled
+		return nil
+	}
+}
+
+// WithIgnoreOrientation keeps pixels as stored instead of applying the EXIF
+// orientation tag before watermarking.
+func WithIgnoreOrientation(ignore bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.IgnoreOrientation = ignore
+		return nil
+	}
+}
+
+// WithTolerant salvages truncated or partially corrupted JPEG inputs instead
+// of failing; Result.Salvaged reports when that happened.
+func WithTolerant(tolerant bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.Tolerant = tolerant
+		return nil
+	}
+}
+
+// WithLimits refuses inputs over the given size with ErrInputTooLarge
+// before decoding them.
+func WithLimits(l SourceLimits) Option {
+	return func(s *pipeline.Settings) error {
+		if l.MaxBytes < 0 || l.MaxPixels < 0 {
+			return fmt.Errorf("input limits must not be negative")
+		}
+		s.Limits = l
+		return nil
+	}
+}
+
+// WithPDFPages limits the watermark on PDF inputs to the pages in spec, a
+// comma-separated list of 1-based page numbers and ranges such as "1-3,5,8-"
+// (8 to the last page). Other pages are left as they are. The default marks
+// every page; image inputs ignore it.
+func WithPDFPages(spec string) Option {
+	return func(s *pipeline.Settings) error {
+		r, err := pdf.ParsePageRanges(spec)
+		if err != nil {
+			return err
+		}
+		s.PDFPages = r
+		return nil
+	}
+}
+
+// WithCrop crops the decoded, auto-oriented input to c before watermarking,
+// so the mark is laid out on the cropped image. WithCleanOutput writes the
+// cropped image too. ICO inputs are not cropped.
+func WithCrop(c Crop) Option {
+	return func(s *pipeline.Settings) error {
+		if c.W <= 0 || c.H <= 0 || c.X < 0 || c.Y < 0 {
+			return fmt.Errorf("invalid crop %s", c)
+		}
+		if c.Gravity != "" {
+			c.Gravity = Position(strings.ToLower(string(c.Gravity)))
+			if !c.Gravity.Valid() {
+				return fmt.Errorf("invalid crop gravity %q", c.Gravity)
+			}
+		}
+		s.Crop = &c
+		return nil
+	}
+}
+
+// WithPadTo extends the decoded input, after WithCrop, to the aspect ratio
+// width/height (see ParseAspectRatio) before watermarking, so one run makes
+// a social-ready image such as a 4:5 portrait. The image is centered and
+// the added area filled with fill, a hex color or PadBlur for a blurred copy
+// of the image. The mark is laid out on the padded canvas, and
+// WithCleanOutput writes it padded too. ICO and PDF inputs are not padded.
+func WithPadTo(ratio float64, fill string) Option {
+	return func(s *pipeline.Settings) error {
+		if !(ratio > 0) || math.IsInf(ratio, 0) {
+			return fmt.Errorf("pad aspect ratio must be positive, got %g", ratio)
+		}
+		p := &compose.Padding{Ratio: ratio, Blur: fill == PadBlur}
+		if !p.Blur {
+			c, err := render.ParseHexColor(fill)
+			if err != nil {
+				return fmt.Errorf("pad fill: %w", err)
+			}
+			p.Color = c
+		}
+		s.Pad = p
+		return nil
+	}
+}
+
+// WithStencil renders the watermark as a light-gray stencil over a grayscale
+// copy of the image, for black-and-white document scans. The stencil only
+// darkens paper, never the text on it.
+func WithStencil(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.Stencil = enabled
+		return nil
+	}
+}
+
+// WithStencilGray sets the gray level of the WithStencil mark (default 200;
+// 0 is black, 255 invisible).
+func WithStencilGray(level uint8) Option {
+	return func(s *pipeline.Settings) error {
+		s.StencilGray = level
+		return nil
+	}
+}
+
+// WithBilevel converts the output to pure black and white with ordered
+// dithering, written as 1-bit PNG or palette TIFF, for archival systems.
+func WithBilevel(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.Bilevel = enabled
+		return nil
+	}
+}
+
+// WithICOMinSize sets the smallest icon, in pixels on its shorter side, that
+// gets marked when the input is a multi-resolution ICO file (default 32).
+// Smaller icons are copied unchanged, since text on them would be illegible.
+func WithICOMinSize(px int) Option {
+	return func(s *pipeline.Settings) error {
+		if px < 1 {
+			return fmt.Errorf("ICO minimum size must be positive, got %d", px)
+		}
+		s.ICOMinSize = px
+		return nil
+	}
+}
+
+// WithFormat writes file outputs in format instead of the one named by the
+// file extension. The extension must still name some image format unless
+// WithForceFormat is set. Streams take their format as an argument and ignore this option.
+func WithFormat(format Format) Option {
+	return func(s *pipeline.Settings) error {
+		if format == "" {
+			s.Format = ""
+			return nil
+		}
+		f, err := ParseFormat(string(format))
+		if err != nil {
+			return err
+		}
+		s.Format = f
+		return nil
+	}
+}
+
+// WithForceFormat lets WithFormat write a file whose extension is not an
+// image format, or that has none.
+func WithForceFormat(force bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.ForceFormat = force
+		return nil
+	}
+}
+
+// WithCleanOutput also writes the decoded, auto-oriented input without a
+// watermark to path, in the format named by its extension, so a clean master
+// can be archived next to the marked copy without decoding twice.
+func WithCleanOutput(path string) Option {
+	return func(s *pipeline.Settings) error {
+		s.CleanPath = path
+		return nil
+	}
+}
+
+// WithQRLevel sets the error-correction level of QR marks (default M).
+func WithQRLevel(level QRLevel) Option {
+	return func(s *pipeline.Settings) error {
+		l, err := ParseQRLevel(string(level))
+		if err != nil {
+			return err
+		}
+		s.QRLevel = l
+		return nil
+	}
+}
+
+// WithQRSize sets the side of QR marks in pixels, quiet zone included. The
+// code is drawn with whole pixels per module, so it may come out slightly
+// smaller. Zero, the default, uses a fifth of the shorter image side.
+func WithQRSize(px int) Option {
+	return func(s *pipeline.Settings) error {
+		if px < 0 {
+			return fmt.Errorf("QR size must not be negative, got %d", px)
+		}
+		s.QRSize = px
+		return nil
+	}
+}
+
+// WithQRQuietZone sets the light border around QR marks, in modules
+// (default 4, the minimum the standard asks for).
+func WithQRQuietZone(modules int) Option {
+	return func(s *pipeline.Settings) error {
+		if modules < 0 {
+			return fmt.Errorf("QR quiet zone must not be negative, got %d", modules)
+		}
+		s.QRQuietZone = modules
+		return nil
+	}
+}
+
+// WithQRTiled tiles QR marks across the image like repeat-mode text,
+// using the repeat spacing and angle, instead of placing one code.
+func WithQRTiled(enabled bool) Option {
+	return func(s *pipeline.Settings) error {
+		s.QRTiled = enabled
+		return nil
+	}
+}
+
+// WithRobustStrength sets the amplitude AddRobustWatermark adds to each
+// carrier DCT coefficient, in luma levels (default 4). Higher values survive
+// stronger compression and resizing but start to show as a fine texture on
+// flat areas.
+func WithRobustStrength(strength float64) Option {
+	return func(s *pipeline.Settings) error {
+		if strength <= 0 || strength > 64 {
+			return fmt.Errorf("robust strength must be in (0, 64], got %g", strength)
+		}
+		s.RobustStrength = strength
+		return nil
+	}
+}
+
+// WithRenderer draws the mark with r instead of rendering the text in the
+// repeat and position modes. The modes still lay it out: repeat tiles it,
+// position places it. The text is passed to r, with template variables
+// filled in.
+func WithRenderer(r MarkRenderer) Option {
+	return func(s *pipeline.Settings) error {
+		s.Renderer = r
+		return nil
+	}
+}
+
+// WithTextPlugin lets the plugin p replace the mark text of every image or
+// PDF page, after template variables are filled in and before text
+// transformers run. See Plugin for the protocol.
+func WithTextPlugin(p *Plugin) Option {
+	return func(s *pipeline.Settings) error {
+		s.TextPlugin = p
+		return nil
+	}
+}
+
+// WithPlacementPlugin lets the plugin p choose the top-left corner of the
+// position-mode mark, given the image and mark sizes and the corner the
+// built-in placement chose. It is not supported for PDF inputs.
+func WithPlacementPlugin(p *Plugin) Option {
+	return func(s *pipeline.Settings) error {
+		s.PlacePlugin = p
+		return nil
+	}
+}
+
+// WithLogger sends warnings (font fallback, invisible result) to l. Without
+// it the library stays silent.
+func WithLogger(l Logger) Option {
+	return func(s *pipeline.Settings) error {
+		s.Logger = l
+		return nil
+	}
+}
+
+// WithEventHandler calls fn for every warning raised during the run.
+func WithEventHandler(fn func(Event)) Option {
+	return func(s *pipeline.Settings) error {
+		s.OnEvent = fn
+		return nil
+	}
+}
@@ -0,0 +1,13 @@
+package watermark
+
+import "watermark/pkg/compose"
+
+// PadBlur is the WithPadTo fill that extends the image with a blurred,
+// enlarged copy of itself, as photo apps do for social posts.
+const PadBlur = "blur"
+
+// ParseAspectRatio parses an aspect ratio as "W:H", e.g. "4:5" or
+// "1.91:1", or as a single number, and returns width divided by height.
+func ParseAspectRatio(s string) (float64, error) {
+	return compose.ParseAspectRatio(s)
+}
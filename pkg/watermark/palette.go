@@ -0,0 +1,18 @@
+package watermark
+
+import "watermark/pkg/render"
+
+// PaletteColor is one named color of a palette.
+type PaletteColor = render.PaletteColor
+
+// PaletteNames returns the names of the built-in color-blind safe palettes.
+// Any option taking a hex color also accepts "palette:color", e.g.
+// "okabe-ito:vermillion".
+func PaletteNames() []string {
+	return render.PaletteNames()
+}
+
+// Palette returns the colors of a built-in palette.
+func Palette(name string) ([]PaletteColor, bool) {
+	return render.Palette(name)
+}
@@ -0,0 +1,112 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"watermark/pkg/pdf"
+)
+
+// minimalPDF returns a PDF of pages empty A4 pages with a classic xref
+// table, and the offset of that table.
+func minimalPDF(pages int) ([]byte, int) {
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	buf.WriteString("%PDF-1.4\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := ""
+	for i := 0; i < pages; i++ {
+		kids += fmt.Sprintf("%d 0 R ", 3+2*i)
+	}
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 595 842] >>", kids, pages))
+	for i := 0; i < pages; i++ {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /Contents %d 0 R /Resources << >> >>", 4+2*i))
+		content := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (page %d) Tj ET", i+1)
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f\r\n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n\r\n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes(), xref
+}
+
+func TestPDFPageRange(t *testing.T) {
+	orig, origXref := minimalPDF(3)
+	var out bytes.Buffer
+	_, err := AddRepeatWatermarkStream(context.Background(), bytes.NewReader(orig), &out, FormatPDF, "DRAFT p{page}", WithPDFPages("2-3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+	if !bytes.HasPrefix(data, orig) {
+		t.Fatal("the original bytes are not kept as they were")
+	}
+	update := data[len(orig):]
+
+	// The update ends in a classic xref table, like the original, whose
+	// offsets point at the objects it lists, and chains to the original.
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(update)
+	if m == nil {
+		t.Fatalf("no startxref at the end of the update:\n%s", update)
+	}
+	xrefAt, _ := strconv.Atoi(string(m[1]))
+	if xrefAt < len(orig) || !bytes.HasPrefix(data[xrefAt:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the update's xref table", xrefAt)
+	}
+	if !bytes.Contains(data[xrefAt:], []byte(fmt.Sprintf("/Prev %d", origXref))) {
+		t.Errorf("the update's trailer does not chain to the original xref at %d", origXref)
+	}
+	listed := 0
+	section := regexp.MustCompile(`(?m)^(\d+) (\d+)\n((?:\d{10} \d{5} n\r\n)+)`)
+	for _, sub := range section.FindAllSubmatch(data[xrefAt:], -1) {
+		first, _ := strconv.Atoi(string(sub[1]))
+		for i, row := range regexp.MustCompile(`\d{10}`).FindAll(sub[3], -1) {
+			off, _ := strconv.Atoi(string(row))
+			header := fmt.Sprintf("%d 0 obj\n", first+i)
+			if off < len(orig) || !bytes.HasPrefix(data[off:], []byte(header)) {
+				t.Errorf("xref entry of object %d points at %d, not at its object", first+i, off)
+			}
+			listed++
+		}
+	}
+	if got := bytes.Count(update, []byte(" obj\n")); listed != got {
+		t.Errorf("xref lists %d objects, the update has %d", listed, got)
+	}
+
+	doc, err := pdf.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := doc.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("%d pages after marking, want 3", len(pages))
+	}
+	for i, p := range pages {
+		_, isRef := p.Dict["Contents"].(pdf.Ref)
+		fonts, _ := doc.Resolve(p.Resources["Font"]).(pdf.Dict)
+		if i == 0 {
+			if !isRef || fonts != nil {
+				t.Errorf("page 1 lies outside the range but was changed: %v", p.Dict)
+			}
+			continue
+		}
+		contents, _ := p.Dict["Contents"].(pdf.Array)
+		if len(contents) != 3 || fonts == nil {
+			t.Errorf("page %d: contents %v and fonts %v, want the original stream wrapped by two more and a font", i+1, p.Dict["Contents"], fonts)
+		}
+	}
+}
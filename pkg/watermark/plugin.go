@@ -0,0 +1,33 @@
+package watermark
+
+import "watermark/internal/pipeline"
+
+// PluginProtocolVersion is the version of the plugin protocol sent in every
+// PluginRequest. It changes only when a field changes meaning.
+const PluginProtocolVersion = pipeline.PluginProtocolVersion
+
+// DefaultPluginTimeout bounds a plugin call when Plugin.Timeout is 0.
+const DefaultPluginTimeout = pipeline.DefaultPluginTimeout
+
+// Plugin hooks name what a plugin is asked for.
+const (
+	// PluginHookText asks for the mark text; see WithTextPlugin.
+	PluginHookText = pipeline.PluginHookText
+	// PluginHookPlace asks where position mode puts the mark; see
+	// WithPlacementPlugin.
+	PluginHookPlace = pipeline.PluginHookPlace
+)
+
+type (
+	// Plugin is an external program that extends marking without forking
+	// this package, e.g. to fetch per-order text from a database. For every
+	// call the program is started, reads one PluginRequest as JSON from
+	// stdin, writes one PluginResponse as JSON to stdout and exits. An error
+	// in the response, a non-zero exit status or a timeout fails the file
+	// being marked.
+	Plugin = pipeline.Plugin
+	// PluginRequest is what a plugin reads from stdin.
+	PluginRequest = pipeline.PluginRequest
+	// PluginResponse is what a plugin writes to stdout.
+	PluginResponse = pipeline.PluginResponse
+)
@@ -0,0 +1,53 @@
+package watermark
+
+import (
+	"context"
+	"io"
+
+	"watermark/internal/pipeline"
+)
+
+// PositionWatermarker adds position-mode marks with a fixed set of options,
+// parsing the font once instead of on every image, for servers and batch
+// jobs. It is safe for concurrent use.
+type PositionWatermarker struct {
+	opts []Option
+	font *pipeline.PositionFont
+}
+
+// NewPositionWatermarker checks opts and loads the font they name, falling
+// back like AddPositionWatermark does.
+func NewPositionWatermarker(opts ...pipeline.Option) (*PositionWatermarker, error) {
+	cfg, err := pipeline.NewSettings(opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := pipeline.LoadPositionFont(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &PositionWatermarker{opts: opts, font: f}, nil
+}
+
+// Add is AddPositionWatermark with the options of p followed by extra.
+// Extra options that change the font path or fallbacks load the fonts per
+// call.
+func (p *PositionWatermarker) Add(ctx context.Context, inputPath, outputPath, text string, extra ...pipeline.Option) (*Result, error) {
+	return pipeline.AddFile(ctx, "position", pipeline.PositionMark, inputPath, outputPath, text, p.options(extra))
+}
+
+// AddStream is AddPositionWatermarkStream with the options of p followed by
+// extra.
+func (p *PositionWatermarker) AddStream(ctx context.Context, r io.Reader, w io.Writer, format Format, text string, extra ...pipeline.Option) (*Result, error) {
+	return pipeline.AddStream(ctx, "position", pipeline.PositionMark, r, w, format, text, p.options(extra))
+}
+
+func (p *PositionWatermarker) options(extra []Option) []Option {
+	opts := make([]Option, 0, len(p.opts)+len(extra)+1)
+	opts = append(opts, p.opts...)
+	opts = append(opts, func(s *pipeline.Settings) error {
+		s.PositionFont = p.font
+		return nil
+	})
+	return append(opts, extra...)
+}
@@ -0,0 +1,171 @@
+package watermark
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"watermark/internal/pipeline"
+	"watermark/pkg/preset"
+)
+
+// Preset is a named watermark configuration; see preset.Preset. Field names
+// in YAML match the CLI flag names, so a presets file doubles as a CLI
+// config file. It converts to and from preset.Preset.
+type Preset preset.Preset
+
+// RegisterPreset adds or replaces a preset under p.Name.
+func RegisterPreset(p Preset) error {
+	return preset.Register(preset.Preset(p))
+}
+
+// RegisterTenantPreset adds or replaces a preset under p.Name for tenant
+// only, so each customer of a shared service, keyed for instance by API
+// key, can have its own fonts, text and styles. Presets kept in a backing
+// store can be registered as they are loaded or changed.
+func RegisterTenantPreset(tenant string, p Preset) error {
+	return preset.RegisterTenant(tenant, preset.Preset(p))
+}
+
+// UnregisterTenant removes every preset of tenant, e.g. when its API key
+// is revoked.
+func UnregisterTenant(tenant string) {
+	preset.UnregisterTenant(tenant)
+}
+
+// LookupPreset returns the preset registered under name.
+func LookupPreset(name string) (Preset, bool) {
+	p, ok := preset.Lookup(name)
+	return Preset(p), ok
+}
+
+// LookupTenantPreset returns the preset tenant registered under name, or
+// else the shared preset of that name. Presets of other tenants are never
+// returned.
+func LookupTenantPreset(tenant, name string) (Preset, bool) {
+	p, ok := preset.LookupTenant(tenant, name)
+	return Preset(p), ok
+}
+
+// TenantPresetNames lists the presets registered for tenant alone in sorted
+// order, or nil if the tenant has none.
+func TenantPresetNames(tenant string) []string {
+	return preset.TenantNames(tenant)
+}
+
+// PresetNames lists the registered presets in sorted order.
+func PresetNames() []string {
+	return preset.Names()
+}
+
+// LoadPresets registers every preset under the top-level "presets" mapping
+// of a YAML file, and the presets under "tenants" for their tenant; see
+// preset.Load.
+func LoadPresets(path string) error {
+	return preset.Load(path)
+}
+
+// Options converts the preset's style settings into options. Unset fields
+// keep the defaults.
+func (p Preset) Options() []Option {
+	var opts []Option
+	if p.Font != "" {
+		opts = append(opts, WithFont(p.Font))
+	}
+	if p.LineHeight != nil {
+		opts = append(opts, WithLineHeight(*p.LineHeight))
+	}
+	if p.Align != "" {
+		opts = append(opts, WithAlign(p.Align))
+	}
+	if p.Color != nil {
+		opts = append(opts, WithColor(*p.Color))
+	}
+	if p.Opacity != nil {
+		opts = append(opts, WithOpacity(*p.Opacity))
+	}
+	if p.Space != nil {
+		opts = append(opts, WithSpace(*p.Space))
+	}
+	if p.Angle != nil {
+		opts = append(opts, WithAngle(*p.Angle))
+	}
+	if p.FontSize != nil {
+		opts = append(opts, WithFontSize(*p.FontSize))
+	}
+	if p.FontHeightCrop != nil {
+		opts = append(opts, WithFontHeightCrop(*p.FontHeightCrop))
+	}
+	if p.RotateTiles {
+		opts = append(opts, WithRotateTiles(true))
+	}
+	if p.Position != "" {
+		opts = append(opts, WithPosition(p.Position))
+	}
+	if p.Stencil {
+		opts = append(opts, WithStencil(true))
+	}
+	if p.StencilGray != nil {
+		opts = append(opts, WithStencilGray(*p.StencilGray))
+	}
+	if p.Bilevel {
+		opts = append(opts, WithBilevel(true))
+	}
+	if len(p.Transforms) > 0 {
+		opts = append(opts, WithTextTransforms(p.Transforms...))
+	}
+	if p.MarginRatio != nil {
+		opts = append(opts, WithMarginRatio(*p.MarginRatio))
+	}
+	if p.PositionSize != nil {
+		opts = append(opts, WithPositionFontSize(*p.PositionSize))
+	}
+	if p.FontSizeRatio != nil {
+		opts = append(opts, WithFontSizeRatio(*p.FontSizeRatio))
+	}
+	if p.WidthRatio != 0 {
+		opts = append(opts, WithWidthRatio(p.WidthRatio))
+	}
+	if p.PositionAngle != 0 {
+		opts = append(opts, WithPositionAngle(p.PositionAngle))
+	}
+	if p.FillColor != "" {
+		opts = append(opts, WithFillColor(p.FillColor))
+	}
+	if p.OutlineColor != "" {
+		opts = append(opts, WithOutlineColor(p.OutlineColor))
+	}
+	if p.OffsetX != 0 || p.OffsetY != 0 {
+		opts = append(opts, WithShift(p.OffsetX, p.OffsetY))
+	}
+	return opts
+}
+
+// Apply watermarks inputPath into outputPath using the preset, followed by
+// any extra options. An empty mode means position mode.
+func (p Preset) Apply(ctx context.Context, inputPath, outputPath string, extra ...pipeline.Option) (*Result, error) {
+	opts := append(p.Options(), extra...)
+	if strings.EqualFold(p.Mode, "repeat") {
+		return AddRepeatWatermark(ctx, inputPath, outputPath, p.Text, opts...)
+	}
+	return AddPositionWatermark(ctx, inputPath, outputPath, p.Text, opts...)
+}
+
+// ApplyPreset looks up a registered preset by name and applies it.
+func ApplyPreset(ctx context.Context, name, inputPath, outputPath string, extra ...pipeline.Option) (*Result, error) {
+	p, ok := LookupPreset(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q", name)
+	}
+	return p.Apply(ctx, inputPath, outputPath, extra...)
+}
+
+// ApplyTenantPreset looks up a preset for tenant, as LookupTenantPreset
+// does, and applies it.
+func ApplyTenantPreset(ctx context.Context, tenant, name, inputPath, outputPath string, extra ...pipeline.Option) (*Result, error) {
+	p, ok := LookupTenantPreset(tenant, name)
+	if !ok {
+		return nil, fmt.Errorf("tenant %q: unknown preset %q", tenant, name)
+	}
+	return p.Apply(ctx, inputPath, outputPath, extra...)
+}
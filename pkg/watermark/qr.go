@@ -0,0 +1,20 @@
+package watermark
+
+import "watermark/pkg/render"
+
+// QRLevel is a QR code error-correction level. Higher levels survive more
+// damage, such as a watermark partly covered or cropped, at the cost of a
+// denser code.
+type QRLevel = render.QRLevel
+
+const (
+	QRLevelL = render.QRLevelL // about 7% of codewords can be restored
+	QRLevelM = render.QRLevelM // about 15%
+	QRLevelQ = render.QRLevelQ // about 25%
+	QRLevelH = render.QRLevelH // about 30%
+)
+
+// ParseQRLevel parses "L", "M", "Q" or "H", in either case.
+func ParseQRLevel(s string) (QRLevel, error) {
+	return render.ParseQRLevel(s)
+}
@@ -0,0 +1,44 @@
+package watermark
+
+import (
+	"image"
+
+	"watermark/pkg/render"
+)
+
+// The renderers are those of package render, so values pass between the
+// two packages unconverted.
+type (
+	// MarkRenderer draws the watermark itself. The repeat layout tiles what
+	// it renders and the position layout places it, so custom marks such as
+	// barcodes or signatures can use either. See WithRenderer and
+	// WatermarkArgs.Renderer.
+	MarkRenderer = render.MarkRenderer
+	// RenderOptions is what a MarkRenderer is told about the mark to draw.
+	RenderOptions = render.RenderOptions
+	// TextRenderer renders text in one color, the way repeat mode always
+	// has; see render.TextRenderer.
+	TextRenderer = render.TextRenderer
+	// ImageRenderer renders a fixed image, such as a logo.
+	ImageRenderer = render.ImageRenderer
+	// QRRenderer renders the text as a QR code.
+	QRRenderer = render.QRRenderer
+	// SVGRenderer renders an SVG document, such as a vector logo,
+	// rasterized at the size it is drawn at.
+	SVGRenderer = render.SVGRenderer
+	// SVG is a parsed SVG document, see ParseSVG.
+	SVG = render.SVG
+)
+
+// ParseSVG parses an SVG document; see render.ParseSVG for what it
+// supports.
+func ParseSVG(data []byte) (*SVG, error) {
+	return render.ParseSVG(data)
+}
+
+// TestCard returns a calibration image of width×height pixels for judging
+// how a mark looks on different content, devices and formats; see
+// render.TestCard. Mark it like any other image.
+func TestCard(width, height int) (*image.NRGBA, error) {
+	return render.TestCard(width, height)
+}
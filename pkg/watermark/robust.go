@@ -0,0 +1,60 @@
+package watermark
+
+import (
+	"context"
+	"io"
+
+	"watermark/internal/pipeline"
+	"watermark/pkg/invisible"
+)
+
+// Detection is the result of DetectRobustWatermark; see
+// invisible.Detection.
+type Detection = invisible.Detection
+
+// AddRobustWatermark embeds an invisible mark derived from key in the
+// mid-frequency DCT coefficients of the image luma and saves the output.
+// Unlike AddInvisibleWatermark it carries no payload, but it survives JPEG
+// recompression and mild resizing; DetectRobustWatermark tells whether an
+// image carries the mark of a given key. WithRobustStrength trades
+// visibility for robustness.
+func AddRobustWatermark(ctx context.Context, inputPath, outputPath, key string, opts ...pipeline.Option) (*Result, error) {
+	return pipeline.AddFile(ctx, "robust", pipeline.RobustMark, inputPath, outputPath, key, opts)
+}
+
+// AddRobustWatermarkStream is AddRobustWatermark reading the input from r and
+// writing the output to w in the given format.
+func AddRobustWatermarkStream(ctx context.Context, r io.Reader, w io.Writer, format Format, key string, opts ...pipeline.Option) (*Result, error) {
+	return pipeline.AddStream(ctx, "robust", pipeline.RobustMark, r, w, format, key, opts)
+}
+
+// DetectRobustWatermark checks the image at path for the mark that
+// AddRobustWatermark embeds for key.
+func DetectRobustWatermark(path, key string) (Detection, error) {
+	src, err := OpenImageSource(path, SourceLimits{})
+	if err != nil {
+		return Detection{}, err
+	}
+	return detectRobust(src, key)
+}
+
+// DetectRobustWatermarkStream is DetectRobustWatermark reading the image
+// from r.
+func DetectRobustWatermarkStream(r io.Reader, key string) (Detection, error) {
+	src, err := ReadImageSource(r, SourceLimits{})
+	if err != nil {
+		return Detection{}, err
+	}
+	return detectRobust(src, key)
+}
+
+func detectRobust(in *ImageSource, key string) (Detection, error) {
+	if key == "" {
+		return Detection{}, ErrEmptyMark
+	}
+	img, err := in.Decode()
+	if err != nil {
+		return Detection{}, err
+	}
+	return invisible.DetectRobust(img, key), nil
+}
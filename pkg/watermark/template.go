@@ -0,0 +1,14 @@
+package watermark
+
+import "watermark/internal/pipeline"
+
+// Sequence numbers the files of a batch: share one between the Add* calls
+// of a batch with WithSequence and each file processed takes the next
+// number for {counter} and {seq}. It is safe for concurrent use, though
+// files marked in parallel are numbered in the order they start.
+type Sequence = pipeline.Sequence
+
+// NewSequence returns a Sequence whose first number is start.
+func NewSequence(start int) *Sequence {
+	return pipeline.NewSequence(start)
+}
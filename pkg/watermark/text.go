@@ -0,0 +1,53 @@
+package watermark
+
+import "watermark/pkg/render"
+
+// The text settings are those of package render, so values pass between
+// the two packages unconverted.
+type (
+	// Align is the horizontal alignment of multi-line text.
+	Align = render.Align
+	// TextTransform changes the case of the mark text as it is rendered,
+	// after every TextTransformer, so template values, plugin text and
+	// non-ASCII letters are all covered. Payloads of QR, invisible and
+	// robust marks are never changed.
+	TextTransform = render.TextTransform
+	// TextDirection is the writing mode of the mark text.
+	TextDirection = render.TextDirection
+	// FontVariation sets an axis of a variable font to a value in the
+	// font's design units, e.g. {"wght", 700} for bold.
+	FontVariation = render.FontVariation
+)
+
+const (
+	AlignLeft   = render.AlignLeft
+	AlignCenter = render.AlignCenter
+	AlignRight  = render.AlignRight
+
+	TextTransformNone      = render.TextTransformNone
+	TextTransformUpper     = render.TextTransformUpper
+	TextTransformLower     = render.TextTransformLower
+	TextTransformTitle     = render.TextTransformTitle
+	TextTransformSmallCaps = render.TextTransformSmallCaps
+
+	TextDirectionHorizontal = render.TextDirectionHorizontal
+	TextDirectionVertical   = render.TextDirectionVertical
+)
+
+// ParseTextTransform parses "upper", "lower", "title", "smallcaps", or
+// "none" or "" for none.
+func ParseTextTransform(s string) (TextTransform, error) {
+	return render.ParseTextTransform(s)
+}
+
+// ParseTextDirection parses "vertical", or "horizontal" or "" for
+// horizontal.
+func ParseTextDirection(s string) (TextDirection, error) {
+	return render.ParseTextDirection(s)
+}
+
+// ParseFontVariations parses comma-separated axis settings such as
+// "wght=700,slnt=-10".
+func ParseFontVariations(s string) ([]FontVariation, error) {
+	return render.ParseFontVariations(s)
+}
@@ -0,0 +1,16 @@
+package watermark
+
+import (
+	"go.opentelemetry.io/otel/trace"
+
+	"watermark/internal/pipeline"
+)
+
+// WithTracerProvider records OpenTelemetry spans for the decode, apply and
+// encode stages with tp. Without it tracing is a no-op.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *pipeline.Settings) error {
+		s.TracerProvider = tp
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+package watermark
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"watermark/internal/pipeline"
+)
+
+// TextTransformer rewrites the watermark text after template variables are
+// filled in and before it is rendered.
+type TextTransformer = pipeline.TextTransformer
+
+var (
+	transformersMu sync.RWMutex
+	transformers   = map[string]TextTransformer{
+		"upper":         strings.ToUpper,
+		"lower":         strings.ToLower,
+		"trim":          strings.TrimSpace,
+		"redact-emails": redactEmails,
+	}
+)
+
+// RegisterTextTransformer adds or replaces the transformer used for name
+// by WithTextTransforms.
+func RegisterTextTransformer(name string, fn TextTransformer) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("text transformer name must not be empty")
+	}
+	if fn == nil {
+		return fmt.Errorf("text transformer %q is nil", name)
+	}
+	transformersMu.Lock()
+	defer transformersMu.Unlock()
+	transformers[name] = fn
+	return nil
+}
+
+// TextTransformerNames lists the registered text transformers in sorted
+// order.
+func TextTransformerNames() []string {
+	transformersMu.RLock()
+	defer transformersMu.RUnlock()
+	names := make([]string, 0, len(transformers))
+	for name := range transformers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupTextTransformer(name string) (TextTransformer, bool) {
+	transformersMu.RLock()
+	defer transformersMu.RUnlock()
+	fn, ok := transformers[name]
+	return fn, ok
+}
+
+var emailPattern = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+
+// redactEmails masks the local part of email addresses, keeping its first
+// character: "jane.doe@example.com" becomes "j***@example.com".
+func redactEmails(text string) string {
+	return emailPattern.ReplaceAllString(text, "$1***@$2")
+}